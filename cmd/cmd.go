@@ -2,42 +2,134 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/yashkadam007/bittorrent-client/internal/dht"
 	"github.com/yashkadam007/bittorrent-client/internal/download"
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
 	"github.com/yashkadam007/bittorrent-client/internal/pieces"
 	"github.com/yashkadam007/bittorrent-client/internal/storage"
 	"github.com/yashkadam007/bittorrent-client/internal/torrent"
 	"github.com/yashkadam007/bittorrent-client/internal/tracker"
 	"github.com/yashkadam007/bittorrent-client/internal/tui"
+	"golang.org/x/term"
 )
 
-// RunWithTUI executes the BitTorrent client with a terminal UI.
-func RunWithTUI(torrentPath, outputDir string, port int, verbose bool) error {
-	runner, err := tui.NewRunner(torrentPath, outputDir, port, verbose)
-	if err != nil {
-		return err
+// ErrIncomplete is returned by Run when the download was stopped (e.g.
+// interrupted) before every piece was verified.
+var ErrIncomplete = errors.New("download stopped before completion")
+
+// Options holds optional settings shared by the headless and TUI runners.
+type Options struct {
+	Storage       storage.Options       // How downloaded files and directories are created
+	Download      download.Options      // Peer connection tuning
+	Media         download.MediaOptions // Fetch the first and last pieces first, then in order
+	VerifyWorkers int                   // Maximum concurrent piece hash checks (0 means one per CPU)
+	SpreadBlocks  bool                  // Start each peer at a different block within a shared piece
+	UDPRetries    int                   // Retransmissions per UDP tracker step (0 keeps the default)
+	Quiet         bool                  // Headless only: suppress all output
+	JSONEvents    bool                  // Headless only: emit one JSON event per line instead of text
+	VerifyMD5     bool                  // Headless only: check files against the torrent's md5sums once complete
+	Seed          bool                  // Headless only: keep serving peers after completion until interrupted
+	AutoQuit      time.Duration         // TUI only: quit this long after completion (0 keeps running)
+	CompleteDir   string                // Move the download here once it completes (empty leaves it in place)
+}
+
+// RunWithTUI executes the BitTorrent client with a terminal UI. When stdout
+// isn't a terminal (e.g. it is piped or redirected to a log file), it falls
+// back to Run instead, so no escape sequences end up in the output.
+// torrentPath may also be a magnet link, whose metadata is fetched before the
+// terminal UI starts.
+func RunWithTUI(torrentPath, outputDir string, port int, verbose bool, opts Options) error {
+	if !isTerminal(os.Stdout) {
+		fmt.Fprintln(os.Stderr, "Output is not a terminal; running without the terminal UI")
+		return Run(torrentPath, outputDir, port, verbose, opts)
 	}
 
+	var t *torrent.TorrentFile
+	var err error
+	if torrent.IsMagnetURI(torrentPath) {
+		// There is nothing for the terminal UI to show until the metadata
+		// arrives, so its progress is printed as Run prints it
+		out := newReporter(os.Stdout, opts)
+		trackerClient := tracker.NewTrackerClientWithOptions(!out.human())
+		if opts.UDPRetries > 0 {
+			trackerClient.SetUDPRetries(opts.UDPRetries)
+		}
+		t, err = fetchMagnet(out, trackerClient, torrentPath, port)
+		if err != nil {
+			return err
+		}
+	} else {
+		t, err = torrent.ParseTorrentFile(torrentPath)
+		if err != nil {
+			return fmt.Errorf("failed to parse torrent file: %w", err)
+		}
+	}
+
+	runner := tui.NewRunnerFor(t, outputDir, port, verbose, tui.Options{
+		Storage:       opts.Storage,
+		Download:      opts.Download,
+		Media:         opts.Media,
+		VerifyWorkers: opts.VerifyWorkers,
+		SpreadBlocks:  opts.SpreadBlocks,
+		UDPRetries:    opts.UDPRetries,
+		AutoQuit:      opts.AutoQuit,
+		CompleteDir:   opts.CompleteDir,
+	})
+
 	return runner.Run()
 }
 
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
 // Run executes the BitTorrent client with the given parameters.
 // This is the main orchestration function that coordinates all components.
-func Run(torrentPath, outputDir string, port int, verbose bool) error {
-	// Parse torrent file
-	fmt.Printf("Parsing torrent file: %s\n", torrentPath)
-	t, err := torrent.ParseTorrentFile(torrentPath)
-	if err != nil {
-		return fmt.Errorf("failed to parse torrent file: %w", err)
+// torrentPath may also be a magnet link. It returns ErrIncomplete if the
+// download is stopped before it completes.
+func Run(torrentPath, outputDir string, port int, verbose bool, opts Options) error {
+	out := newReporter(os.Stdout, opts)
+	quiet := !out.human()
+
+	// Create tracker client
+	trackerClient := tracker.NewTrackerClientWithOptions(quiet)
+	if opts.UDPRetries > 0 {
+		trackerClient.SetUDPRetries(opts.UDPRetries)
+	}
+
+	var t *torrent.TorrentFile
+	var err error
+	if torrent.IsMagnetURI(torrentPath) {
+		t, err = fetchMagnet(out, trackerClient, torrentPath, port)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Parse torrent file
+		out.Printf("Parsing torrent file: %s\n", torrentPath)
+		t, err = torrent.ParseTorrentFile(torrentPath)
+		if err != nil {
+			return fmt.Errorf("failed to parse torrent file: %w", err)
+		}
 	}
 
 	// Print torrent information
-	fmt.Println("\n" + t.String())
+	out.Println("\n" + t.String())
+	out.Event("torrent", map[string]interface{}{
+		"name":         t.Info.Name,
+		"info_hash":    fmt.Sprintf("%x", t.InfoHash),
+		"total_bytes":  t.Info.GetTotalLength(),
+		"total_pieces": t.Info.GetNumPieces(),
+		"private":      t.IsPrivate(),
+	})
 
 	// Create piece manager
 	pieceHashes, err := t.Info.GetPieceHashes()
@@ -45,45 +137,71 @@ func Run(torrentPath, outputDir string, port int, verbose bool) error {
 		return fmt.Errorf("failed to get piece hashes: %w", err)
 	}
 
-	pieceManager := pieces.NewPieceManager(
+	pieceManager := pieces.NewPieceManagerWithOptions(
 		int(t.Info.PieceLength),
 		t.Info.GetTotalLength(),
 		pieceHashes,
+		quiet,
 	)
 
+	// Share one hashing limit between the on-disk check and the download
+	verifier := pieces.NewVerifier(opts.VerifyWorkers)
+	pieceManager.SetVerifier(verifier)
+	pieceManager.SetSpreadBlocks(opts.SpreadBlocks)
+	storageOpts := opts.Storage
+	storageOpts.Verifier = verifier
+
 	// Create file storage
-	fmt.Printf("Setting up file storage in: %s\n", outputDir)
-	fileStorage, err := storage.NewFileStorage(t, outputDir)
+	out.Printf("Setting up file storage in: %s\n", outputDir)
+	fileStorage, err := storage.NewFileStorageWithOptions(t, outputDir, storageOpts)
 	if err != nil {
 		return fmt.Errorf("failed to create file storage: %w", err)
 	}
 	defer fileStorage.Close()
 
-	// Check existing completion
+	// Check existing completion. Data that already verifies complete ends the
+	// run here, before the tracker is contacted.
 	existingBitfield, err := fileStorage.GetCompletionBitfield()
-	if err != nil && verbose {
-		fmt.Printf("Warning: Failed to check existing files: %v\n", err)
-	} else if existingBitfield != nil {
+	if err != nil {
+		out.Printf("Warning: Failed to check existing files, downloading everything: %v\n", err)
+	} else {
 		completed, total, percentage := existingBitfield.GetNumCompletePieces(),
 			existingBitfield.GetNumPieces(), existingBitfield.GetCompletionPercentage()
 
+		if existingBitfield.IsComplete() {
+			out.Println("Download already complete!")
+			out.Printf("Verified %d/%d pieces (%d bytes) in %s\n",
+				completed, total, t.Info.GetTotalLength(), t.GetOutputPath(outputDir))
+			out.Event("already_complete", map[string]interface{}{
+				"completed_pieces": completed,
+				"total_pieces":     total,
+				"total_bytes":      t.Info.GetTotalLength(),
+				"path":             t.GetOutputPath(outputDir),
+			})
+			if opts.VerifyMD5 {
+				err = checkMD5Sums(out, fileStorage)
+				if err != nil {
+					return err
+				}
+			}
+			return moveComplete(out, t, fileStorage, opts.CompleteDir)
+		}
+
 		if completed > 0 {
-			fmt.Printf("Found existing progress: %d/%d pieces (%.1f%%)\n",
+			out.Printf("Found existing progress: %d/%d pieces (%.1f%%)\n",
 				completed, total, percentage)
-
-			if existingBitfield.IsComplete() {
-				fmt.Println("Download already complete!")
-				return nil
-			}
+			pieceManager.SetCompleted(existingBitfield)
 		}
 	}
+	pieceManager.SetStorage(fileStorage)
 
-	// Create tracker client
-	trackerClient := tracker.NewTrackerClient()
-
-	// Create download manager with rarest-first strategy
-	strategy := download.NewRarestFirstStrategy()
-	downloadManager := download.NewDownloadManager(pieceManager, strategy)
+	// Create download manager with rarest-first (or media mode) strategy
+	strategy := download.NewStrategyFor(t.Info.GetNumPieces(), opts.Media)
+	downloadOpts := opts.Download
+	downloadOpts.Quiet = quiet
+	downloadManager := download.NewDownloadManagerWithOptions(pieceManager, strategy, downloadOpts)
+	trackerClient.SetPeerSlots(downloadManager.FreePeerSlots)
+	downloadManager.SetStorage(fileStorage)
 
 	// Set up signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -92,69 +210,123 @@ func Run(torrentPath, outputDir string, port int, verbose bool) error {
 
 	go func() {
 		<-sigChan
-		fmt.Println("\nShutting down...")
+		out.Println("\nShutting down...")
 		cancel()
 	}()
 
+	// Learn more peers from the ones we're connected to. Like the DHT
+	// below, this is off for private torrents (see torrent.IsPrivate).
+	if !t.IsPrivate() {
+		downloadManager.EnablePEX(t.InfoHash, trackerClient.GetPeerID(), port)
+	}
+
+	// Trackerless torrents find peers through the DHT instead. Private
+	// torrents never use it.
+	var dhtNode *dht.Node
+	if t.IsTrackerless() && !t.IsPrivate() {
+		out.Println("Joining the DHT...")
+		dhtNode, err = dht.Join(ctx, port, dht.BootstrapNodes(t), quiet)
+		if err != nil {
+			return fmt.Errorf("failed to join the DHT: %w", err)
+		}
+		defer dhtNode.Close()
+		downloadManager.SetDHT(dhtNode.Port(), dhtNode.AddNode)
+	}
+
 	// Start download
-	fmt.Println("Starting download...")
+	out.Println("Starting download...")
 	downloadManager.Start()
 	defer downloadManager.Stop()
 
-	// Get initial peers from tracker
-	fmt.Println("Contacting tracker...")
-	trackerResp, err := trackerClient.GetPeers(t, port, "started")
+	if out.jsonEvents {
+		go out.forwardEvents(downloadManager, ctx.Done())
+	}
+
+	// Accept inbound peers on the port we announce to the tracker
+	listener, err := peer.ListenWithOptions(fmt.Sprintf(":%d", port), t.InfoHash, trackerClient.GetPeerID(), quiet, downloadManager.PeerOptions())
 	if err != nil {
-		return fmt.Errorf("failed to get peers from tracker: %w", err)
+		out.Printf("Warning: not accepting inbound peers: %v\n", err)
+	} else {
+		defer listener.Close()
+		go listener.Serve(downloadManager.AddInboundPeer)
+	}
+
+	// Get initial peers from tracker, or the DHT
+	var trackerResp *tracker.TrackerResponse
+	if dhtNode != nil {
+		out.Println("Looking up peers in the DHT...")
+		trackerResp, err = dhtNode.Announce(ctx, t.InfoHash)
+		if err != nil {
+			return fmt.Errorf("failed to get peers from the DHT: %w", err)
+		}
+	} else {
+		out.Println("Contacting tracker...")
+		trackerResp, err = trackerClient.GetPeers(ctx, t, port, "started", downloadManager.AnnounceStats())
+		if err != nil {
+			return fmt.Errorf("failed to get peers from tracker: %w", err)
+		}
 	}
 
-	fmt.Printf("Tracker response: %d seeders, %d leechers, %d peers\n",
+	out.Printf("Tracker response: %d seeders, %d leechers, %d peers\n",
 		trackerResp.Complete, trackerResp.Incomplete, len(trackerResp.Peers))
+	out.Event("tracker_response", map[string]interface{}{
+		"seeders":  trackerResp.Complete,
+		"leechers": trackerResp.Incomplete,
+		"peers":    len(trackerResp.Peers),
+	})
 
 	if len(trackerResp.Peers) == 0 {
 		return fmt.Errorf("no peers found")
 	}
 
 	if verbose {
-		fmt.Printf("Found peers: %s\n", tracker.FormatPeers(trackerResp.Peers))
+		out.Printf("Found peers: %s\n", tracker.FormatPeers(trackerResp.Peers))
 	}
 
-	// Add peers to download manager
-	downloadManager.AddPeers(trackerResp.Peers, t.InfoHash, trackerClient.GetPeerID())
+	// Connect to peers and keep announcing
+	go downloadManager.RunAnnouncer(ctx, trackerResp, t.InfoHash, trackerClient.GetPeerID(),
+		func() (*tracker.TrackerResponse, error) {
+			var resp *tracker.TrackerResponse
+			var err error
+			if dhtNode != nil {
+				resp, err = dhtNode.Announce(ctx, t.InfoHash)
+			} else {
+				resp, err = trackerClient.GetPeers(ctx, t, port, "", downloadManager.AnnounceStats())
+			}
+			if err != nil && verbose {
+				out.Printf("Tracker announce failed: %v\n", err)
+			}
+			return resp, err
+		}, trackerClient)
 
-	// Progress reporting
+	// Stop once every piece is verified, unless we're to keep seeding, and
+	// then once a seeding limit is reached
 	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				if !downloadManager.IsActive() {
-					return
-				}
-
-				completed, total, percentage := downloadManager.GetProgress()
-				stats := downloadManager.GetStats()
+		if downloadManager.WaitComplete(ctx) != nil {
+			return
+		}
+		if !opts.Seed {
+			out.Println("Download completed!")
+			cancel()
+			return
+		}
 
-				fmt.Printf("Progress: %d/%d pieces (%.1f%%) | Speed: %.2f KB/s | Peers: %d\n",
-					completed, total, percentage,
-					stats.DownloadSpeed/1024, stats.PeersConnected)
+		trackerClient.GetPeers(ctx, t, port, "completed", downloadManager.AnnounceStats())
+		if opts.Download.SeedRatioLimit > 0 || opts.Download.SeedTimeLimit > 0 {
+			out.Println("Download completed! Seeding until a seeding limit is reached or interrupted")
+		} else {
+			out.Println("Download completed! Seeding until interrupted")
+		}
+		out.Event("seeding", progressFields(downloadManager))
 
-				if pieceManager.IsComplete() {
-					fmt.Println("Download completed!")
-					cancel()
-					return
-				}
-			}
+		if downloadManager.WaitSeedLimit(ctx) == nil {
+			cancel()
 		}
 	}()
 
-	// Periodic tracker announcements
+	// Progress reporting
 	go func() {
-		ticker := time.NewTicker(time.Duration(trackerResp.Interval) * time.Second)
+		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 
 		for {
@@ -166,17 +338,13 @@ func Run(torrentPath, outputDir string, port int, verbose bool) error {
 					return
 				}
 
-				resp, err := trackerClient.GetPeers(t, port, "")
-				if err != nil {
-					if verbose {
-						fmt.Printf("Tracker announce failed: %v\n", err)
-					}
-					continue
-				}
+				progress := downloadManager.GetProgress()
+				stats := downloadManager.GetStats()
 
-				if len(resp.Peers) > 0 {
-					downloadManager.AddPeers(resp.Peers, t.InfoHash, trackerClient.GetPeerID())
-				}
+				out.Printf("Progress: %d/%d pieces (%.1f%%) | Speed: %.2f KB/s | Uploaded: %d bytes | Peers: %d\n",
+					progress.CompletedPieces, progress.TotalPieces, progress.Percentage(),
+					stats.DownloadSpeed/1024, stats.UploadedBytes, stats.PeersConnected)
+				out.Event("progress", progressFields(downloadManager))
 			}
 		}
 	}()
@@ -185,15 +353,94 @@ func Run(torrentPath, outputDir string, port int, verbose bool) error {
 	<-ctx.Done()
 
 	// Final tracker announce
-	if pieceManager.IsComplete() {
-		trackerClient.GetPeers(t, port, "completed")
-		fmt.Println("Download completed successfully!")
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), tracker.StopAnnounceTimeout)
+	defer stopCancel()
+	if downloadManager.IsComplete() {
+		if opts.Seed {
+			trackerClient.GetPeers(stopCtx, t, port, "stopped", downloadManager.AnnounceStats())
+		} else {
+			trackerClient.GetPeers(stopCtx, t, port, "completed", downloadManager.AnnounceStats())
+		}
+		out.Println("Download completed successfully!")
+		out.Summary("complete", downloadManager)
+		out.Event("completed", summaryFields(downloadManager))
+		if opts.VerifyMD5 {
+			err = checkMD5Sums(out, fileStorage)
+			if err != nil {
+				return err
+			}
+		}
+		err = moveComplete(out, t, fileStorage, opts.CompleteDir)
+		if err != nil {
+			return err
+		}
 	} else {
-		trackerClient.GetPeers(t, port, "stopped")
-		completed, total, percentage := downloadManager.GetProgress()
-		fmt.Printf("Download stopped at %.1f%% (%d/%d pieces)\n",
-			percentage, completed, total)
+		trackerClient.GetPeers(stopCtx, t, port, "stopped", downloadManager.AnnounceStats())
+		progress := downloadManager.GetProgress()
+		out.Printf("Download stopped at %.1f%% (%d/%d pieces)\n",
+			progress.Percentage(), progress.CompletedPieces, progress.TotalPieces)
+		out.Summary("stopped", downloadManager)
+		fields := summaryFields(downloadManager)
+		fields["state"] = "stopped"
+		out.Event("stopped", fields)
+		return ErrIncomplete
+	}
+
+	return nil
+}
+
+// locateData returns the directory holding t's data: outputDir, unless
+// nothing of the torrent is there but it is in completeDir, where a
+// finished download was moved.
+func locateData(t *torrent.TorrentFile, outputDir, completeDir string) string {
+	if completeDir == "" {
+		return outputDir
+	}
+	if _, err := os.Stat(t.GetOutputPath(outputDir)); err == nil {
+		return outputDir
+	}
+	if _, err := os.Stat(t.GetOutputPath(completeDir)); err == nil {
+		return completeDir
+	}
+	return outputDir
+}
+
+// moveComplete moves a finished download to completeDir, unless it is empty.
+func moveComplete(out *reporter, t *torrent.TorrentFile, fileStorage *storage.FileStorage, completeDir string) error {
+	if completeDir == "" {
+		return nil
+	}
+
+	out.Printf("Moving %s to %s\n", t.Info.Name, completeDir)
+	err := fileStorage.MoveTo(completeDir)
+	if err != nil {
+		return fmt.Errorf("failed to move download to %s: %w", completeDir, err)
+	}
+	out.Event("moved", map[string]interface{}{
+		"path": t.GetOutputPath(completeDir),
+	})
+	return nil
+}
+
+// checkMD5Sums verifies the downloaded files against the md5sums listed in
+// the torrent, if it lists any.
+func checkMD5Sums(out *reporter, fileStorage *storage.FileStorage) error {
+	checked, err := fileStorage.VerifyMD5Sums()
+	if err != nil {
+		out.Event("md5_failed", map[string]interface{}{
+			"checked_files": checked,
+			"error":         err.Error(),
+		})
+		return err
 	}
 
+	if checked == 0 {
+		out.Println("Torrent lists no md5sums; nothing to check")
+	} else {
+		out.Printf("md5sums match for %d files\n", checked)
+	}
+	out.Event("md5_verified", map[string]interface{}{
+		"checked_files": checked,
+	})
 	return nil
 }
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+	"github.com/yashkadam007/bittorrent-client/internal/download"
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// testPieceLength keeps test torrents to a few pieces
+const testPieceLength = 32 * 1024
+
+// testTorrent describes a single-file torrent written by writeTorrent.
+type testTorrent struct {
+	path     string   // The .torrent file
+	name     string   // The file's name
+	data     []byte   // The file's content
+	infoHash [20]byte // SHA1 of the bencoded info dictionary
+}
+
+// writeTorrent writes a torrent for size bytes of random data named name
+// into a temporary directory. Extra keys, such as "announce", are added to
+// the top-level dictionary.
+func writeTorrent(t *testing.T, name string, size int, extra map[string]interface{}) *testTorrent {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+
+	var hashes []byte
+	for start := 0; start < len(data); start += testPieceLength {
+		sum := sha1.Sum(data[start:min(start+testPieceLength, len(data))])
+		hashes = append(hashes, sum[:]...)
+	}
+	info := map[string]interface{}{
+		"name":         name,
+		"length":       size,
+		"piece length": testPieceLength,
+		"pieces":       hashes,
+	}
+
+	var infoBuf bytes.Buffer
+	if err := bencode.NewEncoder(&infoBuf).Encode(info); err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]interface{}{"info": bencode.RawValue(infoBuf.Bytes())}
+	for key, value := range extra {
+		meta[key] = value
+	}
+
+	var buf bytes.Buffer
+	if err := bencode.NewEncoder(&buf).Encode(meta); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name+".torrent")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &testTorrent{path: path, name: name, data: data, infoHash: sha1.Sum(infoBuf.Bytes())}
+}
+
+// ReadBlock reads a block of the torrent's data, so a testTorrent can be
+// served by a download.Seeder.
+func (tt *testTorrent) ReadBlock(pieceIndex, begin, length int) ([]byte, error) {
+	start := pieceIndex*testPieceLength + begin
+	if start < 0 || start+length > len(tt.data) {
+		return nil, fmt.Errorf("block %d+%d of piece %d out of range", begin, length, pieceIndex)
+	}
+	return tt.data[start : start+length], nil
+}
+
+// seedTo connects to the client listening at addr and serves it the whole
+// torrent until the test ends.
+func (tt *testTorrent) seedTo(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := peer.Connect(addr, tt.infoHash, [20]byte{'S'})
+	if err != nil {
+		t.Fatalf("seed couldn't connect: %v", err)
+	}
+
+	have := pieces.NewBitfield((len(tt.data) + testPieceLength - 1) / testPieceLength)
+	have.SetAll()
+	seeder := download.NewSeeder(have, tt, true)
+	t.Cleanup(seeder.Close)
+	go seeder.ServePeer(conn)
+}
+
+// writeData writes the torrent's whole file into dir, as a finished
+// download leaves it.
+func (tt *testTorrent) writeData(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, tt.name), tt.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// freePort returns a TCP port that nothing is listening on.
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// testTracker starts an HTTP tracker that answers every announce with
+// peers, and returns its announce URL and a channel receiving each
+// announce's query.
+func testTracker(t *testing.T, peers ...net.TCPAddr) (string, <-chan url.Values) {
+	t.Helper()
+	var compact []byte
+	for _, p := range peers {
+		compact = append(compact, p.IP.To4()...)
+		compact = append(compact, byte(p.Port>>8), byte(p.Port))
+	}
+
+	announces := make(chan url.Values, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case announces <- r.URL.Query():
+		default:
+		}
+		bencode.NewEncoder(w).Encode(map[string]interface{}{
+			"interval": 1800,
+			"peers":    compact,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/announce", announces
+}
+
+// captureStdout runs f with os.Stdout redirected, returning what it printed.
+func captureStdout(t *testing.T, f func()) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	printed := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- data
+	}()
+
+	f()
+	w.Close()
+	return <-printed
+}
+
+func TestRunAlreadyComplete(t *testing.T) {
+	announceURL, announces := testTracker(t)
+	tt := writeTorrent(t, "complete.bin", 3*testPieceLength+100, map[string]interface{}{"announce": announceURL})
+	outputDir := t.TempDir()
+	tt.writeData(t, outputDir)
+
+	var err error
+	output := captureStdout(t, func() {
+		err = Run(tt.path, outputDir, freePort(t), false, Options{JSONEvents: true})
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	select {
+	case query := <-announces:
+		t.Errorf("tracker contacted for complete data: %v", query)
+	default:
+	}
+	if !bytes.Contains(output, []byte(`"type":"already_complete"`)) {
+		t.Errorf("no already_complete event in:\n%s", output)
+	}
+}
+
+func TestRunWithTUIFallsBackWithoutTerminal(t *testing.T) {
+	announceURL, _ := testTracker(t)
+	tt := writeTorrent(t, "tui.bin", 2*testPieceLength, map[string]interface{}{"announce": announceURL})
+	outputDir := t.TempDir()
+	tt.writeData(t, outputDir)
+
+	// Under captureStdout, stdout is a pipe
+	var err error
+	output := captureStdout(t, func() {
+		if isTerminal(os.Stdout) {
+			t.Error("pipe taken for a terminal")
+		}
+		err = RunWithTUI(tt.path, outputDir, freePort(t), false, Options{})
+	})
+	if err != nil {
+		t.Fatalf("RunWithTUI: %v", err)
+	}
+
+	// Run's plain output, with no terminal UI escape sequences
+	if !bytes.Contains(output, []byte("Download already complete!")) {
+		t.Errorf("headless output missing:\n%s", output)
+	}
+	if bytes.Contains(output, []byte("\x1b[")) {
+		t.Errorf("escape sequences in the output:\n%q", output)
+	}
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if isTerminal(file) {
+		t.Error("regular file taken for a terminal")
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+	"github.com/yashkadam007/bittorrent-client/internal/qrcode"
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+)
+
+const (
+	maxShownString = 128 // Longer byte strings are truncated
+	maxShownHex    = 32  // Bytes shown for binary strings
+	qrQuietZone    = 2   // Light modules around a printed QR code
+)
+
+// Info prints a torrent's metadata. With raw set it prints the full decoded
+// bencode tree instead, including keys the parser doesn't know about.
+func Info(torrentPath string, raw bool, w io.Writer) error {
+	if !raw {
+		t, err := torrent.ParseTorrentFile(torrentPath)
+		if err != nil {
+			return fmt.Errorf("failed to parse torrent file: %w", err)
+		}
+		fmt.Fprintln(w, t.String())
+		return nil
+	}
+
+	file, err := os.Open(torrentPath)
+	if err != nil {
+		return fmt.Errorf("failed to open torrent file: %w", err)
+	}
+	defer file.Close()
+
+	data, err := bencode.NewDecoder(file).DecodeOnly()
+	if err != nil {
+		return fmt.Errorf("failed to decode torrent file: %w", err)
+	}
+
+	dumpValue(w, "", data, 0)
+	fmt.Fprintln(w)
+	return nil
+}
+
+// Magnet prints a torrent's magnet link, followed by a QR code of it if qr is set.
+func Magnet(torrentPath string, qr bool, w io.Writer) error {
+	t, err := torrent.ParseTorrentFile(torrentPath)
+	if err != nil {
+		return fmt.Errorf("failed to parse torrent file: %w", err)
+	}
+
+	magnet := t.MagnetURI()
+	fmt.Fprintln(w, magnet)
+
+	if qr {
+		code, err := qrcode.Encode([]byte(magnet))
+		if err != nil {
+			return fmt.Errorf("failed to encode QR code: %w", err)
+		}
+		fmt.Fprint(w, code.Terminal(qrQuietZone))
+	}
+
+	return nil
+}
+
+// dumpValue writes a decoded bencode value at the given indentation depth.
+// key names the enclosing dictionary entry, if any, so well-known binary
+// fields can be summarized.
+func dumpValue(w io.Writer, key string, value interface{}, depth int) {
+	indent := strings.Repeat("  ", depth)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			fmt.Fprint(w, "{}")
+			return
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintln(w, "{")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s  %s: ", indent, formatKey(k))
+			dumpValue(w, k, v[k], depth+1)
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s}", indent)
+
+	case []interface{}:
+		if len(v) == 0 {
+			fmt.Fprint(w, "[]")
+			return
+		}
+
+		fmt.Fprintln(w, "[")
+		for _, item := range v {
+			fmt.Fprintf(w, "%s  ", indent)
+			dumpValue(w, "", item, depth+1)
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s]", indent)
+
+	case []byte:
+		fmt.Fprint(w, formatBytes(key, v))
+
+	case int64:
+		fmt.Fprint(w, v)
+
+	default:
+		fmt.Fprintf(w, "%v", v)
+	}
+}
+
+// formatKey quotes a dictionary key only when it isn't plain printable text.
+func formatKey(key string) string {
+	if key == "" || !isPrintable([]byte(key)) || strings.ContainsAny(key, ":\"") {
+		return fmt.Sprintf("%q", key)
+	}
+	return key
+}
+
+// formatBytes renders a byte string: text is quoted (and truncated if long),
+// binary data is shown as a length and a hex prefix.
+func formatBytes(key string, b []byte) string {
+	if key == "pieces" && len(b)%20 == 0 {
+		return fmt.Sprintf("<%d bytes, %d SHA1 hashes>", len(b), len(b)/20)
+	}
+
+	if isPrintable(b) {
+		if len(b) > maxShownString {
+			return fmt.Sprintf("%q... (%d bytes)", b[:maxShownString], len(b))
+		}
+		return fmt.Sprintf("%q", b)
+	}
+
+	if len(b) > maxShownHex {
+		return fmt.Sprintf("<%d bytes: %x...>", len(b), b[:maxShownHex])
+	}
+	return fmt.Sprintf("<%d bytes: %x>", len(b), b)
+}
+
+// isPrintable reports whether b is valid UTF-8 text without control characters.
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < 0x20 && r != '\t' && r != '\n' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMagnetParams(t *testing.T) {
+	tt := writeTorrent(t, "sample file.iso", 100*1024, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"announce-list": []interface{}{
+			[]interface{}{"http://tracker.example/announce"},
+			[]interface{}{"udp://backup.example:6969/announce"},
+		},
+	})
+
+	var out bytes.Buffer
+	if err := Magnet(tt.path, false, &out); err != nil {
+		t.Fatal(err)
+	}
+	link := strings.TrimSpace(out.String())
+	if !strings.HasPrefix(link, "magnet:?") {
+		t.Fatalf("%q isn't a magnet link", link)
+	}
+
+	params, err := url.ParseQuery(strings.TrimPrefix(link, "magnet:?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := params.Get("xt"), fmt.Sprintf("urn:btih:%x", tt.infoHash); got != want {
+		t.Errorf("xt = %q, want %q", got, want)
+	}
+	if got := params.Get("dn"); got != tt.name {
+		t.Errorf("dn = %q, want %q", got, tt.name)
+	}
+	// Each tracker once, in tier order
+	want := []string{"http://tracker.example/announce", "udp://backup.example:6969/announce"}
+	if got := params["tr"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("tr = %q, want %q", got, want)
+	}
+	// Spaces are encoded as %20, which every client reads as a space
+	if strings.Contains(link, "+") {
+		t.Errorf("%q encodes a space as +", link)
+	}
+}
+
+func TestInfoRaw(t *testing.T) {
+	tt := writeTorrent(t, "sample.iso", 100*1024, map[string]interface{}{
+		"announce":      "http://tracker.example/announce",
+		"creation date": 1700000000,
+		"url-list":      []interface{}{"http://mirror.example/sample.iso", []interface{}{}},
+		"x-binary":      []byte{0x00, 0x01, 0xfe, 0xff},
+		"x-big-binary":  bytes.Repeat([]byte{0xab}, 40),
+		"x-long":        strings.Repeat("a", 130),
+		"x-empty":       map[string]interface{}{},
+		"x:odd key":     1,
+	})
+
+	var out bytes.Buffer
+	if err := Info(tt.path, true, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  announce: "http://tracker.example/announce"
+  creation date: 1700000000
+  info: {
+    length: 102400
+    name: "sample.iso"
+    piece length: 32768
+    pieces: <80 bytes, 4 SHA1 hashes>
+  }
+  url-list: [
+    "http://mirror.example/sample.iso"
+    []
+  ]
+  x-big-binary: <40 bytes: ` + strings.Repeat("ab", 32) + `...>
+  x-binary: <4 bytes: 0001feff>
+  x-empty: {}
+  x-long: "` + strings.Repeat("a", 128) + `"... (130 bytes)
+  "x:odd key": 1
+}
+`
+	if got := out.String(); got != want {
+		t.Errorf("Info printed:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yashkadam007/bittorrent-client/internal/dht"
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+	"github.com/yashkadam007/bittorrent-client/internal/tracker"
+)
+
+// metadataPeers caps how many peers are asked for a magnet link's metadata
+// at once.
+const metadataPeers = 10
+
+// fetchMagnet resolves a magnet link passed in place of a .torrent file. The
+// link names the torrent and its trackers; the info dictionary (piece hashes
+// and file layout) is fetched from the peers the trackers return, or that
+// the DHT knows of when the link names no trackers.
+func fetchMagnet(out *reporter, trackerClient *tracker.TrackerClient, uri string, port int) (*torrent.TorrentFile, error) {
+	magnet, err := torrent.ParseMagnetURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse magnet link: %w", err)
+	}
+
+	out.Println("\n" + magnet.String())
+	out.Event("magnet", map[string]interface{}{
+		"name":      magnet.Name,
+		"info_hash": fmt.Sprintf("%x", magnet.InfoHash),
+		"trackers":  magnet.Trackers,
+	})
+
+	var peers []tracker.PeerInfo
+	if len(magnet.Trackers) == 0 {
+		// Whether the torrent is private is only known from its metadata;
+		// a private torrent's magnet link would name its tracker
+		out.Println("Looking up metadata peers in the DHT...")
+		peers, err = dht.FindPeers(magnet.InfoHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get peers from the DHT: %w", err)
+		}
+	} else {
+		out.Println("Contacting tracker for metadata peers...")
+		// The size is unknown until the metadata arrives; any nonzero left
+		// keeps the tracker from counting us as a seed
+		resp, err := trackerClient.GetPeers(context.Background(), magnet.TorrentFile(), port, "started", tracker.AnnounceStats{Left: 1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get peers from tracker: %w", err)
+		}
+		peers = resp.Peers
+	}
+
+	var candidates []string
+	for _, p := range peers {
+		if tracker.IsValidPeer(p) && len(candidates) < metadataPeers {
+			candidates = append(candidates, p.Addr())
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no peers found")
+	}
+
+	out.Printf("Fetching metadata from %d peers...\n", len(candidates))
+	info, err := requestMetadata(candidates, magnet.InfoHash, trackerClient.GetPeerID())
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := magnet.WithMetadata(info)
+	if err != nil {
+		return nil, err
+	}
+	out.Printf("Fetched metadata: %d bytes\n", len(info))
+	return t, nil
+}
+
+// requestMetadata asks every candidate peer for the info dictionary at once
+// and returns the first copy that arrives. The info hash is checked by
+// RequestMetadata, so any peer's answer will do.
+func requestMetadata(candidates []string, infoHash, peerID [20]byte) ([]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan []byte, len(candidates))
+	for _, addr := range candidates {
+		go func(addr string) {
+			conn, err := peer.ConnectContext(ctx, addr, infoHash, peerID)
+			if err != nil {
+				results <- nil
+				return
+			}
+			defer conn.Close()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+
+			info, err := conn.RequestMetadata()
+			if err != nil {
+				results <- nil
+				return
+			}
+			results <- info
+		}(addr)
+	}
+
+	for range candidates {
+		if info := <-results; info != nil {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("none of %d peers sent the torrent's metadata", len(candidates))
+}
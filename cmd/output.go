@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/download"
+)
+
+// reporter routes the headless runner's output. Depending on the options it
+// prints human-readable lines, one JSON object per line, or nothing at all.
+type reporter struct {
+	w          io.Writer
+	quiet      bool // Suppress all output
+	jsonEvents bool // Emit JSON event lines instead of human-readable text
+	mutex      sync.Mutex
+}
+
+func newReporter(w io.Writer, opts Options) *reporter {
+	return &reporter{
+		w:          w,
+		quiet:      opts.Quiet,
+		jsonEvents: opts.JSONEvents && !opts.Quiet,
+	}
+}
+
+// human reports whether human-readable output is enabled.
+func (r *reporter) human() bool {
+	return !r.quiet && !r.jsonEvents
+}
+
+// Printf writes human-readable output.
+func (r *reporter) Printf(format string, args ...interface{}) {
+	if !r.human() {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	fmt.Fprintf(r.w, format, args...)
+}
+
+// Println writes a human-readable line.
+func (r *reporter) Println(args ...interface{}) {
+	if !r.human() {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	fmt.Fprintln(r.w, args...)
+}
+
+// Event writes a single JSON event line with the given type and fields.
+func (r *reporter) Event(eventType string, fields map[string]interface{}) {
+	if !r.jsonEvents {
+		return
+	}
+
+	event := map[string]interface{}{
+		"type": eventType,
+		"time": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.w.Write(append(line, '\n'))
+}
+
+// progressFields snapshots the download's progress for a JSON event.
+func progressFields(dm *download.DownloadManager) map[string]interface{} {
+	progress := dm.GetProgress()
+	stats := dm.GetStats()
+
+	return map[string]interface{}{
+		"completed_pieces": progress.CompletedPieces,
+		"total_pieces":     progress.TotalPieces,
+		"percentage":       progress.Percentage(),
+		"downloaded_bytes": stats.DownloadedBytes,
+		"verified_bytes":   stats.VerifiedBytes,
+		"uploaded_bytes":   stats.UploadedBytes,
+		"ratio":            stats.Ratio,
+		"download_speed":   stats.DownloadSpeed,
+		"peers":            stats.PeersConnected,
+		"state":            downloadState(dm),
+	}
+}
+
+// summaryFields is progressFields plus totals for the whole run, for the
+// event that ends it.
+func summaryFields(dm *download.DownloadManager) map[string]interface{} {
+	fields := progressFields(dm)
+	elapsed, averageSpeed := runTotals(dm)
+	fields["elapsed_seconds"] = elapsed
+	fields["average_speed"] = averageSpeed
+	return fields
+}
+
+// Summary writes the human-readable end of a run as a single line of
+// key=value pairs, so scripts can parse it without JSON events.
+func (r *reporter) Summary(status string, dm *download.DownloadManager) {
+	stats := dm.GetStats()
+	elapsed, averageSpeed := runTotals(dm)
+	r.Printf("Summary: status=%s downloaded_bytes=%d verified_bytes=%d uploaded_bytes=%d ratio=%.2f elapsed_seconds=%.1f average_speed=%.0f\n",
+		status, stats.DownloadedBytes, stats.VerifiedBytes, stats.UploadedBytes, stats.Ratio, elapsed, averageSpeed)
+}
+
+// runTotals returns how long the download has run, in seconds, and its
+// average download speed over that time, in bytes per second.
+func runTotals(dm *download.DownloadManager) (float64, float64) {
+	stats := dm.GetStats()
+	elapsed := time.Since(stats.StartTime).Seconds()
+	if elapsed <= 0 {
+		return 0, 0
+	}
+	return elapsed, float64(stats.DownloadedBytes) / elapsed
+}
+
+// downloadState names what the download is doing: "downloading", "paused",
+// "complete" or "stopped".
+func downloadState(dm *download.DownloadManager) string {
+	switch {
+	case dm.IsComplete():
+		return "complete"
+	case !dm.IsActive():
+		return "stopped"
+	case dm.PauseReason() != nil:
+		return "paused"
+	default:
+		return "downloading"
+	}
+}
+
+// forwardEvents turns download events into JSON event lines until done is closed.
+func (r *reporter) forwardEvents(dm *download.DownloadManager, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case event := <-dm.Events():
+			switch event.Type {
+			case download.EventPeerConnected, download.EventPeerDisconnected:
+				fields := progressFields(dm)
+				fields["peer"] = event.Peer
+				r.Event(string(event.Type), fields)
+			case download.EventPieceCompleted:
+				fields := progressFields(dm)
+				fields["piece"] = event.Piece
+				r.Event(string(event.Type), fields)
+			case download.EventPaused:
+				fields := progressFields(dm)
+				fields["error"] = event.Error
+				r.Event(string(event.Type), fields)
+			default:
+				r.Event(string(event.Type), progressFields(dm))
+			}
+		}
+	}
+}
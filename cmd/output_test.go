@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJSONEventsDownload(t *testing.T) {
+	// The tracker's only peer is on loopback, which the client doesn't dial;
+	// the seed connects in instead
+	announceURL, announces := testTracker(t, net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	tt := writeTorrent(t, "events.bin", 3*testPieceLength+100, map[string]interface{}{"announce": announceURL})
+	port := freePort(t)
+	outputDir := t.TempDir()
+
+	var err error
+	output := captureStdout(t, func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- Run(tt.path, outputDir, port, false, Options{JSONEvents: true})
+		}()
+
+		select {
+		case <-announces:
+		case <-time.After(10 * time.Second):
+			t.Fatal("no announce")
+		}
+		tt.seedTo(t, fmt.Sprintf("127.0.0.1:%d", port))
+
+		select {
+		case err = <-done:
+		case <-time.After(30 * time.Second):
+			t.Fatal("download didn't finish")
+		}
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// Every line is an event object; nothing else is printed
+	seen := make(map[string]bool)
+	var first string
+	var last map[string]interface{}
+	for i, line := range bytes.Split(bytes.TrimSpace(output), []byte("\n")) {
+		var event map[string]interface{}
+		if err := json.Unmarshal(line, &event); err != nil {
+			t.Fatalf("line %d isn't JSON: %q", i+1, line)
+		}
+		eventType, _ := event["type"].(string)
+		if eventType == "" || event["time"] == nil {
+			t.Errorf("line %d has no type or time: %q", i+1, line)
+		}
+		if first == "" {
+			first = eventType
+		}
+		seen[eventType] = true
+		last = event
+	}
+
+	if first != "torrent" {
+		t.Errorf("first event %q, want torrent", first)
+	}
+	for _, eventType := range []string{"tracker_response", "peer_connected"} {
+		if !seen[eventType] {
+			t.Errorf("no %s event", eventType)
+		}
+	}
+	if last["type"] != "completed" || last["state"] != "complete" {
+		t.Errorf("last event %v, want completed", last)
+	}
+	if last["total_pieces"] != float64(4) || last["completed_pieces"] != float64(4) {
+		t.Errorf("completed event reports %v/%v pieces, want 4/4", last["completed_pieces"], last["total_pieces"])
+	}
+
+	data, readErr := os.ReadFile(filepath.Join(outputDir, tt.name))
+	if readErr != nil || !bytes.Equal(data, tt.data) {
+		t.Errorf("downloaded data doesn't match (%v)", readErr)
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+	"github.com/yashkadam007/bittorrent-client/internal/tracker"
+)
+
+// probeWait is how long ProbePeer waits for the peer to announce its pieces.
+const probeWait = 5 * time.Second
+
+// ProbePeer connects to a single peer, reads which of the torrent's pieces
+// it claims to have, and reports that along with its client ID. Nothing is
+// downloaded; it is meant for checking what one member of the swarm offers.
+func ProbePeer(torrentPath, addr string, w io.Writer) error {
+	t, err := torrent.ParseTorrentFile(torrentPath)
+	if err != nil {
+		return fmt.Errorf("failed to parse torrent file: %w", err)
+	}
+	numPieces := t.Info.GetNumPieces()
+
+	peerID := tracker.NewTrackerClientWithOptions(true).GetPeerID()
+	conn, err := peer.Connect(addr, t.InfoHash, peerID)
+	if err != nil {
+		return fmt.Errorf("failed to probe %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetNumPieces(numPieces)
+
+	// Closing the connection is what ends the wait, since ReceiveMessage
+	// sets its own read deadline
+	timer := time.AfterFunc(probeWait, func() { conn.Close() })
+	defer timer.Stop()
+
+	// Peers usually send a bitfield straight after the handshake, but may
+	// send have messages instead (or nothing at all, if they have no pieces)
+	gotBitfield := false
+	for !gotBitfield {
+		msg, err := conn.ReceiveMessage()
+		if err != nil {
+			break
+		}
+
+		err = conn.HandleMessage(msg)
+		if err != nil {
+			return fmt.Errorf("peer %s sent an invalid message: %w", addr, err)
+		}
+		gotBitfield = msg.Type == peer.MsgBitfield || msg.Type == peer.MsgHaveAll || msg.Type == peer.MsgHaveNone
+	}
+
+	have := pieces.NewBitfieldFromBytes(conn.GetBitfield(), numPieces).GetNumCompletePieces()
+	remoteID := conn.GetRemotePeerID()
+
+	fmt.Fprintf(w, "Peer: %s\n", addr)
+	fmt.Fprintf(w, "Client: %s\n", conn.ClientName())
+	fmt.Fprintf(w, "Capabilities: %s\n", peer.FormatCapabilities(conn.GetRemoteReserved()))
+	fmt.Fprintf(w, "Peer ID: %x\n", remoteID)
+	if !gotBitfield {
+		fmt.Fprintf(w, "No bitfield received within %v\n", probeWait)
+	}
+	fmt.Fprintf(w, "Pieces: %d of %d (%.1f%%)\n", have, numPieces, percentOf(have, numPieces))
+
+	return nil
+}
+
+// percentOf returns n as a percentage of total
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/yashkadam007/bittorrent-client/internal/download"
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// seedOn listens on a loopback port as a seed holding the pieces in have,
+// returning its address. peerID is what it handshakes with.
+func (tt *testTorrent) seedOn(t *testing.T, have *pieces.Bitfield, peerID [20]byte) string {
+	t.Helper()
+	listener, err := peer.Listen("127.0.0.1:0", tt.infoHash, peerID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	seeder := download.NewSeeder(have, tt, true)
+	t.Cleanup(seeder.Close)
+	go listener.Serve(seeder.ServePeer)
+	return listener.Addr().String()
+}
+
+func TestProbePeer(t *testing.T) {
+	tt := writeTorrent(t, "probe.bin", 4*testPieceLength, nil)
+	var peerID [20]byte
+	copy(peerID[:], "-TR3000-probetest123")
+
+	tests := []struct {
+		name string
+		have []int // Pieces the seed holds
+		want string
+	}{
+		{"complete", []int{0, 1, 2, 3}, "Pieces: 4 of 4 (100.0%)"},
+		{"partial", []int{1, 3}, "Pieces: 2 of 4 (50.0%)"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			have := pieces.NewBitfield(4)
+			for _, i := range tc.have {
+				have.SetPiece(i)
+			}
+			addr := tt.seedOn(t, have, peerID)
+
+			var out bytes.Buffer
+			if err := ProbePeer(tt.path, addr, &out); err != nil {
+				t.Fatal(err)
+			}
+			report := out.String()
+			for _, want := range []string{
+				"Peer: " + addr + "\n",
+				"Client: TR 3000\n",
+				fmt.Sprintf("Peer ID: %x\n", peerID),
+				tc.want + "\n",
+			} {
+				if !strings.Contains(report, want) {
+					t.Errorf("report lacks %q:\n%s", want, report)
+				}
+			}
+			if strings.Contains(report, "No bitfield") {
+				t.Errorf("bitfield not received:\n%s", report)
+			}
+		})
+	}
+
+	if err := ProbePeer(tt.path, fmt.Sprintf("127.0.0.1:%d", freePort(t)), &bytes.Buffer{}); err == nil {
+		t.Error("probing a closed port succeeded")
+	}
+}
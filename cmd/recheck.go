@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+	"github.com/yashkadam007/bittorrent-client/internal/storage"
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+)
+
+// maxListedFailures caps how many failed piece indices Recheck prints.
+const maxListedFailures = 20
+
+// Recheck re-hashes every piece in outputDir (or opts.CompleteDir, if the
+// download was moved there), ignoring the resume file, and rewrites the
+// resume file from the result. It reports pieces that were
+// recorded as verified but no longer pass, e.g. after on-disk corruption.
+func Recheck(torrentPath, outputDir string, opts Options) error {
+	out := newReporter(os.Stdout, opts)
+
+	t, err := torrent.ParseTorrentFile(torrentPath)
+	if err != nil {
+		return fmt.Errorf("failed to parse torrent file: %w", err)
+	}
+	outputDir = locateData(t, outputDir, opts.CompleteDir)
+
+	storageOpts := opts.Storage
+	storageOpts.Verifier = pieces.NewVerifier(opts.VerifyWorkers)
+	fileStorage, err := storage.NewFileStorageWithOptions(t, outputDir, storageOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create file storage: %w", err)
+	}
+	defer fileStorage.Close()
+
+	out.Printf("Rechecking %d pieces in %s\n", t.Info.GetNumPieces(), t.GetOutputPath(outputDir))
+	bitfield, failed, err := fileStorage.Recheck()
+	if err != nil {
+		return fmt.Errorf("recheck failed: %w", err)
+	}
+
+	out.Printf("Verified %d/%d pieces (%.1f%%)\n",
+		bitfield.GetNumCompletePieces(), bitfield.GetNumPieces(), bitfield.GetCompletionPercentage())
+	if len(failed) > 0 {
+		listed := failed
+		if len(listed) > maxListedFailures {
+			listed = listed[:maxListedFailures]
+		}
+		out.Printf("%d previously verified pieces failed: %v", len(failed), listed)
+		if len(failed) > len(listed) {
+			out.Printf(" and %d more", len(failed)-len(listed))
+		}
+		out.Println()
+	}
+	out.Event("rechecked", map[string]interface{}{
+		"completed_pieces": bitfield.GetNumCompletePieces(),
+		"total_pieces":     bitfield.GetNumPieces(),
+		"failed_pieces":    failed,
+	})
+
+	return nil
+}
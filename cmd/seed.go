@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/download"
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+	"github.com/yashkadam007/bittorrent-client/internal/storage"
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+	"github.com/yashkadam007/bittorrent-client/internal/tracker"
+)
+
+// seedLimitInterval is how often ListenOnly checks the seeding limits.
+var seedLimitInterval = 5 * time.Second
+
+// ListenOnly serves the verified data in outputDir (or opts.CompleteDir, if
+// the download was moved there) to peers that connect on port, without
+// contacting any tracker, until interrupted or a seeding limit in
+// opts.Download is reached. The share ratio is taken over the data served.
+// Peers have to be pointed at this client directly (e.g. a seedbox on a LAN).
+func ListenOnly(torrentPath, outputDir string, port int, opts Options) error {
+	out := newReporter(os.Stdout, opts)
+	quiet := !out.human()
+
+	t, err := torrent.ParseTorrentFile(torrentPath)
+	if err != nil {
+		return fmt.Errorf("failed to parse torrent file: %w", err)
+	}
+	outputDir = locateData(t, outputDir, opts.CompleteDir)
+
+	storageOpts := opts.Storage
+	storageOpts.Verifier = pieces.NewVerifier(opts.VerifyWorkers)
+	fileStorage, err := storage.NewFileStorageWithOptions(t, outputDir, storageOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create file storage: %w", err)
+	}
+	defer fileStorage.Close()
+
+	out.Printf("Verifying existing data in: %s\n", outputDir)
+	have, err := fileStorage.GetCompletionBitfield()
+	if err != nil {
+		return fmt.Errorf("failed to check existing files: %w", err)
+	}
+	if have.GetNumCompletePieces() == 0 {
+		return fmt.Errorf("no verified pieces in %s to serve", t.GetOutputPath(outputDir))
+	}
+
+	peerID := tracker.NewTrackerClientWithOptions(true).GetPeerID()
+	listener, err := peer.Listen(fmt.Sprintf(":%d", port), t.InfoHash, peerID, quiet)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	seeder := download.NewSeeder(have, fileStorage, quiet)
+	seeder.SetMaxUpload(opts.Download.MaxUploadBytesPerSec)
+	defer seeder.Close()
+	go listener.Serve(seeder.ServePeer)
+
+	out.Printf("Serving %d/%d pieces of %s on %s (no tracker)\n",
+		have.GetNumCompletePieces(), have.GetNumPieces(), t.Info.Name, listener.Addr())
+	out.Event("listening", map[string]interface{}{
+		"address":          listener.Addr().String(),
+		"completed_pieces": have.GetNumCompletePieces(),
+		"total_pieces":     have.GetNumPieces(),
+	})
+
+	// What's on disk counts as downloaded for the share ratio, as it does
+	// for a resumed download
+	var verified int64
+	for i := 0; i < have.GetNumPieces(); i++ {
+		if have.HasPiece(i) {
+			verified += t.Info.GetPieceLength(i)
+		}
+	}
+	seedingSince := time.Now()
+	ticker := time.NewTicker(seedLimitInterval)
+	defer ticker.Stop()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	for stopped := false; !stopped; {
+		select {
+		case <-sigChan:
+			out.Println()
+			stopped = true
+		case <-ticker.C:
+			ratio := float64(seeder.Uploaded()) / float64(verified)
+			reason := download.SeedLimitReason(opts.Download, ratio, time.Since(seedingSince))
+			if reason != "" {
+				out.Printf("Seeding stopped: %s\n", reason)
+				stopped = true
+			}
+		}
+	}
+
+	out.Printf("Shutting down after uploading %d bytes\n", seeder.Uploaded())
+	out.Event("stopped", map[string]interface{}{
+		"uploaded_bytes": seeder.Uploaded(),
+	})
+
+	return nil
+}
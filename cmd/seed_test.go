@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/download"
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// fetchPiece downloads a whole piece over conn, as a leecher would: it
+// declares interest, waits to be unchoked and requests every block.
+func fetchPiece(t *testing.T, conn *peer.Connection, pieceIndex, length int) []byte {
+	t.Helper()
+	if err := conn.SendInterested(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, length)
+	received := 0
+	for received < length {
+		msg, err := conn.ReceiveMessage()
+		if err != nil {
+			t.Fatalf("after %d bytes: %v", received, err)
+		}
+		if msg == nil {
+			continue
+		}
+
+		switch msg.Type {
+		case peer.MsgUnchoke:
+			for begin := 0; begin < length; begin += pieces.BlockSize {
+				if err := conn.SendRequest(pieceIndex, begin, min(pieces.BlockSize, length-begin)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		case peer.MsgPiece:
+			index, begin, block, err := peer.ParsePieceMessage(msg.Payload)
+			if err != nil || index != pieceIndex {
+				t.Fatalf("bad piece message for piece %d: %v", index, err)
+			}
+			received += copy(data[begin:], block)
+		}
+	}
+	return data
+}
+
+func TestListenOnly(t *testing.T) {
+	saved := seedLimitInterval
+	seedLimitInterval = 50 * time.Millisecond
+	t.Cleanup(func() { seedLimitInterval = saved })
+
+	// No announce key: a tracker would never be reachable anyway
+	tt := writeTorrent(t, "seed.bin", 4*testPieceLength, nil)
+	outputDir := t.TempDir()
+	tt.writeData(t, outputDir)
+	port := freePort(t)
+
+	// Serving one of the four pieces reaches the share ratio limit
+	done := make(chan error, 1)
+	go func() {
+		done <- ListenOnly(tt.path, outputDir, port, Options{
+			Quiet:    true,
+			Download: download.Options{SeedRatioLimit: 0.2},
+		})
+	}()
+
+	// The listener is up once the data has been verified
+	var conn *peer.Connection
+	var err error
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		conn, err = peer.Connect(fmt.Sprintf("127.0.0.1:%d", port), tt.infoHash, [20]byte{'D'})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("couldn't connect to the seed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetNumPieces(4)
+
+	// A peer asking for another torrent is turned away
+	if other, err := peer.Connect(fmt.Sprintf("127.0.0.1:%d", port), [20]byte{1}, [20]byte{'O'}); err == nil {
+		other.Close()
+		t.Error("peer with the wrong info hash accepted")
+	}
+
+	if got := fetchPiece(t, conn, 2, testPieceLength); !bytes.Equal(got, tt.data[2*testPieceLength:3*testPieceLength]) {
+		t.Error("piece 2 transferred with different data")
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ListenOnly: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("seeding didn't stop at the ratio limit")
+	}
+}
@@ -2,58 +2,254 @@ package bencode
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
+)
+
+// ErrUnterminatedContainer is returned when the input ends inside a list or
+// dictionary, before its closing 'e'. Errors for input that ends part-way
+// through any value also match io.ErrUnexpectedEOF, so callers can tell
+// truncated data from corrupt data.
+var ErrUnterminatedContainer = errors.New("unterminated list or dictionary")
+
+// ErrLimitExceeded is returned when the input nests deeper or holds a longer
+// string than the decoder allows (see DecoderOptions).
+var ErrLimitExceeded = errors.New("decoder limit exceeded")
+
+const (
+	defaultMaxDepth        = 256       // Far deeper than any torrent or tracker response
+	defaultMaxStringLength = 128 << 20 // Fits the piece hashes of any practical torrent
+
+	// stringChunk is the most decodeString allocates before seeing the data,
+	// so a bogus length fails at the end of the input rather than by
+	// allocating all of it
+	stringChunk = 1 << 20
+
+	// maxNumberLength caps the characters read for an integer or a string
+	// length; an int64 needs 20 at most, plus any leading zeros
+	maxNumberLength = 64
 )
 
 // Decoder handles bencode decoding operations.
 // Bencode is the encoding format used by BitTorrent for .torrent files.
 // It supports integers, strings, lists, and dictionaries.
 type Decoder struct {
-	reader *bufio.Reader
+	reader  *bufio.Reader
+	options DecoderOptions
+	depth   int      // Lists and dictionaries currently open
+	offset  int64    // Bytes consumed from the input so far
+	path    []string // Keys and list indexes leading to the value being decoded
+}
+
+// DecoderOptions configures how strictly a Decoder checks its input.
+type DecoderOptions struct {
+	// Lenient accepts dictionaries whose keys are unsorted or repeated (the
+	// last value wins) and integers with leading zeros or a negative zero,
+	// which some non-compliant encoders produce. Such input doesn't re-encode
+	// to the same bytes, so anything hashed must be taken from the raw input.
+	Lenient bool
+
+	MaxDepth        int   // Deepest nesting of lists and dictionaries allowed (0 means 256)
+	MaxStringLength int64 // Longest string allowed, in bytes (0 means 128 MiB)
+}
+
+// SyntaxError reports where in the input decoding failed. It wraps the
+// cause, so errors.Is still matches io.EOF, io.ErrUnexpectedEOF,
+// ErrUnterminatedContainer and ErrLimitExceeded.
+type SyntaxError struct {
+	Offset int64  // Bytes of input consumed when the error was found
+	Path   string // Where the failing value sits, e.g. "info.files[2].length" (empty at the top level)
+	Err    error  // What went wrong
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("invalid bencode at offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("invalid bencode at offset %d (%s): %v", e.Offset, e.Path, e.Err)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
 }
 
 // NewDecoder creates a new bencode decoder for reading from the given reader.
 func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderWithOptions(r, DecoderOptions{})
+}
+
+// NewDecoderWithOptions creates a new bencode decoder with additional options.
+func NewDecoderWithOptions(r io.Reader, options DecoderOptions) *Decoder {
+	if options.MaxDepth <= 0 {
+		options.MaxDepth = defaultMaxDepth
+	}
+	if options.MaxStringLength <= 0 {
+		options.MaxStringLength = defaultMaxStringLength
+	}
+
 	return &Decoder{
-		reader: bufio.NewReader(r),
+		reader:  bufio.NewReader(r),
+		options: options,
 	}
 }
 
 // Decode parses bencode data and returns the decoded value.
-// Returns error if the data is malformed or invalid.
+// Returns a *SyntaxError if the data is malformed or invalid. Empty input
+// gives an error matching io.EOF; input that stops part-way through a value
+// gives one matching io.ErrUnexpectedEOF.
 func (d *Decoder) Decode() (interface{}, error) {
+	_, err := d.reader.Peek(1)
+	if err != nil {
+		return nil, d.errorf("failed to read byte: %w", err)
+	}
+
+	d.path = d.path[:0]
 	return d.decodeValue()
 }
 
+// DecodeOnly is Decode for input that holds a single value, such as a whole
+// file: anything but whitespace after the value is an error.
+func (d *Decoder) DecodeOnly() (interface{}, error) {
+	value, err := d.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.skipWhitespace()
+	if err == nil {
+		return nil, d.errorf("trailing data after value")
+	}
+	if err != io.EOF {
+		return nil, d.errorf("failed to read trailing data: %w", err)
+	}
+	return value, nil
+}
+
+// DecodeStream returns the next of a sequence of values, which may be
+// separated by whitespace, e.g. several messages read from one connection.
+// It returns io.EOF itself, unwrapped, once the input ends between values.
+func (d *Decoder) DecodeStream() (interface{}, error) {
+	err := d.skipWhitespace()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, d.errorf("failed to read byte: %w", err)
+	}
+
+	return d.Decode()
+}
+
+// skipWhitespace consumes any whitespace before the next value. It returns
+// io.EOF if the input ends first.
+func (d *Decoder) skipWhitespace() error {
+	for {
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return d.unreadByte()
+		}
+	}
+}
+
+// errorf returns a *SyntaxError at the current offset and path.
+func (d *Decoder) errorf(format string, args ...interface{}) error {
+	var path strings.Builder
+	for _, element := range d.path {
+		if path.Len() > 0 && !strings.HasPrefix(element, "[") {
+			path.WriteByte('.')
+		}
+		// Keys can be binary, e.g. info hashes in a scrape response
+		if quoted := strconv.Quote(element); quoted[1:len(quoted)-1] != element {
+			element = quoted
+		}
+		path.WriteString(element)
+	}
+
+	return &SyntaxError{
+		Offset: d.offset,
+		Path:   path.String(),
+		Err:    fmt.Errorf(format, args...),
+	}
+}
+
+// readByte reads one byte of input, counting it toward the offset.
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.reader.ReadByte()
+	if err == nil {
+		d.offset++
+	}
+	return b, err
+}
+
+// unreadByte puts back the byte readByte just read.
+func (d *Decoder) unreadByte() error {
+	err := d.reader.UnreadByte()
+	if err == nil {
+		d.offset--
+	}
+	return err
+}
+
+// truncated turns io.EOF into io.ErrUnexpectedEOF; it is used wherever more
+// input is required to finish the current value.
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// unterminated reports the input ending where a container's next element or
+// closing 'e' should be.
+func unterminated(err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("%w: %w", ErrUnterminatedContainer, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
 // decodeValue handles the main decoding logic by reading the first byte
 // to determine the data type (integer, string, list, or dictionary).
+// Errors it returns are already *SyntaxErrors, so containers pass their
+// elements' errors on unchanged.
 func (d *Decoder) decodeValue() (interface{}, error) {
-	b, err := d.reader.ReadByte()
+	b, err := d.readByte()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read byte: %w", err)
+		return nil, d.errorf("failed to read byte: %w", truncated(err))
 	}
 
 	switch {
 	case b == 'i':
 		// Integer
 		return d.decodeInteger()
-	case b == 'l':
-		// List
-		return d.decodeList()
-	case b == 'd':
-		// Dictionary
+	case b == 'l' || b == 'd':
+		// List or dictionary; each level costs stack, so cap how deep
+		if d.depth >= d.options.MaxDepth {
+			return nil, d.errorf("%w: nested deeper than %d", ErrLimitExceeded, d.options.MaxDepth)
+		}
+		d.depth++
+		defer func() { d.depth-- }()
+
+		if b == 'l' {
+			return d.decodeList()
+		}
 		return d.decodeDictionary()
 	case b >= '0' && b <= '9':
 		// String - unread the byte and decode
-		err = d.reader.UnreadByte()
+		err = d.unreadByte()
 		if err != nil {
-			return nil, fmt.Errorf("failed to unread byte: %w", err)
+			return nil, d.errorf("failed to unread byte: %w", err)
 		}
 		return d.decodeString()
 	default:
-		return nil, fmt.Errorf("invalid bencode data: unexpected byte %c", b)
+		return nil, d.errorf("unexpected byte %q", b)
 	}
 }
 
@@ -62,33 +258,38 @@ func (d *Decoder) decodeInteger() (int64, error) {
 	var result []byte
 
 	for {
-		b, err := d.reader.ReadByte()
+		b, err := d.readByte()
 		if err != nil {
-			return 0, fmt.Errorf("failed to read integer: %w", err)
+			return 0, d.errorf("failed to read integer: %w", truncated(err))
 		}
 
 		if b == 'e' {
 			break
 		}
+		if len(result) == maxNumberLength {
+			return 0, d.errorf("%w: integer longer than %d characters", ErrLimitExceeded, maxNumberLength)
+		}
 
 		result = append(result, b)
 	}
 
 	if len(result) == 0 {
-		return 0, fmt.Errorf("empty integer")
+		return 0, d.errorf("empty integer")
 	}
 
 	// Validate integer format
-	if len(result) > 1 && result[0] == '0' {
-		return 0, fmt.Errorf("invalid integer: leading zero")
-	}
-	if len(result) == 2 && result[0] == '-' && result[1] == '0' {
-		return 0, fmt.Errorf("invalid integer: negative zero")
+	if !d.options.Lenient {
+		if len(result) > 1 && result[0] == '0' {
+			return 0, d.errorf("invalid integer: leading zero")
+		}
+		if len(result) == 2 && result[0] == '-' && result[1] == '0' {
+			return 0, d.errorf("invalid integer: negative zero")
+		}
 	}
 
 	num, err := strconv.ParseInt(string(result), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse integer: %w", err)
+		return 0, d.errorf("failed to parse integer: %w", err)
 	}
 
 	return num, nil
@@ -100,9 +301,9 @@ func (d *Decoder) decodeString() ([]byte, error) {
 
 	// Read length until ':'
 	for {
-		b, err := d.reader.ReadByte()
+		b, err := d.readByte()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read string length: %w", err)
+			return nil, d.errorf("failed to read string length: %w", truncated(err))
 		}
 
 		if b == ':' {
@@ -110,30 +311,48 @@ func (d *Decoder) decodeString() ([]byte, error) {
 		}
 
 		if b < '0' || b > '9' {
-			return nil, fmt.Errorf("invalid string length character: %c", b)
+			return nil, d.errorf("invalid string length character: %q", b)
+		}
+		if len(lengthBytes) == maxNumberLength {
+			return nil, d.errorf("%w: string length longer than %d characters", ErrLimitExceeded, maxNumberLength)
 		}
 
 		lengthBytes = append(lengthBytes, b)
 	}
 
 	if len(lengthBytes) == 0 {
-		return nil, fmt.Errorf("empty string length")
+		return nil, d.errorf("empty string length")
 	}
 
 	length, err := strconv.ParseInt(string(lengthBytes), 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse string length: %w", err)
+		return nil, d.errorf("failed to parse string length: %w", err)
 	}
 
 	if length < 0 {
-		return nil, fmt.Errorf("negative string length")
+		return nil, d.errorf("negative string length")
+	}
+	if length > d.options.MaxStringLength {
+		return nil, d.errorf("%w: string of %d bytes is longer than %d", ErrLimitExceeded, length, d.options.MaxStringLength)
 	}
 
 	// Read the string data
+	if length > stringChunk {
+		var buf bytes.Buffer
+		buf.Grow(stringChunk)
+		n, err := io.CopyN(&buf, d.reader, length)
+		d.offset += n
+		if err != nil {
+			return nil, d.errorf("failed to read string data: %w", truncated(err))
+		}
+		return buf.Bytes(), nil
+	}
+
 	data := make([]byte, length)
-	_, err = io.ReadFull(d.reader, data)
+	n, err := io.ReadFull(d.reader, data)
+	d.offset += int64(n)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read string data: %w", err)
+		return nil, d.errorf("failed to read string data: %w", truncated(err))
 	}
 
 	return data, nil
@@ -145,9 +364,9 @@ func (d *Decoder) decodeList() ([]interface{}, error) {
 
 	for {
 		// Check for end marker
-		b, err := d.reader.ReadByte()
+		b, err := d.readByte()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read list: %w", err)
+			return nil, d.errorf("failed to read list: %w", unterminated(err))
 		}
 
 		if b == 'e' {
@@ -155,15 +374,17 @@ func (d *Decoder) decodeList() ([]interface{}, error) {
 		}
 
 		// Unread the byte and decode the value
-		err = d.reader.UnreadByte()
+		err = d.unreadByte()
 		if err != nil {
-			return nil, fmt.Errorf("failed to unread byte: %w", err)
+			return nil, d.errorf("failed to unread byte: %w", err)
 		}
 
+		d.path = append(d.path, fmt.Sprintf("[%d]", len(list)))
 		value, err := d.decodeValue()
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode list element: %w", err)
+			return nil, err
 		}
+		d.path = d.path[:len(d.path)-1]
 
 		list = append(list, value)
 	}
@@ -172,16 +393,20 @@ func (d *Decoder) decodeList() ([]interface{}, error) {
 }
 
 // decodeDictionary parses a dictionary from bencode format: d<key><value>...e
-// Keys must be strings and appear in sorted order.
+// Keys must be strings and, unless the decoder is lenient, appear in sorted order.
+// Keys are raw byte strings that need not be valid UTF-8. Converting them to a
+// Go string keeps every byte, and Go string comparison is byte-wise, which is
+// exactly the ordering bencode requires, so such keys re-encode unchanged.
 func (d *Decoder) decodeDictionary() (map[string]interface{}, error) {
 	dict := make(map[string]interface{})
 	var lastKey string
+	haveKey := false
 
 	for {
 		// Check for end marker
-		b, err := d.reader.ReadByte()
+		b, err := d.readByte()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read dictionary: %w", err)
+			return nil, d.errorf("failed to read dictionary: %w", unterminated(err))
 		}
 
 		if b == 'e' {
@@ -189,30 +414,36 @@ func (d *Decoder) decodeDictionary() (map[string]interface{}, error) {
 		}
 
 		// Unread the byte and decode the key
-		err = d.reader.UnreadByte()
+		err = d.unreadByte()
 		if err != nil {
-			return nil, fmt.Errorf("failed to unread byte: %w", err)
+			return nil, d.errorf("failed to unread byte: %w", err)
 		}
 
 		// Keys must be strings
+		if b < '0' || b > '9' {
+			return nil, d.errorf("dictionary key is not a string")
+		}
 		keyBytes, err := d.decodeString()
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode dictionary key: %w", err)
+			return nil, err
 		}
 
 		key := string(keyBytes)
 
-		// Check for proper ordering
-		if key <= lastKey && lastKey != "" {
-			return nil, fmt.Errorf("dictionary keys not in sorted order: %s <= %s", key, lastKey)
+		// Check for proper ordering (the empty key is valid and sorts first)
+		if haveKey && key <= lastKey && !d.options.Lenient {
+			return nil, d.errorf("dictionary keys not in sorted order: %q <= %q", key, lastKey)
 		}
 		lastKey = key
+		haveKey = true
 
 		// Decode the value
+		d.path = append(d.path, key)
 		value, err := d.decodeValue()
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode dictionary value for key %s: %w", key, err)
+			return nil, err
 		}
+		d.path = d.path[:len(d.path)-1]
 
 		dict[key] = value
 	}
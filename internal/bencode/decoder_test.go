@@ -0,0 +1,138 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"unicode/utf8"
+)
+
+// rawKeys are dictionary keys that aren't valid UTF-8, in bencode order,
+// along with the empty key and a plain ASCII one.
+var rawKeys = []string{"", "a", "\x80", "\xc3\x28", "\xff", "\xff\xfe\x00"}
+
+// rawKeysEncoded is a dictionary mapping each of rawKeys to its index.
+var rawKeysEncoded = []byte("d0:i0e1:ai1e1:\x80i2e2:\xc3\x28i3e1:\xffi4e3:\xff\xfe\x00i5ee")
+
+func TestNonUTF8KeysRoundTrip(t *testing.T) {
+	for _, key := range rawKeys[2:] {
+		if utf8.ValidString(key) {
+			t.Fatalf("test key %q is valid UTF-8", key)
+		}
+	}
+
+	dict := make(map[string]interface{})
+	for i, key := range rawKeys {
+		dict[key] = int64(i)
+	}
+
+	var encoded bytes.Buffer
+	if err := NewEncoder(&encoded).Encode(dict); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded.Bytes(), rawKeysEncoded) {
+		t.Fatalf("encoded %q, want %q", encoded.Bytes(), rawKeysEncoded)
+	}
+
+	decoded, err := NewDecoder(bytes.NewReader(encoded.Bytes())).DecodeOnly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedDict, ok := decoded.(map[string]interface{})
+	if !ok {
+		t.Fatalf("decoded a %T, want a dictionary", decoded)
+	}
+	if len(decodedDict) != len(rawKeys) {
+		t.Errorf("decoded %d keys, want %d", len(decodedDict), len(rawKeys))
+	}
+	for i, key := range rawKeys {
+		if decodedDict[key] != int64(i) {
+			t.Errorf("key %q decoded to %v, want %d", key, decodedDict[key], i)
+		}
+	}
+
+	var reencoded bytes.Buffer
+	if err := NewEncoder(&reencoded).Encode(decodedDict); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reencoded.Bytes(), rawKeysEncoded) {
+		t.Errorf("re-encoded %q, want %q", reencoded.Bytes(), rawKeysEncoded)
+	}
+}
+
+func TestNonUTF8KeyOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{"sorted bytewise", "d1:ai0e1:\x80i0e1:\xffi0ee", true},
+		{"high byte first", "d1:\xffi0e1:\x80i0ee", false},
+		{"prefix after longer key", "d2:\xff\x00i0e1:\xffi0ee", false},
+		{"duplicate raw key", "d1:\x80i0e1:\x80i0ee", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewDecoder(bytes.NewReader([]byte(tc.input))).DecodeOnly()
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("out of order keys accepted")
+			}
+		})
+	}
+}
+
+func TestSplitDictNonUTF8Keys(t *testing.T) {
+	dict, err := SplitDict(rawKeysEncoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range rawKeys {
+		want := []byte("i" + string(rune('0'+i)) + "e")
+		if !bytes.Equal(dict[key], want) {
+			t.Errorf("key %q split to %q, want %q", key, dict[key], want)
+		}
+	}
+}
+
+func TestUnterminatedContainers(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		unterminated bool
+	}{
+		{"empty list", "l", true},
+		{"list", "li1e4:spam", true},
+		{"nested list", "lli1ee", true},
+		{"list in dictionary", "d1:ali1e", true},
+		{"empty dictionary", "d", true},
+		{"dictionary", "d1:ai1e", true},
+		{"nested dictionary", "d1:ad1:bi1ee", true},
+		// Corrupt, not truncated
+		{"junk in list", "li1ex", false},
+		{"junk in dictionary", "d1:ai1ex", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewDecoder(bytes.NewReader([]byte(tc.input))).DecodeOnly()
+			if err == nil {
+				t.Fatal("decoded without error")
+			}
+			if got := errors.Is(err, ErrUnterminatedContainer); got != tc.unterminated {
+				t.Errorf("error %q: unterminated %v, want %v", err, got, tc.unterminated)
+			}
+			if tc.unterminated && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("error %q isn't io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+
+	if _, err := SplitDict([]byte("d1:ai1e")); !errors.Is(err, ErrUnterminatedContainer) {
+		t.Errorf("SplitDict of an unterminated dictionary: %v", err)
+	}
+}
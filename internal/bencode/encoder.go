@@ -38,6 +38,11 @@ func (e *Encoder) encodeValue(value interface{}) error {
 		return fmt.Errorf("cannot encode nil value")
 	}
 
+	if raw, ok := value.(RawValue); ok {
+		_, err := e.writer.Write(raw)
+		return err
+	}
+
 	v := reflect.ValueOf(value)
 
 	switch v.Kind() {
@@ -0,0 +1,121 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RawValue is an already-encoded bencode value. The Encoder writes it out
+// verbatim, so part of a structure can be carried through a re-encode
+// byte-for-byte (e.g. a torrent's info dictionary, whose hash must not change).
+type RawValue []byte
+
+// SplitDict splits an encoded dictionary into its keys and the raw encoding
+// of each value. Values are not validated beyond finding where they end;
+// decode them with a Decoder if their contents matter.
+func SplitDict(data []byte) (map[string]RawValue, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, fmt.Errorf("not a dictionary")
+	}
+
+	dict := make(map[string]RawValue)
+	pos := 1
+	for {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("%w: %w", ErrUnterminatedContainer, io.ErrUnexpectedEOF)
+		}
+		if data[pos] == 'e' {
+			pos++
+			break
+		}
+
+		keyEnd, err := skipValue(data, pos, 1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dictionary key: %w", err)
+		}
+		if data[pos] < '0' || data[pos] > '9' {
+			return nil, fmt.Errorf("dictionary key is not a string")
+		}
+		key := data[pos:keyEnd]
+		key = key[bytes.IndexByte(key, ':')+1:]
+
+		valueEnd, err := skipValue(data, keyEnd, 1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dictionary value for key %q: %w", key, err)
+		}
+
+		dict[string(key)] = RawValue(data[keyEnd:valueEnd])
+		pos = valueEnd
+	}
+
+	if pos != len(data) {
+		return nil, fmt.Errorf("trailing data after dictionary")
+	}
+
+	return dict, nil
+}
+
+// SplitValue splits data into the encoding of its first value and whatever
+// follows it, e.g. the raw bytes appended to a metadata data message.
+func SplitValue(data []byte) (RawValue, []byte, error) {
+	end, err := skipValue(data, 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return RawValue(data[:end]), data[end:], nil
+}
+
+// skipValue returns the offset just past the value starting at pos, which is
+// nested depth lists or dictionaries deep. Nesting is capped as a Decoder
+// caps it by default.
+func skipValue(data []byte, pos, depth int) (int, error) {
+	if pos >= len(data) {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+
+	switch b := data[pos]; {
+	case b == 'i':
+		end := bytes.IndexByte(data[pos:], 'e')
+		if end < 0 {
+			return 0, fmt.Errorf("unterminated integer")
+		}
+		return pos + end + 1, nil
+
+	case b == 'l' || b == 'd':
+		if depth >= defaultMaxDepth {
+			return 0, fmt.Errorf("%w: nested deeper than %d", ErrLimitExceeded, defaultMaxDepth)
+		}
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			next, err := skipValue(data, pos, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			pos = next
+		}
+		if pos >= len(data) {
+			return 0, fmt.Errorf("%w: %w", ErrUnterminatedContainer, io.ErrUnexpectedEOF)
+		}
+		return pos + 1, nil
+
+	case b >= '0' && b <= '9':
+		colon := bytes.IndexByte(data[pos:], ':')
+		if colon < 0 {
+			return 0, fmt.Errorf("unterminated string length")
+		}
+		length, err := strconv.Atoi(string(data[pos : pos+colon]))
+		if err != nil || length < 0 {
+			return 0, fmt.Errorf("invalid string length %q", data[pos:pos+colon])
+		}
+		start := pos + colon + 1
+		if length > len(data)-start {
+			return 0, fmt.Errorf("string extends past end of data")
+		}
+		return start + length, nil
+
+	default:
+		return 0, fmt.Errorf("invalid bencode data: unexpected byte %c", b)
+	}
+}
@@ -0,0 +1,203 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Unmarshal decodes bencode data into the value pointed to by v, much as
+// encoding/json does. Dictionaries decode into structs, using each exported
+// field's `bencode:"key"` tag (or else its name) as the key, or into maps
+// with string keys. Lists decode into slices, strings into string, []byte or
+// [N]byte, and integers into any integer type. Dictionary keys without a
+// matching field are ignored, as are fields tagged "-". An interface{}
+// receives the value as Decode returns it.
+func Unmarshal(data []byte, v interface{}) error {
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Pointer || dst.IsNil() {
+		return fmt.Errorf("cannot unmarshal into %T, need a non-nil pointer", v)
+	}
+
+	value, err := NewDecoder(bytes.NewReader(data)).DecodeOnly()
+	if err != nil {
+		return err
+	}
+
+	return assign(dst.Elem(), value, "")
+}
+
+// assign stores a decoded value in dst. path names dst in errors, e.g.
+// "info.files[2].length".
+func assign(dst reflect.Value, value interface{}, path string) error {
+	switch dst.Kind() {
+	case reflect.Interface:
+		if dst.NumMethod() == 0 {
+			dst.Set(reflect.ValueOf(value))
+			return nil
+		}
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assign(dst.Elem(), value, path)
+	}
+
+	switch v := value.(type) {
+	case int64:
+		return assignInteger(dst, v, path)
+	case []byte:
+		return assignString(dst, v, path)
+	case []interface{}:
+		return assignList(dst, v, path)
+	case map[string]interface{}:
+		return assignDictionary(dst, v, path)
+	}
+	return mismatch(dst, value, path)
+}
+
+// assignInteger stores a decoded integer in dst.
+func assignInteger(dst reflect.Value, value int64, path string) error {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if dst.OverflowInt(value) {
+			return fmt.Errorf("integer %d overflows %s at %s", value, dst.Type(), describePath(path))
+		}
+		dst.SetInt(value)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value < 0 || dst.OverflowUint(uint64(value)) {
+			return fmt.Errorf("integer %d overflows %s at %s", value, dst.Type(), describePath(path))
+		}
+		dst.SetUint(uint64(value))
+		return nil
+	}
+	return mismatch(dst, value, path)
+}
+
+// assignString stores a decoded string in dst.
+func assignString(dst reflect.Value, value []byte, path string) error {
+	switch {
+	case dst.Kind() == reflect.String:
+		dst.SetString(string(value))
+		return nil
+	case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8:
+		dst.SetBytes(value)
+		return nil
+	case dst.Kind() == reflect.Array && dst.Type().Elem().Kind() == reflect.Uint8:
+		if len(value) != dst.Len() {
+			return fmt.Errorf("string of length %d doesn't fit %s at %s", len(value), dst.Type(), describePath(path))
+		}
+		reflect.Copy(dst, reflect.ValueOf(value))
+		return nil
+	}
+	return mismatch(dst, value, path)
+}
+
+// assignList stores a decoded list in dst, which must be a slice.
+func assignList(dst reflect.Value, value []interface{}, path string) error {
+	if dst.Kind() != reflect.Slice {
+		return mismatch(dst, value, path)
+	}
+
+	list := reflect.MakeSlice(dst.Type(), len(value), len(value))
+	for i, item := range value {
+		err := assign(list.Index(i), item, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return err
+		}
+	}
+	dst.Set(list)
+	return nil
+}
+
+// assignDictionary stores a decoded dictionary in dst, which must be a
+// struct or a map with string keys.
+func assignDictionary(dst reflect.Value, value map[string]interface{}, path string) error {
+	switch dst.Kind() {
+	case reflect.Map:
+		if dst.Type().Key().Kind() != reflect.String {
+			return mismatch(dst, value, path)
+		}
+		dict := reflect.MakeMapWithSize(dst.Type(), len(value))
+		for key, item := range value {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			err := assign(elem, item, joinPath(path, key))
+			if err != nil {
+				return err
+			}
+			dict.SetMapIndex(reflect.ValueOf(key).Convert(dst.Type().Key()), elem)
+		}
+		dst.Set(dict)
+		return nil
+
+	case reflect.Struct:
+		for key, index := range structKeys(dst.Type()) {
+			item, ok := value[key]
+			if !ok {
+				continue
+			}
+			err := assign(dst.Field(index), item, joinPath(path, key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return mismatch(dst, value, path)
+}
+
+// structKeys maps the dictionary keys of a struct type's exported fields to
+// their field indexes.
+func structKeys(t reflect.Type) map[string]int {
+	keys := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		key, _, _ := strings.Cut(field.Tag.Get("bencode"), ",")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = field.Name
+		}
+		keys[key] = i
+	}
+	return keys
+}
+
+// mismatch reports a decoded value that dst can't hold.
+func mismatch(dst reflect.Value, value interface{}, path string) error {
+	var kind string
+	switch value.(type) {
+	case int64:
+		kind = "integer"
+	case []byte:
+		kind = "string"
+	case []interface{}:
+		kind = "list"
+	default:
+		kind = "dictionary"
+	}
+	return fmt.Errorf("cannot unmarshal %s into %s at %s", kind, dst.Type(), describePath(path))
+}
+
+// joinPath appends a dictionary key to path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// describePath names path in errors.
+func describePath(path string) string {
+	if path == "" {
+		return "top level"
+	}
+	return path
+}
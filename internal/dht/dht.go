@@ -0,0 +1,428 @@
+// Package dht implements a minimal node of the BitTorrent DHT (BEP 5): just
+// enough Kademlia to find peers for a torrent without a tracker.
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+	"github.com/yashkadam007/bittorrent-client/internal/tracker"
+)
+
+// DefaultBootstrapNodes are contacted to join the DHT when no other node is
+// known.
+var DefaultBootstrapNodes = []string{"router.bittorrent.com:6881"}
+
+var (
+	// queryTimeout is how long a query waits for its reply. Nodes that
+	// don't answer in time are dropped from the routing table.
+	queryTimeout = 3 * time.Second
+
+	// lookupTimeout bounds a FindPeers lookup made without a deadline.
+	lookupTimeout = 30 * time.Second
+)
+
+// ErrNoNodes is returned when a lookup has no DHT node to start from, e.g.
+// because none of the bootstrap nodes answered.
+var ErrNoNodes = errors.New("no DHT nodes known")
+
+// errQueryTimeout is returned when a queried node doesn't answer.
+var errQueryTimeout = errors.New("DHT node did not respond")
+
+// krpcError is an error message sent by a DHT node.
+type krpcError string
+
+func (e krpcError) Error() string {
+	return "DHT error: " + string(e)
+}
+
+// message is a KRPC message (BEP 5): a query, a reply or an error.
+type message struct {
+	T string        `bencode:"t"` // Transaction ID, echoed in the reply
+	Y string        `bencode:"y"` // "q" (query), "r" (reply) or "e" (error)
+	Q string        `bencode:"q"` // Query method
+	A arguments     `bencode:"a"` // Query arguments
+	R reply         `bencode:"r"` // Reply values
+	E []interface{} `bencode:"e"` // Error code and message
+}
+
+// arguments are the arguments of the queries we answer.
+type arguments struct {
+	ID       string `bencode:"id"`        // Querying node's ID
+	Target   string `bencode:"target"`    // find_node: ID being looked up
+	InfoHash string `bencode:"info_hash"` // get_peers: torrent being looked up
+}
+
+// reply holds the values of the replies we ask for.
+type reply struct {
+	ID     string   `bencode:"id"`     // Replying node's ID
+	Nodes  string   `bencode:"nodes"`  // Compact node info of closer nodes
+	Values []string `bencode:"values"` // get_peers: compact peer addresses
+	Token  string   `bencode:"token"`  // get_peers: token for announce_peer
+}
+
+// Node is a DHT node listening on a UDP port. It queries other nodes to find
+// peers, and answers their ping, find_node and get_peers queries from its
+// routing table; it stores no peers, so get_peers is only ever answered
+// with closer nodes.
+type Node struct {
+	id      [20]byte                // Our node ID
+	conn    *net.UDPConn            // Socket for queries and replies
+	secret  [20]byte                // Mixed into the tokens we hand out
+	table   *routingTable           // Nodes we know of
+	pending map[string]chan message // Replies awaited, by transaction ID
+	nextTID uint16                  // Transaction ID of the next query
+	mutex   sync.Mutex              // Protects pending and nextTID
+	quiet   bool                    // Suppress stdout output
+}
+
+// NewNode creates a DHT node listening on the given UDP port (0 picks a
+// free one). It knows no other node until AddNode or Bootstrap is called.
+func NewNode(port int, quiet bool) (*Node, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for DHT: %w", err)
+	}
+
+	n := &Node{
+		conn:    conn,
+		pending: make(map[string]chan message),
+		quiet:   quiet,
+	}
+	rand.Read(n.id[:])
+	rand.Read(n.secret[:])
+	n.table = newRoutingTable(n.id)
+
+	go n.serve()
+	return n, nil
+}
+
+// Join creates a node listening on the given UDP port, or on any free port
+// if that one is taken, and bootstraps it from addrs.
+func Join(ctx context.Context, port int, addrs []string, quiet bool) (*Node, error) {
+	node, err := NewNode(port, quiet)
+	if err != nil && port != 0 {
+		node, err = NewNode(0, quiet)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.Bootstrap(ctx, addrs)
+	if err != nil {
+		node.Close()
+		return nil, err
+	}
+	return node, nil
+}
+
+// BootstrapNodes returns the nodes to join the DHT through for t: those the
+// torrent lists, which trackerless torrents usually carry, followed by
+// DefaultBootstrapNodes.
+func BootstrapNodes(t *torrent.TorrentFile) []string {
+	var addrs []string
+	for _, node := range t.Nodes {
+		addrs = append(addrs, net.JoinHostPort(node.Host, strconv.Itoa(node.Port)))
+	}
+	return append(addrs, DefaultBootstrapNodes...)
+}
+
+// FindPeers joins the DHT through DefaultBootstrapNodes and looks up peers
+// for a torrent, using a node that lives only as long as the lookup.
+func FindPeers(infoHash [20]byte) ([]tracker.PeerInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	node, err := Join(ctx, 0, DefaultBootstrapNodes, true)
+	if err != nil {
+		return nil, err
+	}
+	defer node.Close()
+	return node.FindPeers(ctx, infoHash)
+}
+
+// Port returns the UDP port the node listens on.
+func (n *Node) Port() int {
+	return n.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Close stops the node. Queries in flight fail.
+func (n *Node) Close() error {
+	return n.conn.Close()
+}
+
+// NodeCount returns the number of nodes in the routing table.
+func (n *Node) NodeCount() int {
+	return n.table.len()
+}
+
+// AddNode pings the node at addr (host:port) in the background and adds it
+// to the routing table if it answers. Peers' port messages feed it.
+func (n *Node) AddNode(addr string) {
+	go func() {
+		udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		defer cancel()
+		n.query(ctx, udpAddr, "ping", nil)
+	}()
+}
+
+// Bootstrap asks each of addrs (host:port) for the nodes closest to our own
+// ID, filling the routing table. It fails only if no node is known after.
+func (n *Node) Bootstrap(ctx context.Context, addrs []string) error {
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+			if err != nil {
+				if !n.quiet {
+					fmt.Printf("Failed to resolve DHT node %s: %v\n", addr, err)
+				}
+				return
+			}
+
+			r, err := n.query(ctx, udpAddr, "find_node", map[string]interface{}{"target": string(n.id[:])})
+			if err != nil {
+				if !n.quiet {
+					fmt.Printf("DHT node %s did not answer: %v\n", addr, err)
+				}
+				return
+			}
+			for _, c := range parseNodes(r.Nodes) {
+				n.table.add(c)
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	if n.table.len() == 0 {
+		return ErrNoNodes
+	}
+	return nil
+}
+
+// Announce looks up peers for a torrent and returns them as a tracker
+// response, so that the DHT can stand in for a tracker's announces.
+func (n *Node) Announce(ctx context.Context, infoHash [20]byte) (*tracker.TrackerResponse, error) {
+	peers, err := n.FindPeers(ctx, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	return &tracker.TrackerResponse{Peers: peers}, nil
+}
+
+// query sends a query to addr and waits for the reply. A node that answers
+// is added to the routing table; one that doesn't is removed.
+func (n *Node) query(ctx context.Context, addr *net.UDPAddr, method string, args map[string]interface{}) (*reply, error) {
+	if args == nil {
+		args = make(map[string]interface{})
+	}
+	args["id"] = string(n.id[:])
+
+	n.mutex.Lock()
+	tid := string([]byte{byte(n.nextTID >> 8), byte(n.nextTID)})
+	n.nextTID++
+	replies := make(chan message, 1)
+	n.pending[tid] = replies
+	n.mutex.Unlock()
+
+	defer func() {
+		n.mutex.Lock()
+		delete(n.pending, tid)
+		n.mutex.Unlock()
+	}()
+
+	err := n.send(addr, map[string]interface{}{"t": tid, "y": "q", "q": method, "a": args})
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(queryTimeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-replies:
+		if msg.Y == "e" {
+			return nil, errorMessage(msg.E)
+		}
+		id, ok := toID(msg.R.ID)
+		if !ok {
+			return nil, fmt.Errorf("invalid node ID length: %d", len(msg.R.ID))
+		}
+		n.table.add(contact{id: id, addr: addr})
+		return &msg.R, nil
+	case <-timer.C:
+		n.table.remove(addr)
+		return nil, errQueryTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send writes a bencoded message to addr.
+func (n *Node) send(addr *net.UDPAddr, msg map[string]interface{}) error {
+	var buf bytes.Buffer
+	err := bencode.NewEncoder(&buf).Encode(msg)
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDP(buf.Bytes(), addr)
+	return err
+}
+
+// serve reads packets until the node is closed, passing replies to the
+// queries awaiting them and answering queries. Malformed packets are dropped.
+func (n *Node) serve() {
+	buf := make([]byte, 2048)
+	for {
+		size, addr, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		var msg message
+		if bencode.Unmarshal(buf[:size], &msg) != nil {
+			continue
+		}
+
+		switch msg.Y {
+		case "r", "e":
+			n.mutex.Lock()
+			replies, ok := n.pending[msg.T]
+			n.mutex.Unlock()
+			if ok {
+				select {
+				case replies <- msg:
+				default:
+					// Already answered
+				}
+			}
+		case "q":
+			n.answer(addr, &msg)
+		}
+	}
+}
+
+// answer replies to a query from another node.
+func (n *Node) answer(addr *net.UDPAddr, msg *message) {
+	r := map[string]interface{}{"id": string(n.id[:])}
+
+	switch msg.Q {
+	case "ping":
+	case "find_node":
+		target, ok := toID(msg.A.Target)
+		if !ok {
+			n.sendError(addr, msg.T, 203, "invalid target")
+			return
+		}
+		r["nodes"] = string(compactNodes(n.table.closest(target, bucketSize)))
+	case "get_peers":
+		infoHash, ok := toID(msg.A.InfoHash)
+		if !ok {
+			n.sendError(addr, msg.T, 203, "invalid info_hash")
+			return
+		}
+		r["nodes"] = string(compactNodes(n.table.closest(infoHash, bucketSize)))
+		r["token"] = n.token(addr)
+	default:
+		n.sendError(addr, msg.T, 204, "method unknown")
+		return
+	}
+
+	n.send(addr, map[string]interface{}{"t": msg.T, "y": "r", "r": r})
+}
+
+// sendError replies to a query with a KRPC error.
+func (n *Node) sendError(addr *net.UDPAddr, tid string, code int, text string) {
+	n.send(addr, map[string]interface{}{"t": tid, "y": "e", "e": []interface{}{code, text}})
+}
+
+// token returns the token handed to addr with get_peers replies.
+func (n *Node) token(addr *net.UDPAddr) string {
+	sum := sha1.Sum(append(addr.IP.To4(), n.secret[:]...))
+	return string(sum[:8])
+}
+
+// errorMessage converts the code and message of a KRPC error.
+func errorMessage(e []interface{}) krpcError {
+	if len(e) != 2 {
+		return krpcError("malformed error")
+	}
+	code, _ := e[0].(int64)
+	text, _ := e[1].([]byte)
+	return krpcError(fmt.Sprintf("%d %s", code, text))
+}
+
+// toID converts a 20-byte string to a node ID or info hash.
+func toID(s string) ([20]byte, bool) {
+	var id [20]byte
+	if len(s) != len(id) {
+		return id, false
+	}
+	copy(id[:], s)
+	return id, true
+}
+
+// parseNodes decodes compact node info: 26 bytes per node, its ID followed
+// by its IPv4 address and port. A trailing partial record is ignored.
+func parseNodes(data string) []contact {
+	var contacts []contact
+	for i := 0; i+26 <= len(data); i += 26 {
+		var c contact
+		copy(c.id[:], data[i:i+20])
+		c.addr = &net.UDPAddr{
+			IP:   net.IP([]byte(data[i+20 : i+24])),
+			Port: int(binary.BigEndian.Uint16([]byte(data[i+24 : i+26]))),
+		}
+		if c.addr.Port != 0 {
+			contacts = append(contacts, c)
+		}
+	}
+	return contacts
+}
+
+// compactNodes encodes contacts as compact node info (see parseNodes).
+func compactNodes(contacts []contact) []byte {
+	data := make([]byte, 0, 26*len(contacts))
+	for _, c := range contacts {
+		data = append(data, c.id[:]...)
+		data = append(data, c.addr.IP.To4()...)
+		data = binary.BigEndian.AppendUint16(data, uint16(c.addr.Port))
+	}
+	return data
+}
+
+// parsePeers decodes the compact peer addresses (IPv4 address and port) of
+// a get_peers reply. Values of any other length are skipped.
+func parsePeers(values []string) []tracker.PeerInfo {
+	var peers []tracker.PeerInfo
+	for _, value := range values {
+		if len(value) != 6 {
+			continue
+		}
+		peers = append(peers, tracker.PeerInfo{
+			IP:   net.IP([]byte(value[0:4])).String(),
+			Port: int(binary.BigEndian.Uint16([]byte(value[4:6]))),
+		})
+	}
+	return peers
+}
@@ -0,0 +1,204 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/yashkadam007/bittorrent-client/internal/tracker"
+)
+
+const (
+	// bucketSize is Kademlia's K: how many nodes a reply carries, and how
+	// many of the closest nodes a lookup must have queried to finish.
+	bucketSize = 8
+
+	// lookupAlpha is how many queries a lookup keeps in flight.
+	lookupAlpha = 3
+
+	// maxTableSize caps the routing table. Once it is full, a new node only
+	// gets in by replacing one farther from our ID.
+	maxTableSize = 512
+)
+
+// contact is a node we know of.
+type contact struct {
+	id   [20]byte     // Node ID
+	addr *net.UDPAddr // Where it listens
+}
+
+// distance returns the XOR distance between two IDs, which compares (as
+// bytes) the way Kademlia orders nodes.
+func distance(a, b [20]byte) [20]byte {
+	var d [20]byte
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// sortByDistance orders contacts closest to target first.
+func sortByDistance(contacts []contact, target [20]byte) {
+	sort.Slice(contacts, func(i, j int) bool {
+		di, dj := distance(contacts[i].id, target), distance(contacts[j].id, target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+}
+
+// routingTable holds the nodes we know of, by address. It is a flat list
+// rather than Kademlia's buckets, which is plenty for the few lookups a
+// download makes.
+type routingTable struct {
+	self  [20]byte           // Our node ID, which the table keeps close to
+	nodes map[string]contact // Known nodes by address
+	mutex sync.Mutex         // Protects nodes
+}
+
+// newRoutingTable creates an empty routing table for the node self.
+func newRoutingTable(self [20]byte) *routingTable {
+	return &routingTable{self: self, nodes: make(map[string]contact)}
+}
+
+// add records a node, or updates its ID if its address is known.
+func (t *routingTable) add(c contact) {
+	if c.id == t.self || c.addr.IP.To4() == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := c.addr.String()
+	if _, ok := t.nodes[key]; ok || len(t.nodes) < maxTableSize {
+		t.nodes[key] = c
+		return
+	}
+
+	// Full: replace the node farthest from us, if the new one is closer
+	farthestKey, farthest := "", distance(c.id, t.self)
+	for k, other := range t.nodes {
+		if d := distance(other.id, t.self); bytes.Compare(d[:], farthest[:]) > 0 {
+			farthestKey, farthest = k, d
+		}
+	}
+	if farthestKey != "" {
+		delete(t.nodes, farthestKey)
+		t.nodes[key] = c
+	}
+}
+
+// remove forgets the node at addr.
+func (t *routingTable) remove(addr *net.UDPAddr) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.nodes, addr.String())
+}
+
+// len returns the number of known nodes.
+func (t *routingTable) len() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.nodes)
+}
+
+// closest returns up to count known nodes, closest to target first.
+func (t *routingTable) closest(target [20]byte, count int) []contact {
+	t.mutex.Lock()
+	contacts := make([]contact, 0, len(t.nodes))
+	for _, c := range t.nodes {
+		contacts = append(contacts, c)
+	}
+	t.mutex.Unlock()
+
+	sortByDistance(contacts, target)
+	return contacts[:min(len(contacts), count)]
+}
+
+// lookupResult is the outcome of one get_peers query in a lookup.
+type lookupResult struct {
+	node  contact // Node that was queried
+	reply *reply  // Its reply, or nil if it failed
+}
+
+// FindPeers looks up peers for a torrent: starting from the closest nodes in
+// the routing table, it asks nodes for peers, moving to the closer nodes
+// each reply names until the bucketSize closest nodes found have all been
+// asked. Peers found before ctx is cancelled are returned.
+func (n *Node) FindPeers(ctx context.Context, infoHash [20]byte) ([]tracker.PeerInfo, error) {
+	candidates := n.table.closest(infoHash, bucketSize)
+	if len(candidates) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	known := make(map[string]bool)
+	for _, c := range candidates {
+		known[c.addr.String()] = true
+	}
+	queried := make(map[string]bool)
+	seenPeers := make(map[string]bool)
+	var peers []tracker.PeerInfo
+
+	for ctx.Err() == nil {
+		// Ask up to lookupAlpha of the closest nodes not asked yet
+		var batch []contact
+		for _, c := range candidates[:min(len(candidates), bucketSize)] {
+			if len(batch) < lookupAlpha && !queried[c.addr.String()] {
+				queried[c.addr.String()] = true
+				batch = append(batch, c)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		results := make(chan lookupResult, len(batch))
+		for _, c := range batch {
+			go func(c contact) {
+				r, err := n.query(ctx, c.addr, "get_peers", map[string]interface{}{"info_hash": string(infoHash[:])})
+				if err != nil {
+					r = nil
+				}
+				results <- lookupResult{node: c, reply: r}
+			}(c)
+		}
+
+		for range batch {
+			result := <-results
+			if result.reply == nil {
+				candidates = removeContact(candidates, result.node)
+				continue
+			}
+
+			for _, p := range parsePeers(result.reply.Values) {
+				if !seenPeers[p.Addr()] {
+					seenPeers[p.Addr()] = true
+					peers = append(peers, p)
+				}
+			}
+			for _, c := range parseNodes(result.reply.Nodes) {
+				if !known[c.addr.String()] {
+					known[c.addr.String()] = true
+					candidates = append(candidates, c)
+				}
+			}
+		}
+		sortByDistance(candidates, infoHash)
+	}
+
+	if len(peers) == 0 && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return peers, nil
+}
+
+// removeContact returns contacts without the node c.
+func removeContact(contacts []contact, c contact) []contact {
+	for i, other := range contacts {
+		if other.addr.String() == c.addr.String() {
+			return append(contacts[:i], contacts[i+1:]...)
+		}
+	}
+	return contacts
+}
@@ -0,0 +1,158 @@
+package download
+
+import (
+	"context"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/tracker"
+)
+
+// defaultAnnounceInterval is used when the tracker doesn't send an interval.
+const defaultAnnounceInterval = 30 * time.Minute
+
+// minReannounceDelay is the floor for early re-announces when the tracker
+// doesn't send a min interval.
+var minReannounceDelay = time.Minute
+
+// AnnounceFunc performs a regular (event-less) tracker announce.
+type AnnounceFunc func() (*tracker.TrackerResponse, error)
+
+// AnnounceStats returns the transfer totals to report in a tracker announce.
+func (dm *DownloadManager) AnnounceStats() tracker.AnnounceStats {
+	dm.mutex.RLock()
+	uploaded, downloaded := dm.stats.UploadedBytes, dm.stats.DownloadedBytes
+	dm.mutex.RUnlock()
+
+	return tracker.AnnounceStats{
+		Uploaded:   uploaded,
+		Downloaded: downloaded,
+		Left:       dm.pieceManager.GetBytesLeft(),
+	}
+}
+
+// DialReporter is told how many of a tracker's peers we managed to connect
+// to, so it can prefer trackers with reachable peers. *tracker.TrackerClient
+// implements it.
+type DialReporter interface {
+	ReportDials(trackerURL string, attempted, connected int)
+}
+
+// RunAnnouncer connects to the peers in first and keeps announcing until ctx
+// is cancelled or the download is stopped. Announces happen every tracker interval,
+// or earlier (but never sooner than the min interval) when a batch of
+// connection attempts leaves us with fewer than TargetPeers peers or
+// Reannounce is called. Each response's intervals replace the previous ones.
+// The outcome of each batch is passed to reporter, if it is not nil.
+func (dm *DownloadManager) RunAnnouncer(ctx context.Context, first *tracker.TrackerResponse, infoHash, peerID [20]byte, announce AnnounceFunc, reporter DialReporter) {
+	// Also stop when the download manager is stopped, which waits for us
+	lifeCtx, exit, ok := dm.lifecycle.enter()
+	if !ok {
+		return
+	}
+	defer exit()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(lifeCtx, cancel)
+	defer stop()
+
+	interval, minInterval := announceIntervals(first, defaultAnnounceInterval, 0)
+	lastAnnounce := time.Now()
+
+	// Batches can overlap when an announce returns before the previous
+	// batch's dials finish, so every batch reports on a shared channel
+	results := make(chan DialResult)
+	dial := func(resp *tracker.TrackerResponse) {
+		batch := dm.AddPeers(resp.Peers, infoHash, peerID)
+		go func() {
+			var result DialResult
+			select {
+			case result = <-batch:
+			case <-ctx.Done():
+				return
+			}
+			if reporter != nil {
+				reporter.ReportDials(resp.Tracker, result.Attempted, result.Connected)
+			}
+			select {
+			case results <- result:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	dial(first)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	next := lastAnnounce.Add(interval)
+
+	// announceAfter pulls the next announce forward to delay after the last
+	// one, if that is sooner than it is already due
+	announceAfter := func(delay time.Duration) {
+		if at := lastAnnounce.Add(delay); at.Before(next) {
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(time.Until(at))
+			next = at
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-results:
+			if dm.PeerCount() >= dm.options.TargetPeers {
+				continue
+			}
+
+			// Short of peers: pull the next announce forward
+			announceAfter(max(minInterval, minReannounceDelay))
+		case <-dm.reannounce:
+			// Trackers may ban clients announcing more often than min interval
+			announceAfter(minInterval)
+		case <-timer.C:
+			if !dm.IsActive() {
+				return
+			}
+
+			lastAnnounce = time.Now()
+			resp, err := announce()
+			if err == nil {
+				interval, minInterval = announceIntervals(resp, interval, minInterval)
+			}
+			timer.Reset(interval)
+			next = lastAnnounce.Add(interval)
+			if err != nil {
+				continue
+			}
+
+			if len(resp.Peers) > 0 {
+				dial(resp)
+			}
+		}
+	}
+}
+
+// Reannounce asks RunAnnouncer to announce now, or as soon as the tracker's
+// min interval since the last announce allows.
+func (dm *DownloadManager) Reannounce() {
+	select {
+	case dm.reannounce <- struct{}{}:
+	default:
+		// One is already pending
+	}
+}
+
+// announceIntervals returns the announce interval and min interval from a
+// tracker response, keeping the previous values for any it leaves out. The
+// interval is never shorter than the min interval.
+func announceIntervals(resp *tracker.TrackerResponse, interval, minInterval time.Duration) (time.Duration, time.Duration) {
+	if resp.Interval > 0 {
+		interval = time.Duration(resp.Interval) * time.Second
+	}
+	if resp.MinInterval > 0 {
+		minInterval = time.Duration(resp.MinInterval) * time.Second
+	}
+	return max(interval, minInterval), minInterval
+}
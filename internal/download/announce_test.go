@@ -0,0 +1,102 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/tracker"
+)
+
+// dialReport is one call to a DialReporter.
+type dialReport struct {
+	tracker              string
+	attempted, connected int
+}
+
+// dialReports is a DialReporter passing each report to a channel.
+type dialReports chan dialReport
+
+func (r dialReports) ReportDials(trackerURL string, attempted, connected int) {
+	r <- dialReport{trackerURL, attempted, connected}
+}
+
+// deadPeers returns n peers that refuse connections straight away. Dialing
+// the unspecified address reaches this host, where nothing listens on these
+// ports; loopback addresses would be skipped as invalid.
+func deadPeers(n int) []tracker.PeerInfo {
+	peers := make([]tracker.PeerInfo, n)
+	for i := range peers {
+		peers[i] = tracker.PeerInfo{IP: "0.0.0.0", Port: 1 + i}
+	}
+	return peers
+}
+
+func TestEarlyReannounceWhenPeersFail(t *testing.T) {
+	defer func(delay time.Duration) { minReannounceDelay = delay }(minReannounceDelay)
+	minReannounceDelay = 100 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		minInterval int64         // Sent by the tracker, in seconds
+		wantAfter   time.Duration // Earliest the re-announce may come
+	}{
+		{"no min interval", 0, minReannounceDelay},
+		{"min interval", 1, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := NewDownloadManagerWithOptions(newTestTorrent(3).pieceManager(false), NewRarestFirstStrategy(), Options{
+				Quiet:         true,
+				ConnectBudget: 4,
+				TargetPeers:   5,
+			})
+			dm.Start()
+			defer dm.Stop()
+
+			announced := make(chan time.Time, 1)
+			announce := func() (*tracker.TrackerResponse, error) {
+				select {
+				case announced <- time.Now():
+				default:
+				}
+				return nil, fmt.Errorf("no more peers")
+			}
+			reports := make(dialReports, 1)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			start := time.Now()
+			first := &tracker.TrackerResponse{
+				Tracker:     "http://tracker.example.com/announce",
+				Interval:    3600,
+				MinInterval: tt.minInterval,
+				Peers:       deadPeers(20),
+			}
+			go dm.RunAnnouncer(ctx, first, testInfoHash, testPeerID(0), announce, reports)
+
+			// Only the budget is dialed, and none connect
+			select {
+			case report := <-reports:
+				if report.tracker != first.Tracker || report.attempted != 4 || report.connected != 0 {
+					t.Errorf("reported %+v, want 4 attempted and 0 connected for %s", report, first.Tracker)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("dials not reported")
+			}
+
+			// Short of peers, the next announce comes long before the interval,
+			// but not before the min interval
+			select {
+			case at := <-announced:
+				if elapsed := at.Sub(start); elapsed < tt.wantAfter {
+					t.Errorf("re-announced after %s, want at least %s", elapsed, tt.wantAfter)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("no early re-announce")
+			}
+		})
+	}
+}
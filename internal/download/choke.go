@@ -0,0 +1,180 @@
+package download
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// chokeInterval is how often upload slots are reassigned.
+	chokeInterval = 10 * time.Second
+
+	// optimisticRounds is how many choke rounds an optimistic unchoke lasts
+	// before another choked peer gets a turn (30 seconds).
+	optimisticRounds = 3
+
+	// uploadSlots is how many of the best peers are unchoked each round, in
+	// addition to the optimistic unchoke.
+	uploadSlots = 4
+
+	// ratioSlots is how many more peers are unchoked while the share ratio
+	// is below Options.SeedRatioLimit, so that the target is reached sooner.
+	ratioSlots = 4
+)
+
+// ChokeState describes whether we upload to one peer.
+type ChokeState struct {
+	Address    string  // Peer address
+	Interested bool    // Does the peer want pieces from us?
+	Choking    bool    // Are we choking the peer?
+	Optimistic bool    // Unchoked as the optimistic unchoke
+	Rate       float64 // Bytes per second the peer was ranked by last round
+}
+
+// runChoker reassigns upload slots every chokeInterval until ctx is done.
+func (dm *DownloadManager) runChoker(ctx context.Context) {
+	ticker := time.NewTicker(chokeInterval)
+	defer ticker.Stop()
+
+	for round := 1; ; round++ {
+		select {
+		case <-ticker.C:
+			dm.rechoke(round%optimisticRounds == 0)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rechoke unchokes the interested peers that did the most for us over the
+// last round (sent us the most while downloading, took the most while
+// seeding) and the optimistic unchoke, and chokes everyone else. Below a
+// share ratio target more peers are unchoked (see unchokeSlots). The
+// optimistic unchoke moves to a random choked, interested peer when rotate
+// is set or its peer has left or lost interest; it lets new peers show what
+// they can do, and us find better partners.
+func (dm *DownloadManager) rechoke(rotate bool) {
+	seeding := dm.pieceManager.IsComplete()
+
+	dm.mutex.RLock()
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	optimistic := dm.optimistic
+	dm.mutex.RUnlock()
+
+	var interested []*PeerConnection
+	for _, peerConn := range peerConns {
+		peerConn.mutex.Lock()
+		peerConn.downloadRate = float64(peerConn.downloadedBytes-peerConn.downloadSample) / chokeInterval.Seconds()
+		peerConn.downloadSample = peerConn.downloadedBytes
+		// The new rate also sets how deep to pipeline requests
+		peerConn.tuneRequestDepth()
+		transferred := peerConn.downloadedBytes
+		if seeding {
+			transferred = peerConn.uploadedBytes
+		}
+		peerConn.rate = float64(transferred-peerConn.rateSample) / chokeInterval.Seconds()
+		peerConn.rateSample = transferred
+		peerConn.mutex.Unlock()
+
+		if peerConn.conn.IsPeerInterested() {
+			interested = append(interested, peerConn)
+		}
+	}
+
+	// Nothing to upload, paused or done seeding: leave everyone choked
+	if dm.getSource() == nil || dm.isPaused() || dm.SeedLimitReached() {
+		return
+	}
+
+	sort.Slice(interested, func(i, j int) bool {
+		return peerRate(interested[i]) > peerRate(interested[j])
+	})
+
+	unchoke := make(map[*PeerConnection]bool)
+	for _, peerConn := range interested[:min(len(interested), dm.unchokeSlots())] {
+		unchoke[peerConn] = true
+	}
+
+	// Keep the optimistic unchoke unless it is due to move on
+	keep := false
+	for _, peerConn := range interested {
+		if peerConn.addr == optimistic && !unchoke[peerConn] {
+			keep = !rotate
+			if keep {
+				unchoke[peerConn] = true
+			}
+		}
+	}
+	if !keep {
+		optimistic = ""
+		var candidates []*PeerConnection
+		for _, peerConn := range interested {
+			if !unchoke[peerConn] {
+				candidates = append(candidates, peerConn)
+			}
+		}
+		if len(candidates) > 0 {
+			chosen := candidates[rand.Intn(len(candidates))]
+			unchoke[chosen] = true
+			optimistic = chosen.addr
+		}
+	}
+
+	dm.mutex.Lock()
+	dm.optimistic = optimistic
+	dm.mutex.Unlock()
+
+	for _, peerConn := range peerConns {
+		// Failed sends show up in the peers' message loops
+		if unchoke[peerConn] && peerConn.conn.IsChoking() {
+			peerConn.conn.SendUnchoke()
+		} else if !unchoke[peerConn] && !peerConn.conn.IsChoking() {
+			peerConn.conn.SendChoke()
+		}
+	}
+}
+
+// unchokeSlots returns how many of the best peers rechoke unchokes: more
+// while the share ratio is below Options.SeedRatioLimit.
+func (dm *DownloadManager) unchokeSlots() int {
+	limit := dm.options.SeedRatioLimit
+	if limit > 0 && dm.GetStats().Ratio < limit {
+		return uploadSlots + ratioSlots
+	}
+	return uploadSlots
+}
+
+// peerRate returns the rate a peer was ranked by in the last choke round.
+func peerRate(peerConn *PeerConnection) float64 {
+	peerConn.mutex.Lock()
+	defer peerConn.mutex.Unlock()
+	return peerConn.rate
+}
+
+// GetChokeState returns whether we are uploading to each connected peer,
+// ordered by address.
+func (dm *DownloadManager) GetChokeState() []ChokeState {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+
+	states := make([]ChokeState, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		states = append(states, ChokeState{
+			Address:    peerConn.addr,
+			Interested: peerConn.conn.IsPeerInterested(),
+			Choking:    peerConn.conn.IsChoking(),
+			Optimistic: peerConn.addr == dm.optimistic,
+			Rate:       peerRate(peerConn),
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Address < states[j].Address
+	})
+	return states
+}
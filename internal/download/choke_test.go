@@ -0,0 +1,95 @@
+package download
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// leechPipePeer has a pipePeer ask for the first block whenever it's
+// unchoked, and flip between interested and not every few messages, until
+// the connection closes.
+func leechPipePeer(conn *peer.Connection) {
+	// Read on a goroutine of its own, as in servePipePeer
+	msgs := make(chan *peer.Message, 1024)
+	go func() {
+		defer close(msgs)
+		for {
+			msg, err := conn.ReceiveMessage()
+			if err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	defer conn.Close()
+
+	if conn.SendInterested() != nil {
+		return
+	}
+	received := 0
+	for msg := range msgs {
+		var err error
+		received++
+		if msg.Type == peer.MsgUnchoke {
+			err = conn.SendRequest(0, 0, pieces.BlockSize)
+		} else if received%5 == 0 {
+			if conn.IsInterested() {
+				err = conn.SendNotInterested()
+			} else {
+				err = conn.SendInterested()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestRechokeWhilePeersActive(t *testing.T) {
+	tt := newTestTorrent(3)
+	dm := NewDownloadManagerWithOptions(tt.pieceManager(true), NewRarestFirstStrategy(), Options{Quiet: true})
+	dm.SetStorage(tt)
+	dm.Start()
+	defer dm.Stop()
+
+	numPeers := uploadSlots + 4
+	for n := 1; n <= numPeers; n++ {
+		go leechPipePeer(pipePeer(t, dm, byte(n)))
+	}
+
+	// Rechoke rounds race the peers' message loops, which unchoke into free
+	// slots and record interest as it changes
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 1; round <= 50; round++ {
+			dm.rechoke(round%optimisticRounds == 0)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		dm.GetChokeState()
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+
+	// Only interested peers get the upload slots and the optimistic unchoke
+	dm.rechoke(false)
+	unchoked := 0
+	for _, state := range dm.GetChokeState() {
+		if !state.Choking {
+			unchoked++
+		}
+	}
+	if unchoked > uploadSlots+1 {
+		t.Errorf("%d peers unchoked, want at most %d", unchoked, uploadSlots+1)
+	}
+	if dm.PeerCount() != numPeers {
+		t.Errorf("%d peers connected, want %d", dm.PeerCount(), numPeers)
+	}
+}
@@ -0,0 +1,34 @@
+package download
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+)
+
+// SetDHT tells peers that support DHT that our DHT node listens on UDP port,
+// and passes the DHT node addresses peers announce in port messages to
+// addNode (e.g. dht.Node.AddNode). Call it before adding any peer. Never
+// call it for private torrents (see AddPeers).
+func (dm *DownloadManager) SetDHT(port int, addNode func(addr string)) {
+	dm.dhtPort = port
+	dm.addDHTNode = addNode
+}
+
+// PeerOptions returns the options connections to this download's peers are
+// made with, for listeners accepting them (see peer.ListenWithOptions). DHT
+// is only advertised once SetDHT has been called.
+func (dm *DownloadManager) PeerOptions() peer.Options {
+	return peer.Options{DHT: dm.addDHTNode != nil}
+}
+
+// handlePort passes on the DHT node a peer announced in a port message,
+// which listens at the peer's IP address.
+func (dm *DownloadManager) handlePort(peerConn *PeerConnection, port int) {
+	host, _, err := net.SplitHostPort(peerConn.addr)
+	if err != nil || port == 0 {
+		return
+	}
+	dm.addDHTNode(net.JoinHostPort(host, strconv.Itoa(port)))
+}
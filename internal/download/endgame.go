@@ -0,0 +1,114 @@
+package download
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// requestEndgame asks a peer with nothing new to request for blocks that are
+// already requested from someone else, once every missing block has been
+// requested. Without it, the last few pieces wait on whichever peers were
+// given them, however slow. The piece manager isn't told about duplicates:
+// the original request still owns the block, and whichever copy arrives
+// first is kept while the others are cancelled.
+func (dm *DownloadManager) requestEndgame(peerConn *PeerConnection, peerBitfield *pieces.Bitfield) {
+	if !dm.pieceManager.InEndgame() {
+		return
+	}
+
+	dm.mutex.Lock()
+	entering := !dm.endgame
+	dm.endgame = true
+	dm.mutex.Unlock()
+	if entering && !dm.quiet {
+		fmt.Printf("Entering endgame: requesting the remaining blocks from every peer\n")
+	}
+
+	var blockReqs []*pieces.BlockRequest
+	var requests []peer.Request
+	now := time.Now()
+	peerConn.mutex.Lock()
+	if peerConn.closed {
+		peerConn.mutex.Unlock()
+		return
+	}
+	for _, blockReq := range dm.pieceManager.GetOutstandingBlocks() {
+		if len(peerConn.pendingRequests) >= peerConn.maxRequests {
+			break
+		}
+		key := fmt.Sprintf("%d:%d", blockReq.PieceIndex, blockReq.Begin)
+		if _, pending := peerConn.pendingRequests[key]; pending || !peerBitfield.HasPiece(blockReq.PieceIndex) {
+			continue
+		}
+
+		peerConn.pendingRequests[key] = blockReq
+		peerConn.duplicates[key] = true
+		peerConn.requestedAt[key] = now
+		blockReqs = append(blockReqs, blockReq)
+		requests = append(requests, peer.Request{
+			PieceIndex: blockReq.PieceIndex,
+			Begin:      blockReq.Begin,
+			Length:     blockReq.Length,
+		})
+	}
+	peerConn.mutex.Unlock()
+
+	if len(requests) == 0 {
+		return
+	}
+
+	err := peerConn.conn.SendRequests(requests)
+	if err != nil {
+		// Nothing to release: the original requests still own these blocks
+		peerConn.mutex.Lock()
+		for _, blockReq := range blockReqs {
+			key := fmt.Sprintf("%d:%d", blockReq.PieceIndex, blockReq.Begin)
+			if peerConn.pendingRequests[key] == blockReq {
+				delete(peerConn.pendingRequests, key)
+				delete(peerConn.duplicates, key)
+				delete(peerConn.requestedAt, key)
+			}
+		}
+		peerConn.mutex.Unlock()
+
+		if !dm.quiet {
+			fmt.Printf("Failed to send endgame requests to %s: %v\n", peerConn.addr, err)
+		}
+	}
+}
+
+// cancelDuplicates cancels a just-received block at every other peer it is
+// still pending with. Outside endgame no other peer has it pending, so this
+// sends nothing.
+func (dm *DownloadManager) cancelDuplicates(from *PeerConnection, blockReq *pieces.BlockRequest) {
+	dm.mutex.RLock()
+	endgame := dm.endgame
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		if peerConn != from {
+			peerConns = append(peerConns, peerConn)
+		}
+	}
+	dm.mutex.RUnlock()
+	if !endgame {
+		return
+	}
+
+	key := fmt.Sprintf("%d:%d", blockReq.PieceIndex, blockReq.Begin)
+	for _, peerConn := range peerConns {
+		peerConn.mutex.Lock()
+		_, pending := peerConn.pendingRequests[key]
+		delete(peerConn.pendingRequests, key)
+		delete(peerConn.duplicates, key)
+		delete(peerConn.requestedAt, key)
+		peerConn.mutex.Unlock()
+
+		if pending {
+			// A failed send shows up in the peer's message loop
+			peerConn.conn.SendCancel(blockReq.PieceIndex, blockReq.Begin, blockReq.Length)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package download
+
+import "time"
+
+// EventType identifies what happened in a download Event.
+type EventType string
+
+const (
+	EventPeerConnected    EventType = "peer_connected"    // A peer connection was established
+	EventPeerDisconnected EventType = "peer_disconnected" // A peer connection was closed
+	EventPieceCompleted   EventType = "piece_completed"   // A piece was downloaded and verified
+	EventDownloadComplete EventType = "download_complete" // Every piece has been verified
+	EventPaused           EventType = "paused"            // Requests stopped, e.g. because the disk is full
+	EventResumed          EventType = "resumed"           // Requests started again after a pause
+	EventSeedingDone      EventType = "seeding_done"      // A seeding limit was reached; uploads stopped
+)
+
+// eventBufferSize is how many events can queue up before new ones are dropped.
+const eventBufferSize = 256
+
+// Event describes a change in the download's state.
+type Event struct {
+	Type  EventType // What happened
+	Time  time.Time // When it happened
+	Peer  string    // Peer address, for peer events
+	Piece int       // Piece index, for piece events
+	Error string    // What went wrong, for pause events
+}
+
+// Events returns the channel download events are published on. Events are
+// delivered on a best-effort basis: if the consumer falls behind, new events
+// are dropped rather than stalling the peer connections that produce them.
+func (dm *DownloadManager) Events() <-chan Event {
+	return dm.events
+}
+
+// emit publishes an event without blocking.
+func (dm *DownloadManager) emit(event Event) {
+	event.Time = time.Now()
+
+	select {
+	case dm.events <- event:
+	default:
+	}
+}
@@ -0,0 +1,94 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// stopTimeout bounds how long Stop waits for background goroutines to exit.
+const stopTimeout = 10 * time.Second
+
+// lifecycle tracks the goroutines a DownloadManager runs between Start and
+// Stop, so that Stop can cancel them and wait until they have all exited.
+type lifecycle struct {
+	ctx     context.Context    // Cancelled by Stop
+	cancel  context.CancelFunc // Cancels ctx
+	workers *sync.WaitGroup    // Goroutines running under ctx
+	mutex   sync.Mutex         // Protects the fields above
+}
+
+// newLifecycle creates a running lifecycle.
+func newLifecycle() *lifecycle {
+	lc := &lifecycle{}
+	lc.reset()
+	return lc
+}
+
+// reset starts a fresh context and worker group. The caller must hold the
+// mutex unless lc isn't shared yet.
+func (lc *lifecycle) reset() {
+	lc.ctx, lc.cancel = context.WithCancel(context.Background())
+	lc.workers = &sync.WaitGroup{}
+}
+
+// restart makes a stopped lifecycle usable again.
+func (lc *lifecycle) restart() {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if lc.ctx.Err() != nil {
+		lc.reset()
+	}
+}
+
+// enter registers the calling goroutine as a worker. It returns the context
+// to exit on and a function to call on exit, or false if the lifecycle has
+// been stopped and the caller should return straight away.
+func (lc *lifecycle) enter() (context.Context, func(), bool) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if lc.ctx.Err() != nil {
+		return nil, nil, false
+	}
+	lc.workers.Add(1)
+	return lc.ctx, lc.workers.Done, true
+}
+
+// spawn runs f on a new worker goroutine and returns true, unless the
+// lifecycle has been stopped. f should return promptly once ctx is cancelled.
+func (lc *lifecycle) spawn(f func(ctx context.Context)) bool {
+	ctx, done, ok := lc.enter()
+	if !ok {
+		return false
+	}
+
+	go func() {
+		defer done()
+		f(ctx)
+	}()
+	return true
+}
+
+// stop cancels every worker and waits up to timeout for them to exit.
+func (lc *lifecycle) stop(timeout time.Duration) error {
+	lc.mutex.Lock()
+	lc.cancel()
+	workers := lc.workers
+	lc.mutex.Unlock()
+
+	exited := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("background goroutines still running after %s", timeout)
+	}
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+)
+
+func TestStartStopDoesNotLeakGoroutines(t *testing.T) {
+	tt := newTestTorrent(4)
+	dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(), Options{
+		Quiet:          true,
+		Warmup:         time.Hour,
+		SeedRatioLimit: 1,
+	})
+	dm.SetStorage(tt)
+
+	// Each cycle runs every background goroutine Start spawns, plus those
+	// of a connected peer
+	cycle := func() {
+		dm.Start()
+
+		ours, theirs := net.Pipe()
+		go io.Copy(io.Discard, theirs)
+		dm.AddInboundPeer(peer.NewConnection(ours, testInfoHash, testPeerID(1)))
+
+		dm.Stop()
+		theirs.Close()
+	}
+
+	// The first cycle may start goroutines that live on by design (e.g. the
+	// race detector's or the runtime's own)
+	cycle()
+	before := settledGoroutines(-1)
+
+	const cycles = 20
+	for i := 0; i < cycles; i++ {
+		cycle()
+	}
+
+	if after := settledGoroutines(before); after > before {
+		buf := make([]byte, 1<<20)
+		t.Errorf("%d goroutines before %d Start/Stop cycles, %d after\n%s",
+			before, cycles, after, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// settledGoroutines returns the number of goroutines once it drops to target,
+// or after a second if it doesn't. Goroutines only exit some time after
+// whatever stopped them returns.
+func settledGoroutines(target int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= target || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLifecycleStopWaitsForWorkers(t *testing.T) {
+	lc := newLifecycle()
+
+	exited := make(chan struct{})
+	lc.spawn(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		close(exited)
+	})
+
+	if err := lc.stop(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	default:
+		t.Fatal("stop returned before the worker exited")
+	}
+
+	if lc.spawn(func(context.Context) {}) {
+		t.Error("spawn succeeded after stop")
+	}
+	lc.restart()
+	if !lc.spawn(func(context.Context) {}) {
+		t.Error("spawn failed after restart")
+	}
+}
+
+func TestLifecycleStopTimeout(t *testing.T) {
+	lc := newLifecycle()
+	release := make(chan struct{})
+	defer close(release)
+	lc.spawn(func(context.Context) { <-release })
+
+	if err := lc.stop(20 * time.Millisecond); err == nil {
+		t.Error("stop didn't time out on a worker ignoring cancellation")
+	}
+}
@@ -0,0 +1,105 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// ErrPausedByUser is the reason to give Pause when the user asks for it.
+var ErrPausedByUser = errors.New("paused by user")
+
+// isDiskFull reports whether err means the disk is full. Unlike other write
+// errors it won't go away by retrying, so the download pauses instead of
+// re-fetching pieces it can't store.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// Pause stops requesting blocks and uploading until Resume is called. Peers
+// stay connected but are choked, and blocks already requested are still
+// accepted.
+func (dm *DownloadManager) Pause(reason error) {
+	dm.mutex.Lock()
+	if dm.paused {
+		dm.mutex.Unlock()
+		return
+	}
+	dm.paused = true
+	dm.pauseReason = reason
+	dm.optimistic = ""
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	dm.mutex.Unlock()
+
+	dm.lifecycle.spawn(func(context.Context) {
+		for _, peerConn := range peerConns {
+			// A failed send shows up in the peer's message loop
+			if !peerConn.conn.IsChoking() {
+				peerConn.conn.SendChoke()
+			}
+		}
+	})
+
+	if !dm.quiet {
+		fmt.Printf("Download paused: %v\n", reason)
+	}
+	dm.emit(Event{Type: EventPaused, Error: reason.Error()})
+}
+
+// Resume undoes Pause and starts requesting from every connected peer.
+// Peers are unchoked again from the next choke round.
+func (dm *DownloadManager) Resume() {
+	dm.mutex.Lock()
+	if !dm.paused {
+		dm.mutex.Unlock()
+		return
+	}
+	dm.paused = false
+	dm.pauseReason = nil
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	dm.mutex.Unlock()
+
+	if !dm.quiet {
+		fmt.Println("Download resumed")
+	}
+	dm.emit(Event{Type: EventResumed})
+
+	for _, peerConn := range peerConns {
+		dm.spawnRequests(peerConn)
+	}
+}
+
+// PauseReason returns why the download is paused, or nil if it isn't.
+func (dm *DownloadManager) PauseReason() error {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.pauseReason
+}
+
+// isPaused reports whether requests are held back by Pause.
+func (dm *DownloadManager) isPaused() bool {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.paused
+}
+
+// handleBlockError deals with a block the piece manager failed to take. A
+// full disk pauses the download; anything else is treated as transient and
+// only logged, and the piece is fetched again.
+func (dm *DownloadManager) handleBlockError(err error) {
+	if isDiskFull(err) {
+		dm.Pause(err)
+		return
+	}
+
+	if !dm.quiet {
+		fmt.Printf("Failed to add block: %v\n", err)
+	}
+}
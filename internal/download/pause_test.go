@@ -0,0 +1,159 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingStore is a piece store whose writes fail with err while it is set.
+type failingStore struct {
+	mutex  sync.Mutex
+	err    error          // Returned by WritePiece (nil writes succeed)
+	pieces map[int][]byte // Pieces written
+}
+
+func (s *failingStore) setError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+func (s *failingStore) WritePiece(pieceIndex int, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.pieces == nil {
+		s.pieces = make(map[int][]byte)
+	}
+	s.pieces[pieceIndex] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *failingStore) ReadPiece(pieceIndex int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if data, ok := s.pieces[pieceIndex]; ok {
+		return data, nil
+	}
+	return nil, fmt.Errorf("piece %d not written", pieceIndex)
+}
+
+func (s *failingStore) MarkPieceVerified(pieceIndex int) error {
+	return nil
+}
+
+// waitEvent returns the first event of type want from dm, failing the test
+// if none arrives in time.
+func waitEvent(t *testing.T, dm *DownloadManager, want EventType) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-dm.Events():
+			if event.Type == want {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", want)
+		}
+	}
+}
+
+func TestDiskFullPauses(t *testing.T) {
+	tt := newTestTorrent(4)
+	store := &failingStore{}
+	store.setError(&os.PathError{Op: "write", Path: "test.bin", Err: syscall.ENOSPC})
+	pm := tt.pieceManager(false)
+	pm.SetStorage(store)
+	dm := NewDownloadManagerWithOptions(pm, NewRarestFirstStrategy(), Options{Quiet: true})
+	dm.Start()
+	defer dm.Stop()
+
+	var mutex sync.Mutex
+	requests := 0
+	conn := pipePeer(t, dm, 1)
+	go servePipePeer(conn, func(pieceIndex, begin, length int) error {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		data, _ := tt.ReadBlock(pieceIndex, begin, length)
+		return conn.SendPiece(pieceIndex, begin, data)
+	})
+
+	event := waitEvent(t, dm, EventPaused)
+	if event.Error == "" || !errors.Is(dm.PauseReason(), syscall.ENOSPC) {
+		t.Errorf("paused with event error %q and reason %v, want the disk full error", event.Error, dm.PauseReason())
+	}
+
+	// Blocks already asked for may still arrive, but no more are requested
+	time.Sleep(200 * time.Millisecond)
+	mutex.Lock()
+	paused := requests
+	mutex.Unlock()
+	time.Sleep(300 * time.Millisecond)
+	mutex.Lock()
+	if requests != paused {
+		t.Errorf("%d requests sent while paused", requests-paused)
+	}
+	mutex.Unlock()
+
+	// With space freed, resuming finishes the download
+	store.setError(nil)
+	dm.Resume()
+	waitEvent(t, dm, EventResumed)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dm.WaitComplete(ctx); err != nil {
+		t.Fatalf("download didn't complete after resuming: %v", err)
+	}
+}
+
+func TestTransientWriteErrorDoesNotPause(t *testing.T) {
+	tt := newTestTorrent(4)
+	store := &failingStore{}
+	store.setError(errors.New("transient write error"))
+	pm := tt.pieceManager(false)
+	pm.SetStorage(store)
+	dm := NewDownloadManagerWithOptions(pm, NewRarestFirstStrategy(), Options{Quiet: true})
+	dm.Start()
+	defer dm.Stop()
+
+	var once sync.Once
+	conn := pipePeer(t, dm, 1)
+	go servePipePeer(conn, func(pieceIndex, begin, length int) error {
+		data, _ := tt.ReadBlock(pieceIndex, begin, length)
+		err := conn.SendPiece(pieceIndex, begin, data)
+		// Let writes succeed again once a whole piece has been sent
+		if begin+length == testPieceLength {
+			once.Do(func() { time.AfterFunc(50*time.Millisecond, func() { store.setError(nil) }) })
+		}
+		return err
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := dm.WaitComplete(ctx); err != nil {
+		t.Fatalf("download didn't complete: %v", err)
+	}
+	if reason := dm.PauseReason(); reason != nil {
+		t.Errorf("paused by a transient error: %v", reason)
+	}
+	for drained := false; !drained; {
+		select {
+		case event := <-dm.Events():
+			if event.Type == EventPaused {
+				t.Errorf("paused event for a transient error: %q", event.Error)
+			}
+		default:
+			drained = true
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/tracker"
+)
+
+// pexInterval is how often each peer is sent a ut_pex message, and roughly
+// how often one is accepted from it (BEP 11).
+const pexInterval = time.Minute
+
+// pexSettings are what peer exchange needs to dial the peers it learns of.
+type pexSettings struct {
+	infoHash   [20]byte // Torrent the peers are dialed for
+	peerID     [20]byte // Our peer ID
+	listenPort int      // Port we accept connections on, told to peers (0 if none)
+}
+
+// EnablePEX turns on peer exchange (ut_pex, BEP 11) with peers supporting
+// it: connected peers are passed on to them once a minute, and the peers
+// they pass on are dialed like a tracker's, with infoHash and peerID.
+// listenPort, if not 0, is the port we accept connections on. Call it
+// before Start. Never call it for private torrents (see AddPeers).
+func (dm *DownloadManager) EnablePEX(infoHash, peerID [20]byte, listenPort int) {
+	dm.pex = &pexSettings{infoHash: infoHash, peerID: peerID, listenPort: listenPort}
+}
+
+// handlePex dials the peers a peer passed on in a ut_pex message. Messages
+// arriving much sooner than pexInterval after the last are ignored, so that
+// a peer can't make us dial faster than the protocol intends.
+func (dm *DownloadManager) handlePex(peerConn *PeerConnection, added []*net.TCPAddr) {
+	if time.Since(peerConn.pexReceived) < pexInterval/2 {
+		return
+	}
+	peerConn.pexReceived = time.Now()
+
+	peers := make([]tracker.PeerInfo, len(added))
+	for i, addr := range added {
+		peers[i] = tracker.PeerInfo{IP: addr.IP.String(), Port: addr.Port}
+	}
+	if !dm.quiet && len(peers) > 0 {
+		fmt.Printf("Learned %d peers from %s\n", len(peers), peerConn.addr)
+	}
+	dm.AddPeers(peers, dm.pex.infoHash, dm.pex.peerID)
+}
+
+// runPex sends every peer supporting ut_pex the peers connected and dropped
+// since its last message, every pexInterval until ctx is done.
+func (dm *DownloadManager) runPex(ctx context.Context) {
+	ticker := time.NewTicker(pexInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dm.sendPex()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendPex sends one round of ut_pex messages.
+func (dm *DownloadManager) sendPex() {
+	dm.mutex.RLock()
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	dm.mutex.RUnlock()
+
+	connected := make(map[string]*net.TCPAddr)
+	for _, peerConn := range peerConns {
+		if addr := peerConn.listenAddr(); addr != nil {
+			connected[addr.String()] = addr
+		}
+	}
+
+	for _, peerConn := range peerConns {
+		if !peerConn.conn.SupportsPex() {
+			continue
+		}
+
+		self := peerConn.listenAddr()
+		var added, dropped []*net.TCPAddr
+		for key, addr := range connected {
+			if _, sent := peerConn.pexSent[key]; !sent && (self == nil || key != self.String()) {
+				added = append(added, addr)
+			}
+		}
+		for key, addr := range peerConn.pexSent {
+			if _, ok := connected[key]; !ok {
+				dropped = append(dropped, addr)
+			}
+		}
+		if len(added) == 0 && len(dropped) == 0 {
+			continue
+		}
+
+		added = added[:min(len(added), peer.MaxPexPeers)]
+		dropped = dropped[:min(len(dropped), peer.MaxPexPeers)]
+		if peerConn.conn.SendPex(added, dropped) != nil {
+			// A failed send shows up in the peer's message loop
+			continue
+		}
+		for _, addr := range added {
+			peerConn.pexSent[addr.String()] = addr
+		}
+		for _, addr := range dropped {
+			delete(peerConn.pexSent, addr.String())
+		}
+	}
+}
+
+// listenAddr returns the address the peer accepts connections on, which is
+// what peer exchange passes on: the address we dialed, or for a peer that
+// connected to us, its IP with the port from its extended handshake. It is
+// nil if unknown.
+func (peerConn *PeerConnection) listenAddr() *net.TCPAddr {
+	host, portStr, err := net.SplitHostPort(peerConn.addr)
+	if err != nil {
+		return nil
+	}
+	port, _ := strconv.Atoi(portStr)
+	if peerConn.inbound {
+		port = peerConn.conn.ListenPort()
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || port == 0 {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
@@ -0,0 +1,51 @@
+package download
+
+import (
+	"math"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+const (
+	// defaultRequestDepth is how many requests a peer gets in flight until
+	// its rate and round-trip time are known.
+	defaultRequestDepth = 10
+
+	// minRequestDepth and maxRequestDepth bound the tuned request depth.
+	// Even a slow peer should have the next block queued while it sends
+	// one; most peers drop requests beyond a few hundred.
+	minRequestDepth = 2
+	maxRequestDepth = 250
+
+	// pipelineHeadroom scales the bandwidth-delay product. While too few
+	// requests are in flight the rate measured is what they allow, so
+	// asking for more than that product is what lets the depth grow until
+	// the peer (or our link) is the limit.
+	pipelineHeadroom = 2
+)
+
+// sampleRTT records how long a request took to be answered. The fastest
+// answer is kept as the round-trip time: slower ones also include time
+// spent queued behind the other requests in flight, which would make the
+// depth chase its own queue. Caller must hold peerConn.mutex.
+func (peerConn *PeerConnection) sampleRTT(sample time.Duration) {
+	if sample > 0 && (peerConn.rtt == 0 || sample < peerConn.rtt) {
+		peerConn.rtt = sample
+	}
+}
+
+// tuneRequestDepth sets how many requests the peer may have in flight to
+// its bandwidth-delay product (download rate over the last choke round
+// times round-trip time, in blocks), with headroom. It is left alone until
+// both are known, and while the peer sends nothing (e.g. it choked us).
+// Caller must hold peerConn.mutex.
+func (peerConn *PeerConnection) tuneRequestDepth() {
+	if peerConn.rtt == 0 || peerConn.downloadRate == 0 {
+		return
+	}
+
+	blocks := peerConn.downloadRate * peerConn.rtt.Seconds() / pieces.BlockSize
+	depth := int(math.Ceil(blocks * pipelineHeadroom))
+	peerConn.maxRequests = min(max(depth, minRequestDepth), maxRequestDepth)
+}
@@ -0,0 +1,75 @@
+package download
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// rateLimiter is a token bucket shared by every peer connection, so that
+// together they stay under one byte rate. Callers may overdraw it: the wait
+// for a block is charged after the block is taken, which keeps one large
+// block from waiting forever behind a small bucket.
+type rateLimiter struct {
+	rate   float64    // Bytes added to the bucket per second
+	burst  float64    // Most bytes the bucket holds
+	tokens float64    // Bytes available now; negative while overdrawn
+	last   time.Time  // When tokens was last brought up to date
+	mutex  sync.Mutex // Protects tokens and last
+}
+
+// newRateLimiter creates a limiter allowing bytesPerSec, or returns nil (no
+// limit) when bytesPerSec is 0. It allows bursts of up to a second's worth.
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	burst := max(float64(bytesPerSec), pieces.BlockSize)
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait takes n bytes from the bucket and blocks until the bucket is no
+// longer overdrawn, or ctx is done. A nil limiter never blocks.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainTime returns how long the limit takes to let n bytes through. A nil
+// limiter takes no time.
+func (l *rateLimiter) drainTime(n int64) time.Duration {
+	if l == nil {
+		return 0
+	}
+	return time.Duration(float64(n) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,334 @@
+package download
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// maxQueuedUploads is how many of a peer's requests wait for the upload
+// limit before its message loop waits too. Peers rarely pipeline more.
+const maxQueuedUploads = 250
+
+// BlockReader supplies the data a Seeder serves.
+type BlockReader interface {
+	ReadBlock(pieceIndex, begin, length int) ([]byte, error)
+}
+
+// Seeder serves the pieces we have to peers that connect to us, unchoking
+// every peer as soon as it's interested. It runs no choke rounds: a
+// DownloadManager given a source with SetStorage is what limits uploads to
+// the best peers, rechoking every chokeInterval (see rechoke) and in between
+// unchoking newly interested peers only into free slots (see
+// unchokeInterested).
+type Seeder struct {
+	have     *pieces.Bitfield              // Pieces we serve
+	source   BlockReader                   // Where block data is read from
+	quiet    bool                          // Suppress stdout output
+	uploaded atomic.Int64                  // Bytes sent in piece messages
+	limiter  *rateLimiter                  // Caps the upload rate across all peers (nil for none)
+	conns    map[*peer.Connection]struct{} // Peers currently being served
+	closed   bool                          // Close was called; refuse new peers
+	mutex    sync.Mutex                    // Protects conns and closed
+}
+
+// NewSeeder creates a seeder serving the pieces set in have from source.
+func NewSeeder(have *pieces.Bitfield, source BlockReader, quiet bool) *Seeder {
+	return &Seeder{
+		have:   have,
+		source: source,
+		quiet:  quiet,
+		conns:  make(map[*peer.Connection]struct{}),
+	}
+}
+
+// SetMaxUpload caps how fast the seeder uploads across all peers, in bytes
+// per second (0 means unlimited). Call it before serving any peer.
+func (s *Seeder) SetMaxUpload(bytesPerSec int64) {
+	s.limiter = newRateLimiter(bytesPerSec)
+}
+
+// ServePeer serves a connected peer until it disconnects or the seeder is
+// closed. It has the signature peer.Listener.Serve expects of a handler.
+func (s *Seeder) ServePeer(conn *peer.Connection) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	err := s.serve(conn)
+	if err != nil && !s.quiet {
+		fmt.Printf("Stopped serving %s: %v\n", conn.RemoteAddr(), err)
+	}
+}
+
+// serve runs the message loop for one peer.
+func (s *Seeder) serve(conn *peer.Connection) error {
+	conn.SetNumPieces(s.have.GetNumPieces())
+
+	err := announcePieces(conn, s.have)
+	if err != nil {
+		return fmt.Errorf("failed to send bitfield: %w", err)
+	}
+
+	for {
+		msg, err := conn.ReceiveMessage()
+		if err != nil {
+			return err
+		}
+
+		err = conn.HandleMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case peer.MsgInterested:
+			if conn.IsChoking() {
+				err = conn.SendUnchoke()
+			}
+		case peer.MsgRequest:
+			// Nothing else is read from a peer we only upload to, so the
+			// loop can wait out the limit itself
+			s.limiter.wait(context.Background(), requestLength(msg.Payload))
+			var sent int
+			sent, err = serveRequest(conn, msg.Payload, s.have.HasPiece, s.source)
+			s.uploaded.Add(int64(sent))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// announcePieces sends the pieces set in have as the first message after
+// the handshake. With the fast extension a complete or empty bitfield is
+// sent as have_all or have_none; otherwise a bitfield is left out when we
+// have nothing.
+func announcePieces(conn *peer.Connection, have *pieces.Bitfield) error {
+	switch {
+	case conn.SupportsFast() && have.IsComplete():
+		return conn.SendHaveAll()
+	case conn.SupportsFast() && have.GetNumCompletePieces() == 0:
+		return conn.SendHaveNone()
+	case have.GetNumCompletePieces() > 0:
+		return conn.SendBitfield(have.ToBytes())
+	}
+	return nil
+}
+
+// rejectRequest answers a request we won't serve. Peers with the fast
+// extension are told so; others expect it to be dropped silently.
+func rejectRequest(conn *peer.Connection, payload []byte) error {
+	if !conn.SupportsFast() {
+		return nil
+	}
+	pieceIndex := int(binary.BigEndian.Uint32(payload[0:4]))
+	begin := int(binary.BigEndian.Uint32(payload[4:8]))
+	return conn.SendRejectRequest(pieceIndex, begin, requestLength(payload))
+}
+
+// serveRequest answers a block request from source and returns the number
+// of bytes sent. Requests for pieces we don't have (per have), or for more
+// than a block, end the connection; requests made while the peer is choked
+// are rejected (see rejectRequest).
+func serveRequest(conn *peer.Connection, payload []byte, have func(pieceIndex int) bool, source BlockReader) (int, error) {
+	if conn.IsChoking() {
+		return 0, rejectRequest(conn, payload)
+	}
+
+	pieceIndex := int(binary.BigEndian.Uint32(payload[0:4]))
+	begin := int(binary.BigEndian.Uint32(payload[4:8]))
+	length := requestLength(payload)
+
+	if !have(pieceIndex) {
+		return 0, fmt.Errorf("requested piece %d, which we don't have", pieceIndex)
+	}
+	if length <= 0 || length > peer.MaxBlockLength {
+		return 0, fmt.Errorf("requested invalid block length %d", length)
+	}
+
+	data, err := source.ReadBlock(pieceIndex, begin, length)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read requested block: %w", err)
+	}
+
+	err = conn.SendPiece(pieceIndex, begin, data)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// requestLength returns the block length asked for in a request payload.
+func requestLength(payload []byte) int {
+	return int(binary.BigEndian.Uint32(payload[8:12]))
+}
+
+// Uploaded returns the number of bytes served so far.
+func (s *Seeder) Uploaded() int64 {
+	return s.uploaded.Load()
+}
+
+// PeerCount returns the number of peers currently being served.
+func (s *Seeder) PeerCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+// Close disconnects every peer and refuses new ones.
+func (s *Seeder) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// SetStorage lets the download manager serve blocks of the pieces it has to
+// peers that ask for them, reading them from source (e.g. a FileStorage).
+// Without it, peers stay choked and nothing is uploaded.
+func (dm *DownloadManager) SetStorage(source BlockReader) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	dm.source = source
+}
+
+// getSource returns where requested blocks are read from, or nil
+func (dm *DownloadManager) getSource() BlockReader {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.source
+}
+
+// unchokeInterested unchokes a peer that became interested, if we have
+// something to serve, aren't paused or done seeding and an upload slot is
+// free, so it needn't wait for the next choke round. Otherwise the choke
+// rounds decide (see rechoke).
+func (dm *DownloadManager) unchokeInterested(peerConn *PeerConnection) error {
+	if dm.getSource() == nil || dm.isPaused() || dm.SeedLimitReached() || !peerConn.conn.IsChoking() {
+		return nil
+	}
+
+	slots := dm.unchokeSlots()
+	dm.mutex.RLock()
+	unchoked := 0
+	for _, other := range dm.peers {
+		if !other.conn.IsChoking() {
+			unchoked++
+		}
+	}
+	dm.mutex.RUnlock()
+
+	// Leave room for the optimistic unchoke
+	if unchoked >= slots+1 {
+		return nil
+	}
+	return peerConn.conn.SendUnchoke()
+}
+
+// handleRequest serves a block request from an unchoked peer. Under an
+// upload limit the request is queued for serveUploads instead, so that the
+// wait doesn't hold up the blocks this peer is sending us.
+func (dm *DownloadManager) handleRequest(ctx context.Context, peerConn *PeerConnection, payload []byte) error {
+	if dm.uploadLimiter == nil {
+		return dm.serveBlock(peerConn, payload)
+	}
+
+	select {
+	case peerConn.uploads <- payload:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// serveUploads serves a peer's queued requests as fast as the upload limit
+// allows, until ctx is done. A request that can't be served ends the
+// connection, as it would if served from the message loop.
+func (dm *DownloadManager) serveUploads(ctx context.Context, peerConn *PeerConnection) {
+	for {
+		var payload []byte
+		select {
+		case payload = <-peerConn.uploads:
+		case <-ctx.Done():
+			return
+		}
+
+		if dm.uploadLimiter.wait(ctx, requestLength(payload)) != nil {
+			return
+		}
+
+		err := dm.serveBlock(peerConn, payload)
+		if err != nil {
+			if !dm.quiet {
+				fmt.Printf("Error serving %s: %v\n", peerConn.addr, err)
+			}
+			peerConn.conn.Close()
+			return
+		}
+	}
+}
+
+// serveBlock answers one block request, if we have something to serve.
+func (dm *DownloadManager) serveBlock(peerConn *PeerConnection, payload []byte) error {
+	source := dm.getSource()
+	if source == nil || dm.SeedLimitReached() {
+		return rejectRequest(peerConn.conn, payload)
+	}
+
+	sent, err := serveRequest(peerConn.conn, payload, dm.pieceManager.HasPiece, source)
+	if sent > 0 {
+		dm.mutex.Lock()
+		dm.stats.UploadedBytes += int64(sent)
+		dm.mutex.Unlock()
+
+		peerConn.mutex.Lock()
+		peerConn.uploadedBytes += int64(sent)
+		peerConn.mutex.Unlock()
+	}
+	return err
+}
+
+// broadcastHave tells every connected peer we now have a piece, so that
+// peers who want it can request it from us.
+func (dm *DownloadManager) broadcastHave(pieceIndex int) {
+	dm.mutex.RLock()
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	dm.mutex.RUnlock()
+
+	dm.lifecycle.spawn(func(context.Context) {
+		for _, peerConn := range peerConns {
+			// A failed send shows up in the peer's message loop
+			peerConn.conn.SendHave(pieceIndex)
+		}
+	})
+}
+
+// GetUploadedBytes returns the number of bytes served to peers so far
+func (dm *DownloadManager) GetUploadedBytes() int64 {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.stats.UploadedBytes
+}
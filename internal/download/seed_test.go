@@ -0,0 +1,100 @@
+package download
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// handshakePair returns both ends of an in-memory connection after the
+// handshake, the remote end advertising the fast extension if fast is set.
+func handshakePair(t *testing.T, fast bool) (ours, theirs *peer.Connection) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+
+	connected := make(chan *peer.Connection, 1)
+	go func() {
+		conn, err := peer.ConnectOver(a, testInfoHash, testPeerID(0))
+		if err != nil {
+			t.Error(err)
+		}
+		connected <- conn
+	}()
+
+	if _, err := io.ReadFull(b, make([]byte, 68)); err != nil {
+		t.Fatal(err)
+	}
+	handshake := append([]byte{19}, "BitTorrent protocol"...)
+	handshake = append(handshake, 0, 0, 0, 0, 0, 0, 0, 0)
+	if fast {
+		handshake[len(handshake)-1] = 0x04
+	}
+	handshake = append(handshake, testInfoHash[:]...)
+	theirID := testPeerID(1)
+	handshake = append(handshake, theirID[:]...)
+	if _, err := b.Write(handshake); err != nil {
+		t.Fatal(err)
+	}
+
+	ours = <-connected
+	if ours == nil {
+		t.FailNow()
+	}
+	return ours, peer.NewConnection(b, testInfoHash, theirID)
+}
+
+func TestAnnouncePieces(t *testing.T) {
+	const keepAlive = peer.MessageType(255)
+	tests := []struct {
+		name     string
+		fast     bool
+		have     []int
+		wantType peer.MessageType
+		payload  []byte
+	}{
+		// Pieces 0, 3 and 9 of 10, most significant bit first
+		{"some", false, []int{0, 3, 9}, peer.MsgBitfield, []byte{0x90, 0x40}},
+		{"some, fast", true, []int{0, 3, 9}, peer.MsgBitfield, []byte{0x90, 0x40}},
+		{"all", false, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, peer.MsgBitfield, []byte{0xFF, 0xC0}},
+		{"all, fast", true, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, peer.MsgHaveAll, nil},
+		{"none, fast", true, nil, peer.MsgHaveNone, nil},
+		// Nothing is sent, so the keep-alive that follows arrives first
+		{"none", false, nil, keepAlive, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ours, theirs := handshakePair(t, tc.fast)
+			have := pieces.NewBitfield(10)
+			for _, i := range tc.have {
+				have.SetPiece(i)
+			}
+
+			// The pipe is unbuffered, so send while the other end reads
+			go func() {
+				if err := announcePieces(ours, have); err != nil {
+					t.Error(err)
+				}
+				ours.SendKeepAlive()
+			}()
+
+			msg, err := theirs.ReceiveMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type != tc.wantType || !bytes.Equal(msg.Payload, tc.payload) {
+				t.Errorf("sent %s %x, want %s %x", msg.Type, msg.Payload, tc.wantType, tc.payload)
+			}
+			if msg.Type != keepAlive {
+				if msg, err := theirs.ReceiveMessage(); err != nil || msg.Type != keepAlive {
+					t.Errorf("more than one message sent")
+				}
+			}
+		})
+	}
+}
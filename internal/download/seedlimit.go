@@ -0,0 +1,101 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// seedLimitInterval is how often the seeding limits are checked once the
+// download is complete.
+const seedLimitInterval = 5 * time.Second
+
+// runSeedLimits waits for the download to complete, then ends seeding once
+// the share ratio reaches Options.SeedRatioLimit or Options.SeedTimeLimit
+// has passed, whichever comes first, unless ctx is done before.
+func (dm *DownloadManager) runSeedLimits(ctx context.Context) {
+	if dm.WaitComplete(ctx) != nil {
+		return
+	}
+	seedingSince := time.Now()
+
+	ticker := time.NewTicker(seedLimitInterval)
+	defer ticker.Stop()
+
+	for {
+		reason := SeedLimitReason(dm.options, dm.GetStats().Ratio, time.Since(seedingSince))
+		if reason != "" {
+			dm.endSeeding(reason)
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SeedLimitReason returns why seeding should end under the limits in
+// options, having reached share ratio ratio after seeding for seeded, or ""
+// while no limit is reached.
+func SeedLimitReason(options Options, ratio float64, seeded time.Duration) string {
+	switch {
+	case options.SeedRatioLimit > 0 && ratio >= options.SeedRatioLimit:
+		return fmt.Sprintf("share ratio %.2f reached", ratio)
+	case options.SeedTimeLimit > 0 && seeded >= options.SeedTimeLimit:
+		return fmt.Sprintf("seeded for %s", options.SeedTimeLimit)
+	}
+	return ""
+}
+
+// endSeeding stops uploading for good: every peer is choked, and requests
+// still arriving are rejected (see serveBlock).
+func (dm *DownloadManager) endSeeding(reason string) {
+	dm.mutex.Lock()
+	dm.optimistic = ""
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	dm.mutex.Unlock()
+
+	dm.seededOnce.Do(func() { close(dm.seeded) })
+
+	for _, peerConn := range peerConns {
+		// A failed send shows up in the peer's message loop
+		if !peerConn.conn.IsChoking() {
+			peerConn.conn.SendChoke()
+		}
+	}
+
+	if !dm.quiet {
+		fmt.Printf("Seeding stopped: %s\n", reason)
+	}
+	dm.emit(Event{Type: EventSeedingDone})
+}
+
+// SeedLimitReached reports whether seeding has ended because a seeding
+// limit was reached.
+func (dm *DownloadManager) SeedLimitReached() bool {
+	select {
+	case <-dm.seeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitSeedLimit blocks until seeding ends because a seeding limit was
+// reached, or ctx is done. It returns nil once a limit is reached and
+// ctx.Err() on cancellation. Without limits it only returns on
+// cancellation.
+func (dm *DownloadManager) WaitSeedLimit(ctx context.Context) error {
+	select {
+	case <-dm.seeded:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
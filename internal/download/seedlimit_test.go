@@ -0,0 +1,87 @@
+package download
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSeedingStopsAtRatio(t *testing.T) {
+	tt := newTestTorrent(8)
+	seeder, listener := tt.seeder(t, Options{SeedRatioLimit: 1})
+	if !seeder.IsComplete() {
+		t.Fatal("seeder doesn't hold the whole torrent")
+	}
+
+	leecher := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true})
+	leecher.Start()
+	defer leecher.Stop()
+	connect(t, leecher, listener, testPeerID(2))
+
+	// Uploading the whole torrent once reaches a ratio of 1
+	ctx, cancel := context.WithTimeout(context.Background(), 2*seedLimitInterval+5*time.Second)
+	defer cancel()
+	if err := leecher.WaitComplete(ctx); err != nil {
+		t.Fatalf("leecher didn't complete: %v", err)
+	}
+	if err := seeder.WaitSeedLimit(ctx); err != nil {
+		t.Fatalf("seeding didn't stop: %v", err)
+	}
+
+	stats := seeder.GetStats()
+	if stats.Ratio < 1 {
+		t.Errorf("seeding stopped at ratio %.2f, want at least 1", stats.Ratio)
+	}
+	if stats.UploadedBytes < int64(len(tt.data)) {
+		t.Errorf("uploaded %d bytes, want at least %d", stats.UploadedBytes, len(tt.data))
+	}
+	for _, state := range seeder.GetChokeState() {
+		if !state.Choking {
+			t.Errorf("peer %s still unchoked after seeding stopped", state.Address)
+		}
+	}
+}
+
+func TestUnchokeSlotsBelowRatio(t *testing.T) {
+	tt := newTestTorrent(2)
+	pm := tt.pieceManager(true)
+
+	dm := NewDownloadManagerWithOptions(pm, NewRarestFirstStrategy(), Options{Quiet: true})
+	if got := dm.unchokeSlots(); got != uploadSlots {
+		t.Errorf("without a ratio target: %d slots, want %d", got, uploadSlots)
+	}
+
+	dm = NewDownloadManagerWithOptions(pm, NewRarestFirstStrategy(), Options{Quiet: true, SeedRatioLimit: 1})
+	if got := dm.unchokeSlots(); got != uploadSlots+ratioSlots {
+		t.Errorf("below the ratio target: %d slots, want %d", got, uploadSlots+ratioSlots)
+	}
+
+	dm.stats.UploadedBytes = int64(len(tt.data))
+	if got := dm.unchokeSlots(); got != uploadSlots {
+		t.Errorf("at the ratio target: %d slots, want %d", got, uploadSlots)
+	}
+}
+
+func TestSeedLimitReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		ratio   float64
+		seeded  time.Duration
+		stop    bool
+	}{
+		{"no limits", Options{}, 10, 24 * time.Hour, false},
+		{"below ratio", Options{SeedRatioLimit: 2}, 1.5, time.Hour, false},
+		{"at ratio", Options{SeedRatioLimit: 2}, 2, 0, true},
+		{"before time", Options{SeedTimeLimit: time.Hour}, 10, time.Minute, false},
+		{"after time", Options{SeedTimeLimit: time.Hour}, 0, time.Hour, true},
+		{"either limit", Options{SeedRatioLimit: 2, SeedTimeLimit: time.Hour}, 0, 2 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		reason := SeedLimitReason(tt.options, tt.ratio, tt.seeded)
+		if (reason != "") != tt.stop {
+			t.Errorf("%s: reason %q, want stop %v", tt.name, reason, tt.stop)
+		}
+	}
+}
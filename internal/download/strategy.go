@@ -1,10 +1,14 @@
 package download
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"math/rand"
+	"net"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/yashkadam007/bittorrent-client/internal/peer"
@@ -18,6 +22,14 @@ type PieceStrategy interface {
 	SelectPiece(availablePieces []int, peerBitfield *pieces.Bitfield) (int, error)
 }
 
+// AvailabilityTracker is implemented by strategies that need to know which
+// pieces connected peers have.
+type AvailabilityTracker interface {
+	UpdatePeerBitfield(peerBitfield *pieces.Bitfield) // A peer announced these pieces
+	UpdatePeerHave(pieceIndex int)                    // A peer announced one new piece
+	RemovePeerBitfield(peerBitfield *pieces.Bitfield) // A peer with these pieces went away
+}
+
 // RandomStrategy selects pieces randomly from available pieces.
 // Simple but not optimal for download efficiency.
 type RandomStrategy struct{}
@@ -42,6 +54,100 @@ func (rs *RandomStrategy) SelectPiece(availablePieces []int, peerBitfield *piece
 	return validPieces[rand.Intn(len(validPieces))], nil
 }
 
+// SequentialStrategy selects the lowest-indexed piece the peer has.
+// Useful for streaming, where data is consumed front to back.
+type SequentialStrategy struct{}
+
+func (ss *SequentialStrategy) SelectPiece(availablePieces []int, peerBitfield *pieces.Bitfield) (int, error) {
+	if len(availablePieces) == 0 {
+		return -1, fmt.Errorf("no available pieces")
+	}
+
+	best := -1
+	for _, pieceIndex := range availablePieces {
+		if peerBitfield.HasPiece(pieceIndex) && (best == -1 || pieceIndex < best) {
+			best = pieceIndex
+		}
+	}
+
+	if best == -1 {
+		return -1, fmt.Errorf("peer has no pieces we need")
+	}
+
+	return best, nil
+}
+
+// MediaStrategy fetches the first and last few pieces before anything else,
+// then the rest in order. Media players need the header and the trailing
+// index (e.g. an MP4 moov atom) before they can start playback.
+type MediaStrategy struct {
+	numPieces int // Total pieces in the torrent
+	head      int // Leading pieces to fetch first
+	tail      int // Trailing pieces to fetch right after the head
+}
+
+// NewMediaStrategy creates a media strategy prioritizing the first head and
+// last tail pieces of a torrent with numPieces pieces.
+func NewMediaStrategy(numPieces, head, tail int) *MediaStrategy {
+	return &MediaStrategy{
+		numPieces: numPieces,
+		head:      head,
+		tail:      tail,
+	}
+}
+
+func (ms *MediaStrategy) SelectPiece(availablePieces []int, peerBitfield *pieces.Bitfield) (int, error) {
+	if len(availablePieces) == 0 {
+		return -1, fmt.Errorf("no available pieces")
+	}
+
+	best, bestRank := -1, 0
+	for _, pieceIndex := range availablePieces {
+		if !peerBitfield.HasPiece(pieceIndex) {
+			continue
+		}
+
+		if rank := ms.rank(pieceIndex); best == -1 || rank < bestRank {
+			best, bestRank = pieceIndex, rank
+		}
+	}
+
+	if best == -1 {
+		return -1, fmt.Errorf("peer has no pieces we need")
+	}
+
+	return best, nil
+}
+
+// rank orders pieces: head pieces first, then tail pieces, then the rest,
+// each group in index order.
+func (ms *MediaStrategy) rank(pieceIndex int) int {
+	switch {
+	case pieceIndex < ms.head:
+		return pieceIndex
+	case pieceIndex >= ms.numPieces-ms.tail:
+		return ms.head + pieceIndex - (ms.numPieces - ms.tail)
+	default:
+		return ms.head + ms.tail + pieceIndex
+	}
+}
+
+// MediaOptions configures media mode.
+type MediaOptions struct {
+	Enabled bool // Use MediaStrategy instead of rarest-first
+	Head    int  // Leading pieces to fetch first
+	Tail    int  // Trailing pieces to fetch right after the head
+}
+
+// NewStrategyFor returns the piece selection strategy for a torrent with
+// numPieces pieces: MediaStrategy in media mode, rarest-first otherwise.
+func NewStrategyFor(numPieces int, media MediaOptions) PieceStrategy {
+	if media.Enabled {
+		return NewMediaStrategy(numPieces, media.Head, media.Tail)
+	}
+	return NewRarestFirstStrategy()
+}
+
 // RarestFirstStrategy prioritizes pieces that are rarest among all peers.
 // This helps improve overall swarm health by distributing rare pieces.
 type RarestFirstStrategy struct {
@@ -68,6 +174,26 @@ func (rfs *RarestFirstStrategy) UpdatePeerBitfield(peerBitfield *pieces.Bitfield
 	}
 }
 
+// UpdatePeerHave counts one more peer having a piece.
+func (rfs *RarestFirstStrategy) UpdatePeerHave(pieceIndex int) {
+	rfs.mutex.Lock()
+	defer rfs.mutex.Unlock()
+
+	rfs.pieceCounts[pieceIndex]++
+}
+
+// RemovePeerBitfield updates piece rarity counts when a peer disconnects.
+func (rfs *RarestFirstStrategy) RemovePeerBitfield(peerBitfield *pieces.Bitfield) {
+	rfs.mutex.Lock()
+	defer rfs.mutex.Unlock()
+
+	for i := 0; i < peerBitfield.GetNumPieces(); i++ {
+		if peerBitfield.HasPiece(i) && rfs.pieceCounts[i] > 0 {
+			rfs.pieceCounts[i]--
+		}
+	}
+}
+
 func (rfs *RarestFirstStrategy) SelectPiece(availablePieces []int, peerBitfield *pieces.Bitfield) (int, error) {
 	if len(availablePieces) == 0 {
 		return -1, fmt.Errorf("no available pieces")
@@ -105,119 +231,479 @@ func (rfs *RarestFirstStrategy) SelectPiece(availablePieces []int, peerBitfield
 // DownloadManager coordinates the entire download process.
 // It manages peer connections, piece requests, and download progress.
 type DownloadManager struct {
-	pieceManager *pieces.PieceManager       // Manages piece state and verification
-	strategy     PieceStrategy              // Piece selection strategy
-	peers        map[string]*PeerConnection // Active peer connections
-	maxPeers     int                        // Maximum concurrent peer connections
-	mutex        sync.RWMutex               // Protects shared state
-	active       bool                       // Is the download manager running?
-	stats        *DownloadStats             // Download statistics
-	quiet        bool                       // Suppress stdout output (for TUI mode)
+	pieceManager  *pieces.PieceManager       // Manages piece state and verification
+	strategy      PieceStrategy              // Piece selection strategy
+	peers         map[string]*PeerConnection // Active peer connections
+	maxPeers      int                        // Maximum concurrent peer connections
+	dialing       int                        // Outbound connection attempts holding a peer slot
+	options       Options                    // Configuration
+	mutex         sync.RWMutex               // Protects shared state
+	active        bool                       // Is the download manager running?
+	stats         *DownloadStats             // Download statistics
+	events        chan Event                 // Published download events
+	done          chan struct{}              // Closed once every piece is verified
+	doneOnce      sync.Once                  // Guards closing done
+	seeded        chan struct{}              // Closed once a seeding limit is reached
+	seededOnce    sync.Once                  // Guards closing seeded
+	warmingUp     bool                       // Connecting and collecting bitfields; no requests yet
+	warmupPeers   int                        // Peers that sent a bitfield during warmup
+	paused        bool                       // Requests are held back until Resume
+	endgame       bool                       // Outstanding blocks are being requested from several peers
+	source        BlockReader                // Where blocks requested by peers are read from (nil serves nothing)
+	pauseReason   error                      // Why the download was paused
+	lifecycle     *lifecycle                 // Background goroutines, stopped by Stop
+	reannounce    chan struct{}              // Manual re-announce requests for RunAnnouncer
+	optimistic    string                     // Address of the optimistically unchoked peer
+	limiter       *rateLimiter               // Caps the download rate across all peers (nil for none)
+	uploadLimiter *rateLimiter               // Caps the upload rate across all peers (nil for none)
+	dhtPort       int                        // UDP port of our DHT node, sent to peers supporting DHT
+	addDHTNode    func(addr string)          // Receives peers' DHT node addresses (nil when DHT is off)
+	pex           *pexSettings               // Peer exchange settings (nil when PEX is off)
+	quiet         bool                       // Suppress stdout output (for TUI mode)
 }
 
 // PeerConnection wraps a peer connection with download-specific state.
 type PeerConnection struct {
 	conn            *peer.Connection                // The actual peer connection
 	addr            string                          // Peer address for identification
+	inbound         bool                            // Peer connected to us, so addr's port isn't one it listens on
 	pendingRequests map[string]*pieces.BlockRequest // Outstanding block requests
-	maxRequests     int                             // Max concurrent requests to this peer
+	duplicates      map[string]bool                 // Pending requests made in endgame for blocks another peer also has
+	requestedAt     map[string]time.Time            // When each pending request was made
+	uploads         chan []byte                     // Requests from the peer waiting for the upload limit
+	maxRequests     int                             // Max concurrent requests to this peer (see tuneRequestDepth)
+	rtt             time.Duration                   // Fastest request-to-block time seen (see sampleRTT)
 	downloadedBytes int64                           // Bytes downloaded from this peer
+	uploadedBytes   int64                           // Bytes uploaded to this peer
+	rateSample      int64                           // Bytes transferred as of the last choke round
+	rate            float64                         // Transfer rate over the last choke round (bytes/second)
+	downloadSample  int64                           // downloadedBytes as of the last choke round
+	downloadRate    float64                         // Download rate over the last choke round (bytes/second)
 	lastActivity    time.Time                       // Last time we heard from this peer
+	pexSent         map[string]*net.TCPAddr         // Peers we last told this peer about (only sendPex uses it)
+	pexReceived     time.Time                       // When the peer's last ut_pex message was accepted
+	closed          bool                            // Requests were released; track no more
 	mutex           sync.Mutex                      // Protects peer-specific state
 }
 
+// PeerStats describes one connected peer.
+type PeerStats struct {
+	Address         string        // Peer address
+	Client          string        // Client name decoded from the peer ID
+	Capabilities    []string      // Extensions advertised in the handshake
+	DownloadedBytes int64         // Bytes downloaded from this peer
+	DownloadSpeed   float64       // Bytes/second received from this peer over the last choke round
+	RequestDepth    int           // Requests we keep in flight to this peer
+	RTT             time.Duration // Fastest request-to-block time seen (0 until a block arrives)
+	Pieces          int           // Pieces the peer has
+	Choked          bool          // Is the peer choking us?
+}
+
 // DownloadStats tracks download progress and performance metrics.
 type DownloadStats struct {
-	DownloadedBytes int64     // Total bytes downloaded
+	DownloadedBytes int64     // Bytes received on the wire, including blocks later discarded
+	UploadedBytes   int64     // Bytes sent to peers in piece messages
+	VerifiedBytes   int64     // Bytes in pieces that passed hash verification
 	DownloadSpeed   float64   // Current download speed (bytes/second)
 	StartTime       time.Time // When the download started
 	PeersConnected  int       // Number of active peer connections
+	Ratio           float64   // Share ratio: UploadedBytes over DownloadedBytes, or over VerifiedBytes if that is more
+}
+
+// Options configures a DownloadManager. Zero values select the defaults.
+type Options struct {
+	Quiet           bool // Suppress stdout output (for TUI mode)
+	ConnectBudget   int  // Maximum connection attempts per batch of tracker peers
+	DialConcurrency int  // Maximum connection attempts in flight at once per batch
+	TargetPeers     int  // Re-announce early when fewer peers than this are connected
+	MaxPeers        int  // Maximum concurrent peer connections, inbound and outbound
+
+	MaxDownloadBytesPerSec int64 // Download at most this fast across all peers (0 means unlimited)
+	MaxUploadBytesPerSec   int64 // Upload at most this fast across all peers (0 means unlimited)
+
+	// Holding back requests at startup lets rarest-first pick its first
+	// pieces from several peers' bitfields rather than the first to arrive
+	Warmup      time.Duration // Connect but don't request blocks this long after Start (0 disables)
+	WarmupPeers int           // End the warmup early once this many peers have sent a bitfield (0 waits it out)
+
+	// Once the download is complete, seeding ends (see WaitSeedLimit) when
+	// either limit is reached
+	SeedRatioLimit float64       // Stop uploading at this share ratio (0 seeds indefinitely)
+	SeedTimeLimit  time.Duration // Stop uploading this long after completion (0 seeds indefinitely)
+}
+
+const (
+	defaultConnectBudget   = 30
+	defaultDialConcurrency = 10
+	defaultTargetPeers     = 20
+	defaultMaxPeers        = 50
+)
+
+// DialResult summarizes the connection attempts made for one batch of peers.
+type DialResult struct {
+	Attempted int // Connection attempts made
+	Connected int // Attempts that completed a handshake
 }
 
 // NewDownloadManager creates a new download manager with the given piece manager and strategy.
 func NewDownloadManager(pieceManager *pieces.PieceManager, strategy PieceStrategy) *DownloadManager {
-	return NewDownloadManagerWithOptions(pieceManager, strategy, false)
+	return NewDownloadManagerWithOptions(pieceManager, strategy, Options{})
 }
 
 // NewDownloadManagerWithOptions creates a new download manager with additional options.
-func NewDownloadManagerWithOptions(pieceManager *pieces.PieceManager, strategy PieceStrategy, quiet bool) *DownloadManager {
+func NewDownloadManagerWithOptions(pieceManager *pieces.PieceManager, strategy PieceStrategy, options Options) *DownloadManager {
+	if options.ConnectBudget <= 0 {
+		options.ConnectBudget = defaultConnectBudget
+	}
+	if options.DialConcurrency <= 0 {
+		options.DialConcurrency = defaultDialConcurrency
+	}
+	if options.TargetPeers <= 0 {
+		options.TargetPeers = defaultTargetPeers
+	}
+	if options.MaxPeers <= 0 {
+		options.MaxPeers = defaultMaxPeers
+	}
+
 	return &DownloadManager{
-		pieceManager: pieceManager,
-		strategy:     strategy,
-		peers:        make(map[string]*PeerConnection),
-		maxPeers:     50,
-		quiet:        quiet,
+		pieceManager:  pieceManager,
+		strategy:      strategy,
+		peers:         make(map[string]*PeerConnection),
+		maxPeers:      options.MaxPeers,
+		options:       options,
+		events:        make(chan Event, eventBufferSize),
+		done:          make(chan struct{}),
+		seeded:        make(chan struct{}),
+		reannounce:    make(chan struct{}, 1),
+		limiter:       newRateLimiter(options.MaxDownloadBytesPerSec),
+		uploadLimiter: newRateLimiter(options.MaxUploadBytesPerSec),
+		lifecycle:     newLifecycle(),
+		quiet:         options.Quiet,
 		stats: &DownloadStats{
 			StartTime: time.Now(),
 		},
 	}
 }
 
-// AddPeers adds peers from tracker response
-func (dm *DownloadManager) AddPeers(peers []tracker.PeerInfo, infoHash, peerID [20]byte) {
-	dm.mutex.Lock()
-	defer dm.mutex.Unlock()
+// AddPeers adds peers from tracker response. At most ConnectBudget connection
+// attempts are made per call, DialConcurrency of them at a time; the returned
+// channel receives the outcome once every attempt has finished. Each attempt
+// holds a peer slot until it finishes, so attempts never overshoot MaxPeers.
+//
+// Trackers (through AddPeers) and inbound connections are the only peer
+// sources of private torrents, which keeps them private (see
+// torrent.IsPrivate). Any other source, such as DHT or PEX, must be disabled
+// for them: don't feed them DHT peers or call SetDHT or EnablePEX.
+func (dm *DownloadManager) AddPeers(peers []tracker.PeerInfo, infoHash, peerID [20]byte) <-chan DialResult {
+	var wg sync.WaitGroup
+	var connected int32
+	slots := make(chan struct{}, dm.options.DialConcurrency)
 
+	dm.mutex.Lock()
+	attempts := 0
 	for _, peerInfo := range peers {
 		if !tracker.IsValidPeer(peerInfo) {
 			continue
 		}
 
-		addr := fmt.Sprintf("%s:%d", peerInfo.IP, peerInfo.Port)
+		addr := peerInfo.Addr()
 
 		// Skip if already connected
 		if _, exists := dm.peers[addr]; exists {
 			continue
 		}
 
-		// Skip if we have too many peers
-		if len(dm.peers) >= dm.maxPeers {
+		// Skip if we have too many peers, counting those still being dialed
+		if len(dm.peers)+dm.dialing >= dm.maxPeers {
 			break
 		}
 
+		// Don't burn the whole announce interval on a long list of dead peers
+		if attempts >= dm.options.ConnectBudget {
+			break
+		}
+		attempts++
+		dm.dialing++
+
 		// Connect to peer
-		go dm.connectToPeer(addr, infoHash, peerID)
+		wg.Add(1)
+		spawned := dm.lifecycle.spawn(func(ctx context.Context) {
+			defer wg.Done()
+			defer dm.releaseDialSlot()
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+
+			if dm.connectToPeer(ctx, addr, infoHash, peerID) {
+				atomic.AddInt32(&connected, 1)
+			}
+		})
+		if !spawned {
+			dm.dialing--
+			wg.Done()
+			break
+		}
 	}
+	dm.mutex.Unlock()
+
+	results := make(chan DialResult, 1)
+	go func() {
+		wg.Wait()
+		results <- DialResult{
+			Attempted: attempts,
+			Connected: int(atomic.LoadInt32(&connected)),
+		}
+	}()
+	return results
 }
 
-func (dm *DownloadManager) connectToPeer(addr string, infoHash, peerID [20]byte) {
-	conn, err := peer.Connect(addr, infoHash, peerID)
+// releaseDialSlot gives back the peer slot held by a finished outbound
+// connection attempt.
+func (dm *DownloadManager) releaseDialSlot() {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	dm.dialing--
+}
+
+// connectToPeer dials a peer and starts handling it. Returns true on success.
+// The caller must hold a peer slot for the attempt (see AddPeers).
+func (dm *DownloadManager) connectToPeer(ctx context.Context, addr string, infoHash, peerID [20]byte) bool {
+	conn, err := peer.ConnectContextWithOptions(ctx, addr, infoHash, peerID, dm.PeerOptions())
 	if err != nil {
 		if !dm.quiet {
 			fmt.Printf("Failed to connect to peer %s: %v\n", addr, err)
 		}
+		return false
+	}
+
+	if !dm.addConnection(conn, addr, true) {
+		conn.Close()
+		return false
+	}
+
+	if !dm.quiet {
+		fmt.Printf("Connected to peer %s\n", addr)
+	}
+	return true
+}
+
+// AddInboundPeer takes over a connection accepted by a peer.Listener whose
+// handshake has already completed. The connection is closed if the download
+// isn't running, the peer is already connected, or we're at the peer limit.
+func (dm *DownloadManager) AddInboundPeer(conn *peer.Connection) {
+	addr := conn.RemoteAddr()
+
+	if !dm.addConnection(conn, addr, false) {
+		conn.Close()
 		return
 	}
 
+	if !dm.quiet {
+		fmt.Printf("Accepted peer %s\n", addr)
+	}
+}
+
+// addConnection registers a handshaken connection and starts handling it.
+// Set dialed if the connection came from an attempt holding a peer slot,
+// which it may take. Returns false if the connection was not accepted.
+func (dm *DownloadManager) addConnection(conn *peer.Connection, addr string, dialed bool) bool {
 	peerConn := &PeerConnection{
 		conn:            conn,
 		addr:            addr,
 		pendingRequests: make(map[string]*pieces.BlockRequest),
-		maxRequests:     10,
+		duplicates:      make(map[string]bool),
+		requestedAt:     make(map[string]time.Time),
+		uploads:         make(chan []byte, maxQueuedUploads),
+		maxRequests:     defaultRequestDepth,
 		lastActivity:    time.Now(),
+		inbound:         !dialed,
+		pexSent:         make(map[string]*net.TCPAddr),
 	}
 
+	conn.SetNumPieces(dm.pieceManager.GetBitfield().GetNumPieces())
+
 	dm.mutex.Lock()
+	// Other attempts still being dialed keep their slots
+	used := len(dm.peers) + dm.dialing
+	if dialed {
+		used--
+	}
+	if !dm.active || used >= dm.maxPeers {
+		dm.mutex.Unlock()
+		return false
+	}
+	if _, exists := dm.peers[addr]; exists {
+		dm.mutex.Unlock()
+		return false
+	}
+	if dm.hasPeerID(conn.GetRemotePeerID()) {
+		// Same peer reached through another address (e.g. inbound and outbound)
+		dm.mutex.Unlock()
+		return false
+	}
 	dm.peers[addr] = peerConn
 	dm.stats.PeersConnected++
 	dm.mutex.Unlock()
 
-	if !dm.quiet {
-		fmt.Printf("Connected to peer %s\n", addr)
-	}
+	dm.emit(Event{Type: EventPeerConnected, Peer: addr})
 
 	// Start message handling
-	go dm.handlePeer(peerConn)
+	if !dm.lifecycle.spawn(func(ctx context.Context) { dm.handlePeer(ctx, peerConn) }) {
+		dm.removePeer(addr)
+		return false
+	}
+	return true
+}
+
+// closeRequests stops tracking a departed peer's requests. Blocks requested
+// from it afterwards are released straight away (see requestBlocks); those
+// it already owns are released by removePeer.
+func (dm *DownloadManager) closeRequests(peerConn *PeerConnection) {
+	peerConn.mutex.Lock()
+	defer peerConn.mutex.Unlock()
+
+	peerConn.pendingRequests = make(map[string]*pieces.BlockRequest)
+	peerConn.duplicates = make(map[string]bool)
+	peerConn.requestedAt = make(map[string]time.Time)
+	peerConn.closed = true
+}
+
+// requestFromAll lets every connected peer request again, so that blocks
+// returned to the pool are picked up even by peers that had run out of
+// blocks to ask for.
+func (dm *DownloadManager) requestFromAll() {
+	dm.mutex.RLock()
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	dm.mutex.RUnlock()
+
+	for _, peerConn := range peerConns {
+		dm.spawnRequests(peerConn)
+	}
+}
+
+// selectInProgress picks an in-progress piece the peer has that still needs
+// blocks requested.
+func (dm *DownloadManager) selectInProgress(peerBitfield *pieces.Bitfield) (int, bool) {
+	for _, pieceIndex := range dm.pieceManager.GetInProgressPieces() {
+		if peerBitfield.HasPiece(pieceIndex) && dm.pieceManager.HasUnrequestedBlocks(pieceIndex) {
+			return pieceIndex, true
+		}
+	}
+	return -1, false
+}
+
+// StalledPieces returns in-progress pieces that no connected peer has. They
+// keep the blocks downloaded so far and are resumed once a peer that has
+// them connects.
+func (dm *DownloadManager) StalledPieces() []int {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+
+	numPieces := dm.pieceManager.GetBitfield().GetNumPieces()
+	var peerBitfields []*pieces.Bitfield
+	for _, peerConn := range dm.peers {
+		peerBitfields = append(peerBitfields, pieces.NewBitfieldFromBytes(peerConn.conn.GetBitfield(), numPieces))
+	}
+
+	var stalled []int
+	for _, pieceIndex := range dm.pieceManager.GetInProgressPieces() {
+		available := false
+		for _, bf := range peerBitfields {
+			if bf.HasPiece(pieceIndex) {
+				available = true
+				break
+			}
+		}
+		if !available {
+			stalled = append(stalled, pieceIndex)
+		}
+	}
+
+	return stalled
+}
+
+// hasPeerID reports whether a connected peer uses the given peer ID.
+// An all-zero ID is never considered a duplicate. Caller must hold dm.mutex.
+func (dm *DownloadManager) hasPeerID(peerID [20]byte) bool {
+	if peerID == ([20]byte{}) {
+		return false
+	}
+
+	for _, peerConn := range dm.peers {
+		if peerConn.conn.GetRemotePeerID() == peerID {
+			return true
+		}
+	}
+	return false
 }
 
-func (dm *DownloadManager) handlePeer(peerConn *PeerConnection) {
+func (dm *DownloadManager) handlePeer(ctx context.Context, peerConn *PeerConnection) {
+	// Stop the peer's keep-alives as soon as we're done with it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	peerConn.conn.OnPiece(func(pieceIndex, begin int, data []byte) error {
+		return dm.handlePiece(ctx, peerConn, pieceIndex, begin, data)
+	})
+	peerConn.conn.OnReject(func(pieceIndex, begin, length int) error {
+		dm.handleReject(peerConn, pieceIndex, begin, length)
+		return nil
+	})
+	if dm.addDHTNode != nil {
+		peerConn.conn.OnPort(func(port int) { dm.handlePort(peerConn, port) })
+	}
+	if dm.pex != nil {
+		peerConn.conn.OnPex(func(added []*net.TCPAddr) { dm.handlePex(peerConn, added) })
+	}
+
 	defer func() {
-		dm.removePeer(peerConn.addr)
 		peerConn.conn.Close()
+		dm.closeRequests(peerConn)
+		if dm.removePeer(peerConn.addr) {
+			// The peers left may all be idle, with nothing else to ask for
+			dm.requestFromAll()
+		}
 	}()
 
+	// Announce the pieces we have
+	err := announcePieces(peerConn.conn, dm.pieceManager.GetBitfield())
+	if err != nil {
+		if !dm.quiet {
+			fmt.Printf("Failed to send bitfield to %s: %v\n", peerConn.addr, err)
+		}
+		return
+	}
+
+	// Tell peers supporting DHT where our node listens
+	if dm.addDHTNode != nil && peerConn.conn.SupportsDHT() {
+		err = peerConn.conn.SendPort(dm.dhtPort)
+		if err != nil {
+			if !dm.quiet {
+				fmt.Printf("Failed to send DHT port to %s: %v\n", peerConn.addr, err)
+			}
+			return
+		}
+	}
+
+	// Offer peer exchange
+	if dm.pex != nil && peerConn.conn.SupportsExtensions() {
+		err = peerConn.conn.SendExtendedHandshake(dm.pex.listenPort)
+		if err != nil {
+			if !dm.quiet {
+				fmt.Printf("Failed to send extended handshake to %s: %v\n", peerConn.addr, err)
+			}
+			return
+		}
+	}
+
 	// Send interested message
-	err := peerConn.conn.SendInterested()
+	err = peerConn.conn.SendInterested()
 	if err != nil {
 		if !dm.quiet {
 			fmt.Printf("Failed to send interested to %s: %v\n", peerConn.addr, err)
@@ -226,13 +712,20 @@ func (dm *DownloadManager) handlePeer(peerConn *PeerConnection) {
 	}
 
 	// Start keep-alive routine
-	go dm.keepAlive(peerConn)
+	dm.lifecycle.spawn(func(context.Context) { dm.keepAlive(ctx, peerConn) })
 
 	// Start request routine
-	go dm.requestBlocks(peerConn)
+	dm.spawnRequests(peerConn)
+
+	if dm.uploadLimiter != nil {
+		// Serve queued requests until the peer goes away
+		uploadCtx, stopUploads := context.WithCancel(ctx)
+		defer stopUploads()
+		dm.lifecycle.spawn(func(context.Context) { dm.serveUploads(uploadCtx, peerConn) })
+	}
 
 	// Message loop
-	for dm.active {
+	for ctx.Err() == nil {
 		msg, err := peerConn.conn.ReceiveMessage()
 		if err != nil {
 			if !dm.quiet {
@@ -243,7 +736,7 @@ func (dm *DownloadManager) handlePeer(peerConn *PeerConnection) {
 
 		peerConn.lastActivity = time.Now()
 
-		err = dm.handleMessage(peerConn, msg)
+		err = dm.handleMessage(ctx, peerConn, msg)
 		if err != nil {
 			if !dm.quiet {
 				fmt.Printf("Error handling message from %s: %v\n", peerConn.addr, err)
@@ -253,57 +746,273 @@ func (dm *DownloadManager) handlePeer(peerConn *PeerConnection) {
 	}
 }
 
-func (dm *DownloadManager) handleMessage(peerConn *PeerConnection, msg *peer.Message) error {
+func (dm *DownloadManager) handleMessage(ctx context.Context, peerConn *PeerConnection, msg *peer.Message) error {
 	switch msg.Type {
+	case peer.MsgChoke:
+		err := peerConn.conn.HandleMessage(msg)
+		if err != nil {
+			return err
+		}
+		// Without the fast extension a choke silently drops every request
+		// we have outstanding; with it, each one is rejected explicitly
+		if !peerConn.conn.SupportsFast() {
+			dm.rejectPending(peerConn)
+		}
+		return nil
+
 	case peer.MsgUnchoke:
 		// Start requesting pieces
-		go dm.requestBlocks(peerConn)
+		dm.spawnRequests(peerConn)
+
+	case peer.MsgInterested:
+		err := peerConn.conn.HandleMessage(msg)
+		if err != nil {
+			return err
+		}
+		return dm.unchokeInterested(peerConn)
 
-	case peer.MsgPiece:
-		if len(msg.Payload) < 8 {
-			return fmt.Errorf("invalid piece message")
+	case peer.MsgRequest:
+		err := peerConn.conn.HandleMessage(msg)
+		if err != nil {
+			return err
 		}
+		return dm.handleRequest(ctx, peerConn, msg.Payload)
 
-		pieceIndex := int(uint32(msg.Payload[0])<<24 | uint32(msg.Payload[1])<<16 | uint32(msg.Payload[2])<<8 | uint32(msg.Payload[3]))
-		begin := int(uint32(msg.Payload[4])<<24 | uint32(msg.Payload[5])<<16 | uint32(msg.Payload[6])<<8 | uint32(msg.Payload[7]))
-		data := msg.Payload[8:]
+	case peer.MsgHave, peer.MsgBitfield, peer.MsgHaveAll, peer.MsgHaveNone:
+		return dm.handleAvailability(peerConn, msg)
+	}
 
-		// Remove from pending requests
-		peerConn.mutex.Lock()
-		key := fmt.Sprintf("%d:%d", pieceIndex, begin)
-		delete(peerConn.pendingRequests, key)
-		peerConn.downloadedBytes += int64(len(data))
+	// Handle message in peer connection; piece messages come back through
+	// handlePiece
+	return peerConn.conn.HandleMessage(msg)
+}
+
+// handlePiece takes a block a peer sent us, passed on by the connection's
+// HandleMessage (see peer.Connection.OnPiece).
+func (dm *DownloadManager) handlePiece(ctx context.Context, peerConn *PeerConnection, pieceIndex, begin int, data []byte) error {
+	// Only accept blocks we asked this peer for; anything else would let
+	// a peer spend our verification time or race other peers' requests
+	peerConn.mutex.Lock()
+	key := fmt.Sprintf("%d:%d", pieceIndex, begin)
+	blockReq, requested := peerConn.pendingRequests[key]
+	if !requested {
 		peerConn.mutex.Unlock()
+		return nil
+	}
+	if blockReq.Length != len(data) {
+		// The block we asked for isn't coming; let another peer fetch it
+		peerConn.mutex.Unlock()
+		dm.handleReject(peerConn, pieceIndex, begin, blockReq.Length)
+		return nil
+	}
+	if requestedAt, ok := peerConn.requestedAt[key]; ok {
+		peerConn.sampleRTT(time.Since(requestedAt))
+	}
+	delete(peerConn.pendingRequests, key)
+	delete(peerConn.duplicates, key)
+	delete(peerConn.requestedAt, key)
+	peerConn.downloadedBytes += int64(len(data))
+	peerConn.mutex.Unlock()
 
-		// Add block to piece manager
-		err := dm.pieceManager.AddBlock(pieceIndex, begin, data)
-		if err != nil {
-			if !dm.quiet {
-				fmt.Printf("Failed to add block: %v\n", err)
-			}
+	// In endgame other peers may have been asked for this block too
+	dm.cancelDuplicates(peerConn, blockReq)
+
+	// Add block to piece manager
+	hadPiece := dm.pieceManager.HasPiece(pieceIndex)
+	err := dm.pieceManager.AddBlockFromPeer(pieceIndex, begin, data, peerConn.addr)
+	if err != nil {
+		dm.handleBlockError(err)
+	} else if !hadPiece && dm.pieceManager.HasPiece(pieceIndex) {
+		dm.emit(Event{Type: EventPieceCompleted, Piece: pieceIndex})
+		dm.broadcastHave(pieceIndex)
+		if dm.pieceManager.IsComplete() {
+			dm.doneOnce.Do(func() {
+				close(dm.done)
+				dm.emit(Event{Type: EventDownloadComplete})
+			})
 		}
+	}
 
-		// Update stats
-		dm.updateDownloadStats(int64(len(data)))
+	// Update stats
+	dm.updateDownloadStats(int64(len(data)))
+
+	// Hold off reading from this peer and requesting more while over the
+	// rate limit. If ctx ends the message loop stops anyway.
+	dm.limiter.wait(ctx, len(data))
+
+	// Request more blocks
+	dm.spawnRequests(peerConn)
+	return nil
+}
 
-		// Request more blocks
-		go dm.requestBlocks(peerConn)
+// handleReject returns a block the peer refused to send (see
+// peer.Connection.OnReject) to the pool, steering it to other peers, rather
+// than waiting for the request to time out.
+func (dm *DownloadManager) handleReject(peerConn *PeerConnection, pieceIndex, begin, length int) {
+	peerConn.mutex.Lock()
+	key := fmt.Sprintf("%d:%d", pieceIndex, begin)
+	blockReq, requested := peerConn.pendingRequests[key]
+	if !requested || blockReq.Length != length {
+		peerConn.mutex.Unlock()
+		return
 	}
+	duplicate := peerConn.duplicates[key]
+	delete(peerConn.pendingRequests, key)
+	delete(peerConn.duplicates, key)
+	delete(peerConn.requestedAt, key)
+	peerConn.mutex.Unlock()
 
-	// Handle message in peer connection
-	return peerConn.conn.HandleMessage(msg)
+	// An endgame duplicate's block is still owned by the original request
+	if !duplicate {
+		dm.pieceManager.UnrequestBlock(pieceIndex, begin, peerConn.addr)
+	}
+	dm.spawnRequests(peerConn)
+}
+
+// rejectPending treats every request outstanding with a peer as rejected,
+// returning the blocks to the pool for other peers to fetch.
+func (dm *DownloadManager) rejectPending(peerConn *PeerConnection) {
+	peerConn.mutex.Lock()
+	requests := peerConn.pendingRequests
+	duplicates := peerConn.duplicates
+	peerConn.pendingRequests = make(map[string]*pieces.BlockRequest)
+	peerConn.duplicates = make(map[string]bool)
+	peerConn.requestedAt = make(map[string]time.Time)
+	peerConn.mutex.Unlock()
+
+	for key, req := range requests {
+		if !duplicates[key] {
+			dm.pieceManager.UnrequestBlock(req.PieceIndex, req.Begin, peerConn.addr)
+		}
+	}
+}
+
+// handleAvailability applies a have or bitfield message and keeps the
+// strategy's availability counts in step. Peers may skip the bitfield
+// entirely and announce pieces one have at a time, so each new piece is
+// counted and may unblock requests to a peer that had nothing we need.
+func (dm *DownloadManager) handleAvailability(peerConn *PeerConnection, msg *peer.Message) error {
+	numPieces := dm.pieceManager.GetBitfield().GetNumPieces()
+	before := pieces.NewBitfieldFromBytes(peerConn.conn.GetBitfield(), numPieces)
+
+	err := peerConn.conn.HandleMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	availability, tracksAvailability := dm.getStrategy().(AvailabilityTracker)
+
+	if msg.Type == peer.MsgHave {
+		pieceIndex := int(binary.BigEndian.Uint32(msg.Payload))
+		if before.HasPiece(pieceIndex) {
+			return nil
+		}
+		if tracksAvailability {
+			availability.UpdatePeerHave(pieceIndex)
+		}
+		if dm.pieceManager.HasPiece(pieceIndex) {
+			return nil
+		}
+	} else {
+		if tracksAvailability {
+			availability.RemovePeerBitfield(before)
+			availability.UpdatePeerBitfield(pieces.NewBitfieldFromBytes(peerConn.conn.GetBitfield(), numPieces))
+		}
+		if dm.countWarmupBitfield() {
+			return nil
+		}
+	}
+
+	dm.spawnRequests(peerConn)
+	return nil
+}
+
+// countWarmupBitfield counts a peer's bitfield towards ending the warmup,
+// ending it if enough have arrived. It returns true if the warmup ended,
+// in which case requests to every peer have already been started.
+func (dm *DownloadManager) countWarmupBitfield() bool {
+	dm.mutex.Lock()
+	if !dm.warmingUp {
+		dm.mutex.Unlock()
+		return false
+	}
+	dm.warmupPeers++
+	reached := dm.options.WarmupPeers > 0 && dm.warmupPeers >= dm.options.WarmupPeers
+	dm.mutex.Unlock()
+
+	if !reached {
+		return false
+	}
+	dm.endWarmup()
+	return true
+}
+
+// endWarmup lets requests begin and starts requesting from every peer
+// connected so far. It does nothing once the warmup is over.
+func (dm *DownloadManager) endWarmup() {
+	dm.mutex.Lock()
+	if !dm.warmingUp {
+		dm.mutex.Unlock()
+		return
+	}
+	dm.warmingUp = false
+	bitfields := dm.warmupPeers
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	dm.mutex.Unlock()
+
+	if !dm.quiet {
+		fmt.Printf("Warmup finished with bitfields from %d peers\n", bitfields)
+	}
+
+	for _, peerConn := range peerConns {
+		dm.spawnRequests(peerConn)
+	}
+}
+
+// isWarmingUp reports whether requests are still being held back.
+func (dm *DownloadManager) isWarmingUp() bool {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.warmingUp
+}
+
+// notStarted filters out pieces already in progress. Those with blocks left
+// to request are offered by selectInProgress, and the rest must not be picked
+// again or the peer would find nothing to request.
+func (dm *DownloadManager) notStarted(missingPieces []int) []int {
+	inProgress := make(map[int]bool)
+	for _, pieceIndex := range dm.pieceManager.GetInProgressPieces() {
+		inProgress[pieceIndex] = true
+	}
+
+	var candidates []int
+	for _, pieceIndex := range missingPieces {
+		if !inProgress[pieceIndex] {
+			candidates = append(candidates, pieceIndex)
+		}
+	}
+	return candidates
+}
+
+// spawnRequests runs requestBlocks for a peer in the background.
+func (dm *DownloadManager) spawnRequests(peerConn *PeerConnection) {
+	dm.lifecycle.spawn(func(context.Context) { dm.requestBlocks(peerConn) })
 }
 
 func (dm *DownloadManager) requestBlocks(peerConn *PeerConnection) {
-	if peerConn.conn.IsChoked() {
+	if peerConn.conn.IsChoked() || dm.isWarmingUp() || dm.isPaused() {
 		return
 	}
 
 	peerConn.mutex.Lock()
 	pendingCount := len(peerConn.pendingRequests)
+	maxRequests := peerConn.maxRequests
 	peerConn.mutex.Unlock()
 
-	if pendingCount >= peerConn.maxRequests {
+	if pendingCount >= maxRequests {
 		return
 	}
 
@@ -319,49 +1028,102 @@ func (dm *DownloadManager) requestBlocks(peerConn *PeerConnection) {
 		dm.pieceManager.GetBitfield().GetNumPieces(),
 	)
 
-	// Select piece to download
-	pieceIndex, err := dm.strategy.SelectPiece(missingPieces, peerBitfield)
-	if err != nil {
-		return
+	// Finish pieces that are already underway before starting new ones; this
+	// is also how a piece stalled by a departed peer gets picked up again
+	pieceIndex, found := dm.selectInProgress(peerBitfield)
+	if !found {
+		var err error
+		pieceIndex, err = dm.getStrategy().SelectPiece(dm.notStarted(missingPieces), peerBitfield)
+		if err != nil {
+			dm.requestEndgame(peerConn, peerBitfield)
+			return
+		}
 	}
 
 	// Start piece if not already started
-	err = dm.pieceManager.StartPiece(pieceIndex)
+	err := dm.pieceManager.StartPiece(pieceIndex)
 	if err != nil && err.Error() != fmt.Sprintf("piece %d already in progress", pieceIndex) {
 		return
 	}
 
-	// Request blocks for this piece
-	for pendingCount < peerConn.maxRequests {
-		blockReq, err := dm.pieceManager.GetNextBlockRequest(pieceIndex)
+	// Collect blocks for this piece
+	var blockReqs []*pieces.BlockRequest
+	var requests []peer.Request
+	for pendingCount+len(blockReqs) < maxRequests {
+		blockReq, err := dm.pieceManager.GetNextBlockRequestForPeer(pieceIndex, peerConn.addr)
 		if err != nil || blockReq == nil {
 			break
 		}
 
-		// Send request
-		err = peerConn.conn.SendRequest(blockReq.PieceIndex, blockReq.Begin, blockReq.Length)
-		if err != nil {
-			if !dm.quiet {
-				fmt.Printf("Failed to send request to %s: %v\n", peerConn.addr, err)
-			}
-			break
+		blockReqs = append(blockReqs, blockReq)
+		requests = append(requests, peer.Request{
+			PieceIndex: blockReq.PieceIndex,
+			Begin:      blockReq.Begin,
+			Length:     blockReq.Length,
+		})
+	}
+	if len(blockReqs) == 0 {
+		dm.requestEndgame(peerConn, peerBitfield)
+		return
+	}
+
+	// Track pending requests before sending them, since blocks that arrive
+	// without a matching request are discarded
+	peerConn.mutex.Lock()
+	closed := peerConn.closed
+	if !closed {
+		now := time.Now()
+		for _, blockReq := range blockReqs {
+			key := fmt.Sprintf("%d:%d", blockReq.PieceIndex, blockReq.Begin)
+			peerConn.pendingRequests[key] = blockReq
+			peerConn.requestedAt[key] = now
 		}
+	}
+	peerConn.mutex.Unlock()
 
-		// Track pending request
+	if closed {
+		for _, blockReq := range blockReqs {
+			dm.pieceManager.ReleaseBlock(blockReq.PieceIndex, blockReq.Begin)
+		}
+		return
+	}
+
+	// Send the whole burst in one write
+	err = peerConn.conn.SendRequests(requests)
+	if err != nil {
+		// Release whatever is still tracked; a concurrent close may have
+		// released some of the blocks already
+		var unsent []*pieces.BlockRequest
 		peerConn.mutex.Lock()
-		key := fmt.Sprintf("%d:%d", blockReq.PieceIndex, blockReq.Begin)
-		peerConn.pendingRequests[key] = blockReq
-		pendingCount++
+		for _, blockReq := range blockReqs {
+			key := fmt.Sprintf("%d:%d", blockReq.PieceIndex, blockReq.Begin)
+			if peerConn.pendingRequests[key] == blockReq {
+				delete(peerConn.pendingRequests, key)
+				delete(peerConn.requestedAt, key)
+				unsent = append(unsent, blockReq)
+			}
+		}
 		peerConn.mutex.Unlock()
+
+		for _, blockReq := range unsent {
+			dm.pieceManager.ReleaseBlock(blockReq.PieceIndex, blockReq.Begin)
+		}
+		if !dm.quiet {
+			fmt.Printf("Failed to send requests to %s: %v\n", peerConn.addr, err)
+		}
 	}
 }
 
-func (dm *DownloadManager) keepAlive(peerConn *PeerConnection) {
+func (dm *DownloadManager) keepAlive(ctx context.Context, peerConn *PeerConnection) {
 	ticker := time.NewTicker(2 * time.Minute)
 	defer ticker.Stop()
 
-	for dm.active {
-		<-ticker.C
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
 		if time.Since(peerConn.lastActivity) > 3*time.Minute {
 			// Peer is inactive, disconnect
 			if !dm.quiet {
@@ -380,17 +1142,29 @@ func (dm *DownloadManager) keepAlive(peerConn *PeerConnection) {
 	}
 }
 
-func (dm *DownloadManager) removePeer(addr string) {
+// removePeer forgets a disconnected peer and hands the blocks it was asked
+// for but didn't deliver back to the piece manager, so that other peers can
+// fetch them. It reports whether there were any. Endgame duplicates are left
+// alone, since another peer owns the block.
+func (dm *DownloadManager) removePeer(addr string) bool {
 	dm.mutex.Lock()
 	defer dm.mutex.Unlock()
 
-	if _, exists := dm.peers[addr]; exists {
+	released := dm.pieceManager.ReleasePeerBlocks(addr) > 0
+	if peerConn, exists := dm.peers[addr]; exists {
+		if availability, ok := dm.strategy.(AvailabilityTracker); ok {
+			numPieces := dm.pieceManager.GetBitfield().GetNumPieces()
+			availability.RemovePeerBitfield(pieces.NewBitfieldFromBytes(peerConn.conn.GetBitfield(), numPieces))
+		}
+
 		delete(dm.peers, addr)
 		dm.stats.PeersConnected--
 		if !dm.quiet {
 			fmt.Printf("Disconnected from peer %s\n", addr)
 		}
+		dm.emit(Event{Type: EventPeerDisconnected, Peer: addr})
 	}
+	return released
 }
 
 func (dm *DownloadManager) updateDownloadStats(bytes int64) {
@@ -406,16 +1180,48 @@ func (dm *DownloadManager) updateDownloadStats(bytes int64) {
 	}
 }
 
-// Start begins the download process
+// Start begins the download process. A stopped download manager can be
+// started again.
 func (dm *DownloadManager) Start() {
+	dm.lifecycle.restart()
+
 	dm.mutex.Lock()
 	dm.active = true
+	dm.warmingUp = dm.options.Warmup > 0
 	dm.mutex.Unlock()
 
-	fmt.Println("Download started")
+	if !dm.quiet {
+		fmt.Println("Download started")
+	}
+
+	dm.lifecycle.spawn(dm.sweepRequests)
+	dm.lifecycle.spawn(dm.runChoker)
+	if dm.pex != nil {
+		dm.lifecycle.spawn(dm.runPex)
+	}
+	if dm.options.SeedRatioLimit > 0 || dm.options.SeedTimeLimit > 0 {
+		dm.lifecycle.spawn(dm.runSeedLimits)
+	}
+
+	if dm.options.Warmup > 0 {
+		if !dm.quiet {
+			fmt.Printf("Warming up for %s before requesting pieces\n", dm.options.Warmup)
+		}
+		dm.lifecycle.spawn(func(ctx context.Context) {
+			timer := time.NewTimer(dm.options.Warmup)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+				dm.endWarmup()
+			case <-ctx.Done():
+			}
+		})
+	}
 }
 
-// Stop stops the download process
+// Stop stops the download process. It disconnects every peer and waits,
+// up to stopTimeout, for the goroutines serving them to exit.
 func (dm *DownloadManager) Stop() {
 	dm.mutex.Lock()
 	dm.active = false
@@ -427,7 +1233,53 @@ func (dm *DownloadManager) Stop() {
 	dm.peers = make(map[string]*PeerConnection)
 	dm.mutex.Unlock()
 
-	fmt.Println("Download stopped")
+	err := dm.lifecycle.stop(stopTimeout)
+	if err != nil && !dm.quiet {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	if !dm.quiet {
+		fmt.Println("Download stopped")
+	}
+}
+
+// SetStrategy replaces the piece selection strategy; the next piece selected
+// uses it. Pieces already in progress are unaffected. If the new strategy
+// tracks availability it is seeded from the currently connected peers, so
+// pass a fresh instance rather than one that was used before.
+func (dm *DownloadManager) SetStrategy(strategy PieceStrategy) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	if availability, ok := strategy.(AvailabilityTracker); ok {
+		numPieces := dm.pieceManager.GetBitfield().GetNumPieces()
+		for _, peerConn := range dm.peers {
+			availability.UpdatePeerBitfield(pieces.NewBitfieldFromBytes(peerConn.conn.GetBitfield(), numPieces))
+		}
+	}
+
+	dm.strategy = strategy
+}
+
+// getStrategy returns the current piece selection strategy
+func (dm *DownloadManager) getStrategy() PieceStrategy {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.strategy
+}
+
+// PeerCount returns the number of connected peers
+func (dm *DownloadManager) PeerCount() int {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return len(dm.peers)
+}
+
+// FreePeerSlots returns how many more peers can be connected
+func (dm *DownloadManager) FreePeerSlots() int {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+	return dm.maxPeers - len(dm.peers) - dm.dialing
 }
 
 // IsActive returns true if the download is active
@@ -445,16 +1297,81 @@ func (dm *DownloadManager) GetStats() DownloadStats {
 	// Return a copy of the stats with current peer count
 	stats := *dm.stats
 	stats.PeersConnected = len(dm.peers)
+	stats.VerifiedBytes = dm.pieceManager.GetVerifiedBytes()
+	// Data already on disk counts as downloaded, or a resumed download
+	// would reach any ratio after uploading a little
+	if downloaded := max(stats.DownloadedBytes, stats.VerifiedBytes); downloaded > 0 {
+		stats.Ratio = float64(stats.UploadedBytes) / float64(downloaded)
+	}
 
 	return stats
 }
 
-// GetProgress returns download progress
-func (dm *DownloadManager) GetProgress() (int, int, float64) {
+// GetPeerStats returns a snapshot of every connected peer, ordered by address
+func (dm *DownloadManager) GetPeerStats() []PeerStats {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+
+	numPieces := dm.pieceManager.GetBitfield().GetNumPieces()
+	peers := make([]PeerStats, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConn.mutex.Lock()
+		downloaded := peerConn.downloadedBytes
+		speed := peerConn.downloadRate
+		depth, rtt := peerConn.maxRequests, peerConn.rtt
+		peerConn.mutex.Unlock()
+
+		peers = append(peers, PeerStats{
+			Address:         peerConn.addr,
+			Client:          peerConn.conn.ClientName(),
+			Capabilities:    peerConn.conn.Capabilities(),
+			DownloadedBytes: downloaded,
+			DownloadSpeed:   speed,
+			RequestDepth:    depth,
+			RTT:             rtt,
+			Pieces:          pieces.NewBitfieldFromBytes(peerConn.conn.GetBitfield(), numPieces).GetNumCompletePieces(),
+			Choked:          peerConn.conn.IsChoked(),
+		})
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Address < peers[j].Address
+	})
+	return peers
+}
+
+// GetProgress returns download progress in pieces and in bytes, counting
+// only wanted pieces
+func (dm *DownloadManager) GetProgress() pieces.Progress {
 	return dm.pieceManager.GetProgress()
 }
 
+// GetPieceBitfield returns a snapshot of the pieces we have
+func (dm *DownloadManager) GetPieceBitfield() *pieces.Bitfield {
+	return dm.pieceManager.GetBitfield()
+}
+
+// GetInProgressPieces returns the pieces being downloaded, in index order
+func (dm *DownloadManager) GetInProgressPieces() []int {
+	return dm.pieceManager.GetInProgressPieces()
+}
+
 // IsComplete returns true if download is complete
 func (dm *DownloadManager) IsComplete() bool {
 	return dm.pieceManager.IsComplete()
 }
+
+// WaitComplete blocks until every piece has been verified or ctx is done.
+// It returns nil on completion and ctx.Err() on cancellation.
+func (dm *DownloadManager) WaitComplete(ctx context.Context) error {
+	if dm.pieceManager.IsComplete() {
+		return nil
+	}
+
+	select {
+	case <-dm.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
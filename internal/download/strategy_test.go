@@ -0,0 +1,795 @@
+package download
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+// testPieceLength keeps test torrents to a few blocks per piece
+const testPieceLength = 4 * pieces.BlockSize
+
+// testTorrent is torrent data held in memory, served as a BlockReader.
+type testTorrent struct {
+	data   []byte     // The torrent's content
+	hashes [][20]byte // SHA1 of each piece
+}
+
+// newTestTorrent returns numPieces pieces of random data, the last one short.
+func newTestTorrent(numPieces int) *testTorrent {
+	data := make([]byte, testPieceLength*(numPieces-1)+testPieceLength/3)
+	rand.Read(data)
+
+	tt := &testTorrent{data: data}
+	for start := 0; start < len(data); start += testPieceLength {
+		tt.hashes = append(tt.hashes, sha1.Sum(data[start:min(start+testPieceLength, len(data))]))
+	}
+	return tt
+}
+
+func (tt *testTorrent) ReadBlock(pieceIndex, begin, length int) ([]byte, error) {
+	start := pieceIndex*testPieceLength + begin
+	return tt.data[start : start+length], nil
+}
+
+// pieceManager returns a piece manager for the torrent, holding every piece
+// if complete is set and none otherwise.
+func (tt *testTorrent) pieceManager(complete bool) *pieces.PieceManager {
+	pm := pieces.NewPieceManagerWithOptions(testPieceLength, int64(len(tt.data)), tt.hashes, true)
+	if complete {
+		have := pieces.NewBitfield(len(tt.hashes))
+		have.SetAll()
+		pm.SetCompleted(have)
+	}
+	return pm
+}
+
+// seeder starts a download manager holding the whole torrent, accepting
+// peers on a local listener. Both are shut down when the test ends.
+func (tt *testTorrent) seeder(t *testing.T, options Options) (*DownloadManager, *peer.Listener) {
+	t.Helper()
+	options.Quiet = true
+	dm := NewDownloadManagerWithOptions(tt.pieceManager(true), NewRarestFirstStrategy(), options)
+	dm.SetStorage(tt)
+	dm.Start()
+	t.Cleanup(dm.Stop)
+
+	listener, err := peer.Listen("127.0.0.1:0", testInfoHash, testPeerID(1), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go listener.Serve(dm.AddInboundPeer)
+
+	return dm, listener
+}
+
+// testInfoHash is the info hash every test connection uses
+var testInfoHash = [20]byte{1}
+
+// testPeerID returns a distinct peer ID for each n
+func testPeerID(n byte) [20]byte {
+	return [20]byte{0: 'T', 1: n}
+}
+
+// connect dials listener and hands the connection to dm.
+func connect(t *testing.T, dm *DownloadManager, listener *peer.Listener, peerID [20]byte) {
+	t.Helper()
+	conn, err := peer.Connect(listener.Addr().String(), testInfoHash, peerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm.AddInboundPeer(conn)
+}
+
+// addrConn gives one end of a net.Pipe a remote address of its own, since
+// the download manager tells peers apart by address.
+type addrConn struct {
+	net.Conn
+	remote net.Addr // Reported by RemoteAddr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// pipePeer connects an in-memory peer to dm, n setting its peer ID and
+// address, and returns the peer's end of the connection. The peer advertises
+// the fast extension.
+func pipePeer(t *testing.T, dm *DownloadManager, n byte) *peer.Connection {
+	t.Helper()
+	return pipePeerWithID(t, dm, n, testPeerID(n))
+}
+
+// pipePeerWithID is pipePeer for a peer that sends peerID in its handshake.
+// dm's own peer ID is testPeerID(0).
+func pipePeerWithID(t *testing.T, dm *DownloadManager, n byte, peerID [20]byte) *peer.Connection {
+	t.Helper()
+	ours, theirs := net.Pipe()
+	t.Cleanup(func() { theirs.Close() })
+
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6880 + int(n)}
+	go func() {
+		conn, err := peer.ConnectOver(addrConn{ours, remote}, testInfoHash, testPeerID(0))
+		if err == nil {
+			dm.AddInboundPeer(conn)
+		}
+	}()
+
+	// Our handshake goes first, then the peer answers
+	if _, err := io.ReadFull(theirs, make([]byte, 68)); err != nil {
+		t.Fatal(err)
+	}
+	handshake := append([]byte{19}, "BitTorrent protocol"...)
+	handshake = append(handshake, 0, 0, 0, 0, 0, 0, 0, 0x04) // Fast extension
+	handshake = append(handshake, testInfoHash[:]...)
+	handshake = append(handshake, peerID[:]...)
+	if _, err := theirs.Write(handshake); err != nil {
+		t.Fatal(err)
+	}
+
+	return peer.NewConnection(theirs, testInfoHash, peerID)
+}
+
+// servePipePeer has a pipePeer claim every piece, unchoke as soon as it's
+// asked to and pass each request to respond, until the connection closes
+// or respond fails.
+func servePipePeer(conn *peer.Connection, respond func(pieceIndex, begin, length int) error) {
+	// A net.Pipe is unbuffered, so messages are read on their own goroutine:
+	// with both ends writing at once, neither write would ever finish
+	msgs := make(chan *peer.Message, 1024)
+	go func() {
+		defer close(msgs)
+		for {
+			msg, err := conn.ReceiveMessage()
+			if err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	defer conn.Close()
+
+	if conn.SendHaveAll() != nil {
+		return
+	}
+	for msg := range msgs {
+		var err error
+		switch msg.Type {
+		case peer.MsgInterested:
+			err = conn.SendUnchoke()
+		case peer.MsgRequest:
+			err = respond(
+				int(binary.BigEndian.Uint32(msg.Payload[0:4])),
+				int(binary.BigEndian.Uint32(msg.Payload[4:8])),
+				int(binary.BigEndian.Uint32(msg.Payload[8:12])),
+			)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestRejectedBlocksRetriedOnAnotherPeer(t *testing.T) {
+	tt := newTestTorrent(3)
+	dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true})
+	dm.Start()
+	defer dm.Stop()
+
+	// The first peer rejects everything it's asked for
+	var mutex sync.Mutex
+	rejected := make(map[string]bool)
+	firstReject := make(chan struct{})
+	rejecter := pipePeer(t, dm, 1)
+	go servePipePeer(rejecter, func(pieceIndex, begin, length int) error {
+		mutex.Lock()
+		if len(rejected) == 0 {
+			close(firstReject)
+		}
+		rejected[fmt.Sprintf("%d:%d", pieceIndex, begin)] = true
+		mutex.Unlock()
+		return rejecter.SendRejectRequest(pieceIndex, begin, length)
+	})
+
+	select {
+	case <-firstReject:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request reached the rejecting peer")
+	}
+
+	// The second serves everything
+	served := make(map[string]bool)
+	server := pipePeer(t, dm, 2)
+	go servePipePeer(server, func(pieceIndex, begin, length int) error {
+		mutex.Lock()
+		served[fmt.Sprintf("%d:%d", pieceIndex, begin)] = true
+		mutex.Unlock()
+		data, _ := tt.ReadBlock(pieceIndex, begin, length)
+		return server.SendPiece(pieceIndex, begin, data)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dm.WaitComplete(ctx); err != nil {
+		t.Fatalf("download didn't complete: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for key := range rejected {
+		if !served[key] {
+			t.Errorf("rejected block %s wasn't fetched from the other peer", key)
+		}
+	}
+	// Rejecting requests is no reason to drop a peer
+	if dm.PeerCount() != 2 {
+		t.Errorf("%d peers connected, want 2", dm.PeerCount())
+	}
+}
+
+func TestPeerStatsWhileAvailabilityChanges(t *testing.T) {
+	tt := newTestTorrent(20)
+	dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true})
+	dm.Start()
+	defer dm.Stop()
+
+	conn := pipePeer(t, dm, 1)
+	go func() {
+		// Whatever we're sent is read and dropped, or the pipe would block
+		for {
+			if _, err := conn.ReceiveMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The peer announces its pieces over and over, replacing and growing
+	// its bitfield in the connection's message loop
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := 0; round < 20; round++ {
+			if conn.SendBitfield(make([]byte, (len(tt.hashes)+7)/8)) != nil {
+				return
+			}
+			for piece := 0; piece < len(tt.hashes); piece++ {
+				if conn.SendHave(piece) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for polling := true; polling; {
+		select {
+		case <-done:
+			polling = false
+		default:
+		}
+		for _, stats := range dm.GetPeerStats() {
+			if stats.Pieces > len(tt.hashes) {
+				t.Fatalf("peer has %d pieces of %d", stats.Pieces, len(tt.hashes))
+			}
+		}
+	}
+}
+
+func TestUnrequestedBlocksDropped(t *testing.T) {
+	tt := newTestTorrent(3)
+	dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true})
+	dm.Start()
+	defer dm.Stop()
+
+	conn := pipePeer(t, dm, 1)
+	go func() {
+		for {
+			if _, err := conn.ReceiveMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The peer never unchokes us, so nothing is requested from it; it sends
+	// a block anyway, then announces a piece so we know the block was read
+	data, _ := tt.ReadBlock(0, 0, pieces.BlockSize)
+	if err := conn.SendHaveNone(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendPiece(0, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendHave(1); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := dm.GetPeerStats()
+		if len(stats) == 1 && stats[0].Pieces == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the peer's have message was never handled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if received, _ := dm.pieceManager.GetPieceProgress(0); received != 0 {
+		t.Errorf("piece 0 has %d bytes after an unrequested block", received)
+	}
+	if len(dm.pieceManager.GetInProgressPieces()) != 0 {
+		t.Errorf("pieces in progress: %v", dm.pieceManager.GetInProgressPieces())
+	}
+	if stats := dm.GetStats(); stats.DownloadedBytes != 0 {
+		t.Errorf("%d bytes counted as downloaded", stats.DownloadedBytes)
+	}
+	// A block nobody asked for is no reason to drop the peer
+	if dm.PeerCount() != 1 {
+		t.Errorf("%d peers connected, want 1", dm.PeerCount())
+	}
+}
+
+func TestWaitComplete(t *testing.T) {
+	// wait runs WaitComplete on its own goroutine, returning a channel for
+	// its result
+	wait := func(ctx context.Context, dm *DownloadManager) <-chan error {
+		result := make(chan error, 1)
+		go func() { result <- dm.WaitComplete(ctx) }()
+		return result
+	}
+
+	t.Run("completes", func(t *testing.T) {
+		tt := newTestTorrent(3)
+		dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true})
+		dm.Start()
+		defer dm.Stop()
+
+		result := wait(context.Background(), dm)
+		start := time.Now()
+		server := pipePeer(t, dm, 1)
+		go servePipePeer(server, func(pieceIndex, begin, length int) error {
+			data, _ := tt.ReadBlock(pieceIndex, begin, length)
+			return server.SendPiece(pieceIndex, begin, data)
+		})
+
+		select {
+		case err := <-result:
+			if err != nil || !dm.IsComplete() {
+				t.Errorf("returned %v with the download complete: %v", err, dm.IsComplete())
+			}
+			// No polling interval to wait out
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("returned %s after the peer connected", elapsed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("didn't return on completion")
+		}
+
+		// Once complete, it returns straight away, whatever the context
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := dm.WaitComplete(ctx); err != nil {
+			t.Errorf("returned %v after completion", err)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		dm := NewDownloadManagerWithOptions(newTestTorrent(3).pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true})
+		dm.Start()
+		defer dm.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		result := wait(ctx, dm)
+		select {
+		case err := <-result:
+			t.Fatalf("returned %v before completion", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		cancel()
+		select {
+		case err := <-result:
+			if err != context.Canceled {
+				t.Errorf("returned %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("didn't return on cancellation")
+		}
+	})
+}
+
+func TestSetStrategy(t *testing.T) {
+	tt := newTestTorrent(10)
+	dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true})
+	dm.Start()
+	defer dm.Stop()
+
+	// One peer has the first half and never unchokes us, leaving the second
+	// half rarest
+	common := pipePeer(t, dm, 1)
+	go func() {
+		for {
+			if _, err := common.ReceiveMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	if err := common.SendBitfield([]byte{0xF8, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stats := dm.GetPeerStats(); len(stats) != 1 || stats[0].Pieces != 5; stats = dm.GetPeerStats() {
+		if time.Now().After(deadline) {
+			t.Fatal("the first peer's bitfield was never handled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The other has everything, but holds back its blocks until the switch
+	var mutex sync.Mutex
+	var before []int  // Pieces requested before the switch
+	var held [][3]int // Blocks requested before the switch, unanswered
+	released := false
+	server := pipePeer(t, dm, 2)
+	send := func(pieceIndex, begin, length int) error {
+		data, _ := tt.ReadBlock(pieceIndex, begin, length)
+		return server.SendPiece(pieceIndex, begin, data)
+	}
+	go servePipePeer(server, func(pieceIndex, begin, length int) error {
+		mutex.Lock()
+		if !released {
+			if !containsInt(before, pieceIndex) {
+				before = append(before, pieceIndex)
+			}
+			held = append(held, [3]int{pieceIndex, begin, length})
+			mutex.Unlock()
+			return nil
+		}
+		mutex.Unlock()
+		return send(pieceIndex, begin, length)
+	})
+
+	// Wait for the request pipeline to fill
+	requested := 0
+	for settled := time.Now().Add(200 * time.Millisecond); time.Now().Before(settled); {
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		if len(held) != requested {
+			requested = len(held)
+			settled = time.Now().Add(200 * time.Millisecond)
+		}
+		mutex.Unlock()
+	}
+	mutex.Lock()
+	rarest := append([]int(nil), before...)
+	mutex.Unlock()
+	if len(rarest) == 0 || len(rarest) >= 5 {
+		t.Fatalf("pieces %v requested before the switch, want a few", rarest)
+	}
+	for _, pieceIndex := range rarest {
+		if pieceIndex < 5 {
+			t.Fatalf("rarest-first requested common piece %d", pieceIndex)
+		}
+	}
+
+	// From here on, new pieces are started in order
+	sequential := &recordingStrategy{PieceStrategy: &SequentialStrategy{}}
+	dm.SetStrategy(sequential)
+	mutex.Lock()
+	released = true
+	blocks := held
+	mutex.Unlock()
+	for _, block := range blocks {
+		if err := send(block[0], block[1], block[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dm.WaitComplete(ctx); err != nil {
+		t.Fatalf("download didn't complete: %v", err)
+	}
+
+	var want []int
+	for pieceIndex := 0; pieceIndex < len(tt.hashes); pieceIndex++ {
+		if !containsInt(rarest, pieceIndex) {
+			want = append(want, pieceIndex)
+		}
+	}
+	if picks := sequential.selected(); fmt.Sprint(picks) != fmt.Sprint(want) {
+		t.Errorf("pieces %v selected after the switch, want %v", picks, want)
+	}
+}
+
+// recordingStrategy notes the pieces a strategy selects.
+type recordingStrategy struct {
+	PieceStrategy
+	mutex sync.Mutex
+	picks []int // Pieces selected, in order
+}
+
+func (rs *recordingStrategy) SelectPiece(availablePieces []int, peerBitfield *pieces.Bitfield) (int, error) {
+	pieceIndex, err := rs.PieceStrategy.SelectPiece(availablePieces, peerBitfield)
+	if err == nil {
+		rs.mutex.Lock()
+		rs.picks = append(rs.picks, pieceIndex)
+		rs.mutex.Unlock()
+	}
+	return pieceIndex, err
+}
+
+// selected returns the pieces selected so far, each once.
+func (rs *recordingStrategy) selected() []int {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	var picks []int
+	for _, pieceIndex := range rs.picks {
+		if !containsInt(picks, pieceIndex) {
+			picks = append(picks, pieceIndex)
+		}
+	}
+	return picks
+}
+
+// containsInt reports whether list holds n.
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPeerIDChecks(t *testing.T) {
+	dm := NewDownloadManagerWithOptions(newTestTorrent(3).pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true})
+	dm.Start()
+	defer dm.Stop()
+
+	// dropped reports whether dm closed conn rather than announcing its
+	// pieces, which it does first thing with a peer it keeps
+	dropped := func(conn *peer.Connection) bool {
+		_, err := conn.ReceiveMessage()
+		return err != nil
+	}
+
+	// A peer sending our own peer ID is us, reached through some other address
+	self := pipePeerWithID(t, dm, 1, testPeerID(0))
+	if !dropped(self) {
+		t.Error("connection to ourselves kept")
+	}
+
+	// A peer already connected under another address is dropped the second time
+	first := pipePeer(t, dm, 2)
+	if dropped(first) {
+		t.Fatal("first connection dropped")
+	}
+	second := pipePeerWithID(t, dm, 3, testPeerID(2))
+	if !dropped(second) {
+		t.Error("second connection to the same peer ID kept")
+	}
+	if stats := dm.GetPeerStats(); len(stats) != 1 || stats[0].Address != "127.0.0.1:6882" {
+		t.Errorf("peers connected: %+v, want only the first", stats)
+	}
+}
+
+func TestPeerWithoutBitfield(t *testing.T) {
+	tt := newTestTorrent(4)
+	pm := tt.pieceManager(false)
+	have := pieces.NewBitfield(len(tt.hashes))
+	have.SetPiece(0)
+	pm.SetCompleted(have)
+
+	strategy := NewRarestFirstStrategy()
+	dm := NewDownloadManagerWithOptions(pm, strategy, Options{Quiet: true})
+	dm.Start()
+	defer dm.Stop()
+
+	// The peer sends no bitfield, unchokes as soon as it's asked, and
+	// answers nothing
+	conn := pipePeer(t, dm, 1)
+	requests := make(chan int, 64)
+	go func() {
+		for {
+			msg, err := conn.ReceiveMessage()
+			if err != nil {
+				return
+			}
+			switch msg.Type {
+			case peer.MsgInterested:
+				conn.SendUnchoke()
+			case peer.MsgRequest:
+				requests <- int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+			}
+		}
+	}()
+
+	// announce sends a have for pieceIndex and waits for it to be counted
+	announce := func(pieceIndex int) {
+		t.Helper()
+		if err := conn.SendHave(pieceIndex); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			strategy.mutex.RLock()
+			count := strategy.pieceCounts[pieceIndex]
+			strategy.mutex.RUnlock()
+			if count == 1 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("have %d not counted towards rarity", pieceIndex)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	noRequests := func(when string) {
+		t.Helper()
+		select {
+		case pieceIndex := <-requests:
+			t.Fatalf("piece %d requested %s", pieceIndex, when)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	noRequests("from a peer with no pieces")
+	announce(0)
+	noRequests("when the peer only has a piece we have")
+
+	// Requests start with the first piece we need
+	announce(2)
+	select {
+	case pieceIndex := <-requests:
+		if pieceIndex != 2 {
+			t.Errorf("piece %d requested, want 2", pieceIndex)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing requested once the peer had a piece we need")
+	}
+	if stats := dm.GetPeerStats(); len(stats) != 1 || stats[0].Pieces != 2 {
+		t.Errorf("peer stats %+v, want one peer with 2 pieces", stats)
+	}
+}
+
+func TestMediaStrategyOrder(t *testing.T) {
+	tests := []struct {
+		name                  string
+		numPieces, head, tail int
+		peerLacks             []int // Pieces the peer doesn't have
+		want                  []int
+	}{
+		{"head and tail first", 10, 2, 2, nil, []int{0, 1, 8, 9, 2, 3, 4, 5, 6, 7}},
+		{"no tail", 6, 1, 0, nil, []int{0, 1, 2, 3, 4, 5}},
+		{"no head", 6, 0, 2, nil, []int{4, 5, 0, 1, 2, 3}},
+		{"head and tail overlap", 3, 2, 2, nil, []int{0, 1, 2}},
+		{"peer lacks the first piece", 8, 2, 1, []int{0}, []int{1, 7, 2, 3, 4, 5, 6}},
+	}
+
+	for _, tt := range tests {
+		strategy := NewStrategyFor(tt.numPieces, MediaOptions{Enabled: true, Head: tt.head, Tail: tt.tail})
+		peerBitfield := pieces.NewBitfield(tt.numPieces)
+		peerBitfield.SetAll()
+		for _, pieceIndex := range tt.peerLacks {
+			peerBitfield.ClearPiece(pieceIndex)
+		}
+
+		// Select until nothing the peer has is left, as pieces complete
+		var available, got []int
+		for pieceIndex := 0; pieceIndex < tt.numPieces; pieceIndex++ {
+			available = append(available, pieceIndex)
+		}
+		for {
+			pieceIndex, err := strategy.SelectPiece(available, peerBitfield)
+			if err != nil {
+				break
+			}
+			got = append(got, pieceIndex)
+			for i, candidate := range available {
+				if candidate == pieceIndex {
+					available = append(available[:i], available[i+1:]...)
+					break
+				}
+			}
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: selected %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWarmupDelaysRequests(t *testing.T) {
+	// servePeer connects pipePeer n to serve the torrent, reporting its
+	// requests on requests
+	servePeer := func(t *testing.T, dm *DownloadManager, tt *testTorrent, n byte, requests chan<- byte) {
+		conn := pipePeer(t, dm, n)
+		go servePipePeer(conn, func(pieceIndex, begin, length int) error {
+			select {
+			case requests <- n:
+			default:
+			}
+			data, _ := tt.ReadBlock(pieceIndex, begin, length)
+			return conn.SendPiece(pieceIndex, begin, data)
+		})
+	}
+
+	t.Run("until enough bitfields", func(t *testing.T) {
+		tt := newTestTorrent(4)
+		dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(),
+			Options{Quiet: true, Warmup: time.Minute, WarmupPeers: 2})
+		dm.Start()
+		defer dm.Stop()
+
+		requests := make(chan byte, 64)
+		servePeer(t, dm, tt, 1, requests)
+		select {
+		case <-requests:
+			t.Fatal("requested with one bitfield of the two the warmup waits for")
+		case <-time.After(300 * time.Millisecond):
+		}
+
+		// The second bitfield ends the warmup, long before its window
+		servePeer(t, dm, tt, 2, requests)
+		select {
+		case <-requests:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no requests after the warmup")
+		}
+	})
+
+	t.Run("until the window ends", func(t *testing.T) {
+		const warmup = 500 * time.Millisecond
+		tt := newTestTorrent(4)
+		dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(),
+			Options{Quiet: true, Warmup: warmup})
+		started := time.Now()
+		dm.Start()
+		defer dm.Stop()
+
+		requests := make(chan byte, 64)
+		servePeer(t, dm, tt, 1, requests)
+		servePeer(t, dm, tt, 2, requests)
+		select {
+		case <-requests:
+			if elapsed := time.Since(started); elapsed < warmup {
+				t.Errorf("requested after %s, within the %s warmup", elapsed, warmup)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no requests after the warmup")
+		}
+	})
+}
+
+func TestFreePeerSlots(t *testing.T) {
+	tt := newTestTorrent(3)
+	dm := NewDownloadManagerWithOptions(tt.pieceManager(false), NewRarestFirstStrategy(), Options{Quiet: true, MaxPeers: 4})
+	dm.Start()
+	defer dm.Stop()
+
+	if free := dm.FreePeerSlots(); free != 4 {
+		t.Errorf("%d free slots with no peers, want 4", free)
+	}
+	for n := byte(1); n <= 3; n++ {
+		go servePipePeer(pipePeer(t, dm, n), func(pieceIndex, begin, length int) error { return nil })
+	}
+	for deadline := time.Now().Add(5 * time.Second); dm.PeerCount() < 3 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if free := dm.FreePeerSlots(); dm.PeerCount() != 3 || free != 1 {
+		t.Errorf("%d free slots with %d of 4 peers, want 1 with 3", free, dm.PeerCount())
+	}
+}
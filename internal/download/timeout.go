@@ -0,0 +1,107 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+const (
+	// requestTimeout is how long a peer has to deliver a requested block
+	// before it is cancelled and requested elsewhere. A peer that accepts
+	// requests and never answers them would otherwise hold the blocks, and
+	// so their pieces, forever.
+	requestTimeout = 30 * time.Second
+
+	// requestSweepInterval is how often pending requests are checked
+	// against requestTimeout.
+	requestSweepInterval = 5 * time.Second
+)
+
+// sweepRequests expires timed-out block requests until ctx is cancelled.
+func (dm *DownloadManager) sweepRequests(ctx context.Context) {
+	ticker := time.NewTicker(requestSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Under a rate limit, blocks queue behind each other; allow for
+			// the time it takes to let everything outstanding through
+			backlog := dm.limiter.drainTime(dm.outstandingBytes())
+			dm.expireRequests(time.Now().Add(-requestTimeout - backlog))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// outstandingBytes returns the bytes requested from all peers and not yet
+// received.
+func (dm *DownloadManager) outstandingBytes() int64 {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+
+	var total int64
+	for _, peerConn := range dm.peers {
+		peerConn.mutex.Lock()
+		for _, blockReq := range peerConn.pendingRequests {
+			total += int64(blockReq.Length)
+		}
+		peerConn.mutex.Unlock()
+	}
+	return total
+}
+
+// expireRequests cancels every pending request made before deadline and
+// returns its block to the pool, then lets every peer request again so the
+// blocks are picked up by someone else.
+func (dm *DownloadManager) expireRequests(deadline time.Time) {
+	dm.mutex.RLock()
+	peerConns := make([]*PeerConnection, 0, len(dm.peers))
+	for _, peerConn := range dm.peers {
+		peerConns = append(peerConns, peerConn)
+	}
+	dm.mutex.RUnlock()
+
+	expiredAny := false
+	for _, peerConn := range peerConns {
+		var expired []*pieces.BlockRequest
+		var duplicates []bool
+		peerConn.mutex.Lock()
+		for key, requestedAt := range peerConn.requestedAt {
+			if requestedAt.After(deadline) {
+				continue
+			}
+			expired = append(expired, peerConn.pendingRequests[key])
+			duplicates = append(duplicates, peerConn.duplicates[key])
+			delete(peerConn.pendingRequests, key)
+			delete(peerConn.duplicates, key)
+			delete(peerConn.requestedAt, key)
+		}
+		peerConn.mutex.Unlock()
+
+		if len(expired) == 0 {
+			continue
+		}
+		expiredAny = true
+		if !dm.quiet {
+			fmt.Printf("%d requests to %s timed out\n", len(expired), peerConn.addr)
+		}
+
+		for i, blockReq := range expired {
+			// An endgame duplicate's block is still owned by the original request
+			if !duplicates[i] {
+				dm.pieceManager.UnrequestBlock(blockReq.PieceIndex, blockReq.Begin, peerConn.addr)
+			}
+			// A failed send shows up in the peer's message loop
+			peerConn.conn.SendCancel(blockReq.PieceIndex, blockReq.Begin, blockReq.Length)
+		}
+	}
+
+	if expiredAny {
+		dm.requestFromAll()
+	}
+}
@@ -0,0 +1,66 @@
+package peer
+
+import "strings"
+
+// Reserved handshake bits advertising protocol extensions
+const (
+	reservedExtended = 0x10 // reserved[5]: extension protocol (BEP 10)
+	reservedDHT      = 0x01 // reserved[7]: DHT (BEP 5)
+	reservedFast     = 0x04 // reserved[7]: fast extension (BEP 6)
+)
+
+// ourReserved are the reserved bytes every connection sends: we speak the
+// extension protocol, which metadata exchange (BEP 9) runs over, and the
+// fast extension. DHT is only advertised by connections made with
+// Options.DHT, as only then is there a DHT node to tell peers about.
+var ourReserved = [8]byte{5: reservedExtended, 7: reservedFast}
+
+// Capabilities returns labels for the extensions advertised in a handshake's
+// reserved bytes, in the order EXT, DHT, FAST. Unknown bits are ignored.
+func Capabilities(reserved [8]byte) []string {
+	var labels []string
+	if reserved[5]&reservedExtended != 0 {
+		labels = append(labels, "EXT")
+	}
+	if reserved[7]&reservedDHT != 0 {
+		labels = append(labels, "DHT")
+	}
+	if reserved[7]&reservedFast != 0 {
+		labels = append(labels, "FAST")
+	}
+	return labels
+}
+
+// FormatCapabilities joins the capability labels for display, e.g.
+// "EXT DHT FAST", or returns "-" if none are advertised.
+func FormatCapabilities(reserved [8]byte) string {
+	labels := Capabilities(reserved)
+	if len(labels) == 0 {
+		return "-"
+	}
+	return strings.Join(labels, " ")
+}
+
+// ClientName names the client behind a peer ID. Most clients use the
+// Azureus convention "-XXvvvv-" (client code and version); anything else is
+// shown as its printable prefix.
+func ClientName(id [20]byte) string {
+	if id[0] == '-' && id[7] == '-' && printablePrefix(id[1:7]) == 6 {
+		return string(id[1:3]) + " " + string(id[3:7])
+	}
+
+	end := printablePrefix(id[:])
+	if end == 0 {
+		return "unknown"
+	}
+	return string(id[:end])
+}
+
+// printablePrefix returns the length of the printable ASCII prefix of b.
+func printablePrefix(b []byte) int {
+	end := 0
+	for end < len(b) && b[end] >= 0x20 && b[end] < 0x7f {
+		end++
+	}
+	return end
+}
@@ -0,0 +1,104 @@
+package peer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved [8]byte
+		want     []string
+		format   string
+	}{
+		{"none", [8]byte{}, nil, "-"},
+		{"extension protocol", [8]byte{5: 0x10}, []string{"EXT"}, "EXT"},
+		{"DHT", [8]byte{7: 0x01}, []string{"DHT"}, "DHT"},
+		{"fast", [8]byte{7: 0x04}, []string{"FAST"}, "FAST"},
+		{"all", [8]byte{5: 0x10, 7: 0x05}, []string{"EXT", "DHT", "FAST"}, "EXT DHT FAST"},
+		{"unknown bits", [8]byte{0: 0x80, 5: 0x01, 7: 0x08}, nil, "-"},
+	}
+
+	for _, tt := range tests {
+		if got := Capabilities(tt.reserved); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Capabilities = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := FormatCapabilities(tt.reserved); got != tt.format {
+			t.Errorf("%s: FormatCapabilities = %q, want %q", tt.name, got, tt.format)
+		}
+	}
+}
+
+func TestClientName(t *testing.T) {
+	id := func(prefix string) [20]byte {
+		var id [20]byte
+		copy(id[:], prefix)
+		return id
+	}
+
+	tests := []struct {
+		id   [20]byte
+		want string
+	}{
+		{id("-qB4630-\x01\x02\x03"), "qB 4630"},
+		{id("-TR3000-abcdefghijkl"), "TR 3000"},
+		{id("M7-2-2--\xff"), "M7-2-2--"},
+		{id("-XX\x0112-"), "-XX"},
+		{[20]byte{0xff, 'a'}, "unknown"},
+		{[20]byte{}, "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := ClientName(tt.id); got != tt.want {
+			t.Errorf("ClientName(%q) = %q, want %q", tt.id[:], got, tt.want)
+		}
+	}
+}
+
+func TestDHTAdvertisedOnlyWithOption(t *testing.T) {
+	infoHash := [20]byte{1}
+	tests := []struct {
+		name     string
+		outbound Options // Options we dial with
+		inbound  Options // Options the listener answers with
+	}{
+		{"neither", Options{}, Options{}},
+		{"outbound", Options{DHT: true}, Options{}},
+		{"inbound", Options{}, Options{DHT: true}},
+		{"both", Options{DHT: true}, Options{DHT: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := ListenWithOptions("127.0.0.1:0", infoHash, [20]byte{'L'}, true, tt.inbound)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			accepted := make(chan *Connection, 1)
+			go listener.Serve(func(conn *Connection) { accepted <- conn })
+
+			conn, err := ConnectContextWithOptions(context.Background(), listener.Addr().String(), infoHash, [20]byte{'D'}, tt.outbound)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			inbound := <-accepted
+			defer inbound.Close()
+
+			if conn.SupportsDHT() != tt.inbound.DHT {
+				t.Errorf("listener advertised DHT: %v, want %v", conn.SupportsDHT(), tt.inbound.DHT)
+			}
+			if inbound.SupportsDHT() != tt.outbound.DHT {
+				t.Errorf("dialer advertised DHT: %v, want %v", inbound.SupportsDHT(), tt.outbound.DHT)
+			}
+			// The other extensions are advertised either way
+			if !conn.SupportsFast() || !inbound.SupportsFast() {
+				t.Error("fast extension not advertised")
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// inboundHandshakeTimeout is deliberately shorter than the outbound one:
+	// a real client sends its handshake immediately after connecting, so a
+	// silent connection is almost always a scan or a half-open probe.
+	inboundHandshakeTimeout = 5 * time.Second
+
+	// maxAcceptsPerIP limits how many connections a single IP may open
+	// within acceptWindow before further attempts are dropped unanswered.
+	maxAcceptsPerIP = 5
+	acceptWindow    = time.Minute
+)
+
+// Listener accepts inbound peer connections for a single torrent.
+// Each connection must complete the handshake before it's handed to the caller.
+type Listener struct {
+	listener net.Listener           // Underlying TCP listener
+	infoHash [20]byte               // Torrent we're serving
+	peerID   [20]byte               // Our client ID
+	options  Options                // Options inbound connections are made with
+	attempts map[string][]time.Time // Recent accept times per remote IP
+	quiet    bool                   // Suppress stdout output
+	mutex    sync.Mutex             // Protects attempts
+}
+
+// Listen starts listening for inbound peer connections on the given address.
+func Listen(addr string, infoHash, peerID [20]byte, quiet bool) (*Listener, error) {
+	return ListenWithOptions(addr, infoHash, peerID, quiet, Options{})
+}
+
+// ListenWithOptions is Listen, answering handshakes as connections made
+// with options do.
+func ListenWithOptions(addr string, infoHash, peerID [20]byte, quiet bool, options Options) (*Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	return &Listener{
+		listener: listener,
+		infoHash: infoHash,
+		peerID:   peerID,
+		options:  options,
+		attempts: make(map[string][]time.Time),
+		quiet:    quiet,
+	}, nil
+}
+
+// Serve accepts connections until the listener is closed, performing the
+// handshake for each one in its own goroutine and passing successful
+// connections to handler.
+func (l *Listener) Serve(handler func(*Connection)) error {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		if !l.allow(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+
+		go l.handshake(conn, handler)
+	}
+}
+
+// handshake completes the server-side handshake for an accepted connection.
+func (l *Listener) handshake(conn net.Conn, handler func(*Connection)) {
+	peerConn := NewConnectionWithOptions(conn, l.infoHash, l.peerID, l.options)
+
+	err := peerConn.acceptHandshake(inboundHandshakeTimeout)
+	if err != nil {
+		conn.Close()
+
+		// Scans and probes are routine on an open port, so only
+		// report failures from clients that actually speak the protocol
+		if !l.quiet && !IsProbeError(err) {
+			fmt.Printf("Rejected inbound peer %s: %v\n", conn.RemoteAddr(), err)
+		}
+		return
+	}
+
+	handler(peerConn)
+}
+
+// allow applies the per-IP accept rate limit.
+func (l *Listener) allow(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-acceptWindow)
+
+	// Forget attempts that fell out of the window
+	for ip, times := range l.attempts {
+		recent := times[:0]
+		for _, t := range times {
+			if t.After(cutoff) {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(l.attempts, ip)
+		} else {
+			l.attempts[ip] = recent
+		}
+	}
+
+	if len(l.attempts[host]) >= maxAcceptsPerIP {
+		return false
+	}
+
+	l.attempts[host] = append(l.attempts[host], now)
+	return true
+}
+
+// Addr returns the address the listener is bound to.
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close stops accepting new connections.
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// IsProbeError reports whether a handshake error looks like a port scan or
+// half-open probe rather than a misbehaving BitTorrent client.
+func IsProbeError(err error) bool {
+	return errors.Is(err, ErrHandshakeTimeout) ||
+		errors.Is(err, ErrHandshakeTruncated) ||
+		errors.Is(err, ErrNotBitTorrent)
+}
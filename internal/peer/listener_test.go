@@ -0,0 +1,101 @@
+package peer
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testListener listens on a loopback port, handing each connection that
+// completes the handshake to accepted.
+func testListener(t *testing.T) (*Listener, chan *Connection) {
+	t.Helper()
+	listener, err := Listen("127.0.0.1:0", [20]byte{1}, [20]byte{'L'}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	accepted := make(chan *Connection, 16)
+	go listener.Serve(func(conn *Connection) { accepted <- conn })
+	return listener, accepted
+}
+
+// closedAfter dials listener, sends data and returns how long the listener
+// took to close the connection.
+func closedAfter(t *testing.T, listener *Listener, data []byte) time.Duration {
+	t.Helper()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * inboundHandshakeTimeout))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("connection wasn't closed: %v", err)
+	}
+	return time.Since(start)
+}
+
+func TestInboundHandshakeTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"silent", nil},
+		{"partial handshake", append([]byte{19}, "BitTorrent prot"...)},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			listener, accepted := testListener(t)
+
+			elapsed := closedAfter(t, listener, tt.data)
+			if elapsed < inboundHandshakeTimeout-time.Second || elapsed > inboundHandshakeTimeout+2*time.Second {
+				t.Errorf("closed after %s, want about %s", elapsed, inboundHandshakeTimeout)
+			}
+			select {
+			case <-accepted:
+				t.Error("handler called without a handshake")
+			default:
+			}
+		})
+	}
+}
+
+func TestInboundRateLimitPerIP(t *testing.T) {
+	listener, accepted := testListener(t)
+
+	// Connections up to the limit are kept open, waiting for a handshake
+	for i := 0; i < maxAcceptsPerIP; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	// The next is dropped unanswered, long before the handshake timeout
+	if elapsed := closedAfter(t, listener, nil); elapsed > time.Second {
+		t.Errorf("connection over the limit closed after %s", elapsed)
+	}
+
+	// Even with a valid handshake
+	_, err := Connect(listener.Addr().String(), [20]byte{1}, [20]byte{'D'})
+	if err == nil {
+		t.Error("handshake accepted over the limit")
+	}
+	select {
+	case <-accepted:
+		t.Error("handler called over the limit")
+	default:
+	}
+}
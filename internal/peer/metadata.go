@@ -0,0 +1,201 @@
+package peer
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+)
+
+// Metadata exchange (BEP 9) runs over the extension protocol (BEP 10): both
+// sides send an extended handshake naming the message IDs they want used for
+// ut_metadata, then the info dictionary is requested in 16 KiB pieces.
+const (
+	extHandshakeID      = 0       // Extended message ID of the extended handshake
+	utMetadataID        = 1       // Extended message ID we ask peers to use for ut_metadata
+	metadataPieceLength = 16384   // Size of every metadata piece but the last
+	maxMetadataSize     = 8 << 20 // Largest info dictionary we accept
+
+	metadataRequest = 0 // ut_metadata msg_type: request a piece
+	metadataData    = 1 // ut_metadata msg_type: piece data
+	metadataReject  = 2 // ut_metadata msg_type: peer won't send the piece
+)
+
+// Metadata exchange failures; a caller would typically try another peer.
+var (
+	ErrNoMetadataSupport    = errors.New("peer doesn't support metadata exchange")
+	ErrMetadataRejected     = errors.New("peer rejected metadata request")
+	ErrMetadataHashMismatch = errors.New("metadata doesn't match info hash")
+)
+
+// SupportsExtensions reports whether the peer advertised the extension
+// protocol in its handshake.
+func (c *Connection) SupportsExtensions() bool {
+	return c.remoteReserved[5]&reservedExtended != 0
+}
+
+// RequestMetadata fetches the torrent's info dictionary from the peer and
+// returns it bencoded, once its SHA1 matches the info hash we connected
+// with. Other messages received meanwhile (e.g. the peer's bitfield) are
+// applied to the connection as usual.
+func (c *Connection) RequestMetadata() ([]byte, error) {
+	if !c.SupportsExtensions() {
+		return nil, ErrNoMetadataSupport
+	}
+
+	err := c.sendExtended(extHandshakeID, map[string]interface{}{
+		"m": map[string]interface{}{"ut_metadata": utMetadataID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send extended handshake: %w", err)
+	}
+
+	remoteID, size, err := c.receiveMetadataOffer()
+	if err != nil {
+		return nil, err
+	}
+
+	numPieces := (size + metadataPieceLength - 1) / metadataPieceLength
+	for piece := 0; piece < numPieces; piece++ {
+		err = c.sendExtended(remoteID, map[string]interface{}{
+			"msg_type": metadataRequest,
+			"piece":    piece,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to request metadata piece %d: %w", piece, err)
+		}
+	}
+
+	metadata := make([]byte, size)
+	received := make([]bool, numPieces)
+	for remaining := numPieces; remaining > 0; {
+		payload, err := c.receiveExtended(utMetadataID)
+		if err != nil {
+			return nil, err
+		}
+
+		piece, data, err := parseMetadataMessage(payload)
+		if err != nil {
+			return nil, err
+		}
+		if piece < 0 || piece >= numPieces {
+			return nil, fmt.Errorf("metadata piece %d out of range", piece)
+		}
+
+		expected := min(metadataPieceLength, size-piece*metadataPieceLength)
+		if len(data) != expected {
+			return nil, fmt.Errorf("metadata piece %d has %d bytes, expected %d", piece, len(data), expected)
+		}
+		if !received[piece] {
+			copy(metadata[piece*metadataPieceLength:], data)
+			received[piece] = true
+			remaining--
+		}
+	}
+
+	if sha1.Sum(metadata) != c.infoHash {
+		return nil, ErrMetadataHashMismatch
+	}
+	return metadata, nil
+}
+
+// receiveMetadataOffer waits for the peer's extended handshake and returns
+// the message ID it wants ut_metadata requests sent with and the size of
+// the info dictionary.
+func (c *Connection) receiveMetadataOffer() (int, int, error) {
+	payload, err := c.receiveExtended(extHandshakeID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value, err := bencode.NewDecoder(bytes.NewReader(payload)).Decode()
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid extended handshake: %w", err)
+	}
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return 0, 0, fmt.Errorf("extended handshake is not a dictionary")
+	}
+
+	extensions, _ := dict["m"].(map[string]interface{})
+	remoteID, ok := extensions["ut_metadata"].(int64)
+	if !ok || remoteID <= 0 || remoteID > 255 {
+		return 0, 0, ErrNoMetadataSupport
+	}
+
+	size, ok := dict["metadata_size"].(int64)
+	if !ok || size <= 0 || size > maxMetadataSize {
+		return 0, 0, fmt.Errorf("invalid metadata size %d", size)
+	}
+
+	return int(remoteID), int(size), nil
+}
+
+// parseMetadataMessage splits a ut_metadata data message into its piece
+// index and data. A reject gives ErrMetadataRejected.
+func parseMetadataMessage(payload []byte) (int, []byte, error) {
+	header, data, err := bencode.SplitValue(payload)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid metadata message: %w", err)
+	}
+
+	value, err := bencode.NewDecoder(bytes.NewReader(header)).Decode()
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid metadata message: %w", err)
+	}
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return 0, nil, fmt.Errorf("metadata message is not a dictionary")
+	}
+
+	msgType, _ := dict["msg_type"].(int64)
+	piece, ok := dict["piece"].(int64)
+	if !ok {
+		return 0, nil, fmt.Errorf("metadata message has no piece index")
+	}
+
+	switch msgType {
+	case metadataData:
+		return int(piece), data, nil
+	case metadataReject:
+		return 0, nil, fmt.Errorf("%w: piece %d", ErrMetadataRejected, piece)
+	default:
+		return 0, nil, fmt.Errorf("unexpected metadata message type %d", msgType)
+	}
+}
+
+// sendExtended sends a bencoded extended message with the given ID.
+func (c *Connection) sendExtended(id int, value interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(id))
+	err := bencode.NewEncoder(&buf).Encode(value)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(Message{Type: MsgExtended, Payload: buf.Bytes()})
+}
+
+// receiveExtended reads messages until an extended message with the given
+// ID arrives, and returns its payload after the ID byte.
+func (c *Connection) receiveExtended(id int) ([]byte, error) {
+	for {
+		msg, err := c.ReceiveMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Type != MsgExtended {
+			err = c.HandleMessage(msg)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if len(msg.Payload) > 0 && int(msg.Payload[0]) == id {
+			return msg.Payload[1:], nil
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+)
+
+// Peer exchange (BEP 11) runs over the extension protocol as well: a peer
+// that offers ut_pex in its extended handshake can be sent, about once a
+// minute, the peers we connected to and dropped since the last message.
+const (
+	utPexID = 2 // Extended message ID we ask peers to use for ut_pex
+
+	// MaxPexPeers is the most peers a ut_pex message may add, and the most
+	// it may drop.
+	MaxPexPeers = 50
+)
+
+// SendExtendedHandshake offers ut_pex to a peer supporting the extension
+// protocol. listenPort, if not 0, tells the peer which port we accept
+// connections on, so that it can pass us on to others.
+func (c *Connection) SendExtendedHandshake(listenPort int) error {
+	handshake := map[string]interface{}{
+		"m": map[string]interface{}{"ut_pex": utPexID},
+	}
+	if listenPort > 0 {
+		handshake["p"] = listenPort
+	}
+	return c.sendExtended(extHandshakeID, handshake)
+}
+
+// SupportsPex reports whether the peer offered ut_pex in its extended
+// handshake.
+func (c *Connection) SupportsPex() bool {
+	return c.remotePexID.Load() != 0
+}
+
+// ListenPort returns the port the peer said in its extended handshake that
+// it accepts connections on, or 0.
+func (c *Connection) ListenPort() int {
+	return int(c.listenPort.Load())
+}
+
+// OnPex makes HandleMessage pass the peers added by ut_pex messages to
+// handler. Without one, ut_pex messages are ignored.
+func (c *Connection) OnPex(handler func(added []*net.TCPAddr)) {
+	c.onPex = handler
+}
+
+// SendPex tells a peer supporting ut_pex about the peers we connected to
+// and those we dropped since the last message. At most MaxPexPeers of each
+// are sent.
+func (c *Connection) SendPex(added, dropped []*net.TCPAddr) error {
+	remoteID := c.remotePexID.Load()
+	if remoteID == 0 {
+		return fmt.Errorf("peer doesn't support peer exchange")
+	}
+
+	added = added[:min(len(added), MaxPexPeers)]
+	dropped = dropped[:min(len(dropped), MaxPexPeers)]
+
+	added4, added6 := compactPeers(added)
+	dropped4, dropped6 := compactPeers(dropped)
+	return c.sendExtended(int(remoteID), map[string]interface{}{
+		"added":    added4,
+		"added.f":  make([]byte, len(added4)/6),
+		"added6":   added6,
+		"added6.f": make([]byte, len(added6)/18),
+		"dropped":  dropped4,
+		"dropped6": dropped6,
+	})
+}
+
+// handleExtended handles an extended message received outside of metadata
+// exchange: the peer's extended handshake, or a ut_pex message. Other
+// extensions are ignored.
+func (c *Connection) handleExtended(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("empty extended message")
+	}
+
+	switch int(payload[0]) {
+	case extHandshakeID:
+		dict, err := decodeExtended(payload[1:])
+		if err != nil {
+			return fmt.Errorf("invalid extended handshake: %w", err)
+		}
+		extensions, _ := dict["m"].(map[string]interface{})
+		if remoteID, ok := extensions["ut_pex"].(int64); ok && remoteID >= 0 && remoteID <= 255 {
+			// An ID of 0 turns ut_pex off again
+			c.remotePexID.Store(int32(remoteID))
+		}
+		if port, ok := dict["p"].(int64); ok && port > 0 && port <= 65535 {
+			c.listenPort.Store(int32(port))
+		}
+	case utPexID:
+		if c.onPex == nil {
+			return nil
+		}
+		dict, err := decodeExtended(payload[1:])
+		if err != nil {
+			return fmt.Errorf("invalid ut_pex message: %w", err)
+		}
+		added4, _ := dict["added"].([]byte)
+		added6, _ := dict["added6"].([]byte)
+		added := append(parseCompactPeers(added4, net.IPv4len), parseCompactPeers(added6, net.IPv6len)...)
+		c.onPex(added[:min(len(added), MaxPexPeers)])
+	}
+	return nil
+}
+
+// decodeExtended decodes the bencoded dictionary of an extended message.
+func decodeExtended(payload []byte) (map[string]interface{}, error) {
+	value, err := bencode.NewDecoder(bytes.NewReader(payload)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not a dictionary")
+	}
+	return dict, nil
+}
+
+// compactPeers encodes addresses in compact form, IPv4 and IPv6 separately:
+// each address followed by its port.
+func compactPeers(addrs []*net.TCPAddr) ([]byte, []byte) {
+	var compact4, compact6 []byte
+	for _, addr := range addrs {
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			compact4 = binary.BigEndian.AppendUint16(append(compact4, ip4...), uint16(addr.Port))
+		} else if ip6 := addr.IP.To16(); ip6 != nil {
+			compact6 = binary.BigEndian.AppendUint16(append(compact6, ip6...), uint16(addr.Port))
+		}
+	}
+	return compact4, compact6
+}
+
+// parseCompactPeers decodes compact addresses with IPs of ipLength bytes.
+// A trailing partial record is ignored.
+func parseCompactPeers(data []byte, ipLength int) []*net.TCPAddr {
+	var addrs []*net.TCPAddr
+	recordLength := ipLength + 2
+	for i := 0; i+recordLength <= len(data); i += recordLength {
+		addrs = append(addrs, &net.TCPAddr{
+			IP:   net.IP(append([]byte(nil), data[i:i+ipLength]...)),
+			Port: int(binary.BigEndian.Uint16(data[i+ipLength : i+recordLength])),
+		})
+	}
+	return addrs
+}
@@ -1,28 +1,64 @@
 package peer
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// protocolName is the protocol string sent in every handshake.
+const protocolName = "BitTorrent protocol"
+
+// handshakeTimeout bounds each half of an outbound handshake.
+const handshakeTimeout = 10 * time.Second
+
+const (
+	// MaxBlockLength is the largest block we request, and therefore the
+	// largest piece payload (plus its 8-byte header) we accept.
+	MaxBlockLength = 16 * 1024
+
+	// maxMessageLength caps messages whose size isn't otherwise known,
+	// unless Options.MaxMessageLength sets another cap.
+	maxMessageLength = 1 << 17
+)
+
+// Handshake failures are classified with these errors so callers can tell
+// port scans and half-open probes apart from real protocol problems.
+var (
+	ErrHandshakeTimeout   = errors.New("handshake timed out")
+	ErrHandshakeTruncated = errors.New("connection closed during handshake")
+	ErrNotBitTorrent      = errors.New("not a BitTorrent handshake")
+	ErrInfoHashMismatch   = errors.New("info hash mismatch")
+	ErrSelfConnection     = errors.New("connected to ourselves")
+)
+
 // MessageType represents the type of BitTorrent peer wire protocol message.
 // These constants define the standard message types used in peer communication.
 type MessageType uint8
 
 const (
-	MsgChoke         MessageType = 0 // Peer is choking us (won't send data)
-	MsgUnchoke       MessageType = 1 // Peer is unchoking us (will send data)
-	MsgInterested    MessageType = 2 // We are interested in peer's data
-	MsgNotInterested MessageType = 3 // We are not interested in peer's data
-	MsgHave          MessageType = 4 // Peer announces it has a piece
-	MsgBitfield      MessageType = 5 // Peer sends its complete bitfield
-	MsgRequest       MessageType = 6 // Request a block of data
-	MsgPiece         MessageType = 7 // Piece data response
-	MsgCancel        MessageType = 8 // Cancel a previous request
-	MsgPort          MessageType = 9 // DHT port announcement (rarely used)
+	MsgChoke         MessageType = 0  // Peer is choking us (won't send data)
+	MsgUnchoke       MessageType = 1  // Peer is unchoking us (will send data)
+	MsgInterested    MessageType = 2  // We are interested in peer's data
+	MsgNotInterested MessageType = 3  // We are not interested in peer's data
+	MsgHave          MessageType = 4  // Peer announces it has a piece
+	MsgBitfield      MessageType = 5  // Peer sends its complete bitfield
+	MsgRequest       MessageType = 6  // Request a block of data
+	MsgPiece         MessageType = 7  // Piece data response
+	MsgCancel        MessageType = 8  // Cancel a previous request
+	MsgPort          MessageType = 9  // DHT port announcement (BEP 5)
+	MsgSuggestPiece  MessageType = 13 // Peer suggests a piece to download (BEP 6)
+	MsgHaveAll       MessageType = 14 // Peer has every piece, in place of a bitfield (BEP 6)
+	MsgHaveNone      MessageType = 15 // Peer has no pieces, in place of a bitfield (BEP 6)
+	MsgRejectRequest MessageType = 16 // Peer won't serve a block we requested (BEP 6)
+	MsgAllowedFast   MessageType = 17 // Peer will serve a piece even while choking us (BEP 6)
+	MsgExtended      MessageType = 20 // Extension protocol message (BEP 10)
 )
 
 // Message represents a peer wire protocol message with type and optional payload.
@@ -47,35 +83,107 @@ type Connection struct {
 	infoHash       [20]byte // Torrent we're downloading
 	peerID         [20]byte // Our client ID
 	remotePeerID   [20]byte // Remote peer's ID
-	choked         bool     // Are we choked by the peer?
-	choking        bool     // Are we choking the peer?
-	interested     bool     // Are we interested in the peer?
-	peerInterested bool     // Is the peer interested in us?
-	bitfield       []byte   // Peer's piece availability
+	reserved       [8]byte  // Reserved bytes we send in our handshake
+	remoteReserved [8]byte  // Reserved bytes from the remote handshake
+	messageLimit   uint32   // Largest message accepted whose size isn't otherwise known
+
+	// The peer's availability is updated by its message loop and read by
+	// whatever picks pieces or reports on peers
+	bitfield     []byte       // Peer's piece availability
+	numPieces    int          // Pieces in the torrent (0 if unknown)
+	haveAll      bool         // Peer sent have_all; bitfield is filled in once numPieces is known
+	availability sync.RWMutex // Protects bitfield, numPieces and haveAll
+
+	// Choke and interest state is set by whichever goroutine sends or
+	// receives the message, and read by the choker and the message loops
+	choked         atomic.Bool // Are we choked by the peer?
+	choking        atomic.Bool // Are we choking the peer?
+	interested     atomic.Bool // Are we interested in the peer?
+	peerInterested atomic.Bool // Is the peer interested in us?
+
+	remotePexID atomic.Int32 // Extended message ID the peer wants ut_pex sent with (0 if unsupported)
+	listenPort  atomic.Int32 // Port the peer accepts connections on, from its extended handshake (0 if unknown)
+
+	onPiece  func(pieceIndex, begin int, data []byte) error // Receives piece messages (see OnPiece)
+	onReject func(pieceIndex, begin, length int) error      // Receives reject_request messages (see OnReject)
+	onPort   func(port int)                                 // Receives the peer's DHT port (see OnPort)
+	onPex    func(added []*net.TCPAddr)                     // Receives peers from ut_pex messages (see OnPex)
+}
+
+// Options configures what a connection advertises to the peer and what it
+// accepts from it.
+type Options struct {
+	DHT              bool // Advertise our DHT node in the handshake (BEP 5); leave unset for private torrents
+	MaxMessageLength int  // Largest message accepted whose size isn't otherwise known, such as an extended message (0 means 128 KiB)
 }
 
 // NewConnection creates a new peer connection wrapper around an existing TCP connection.
 func NewConnection(conn net.Conn, infoHash, peerID [20]byte) *Connection {
-	return &Connection{
-		conn:     conn,
-		infoHash: infoHash,
-		peerID:   peerID,
-		choked:   true, // Start choked (peer won't send us data initially)
-		choking:  true, // Start choking (we won't send peer data initially)
+	return NewConnectionWithOptions(conn, infoHash, peerID, Options{})
+}
+
+// NewConnectionWithOptions is NewConnection with options.
+func NewConnectionWithOptions(conn net.Conn, infoHash, peerID [20]byte, options Options) *Connection {
+	c := &Connection{
+		conn:         conn,
+		infoHash:     infoHash,
+		peerID:       peerID,
+		reserved:     ourReserved,
+		messageLimit: maxMessageLength,
+	}
+	if options.DHT {
+		c.reserved[7] |= reservedDHT
+	}
+	if options.MaxMessageLength > 0 {
+		c.messageLimit = uint32(options.MaxMessageLength)
 	}
+	c.choked.Store(true)  // Start choked (peer won't send us data initially)
+	c.choking.Store(true) // Start choking (we won't send peer data initially)
+	return c
 }
 
 // Connect establishes a new TCP connection to a peer and performs the handshake.
 func Connect(addr string, infoHash, peerID [20]byte) (*Connection, error) {
-	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	return ConnectContext(context.Background(), addr, infoHash, peerID)
+}
+
+// ConnectContext is Connect, giving up as soon as ctx is cancelled.
+func ConnectContext(ctx context.Context, addr string, infoHash, peerID [20]byte) (*Connection, error) {
+	return ConnectContextWithOptions(ctx, addr, infoHash, peerID, Options{})
+}
+
+// ConnectContextWithOptions is ConnectContext with options.
+func ConnectContextWithOptions(ctx context.Context, addr string, infoHash, peerID [20]byte, options Options) (*Connection, error) {
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to peer: %w", err)
 	}
 
-	peerConn := NewConnection(conn, infoHash, peerID)
+	peerConn := NewConnectionWithOptions(conn, infoHash, peerID, options)
 
-	// Perform handshake to establish the protocol
+	// Perform handshake to establish the protocol; closing the connection
+	// is what unblocks it on cancellation
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
 	err = peerConn.performHandshake()
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+
+	return peerConn, nil
+}
+
+// ConnectOver performs the handshake over an already established
+// connection, such as one made through a proxy or one end of a net.Pipe,
+// as Connect does once it has dialed. conn is closed if the handshake fails.
+func ConnectOver(conn net.Conn, infoHash, peerID [20]byte) (*Connection, error) {
+	peerConn := NewConnection(conn, infoHash, peerID)
+
+	err := peerConn.performHandshake()
 	if err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("handshake failed: %w", err)
@@ -89,34 +197,91 @@ func Connect(addr string, infoHash, peerID [20]byte) (*Connection, error) {
 func (c *Connection) performHandshake() error {
 	// Create handshake
 	handshake := Handshake{
-		Protocol: "BitTorrent protocol",
+		Protocol: protocolName,
+		Reserved: c.reserved,
 		InfoHash: c.infoHash,
 		PeerID:   c.peerID,
 	}
 
 	// Send handshake
-	err := c.sendHandshake(handshake)
+	err := c.sendHandshake(handshake, handshakeTimeout)
 	if err != nil {
 		return fmt.Errorf("failed to send handshake: %w", err)
 	}
 
 	// Receive handshake
-	remoteHandshake, err := c.receiveHandshake()
+	remoteHandshake, err := c.receiveHandshake(handshakeTimeout)
 	if err != nil {
 		return fmt.Errorf("failed to receive handshake: %w", err)
 	}
 
 	// Verify handshake
 	if remoteHandshake.InfoHash != c.infoHash {
-		return fmt.Errorf("info hash mismatch")
+		return ErrInfoHashMismatch
+	}
+	if remoteHandshake.PeerID == c.peerID {
+		return ErrSelfConnection
 	}
 
 	c.remotePeerID = remoteHandshake.PeerID
+	c.remoteReserved = remoteHandshake.Reserved
 	return nil
 }
 
+// acceptHandshake executes the server side of the handshake for an inbound
+// connection: the remote peer speaks first, and we only answer once it has
+// named the torrent we're serving. Each half must finish within timeout.
+func (c *Connection) acceptHandshake(timeout time.Duration) error {
+	remoteHandshake, err := c.receiveHandshake(timeout)
+	if err != nil {
+		return err
+	}
+
+	if remoteHandshake.InfoHash != c.infoHash {
+		return ErrInfoHashMismatch
+	}
+	if remoteHandshake.PeerID == c.peerID {
+		return ErrSelfConnection
+	}
+
+	err = c.sendHandshake(Handshake{
+		Protocol: protocolName,
+		Reserved: c.reserved,
+		InfoHash: c.infoHash,
+		PeerID:   c.peerID,
+	}, timeout)
+	if err != nil {
+		return classifyHandshakeError(fmt.Errorf("failed to send handshake: %w", err))
+	}
+
+	c.remotePeerID = remoteHandshake.PeerID
+	c.remoteReserved = remoteHandshake.Reserved
+	return nil
+}
+
+// classifyHandshakeError wraps a low-level handshake error with the matching
+// sentinel error, leaving errors that are already classified untouched.
+func classifyHandshakeError(err error) error {
+	if errors.Is(err, ErrHandshakeTimeout) || errors.Is(err, ErrHandshakeTruncated) ||
+		errors.Is(err, ErrNotBitTorrent) || errors.Is(err, ErrInfoHashMismatch) ||
+		errors.Is(err, ErrSelfConnection) {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %v", ErrHandshakeTimeout, err)
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %v", ErrHandshakeTruncated, err)
+	}
+
+	return err
+}
+
 // sendHandshake constructs and sends a handshake message to the peer.
-func (c *Connection) sendHandshake(h Handshake) error {
+func (c *Connection) sendHandshake(h Handshake, timeout time.Duration) error {
 	// Protocol length + protocol + reserved + info hash + peer ID
 	buf := make([]byte, 1+len(h.Protocol)+8+20+20)
 
@@ -135,37 +300,41 @@ func (c *Connection) sendHandshake(h Handshake) error {
 
 	copy(buf[offset:], h.PeerID[:])
 
-	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
 	_, err := c.conn.Write(buf)
 	return err
 }
 
 // receiveHandshake reads and parses a handshake message from the peer.
-func (c *Connection) receiveHandshake() (*Handshake, error) {
-	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+// Errors are classified with the Err* handshake errors.
+func (c *Connection) receiveHandshake(timeout time.Duration) (*Handshake, error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
 
 	// Read protocol length
 	protocolLenBuf := make([]byte, 1)
 	_, err := io.ReadFull(c.conn, protocolLenBuf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read protocol length: %w", err)
+		return nil, classifyHandshakeError(fmt.Errorf("failed to read protocol length: %w", err))
 	}
 
 	protocolLen := int(protocolLenBuf[0])
-	if protocolLen != 19 {
-		return nil, fmt.Errorf("invalid protocol length: %d", protocolLen)
+	if protocolLen != len(protocolName) {
+		return nil, fmt.Errorf("%w: invalid protocol length: %d", ErrNotBitTorrent, protocolLen)
 	}
 
 	// Read rest of handshake
 	handshakeBuf := make([]byte, protocolLen+8+20+20)
 	_, err = io.ReadFull(c.conn, handshakeBuf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read handshake: %w", err)
+		return nil, classifyHandshakeError(fmt.Errorf("failed to read handshake: %w", err))
 	}
 
 	handshake := &Handshake{
 		Protocol: string(handshakeBuf[:protocolLen]),
 	}
+	if handshake.Protocol != protocolName {
+		return nil, fmt.Errorf("%w: unexpected protocol %q", ErrNotBitTorrent, handshake.Protocol)
+	}
 
 	offset := protocolLen
 	copy(handshake.Reserved[:], handshakeBuf[offset:offset+8])
@@ -181,20 +350,22 @@ func (c *Connection) receiveHandshake() (*Handshake, error) {
 
 // SendMessage sends a message to the peer
 func (c *Connection) SendMessage(msg Message) error {
-	var buf []byte
+	return c.write(appendMessage(nil, msg))
+}
 
+// appendMessage appends the wire encoding of msg to buf
+func appendMessage(buf []byte, msg Message) []byte {
 	if msg.Type == 255 { // Keep-alive
-		buf = make([]byte, 4)
-		binary.BigEndian.PutUint32(buf, 0)
-	} else {
-		payloadLen := len(msg.Payload)
-		buf = make([]byte, 4+1+payloadLen)
-
-		binary.BigEndian.PutUint32(buf[0:4], uint32(1+payloadLen))
-		buf[4] = byte(msg.Type)
-		copy(buf[5:], msg.Payload)
+		return binary.BigEndian.AppendUint32(buf, 0)
 	}
 
+	buf = binary.BigEndian.AppendUint32(buf, uint32(1+len(msg.Payload)))
+	buf = append(buf, byte(msg.Type))
+	return append(buf, msg.Payload...)
+}
+
+// write sends already-encoded messages in a single write
+func (c *Connection) write(buf []byte) error {
 	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 	_, err := c.conn.Write(buf)
 	return err
@@ -218,25 +389,113 @@ func (c *Connection) ReceiveMessage() (*Message, error) {
 		return &Message{Type: 255}, nil
 	}
 
-	if length > 1<<17 { // 128KB max message size
+	if length > c.maxMessageLength() {
 		return nil, fmt.Errorf("message too large: %d bytes", length)
 	}
 
-	// Read message type and payload
-	msgBuf := make([]byte, length)
-	_, err = io.ReadFull(c.conn, msgBuf)
+	// Read the type first so the payload length can be checked before we
+	// allocate for it
+	typeBuf := make([]byte, 1)
+	_, err = io.ReadFull(c.conn, typeBuf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message type: %w", err)
+	}
+
+	msgType := MessageType(typeBuf[0])
+	payloadLength := int(length) - 1
+	err = c.validatePayloadLength(msgType, payloadLength)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read payload
+	payload := make([]byte, payloadLength)
+	_, err = io.ReadFull(c.conn, payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read message: %w", err)
 	}
 
 	msg := &Message{
-		Type:    MessageType(msgBuf[0]),
-		Payload: msgBuf[1:],
+		Type:    msgType,
+		Payload: payload,
 	}
 
 	return msg, nil
 }
 
+// maxMessageLength returns the largest length prefix we accept from this peer.
+// A bitfield for a torrent with many pieces may legitimately exceed the
+// generic cap (see Options.MaxMessageLength), so the limit grows with the
+// piece count.
+func (c *Connection) maxMessageLength() uint32 {
+	limit := c.messageLimit
+	if bitfieldLength := uint32(1 + (c.pieceCount()+7)/8); bitfieldLength > limit {
+		limit = bitfieldLength
+	}
+	return limit
+}
+
+// validatePayloadLength rejects messages whose payload length can't be
+// correct for their type. Fixed-size messages must match exactly, a bitfield
+// must match our piece count (once known), and piece data can't exceed the
+// largest block we ever request.
+func (c *Connection) validatePayloadLength(msgType MessageType, length int) error {
+	valid := true
+
+	switch msgType {
+	case MsgChoke, MsgUnchoke, MsgInterested, MsgNotInterested:
+		valid = length == 0
+	case MsgHave:
+		valid = length == 4
+	case MsgBitfield:
+		if numPieces := c.pieceCount(); numPieces > 0 {
+			valid = length == (numPieces+7)/8
+		}
+	case MsgRequest, MsgCancel, MsgRejectRequest:
+		valid = length == 12
+	case MsgHaveAll, MsgHaveNone:
+		valid = length == 0
+	case MsgSuggestPiece, MsgAllowedFast:
+		valid = length == 4
+	case MsgPiece:
+		valid = length >= 8 && length <= 8+MaxBlockLength
+	case MsgPort:
+		valid = length == 2
+	}
+
+	if !valid {
+		return fmt.Errorf("invalid %s message length: %d", msgType, length)
+	}
+	return nil
+}
+
+// SetNumPieces tells the connection how many pieces the torrent has, which
+// is needed to validate the size of the peer's bitfield message.
+func (c *Connection) SetNumPieces(numPieces int) {
+	c.availability.Lock()
+	defer c.availability.Unlock()
+	c.numPieces = numPieces
+	if c.haveAll {
+		c.fillBitfield()
+	}
+}
+
+// pieceCount returns the piece count set with SetNumPieces (0 if unknown).
+func (c *Connection) pieceCount() int {
+	c.availability.RLock()
+	defer c.availability.RUnlock()
+	return c.numPieces
+}
+
+// fillBitfield sets every piece in the peer's bitfield, for have_all. The
+// caller must hold availability.
+func (c *Connection) fillBitfield() {
+	c.bitfield = make([]byte, (c.numPieces+7)/8)
+	for i := 0; i < c.numPieces; i++ {
+		c.bitfield[i/8] |= 0x80 >> uint(i%8)
+	}
+}
+
 // SendKeepAlive sends a keep-alive message
 func (c *Connection) SendKeepAlive() error {
 	return c.SendMessage(Message{Type: 255})
@@ -244,25 +503,25 @@ func (c *Connection) SendKeepAlive() error {
 
 // SendChoke sends a choke message
 func (c *Connection) SendChoke() error {
-	c.choking = true
+	c.choking.Store(true)
 	return c.SendMessage(Message{Type: MsgChoke})
 }
 
 // SendUnchoke sends an unchoke message
 func (c *Connection) SendUnchoke() error {
-	c.choking = false
+	c.choking.Store(false)
 	return c.SendMessage(Message{Type: MsgUnchoke})
 }
 
 // SendInterested sends an interested message
 func (c *Connection) SendInterested() error {
-	c.interested = true
+	c.interested.Store(true)
 	return c.SendMessage(Message{Type: MsgInterested})
 }
 
 // SendNotInterested sends a not interested message
 func (c *Connection) SendNotInterested() error {
-	c.interested = false
+	c.interested.Store(false)
 	return c.SendMessage(Message{Type: MsgNotInterested})
 }
 
@@ -280,11 +539,34 @@ func (c *Connection) SendBitfield(bitfield []byte) error {
 
 // SendRequest sends a request message
 func (c *Connection) SendRequest(pieceIndex, begin, length int) error {
+	return c.SendRequests([]Request{{PieceIndex: pieceIndex, Begin: begin, Length: length}})
+}
+
+// Request identifies a block to ask a peer for.
+type Request struct {
+	PieceIndex int // Piece containing the block
+	Begin      int // Byte offset within the piece
+	Length     int // Block length in bytes
+}
+
+// SendRequests sends a request message for each block in a single write, so
+// a burst of requests costs one syscall rather than one per block. On error
+// any prefix of the requests may have reached the peer.
+func (c *Connection) SendRequests(requests []Request) error {
+	if len(requests) == 0 {
+		return nil
+	}
+
+	buf := make([]byte, 0, len(requests)*(4+1+12))
 	payload := make([]byte, 12)
-	binary.BigEndian.PutUint32(payload[0:4], uint32(pieceIndex))
-	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
-	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
-	return c.SendMessage(Message{Type: MsgRequest, Payload: payload})
+	for _, req := range requests {
+		binary.BigEndian.PutUint32(payload[0:4], uint32(req.PieceIndex))
+		binary.BigEndian.PutUint32(payload[4:8], uint32(req.Begin))
+		binary.BigEndian.PutUint32(payload[8:12], uint32(req.Length))
+		buf = appendMessage(buf, Message{Type: MsgRequest, Payload: payload})
+	}
+
+	return c.write(buf)
 }
 
 // SendPiece sends a piece message
@@ -296,6 +578,36 @@ func (c *Connection) SendPiece(pieceIndex, begin int, data []byte) error {
 	return c.SendMessage(Message{Type: MsgPiece, Payload: payload})
 }
 
+// SendHaveAll tells a peer supporting the fast extension that we have every
+// piece, in place of a bitfield.
+func (c *Connection) SendHaveAll() error {
+	return c.SendMessage(Message{Type: MsgHaveAll})
+}
+
+// SendHaveNone tells a peer supporting the fast extension that we have no
+// pieces, in place of a bitfield.
+func (c *Connection) SendHaveNone() error {
+	return c.SendMessage(Message{Type: MsgHaveNone})
+}
+
+// SendRejectRequest tells a peer supporting the fast extension that a block
+// it requested won't be sent.
+func (c *Connection) SendRejectRequest(pieceIndex, begin, length int) error {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(pieceIndex))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+	return c.SendMessage(Message{Type: MsgRejectRequest, Payload: payload})
+}
+
+// SendPort tells a peer supporting DHT the UDP port our DHT node listens
+// on.
+func (c *Connection) SendPort(port int) error {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(port))
+	return c.SendMessage(Message{Type: MsgPort, Payload: payload})
+}
+
 // SendCancel sends a cancel message
 func (c *Connection) SendCancel(pieceIndex, begin, length int) error {
 	payload := make([]byte, 12)
@@ -307,15 +619,19 @@ func (c *Connection) SendCancel(pieceIndex, begin, length int) error {
 
 // HandleMessage processes a received message
 func (c *Connection) HandleMessage(msg *Message) error {
+	if isFastMessage(msg.Type) && !c.SupportsFast() {
+		return fmt.Errorf("received %s without negotiating the fast extension", msg.Type)
+	}
+
 	switch msg.Type {
 	case MsgChoke:
-		c.choked = true
+		c.choked.Store(true)
 	case MsgUnchoke:
-		c.choked = false
+		c.choked.Store(false)
 	case MsgInterested:
-		c.peerInterested = true
+		c.peerInterested.Store(true)
 	case MsgNotInterested:
-		c.peerInterested = false
+		c.peerInterested.Store(false)
 	case MsgHave:
 		if len(msg.Payload) != 4 {
 			return fmt.Errorf("invalid have message length: %d", len(msg.Payload))
@@ -323,8 +639,11 @@ func (c *Connection) HandleMessage(msg *Message) error {
 		pieceIndex := binary.BigEndian.Uint32(msg.Payload)
 		return c.handleHave(int(pieceIndex))
 	case MsgBitfield:
+		c.availability.Lock()
+		c.haveAll = false
 		c.bitfield = make([]byte, len(msg.Payload))
 		copy(c.bitfield, msg.Payload)
+		c.availability.Unlock()
 	case MsgRequest:
 		if len(msg.Payload) != 12 {
 			return fmt.Errorf("invalid request message length: %d", len(msg.Payload))
@@ -334,13 +653,13 @@ func (c *Connection) HandleMessage(msg *Message) error {
 		length := binary.BigEndian.Uint32(msg.Payload[8:12])
 		return c.handleRequest(int(pieceIndex), int(begin), int(length))
 	case MsgPiece:
-		if len(msg.Payload) < 8 {
-			return fmt.Errorf("invalid piece message length: %d", len(msg.Payload))
+		pieceIndex, begin, data, err := ParsePieceMessage(msg.Payload)
+		if err != nil {
+			return err
+		}
+		if c.onPiece != nil {
+			return c.onPiece(pieceIndex, begin, data)
 		}
-		pieceIndex := binary.BigEndian.Uint32(msg.Payload[0:4])
-		begin := binary.BigEndian.Uint32(msg.Payload[4:8])
-		data := msg.Payload[8:]
-		return c.handlePiece(int(pieceIndex), int(begin), data)
 	case MsgCancel:
 		if len(msg.Payload) != 12 {
 			return fmt.Errorf("invalid cancel message length: %d", len(msg.Payload))
@@ -349,6 +668,34 @@ func (c *Connection) HandleMessage(msg *Message) error {
 		begin := binary.BigEndian.Uint32(msg.Payload[4:8])
 		length := binary.BigEndian.Uint32(msg.Payload[8:12])
 		return c.handleCancel(int(pieceIndex), int(begin), int(length))
+	case MsgHaveAll:
+		// Before the piece count is known (e.g. while fetching metadata)
+		// the bitfield is left for SetNumPieces to fill in
+		c.availability.Lock()
+		c.haveAll = true
+		c.fillBitfield()
+		c.availability.Unlock()
+	case MsgHaveNone:
+		c.availability.Lock()
+		c.haveAll = false
+		c.bitfield = make([]byte, (c.numPieces+7)/8)
+		c.availability.Unlock()
+	case MsgRejectRequest:
+		pieceIndex := binary.BigEndian.Uint32(msg.Payload[0:4])
+		begin := binary.BigEndian.Uint32(msg.Payload[4:8])
+		length := binary.BigEndian.Uint32(msg.Payload[8:12])
+		if c.onReject != nil {
+			return c.onReject(int(pieceIndex), int(begin), int(length))
+		}
+	case MsgExtended:
+		return c.handleExtended(msg.Payload)
+	case MsgPort:
+		if c.onPort != nil {
+			c.onPort(int(binary.BigEndian.Uint16(msg.Payload)))
+		}
+	case MsgSuggestPiece, MsgAllowedFast:
+		// Advisory only: we pick pieces ourselves, and don't request
+		// while choked
 	case 255: // Keep-alive
 		// Do nothing for keep-alive
 	default:
@@ -359,6 +706,13 @@ func (c *Connection) HandleMessage(msg *Message) error {
 
 // handleHave handles a have message
 func (c *Connection) handleHave(pieceIndex int) error {
+	c.availability.Lock()
+	defer c.availability.Unlock()
+
+	if c.numPieces > 0 && pieceIndex >= c.numPieces {
+		return fmt.Errorf("have for piece %d out of range", pieceIndex)
+	}
+
 	// Expand bitfield if necessary
 	byteIndex := pieceIndex / 8
 	if byteIndex >= len(c.bitfield) {
@@ -375,17 +729,59 @@ func (c *Connection) handleHave(pieceIndex int) error {
 }
 
 // handleRequest processes a piece request from the peer.
-// In this simplified client, we don't serve pieces to others (download-only).
+// Requests are served by the download manager (or a Seeder), which knows
+// which pieces we have and where to read them from.
 func (c *Connection) handleRequest(_, _, _ int) error {
-	// Download-only client - we don't serve pieces
 	return nil
 }
 
-// handlePiece processes incoming piece data.
-// The actual piece storage is handled by the download manager.
-func (c *Connection) handlePiece(_, _ int, _ []byte) error {
-	// Piece handling is done by the download manager
-	return nil
+// ParsePieceMessage splits a piece message payload into the piece index,
+// the block's offset within the piece and the block data. The data shares
+// the payload's memory.
+func ParsePieceMessage(payload []byte) (int, int, []byte, error) {
+	if len(payload) < 8 {
+		return 0, 0, nil, fmt.Errorf("invalid piece message length: %d", len(payload))
+	}
+	pieceIndex := binary.BigEndian.Uint32(payload[0:4])
+	begin := binary.BigEndian.Uint32(payload[4:8])
+	return int(pieceIndex), int(begin), payload[8:], nil
+}
+
+// OnPiece makes HandleMessage pass the blocks of piece messages to handler,
+// whose error HandleMessage returns. Without one, blocks are dropped.
+func (c *Connection) OnPiece(handler func(pieceIndex, begin int, data []byte) error) {
+	c.onPiece = handler
+}
+
+// OnReject makes HandleMessage pass the blocks named in reject_request
+// messages to handler, whose error HandleMessage returns, so they can be
+// requested elsewhere straight away.
+func (c *Connection) OnReject(handler func(pieceIndex, begin, length int) error) {
+	c.onReject = handler
+}
+
+// OnPort makes HandleMessage pass the UDP port from port messages to
+// handler: the peer's DHT node listens there, at the peer's IP address.
+// Without one, port messages are ignored.
+func (c *Connection) OnPort(handler func(port int)) {
+	c.onPort = handler
+}
+
+// SupportsDHT reports whether the peer advertised DHT (BEP 5), and so
+// expects a port message telling it where our DHT node listens.
+func (c *Connection) SupportsDHT() bool {
+	return c.remoteReserved[7]&reservedDHT != 0
+}
+
+// SupportsFast reports whether both sides advertised the fast extension
+// (BEP 6), which allows its messages on this connection.
+func (c *Connection) SupportsFast() bool {
+	return c.reserved[7]&reservedFast != 0 && c.remoteReserved[7]&reservedFast != 0
+}
+
+// isFastMessage reports whether a message type belongs to the fast extension.
+func isFastMessage(m MessageType) bool {
+	return m >= MsgSuggestPiece && m <= MsgAllowedFast
 }
 
 // handleCancel processes a request cancellation from the peer.
@@ -396,6 +792,12 @@ func (c *Connection) handleCancel(_, _, _ int) error {
 
 // HasPiece returns true if the peer has the specified piece
 func (c *Connection) HasPiece(pieceIndex int) bool {
+	c.availability.RLock()
+	defer c.availability.RUnlock()
+
+	if c.haveAll && c.numPieces == 0 {
+		return true
+	}
 	if c.bitfield == nil {
 		return false
 	}
@@ -411,26 +813,29 @@ func (c *Connection) HasPiece(pieceIndex int) bool {
 
 // IsChoked returns true if this client is choked by the peer
 func (c *Connection) IsChoked() bool {
-	return c.choked
+	return c.choked.Load()
 }
 
 // IsChoking returns true if this client is choking the peer
 func (c *Connection) IsChoking() bool {
-	return c.choking
+	return c.choking.Load()
 }
 
 // IsInterested returns true if this client is interested in the peer
 func (c *Connection) IsInterested() bool {
-	return c.interested
+	return c.interested.Load()
 }
 
 // IsPeerInterested returns true if the peer is interested in this client
 func (c *Connection) IsPeerInterested() bool {
-	return c.peerInterested
+	return c.peerInterested.Load()
 }
 
 // GetBitfield returns the peer's bitfield
 func (c *Connection) GetBitfield() []byte {
+	c.availability.RLock()
+	defer c.availability.RUnlock()
+
 	if c.bitfield == nil {
 		return nil
 	}
@@ -445,6 +850,26 @@ func (c *Connection) GetRemotePeerID() [20]byte {
 	return c.remotePeerID
 }
 
+// GetRemoteReserved returns the reserved bytes from the remote handshake
+func (c *Connection) GetRemoteReserved() [8]byte {
+	return c.remoteReserved
+}
+
+// Capabilities returns the extensions the remote peer advertised
+func (c *Connection) Capabilities() []string {
+	return Capabilities(c.remoteReserved)
+}
+
+// ClientName returns the client name decoded from the remote peer's ID
+func (c *Connection) ClientName() string {
+	return ClientName(c.remotePeerID)
+}
+
+// RemoteAddr returns the remote peer's network address
+func (c *Connection) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
+
 // Close closes the connection
 func (c *Connection) Close() error {
 	return c.conn.Close()
@@ -473,6 +898,18 @@ func (m MessageType) String() string {
 		return "cancel"
 	case MsgPort:
 		return "port"
+	case MsgSuggestPiece:
+		return "suggest_piece"
+	case MsgHaveAll:
+		return "have_all"
+	case MsgHaveNone:
+		return "have_none"
+	case MsgRejectRequest:
+		return "reject_request"
+	case MsgAllowedFast:
+		return "allowed_fast"
+	case MsgExtended:
+		return "extended"
 	default:
 		if m == 255 {
 			return "keep_alive"
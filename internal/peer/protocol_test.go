@@ -0,0 +1,222 @@
+package peer
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// recordConn is a net.Conn that records every write instead of sending it.
+type recordConn struct {
+	net.Conn          // Unset: only writes are supported
+	writes   [][]byte // Each Write's data, in order
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *recordConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// readConnection returns a Connection reading data, as if a peer had sent it.
+func readConnection(t testing.TB, data []byte) *Connection {
+	return readConnectionWithOptions(t, data, Options{})
+}
+
+// readConnectionWithOptions is readConnection with options.
+func readConnectionWithOptions(t testing.TB, data []byte, options Options) *Connection {
+	t.Helper()
+	ours, theirs := net.Pipe()
+	t.Cleanup(func() { ours.Close() })
+	go func() {
+		theirs.Write(data)
+		theirs.Close()
+	}()
+	return NewConnectionWithOptions(ours, [20]byte{}, [20]byte{}, options)
+}
+
+// testRequests returns n requests for consecutive blocks
+func testRequests(n int) []Request {
+	requests := make([]Request, n)
+	for i := range requests {
+		requests[i] = Request{PieceIndex: i / 16, Begin: i % 16 * MaxBlockLength, Length: MaxBlockLength}
+	}
+	return requests
+}
+
+func TestSendRequestsParsesBack(t *testing.T) {
+	requests := testRequests(40)
+	requests[len(requests)-1].Length = 1234 // A short last block
+
+	rec := &recordConn{}
+	conn := NewConnection(rec, [20]byte{}, [20]byte{})
+	if err := conn.SendRequests(requests); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.writes) != 1 {
+		t.Fatalf("%d writes for a batch of requests, want 1", len(rec.writes))
+	}
+
+	// The batch reads back as the individual request messages, in order
+	reader := readConnection(t, rec.writes[0])
+	for i, want := range requests {
+		msg, err := reader.ReceiveMessage()
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if msg.Type != MsgRequest {
+			t.Fatalf("message %d is a %s, want a request", i, msg.Type)
+		}
+		got := Request{
+			PieceIndex: int(binary.BigEndian.Uint32(msg.Payload[0:4])),
+			Begin:      int(binary.BigEndian.Uint32(msg.Payload[4:8])),
+			Length:     int(binary.BigEndian.Uint32(msg.Payload[8:12])),
+		}
+		if got != want {
+			t.Errorf("request %d is %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := reader.ReceiveMessage(); err == nil {
+		t.Error("more messages than requests sent")
+	}
+
+	// Each request on its own encodes the same bytes
+	single := &recordConn{}
+	conn = NewConnection(single, [20]byte{}, [20]byte{})
+	for _, req := range requests {
+		if err := conn.SendRequest(req.PieceIndex, req.Begin, req.Length); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var joined []byte
+	for _, w := range single.writes {
+		joined = append(joined, w...)
+	}
+	if string(joined) != string(rec.writes[0]) {
+		t.Error("batched requests encode differently from separate ones")
+	}
+}
+
+func TestSendRequestsEmpty(t *testing.T) {
+	rec := &recordConn{}
+	if err := NewConnection(rec, [20]byte{}, [20]byte{}).SendRequests(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.writes) != 0 {
+		t.Errorf("%d writes for no requests", len(rec.writes))
+	}
+}
+
+func TestMessageLengthBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		msgType   MessageType
+		length    int     // Payload length
+		numPieces int     // Set with SetNumPieces, if not 0
+		options   Options // Connection options
+		valid     bool
+	}{
+		{"bitfield", MsgBitfield, 3, 20, Options{}, true},
+		{"short bitfield", MsgBitfield, 2, 20, Options{}, false},
+		{"long bitfield", MsgBitfield, 4, 20, Options{}, false},
+		{"bitfield before piece count", MsgBitfield, 100, 0, Options{}, true},
+		{"bitfield before piece count over cap", MsgBitfield, maxMessageLength, 0, Options{}, false},
+		{"bitfield over cap", MsgBitfield, 2 * maxMessageLength, 16 * maxMessageLength, Options{}, true},
+		{"piece", MsgPiece, 8 + MaxBlockLength, 0, Options{}, true},
+		{"short piece", MsgPiece, 8 + 1, 0, Options{}, true},
+		{"piece without header", MsgPiece, 7, 0, Options{}, false},
+		{"piece over a block", MsgPiece, 8 + MaxBlockLength + 1, 0, Options{}, false},
+		{"request", MsgRequest, 12, 0, Options{}, true},
+		{"short request", MsgRequest, 11, 0, Options{}, false},
+		{"long request", MsgRequest, 13, 0, Options{}, false},
+		{"extended", MsgExtended, maxMessageLength - 1, 0, Options{}, true},
+		{"extended over cap", MsgExtended, maxMessageLength, 0, Options{}, false},
+		{"extended under raised cap", MsgExtended, 4 * maxMessageLength, 0, Options{MaxMessageLength: 1 << 20}, true},
+		{"extended over lowered cap", MsgExtended, 1024, 0, Options{MaxMessageLength: 1024}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := binary.BigEndian.AppendUint32(nil, uint32(1+tt.length))
+			data = append(data, byte(tt.msgType))
+			data = append(data, make([]byte, tt.length)...)
+
+			conn := readConnectionWithOptions(t, data, tt.options)
+			if tt.numPieces > 0 {
+				conn.SetNumPieces(tt.numPieces)
+			}
+			msg, err := conn.ReceiveMessage()
+			if tt.valid && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("accepted")
+			}
+			if tt.valid && (msg.Type != tt.msgType || len(msg.Payload) != tt.length) {
+				t.Errorf("read a %s with %d bytes, want a %s with %d", msg.Type, len(msg.Payload), tt.msgType, tt.length)
+			}
+		})
+	}
+}
+
+// loopback returns a Connection over TCP to a peer that discards everything
+// it's sent, so that each write costs a real system call.
+func loopback(b *testing.B) *Connection {
+	b.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	return NewConnection(conn, [20]byte{}, [20]byte{})
+}
+
+// A typical pipeline refill: a peer's request queue topped up by 16 blocks
+const benchRequests = 16
+
+func BenchmarkSendRequestsBatched(b *testing.B) {
+	conn := loopback(b)
+	requests := testRequests(benchRequests)
+	b.SetBytes(benchRequests * 17)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := conn.SendRequests(requests); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendRequestsSeparately(b *testing.B) {
+	conn := loopback(b)
+	requests := testRequests(benchRequests)
+	b.SetBytes(benchRequests * 17)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, req := range requests {
+			if err := conn.SendRequest(req.PieceIndex, req.Begin, req.Length); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
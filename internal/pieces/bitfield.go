@@ -2,6 +2,7 @@ package pieces
 
 import (
 	"fmt"
+	"math/bits"
 )
 
 // Bitfield represents a bitfield for tracking pieces
@@ -61,6 +62,91 @@ func (bf *Bitfield) ClearPiece(pieceIndex int) error {
 	return nil
 }
 
+// SetAll marks every piece as available, e.g. for a have_all message or
+// when seeding. Bits past the last piece in the trailing byte stay zero.
+func (bf *Bitfield) SetAll() {
+	numBytes := (bf.size + 7) / 8
+	for i := range bf.data {
+		if i < numBytes {
+			bf.data[i] = 0xFF
+		} else {
+			bf.data[i] = 0
+		}
+	}
+	bf.clearTrailingBits()
+}
+
+// ClearAll marks every piece as unavailable
+func (bf *Bitfield) ClearAll() {
+	for i := range bf.data {
+		bf.data[i] = 0
+	}
+}
+
+// SetRange marks pieces in the half-open range [start, end) as available
+func (bf *Bitfield) SetRange(start, end int) error {
+	if err := bf.checkRange(start, end); err != nil {
+		return err
+	}
+
+	for i := start; i < end; {
+		byteIndex := i / 8
+		bitIndex := i % 8
+
+		// Fill whole bytes directly once we're byte-aligned
+		if bitIndex == 0 && end-i >= 8 {
+			bf.data[byteIndex] = 0xFF
+			i += 8
+			continue
+		}
+
+		bf.data[byteIndex] |= 0x80 >> uint(bitIndex)
+		i++
+	}
+	return nil
+}
+
+// CountRange returns how many pieces in the half-open range [start, end) are available
+func (bf *Bitfield) CountRange(start, end int) (int, error) {
+	if err := bf.checkRange(start, end); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := start; i < end; {
+		byteIndex := i / 8
+		bitIndex := i % 8
+
+		// Count whole bytes at once once we're byte-aligned
+		if bitIndex == 0 && end-i >= 8 {
+			count += bits.OnesCount8(bf.data[byteIndex])
+			i += 8
+			continue
+		}
+
+		if bf.data[byteIndex]&(0x80>>uint(bitIndex)) != 0 {
+			count++
+		}
+		i++
+	}
+	return count, nil
+}
+
+// checkRange validates a half-open piece range [start, end)
+func (bf *Bitfield) checkRange(start, end int) error {
+	if start < 0 || end > bf.size || start > end {
+		return fmt.Errorf("piece range [%d, %d) out of range [0, %d)", start, end, bf.size)
+	}
+	return nil
+}
+
+// clearTrailingBits zeroes the unused bits after the last piece
+func (bf *Bitfield) clearTrailingBits() {
+	if extra := bf.size % 8; extra != 0 && len(bf.data) > 0 {
+		bf.data[bf.size/8] &= 0xFF << uint(8-extra)
+	}
+}
+
 // HasPiece returns true if the piece is available
 func (bf *Bitfield) HasPiece(pieceIndex int) bool {
 	if pieceIndex < 0 || pieceIndex >= bf.size {
@@ -0,0 +1,122 @@
+package pieces
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetAll(t *testing.T) {
+	tests := []struct {
+		numPieces int
+		want      []byte
+	}{
+		{0, []byte{}},
+		{1, []byte{0x80}},
+		{8, []byte{0xFF}},
+		{10, []byte{0xFF, 0xC0}},
+		{15, []byte{0xFF, 0xFE}},
+		{17, []byte{0xFF, 0xFF, 0x80}},
+	}
+
+	for _, tt := range tests {
+		bf := NewBitfield(tt.numPieces)
+		bf.SetAll()
+		// Bits past the last piece in the trailing byte stay zero
+		if got := bf.ToBytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%d pieces: SetAll = %08b, want %08b", tt.numPieces, got, tt.want)
+		}
+		if !bf.IsComplete() || bf.GetNumCompletePieces() != tt.numPieces {
+			t.Errorf("%d pieces: %d complete after SetAll", tt.numPieces, bf.GetNumCompletePieces())
+		}
+
+		bf.ClearAll()
+		if bf.GetNumCompletePieces() != 0 {
+			t.Errorf("%d pieces: %d complete after ClearAll", tt.numPieces, bf.GetNumCompletePieces())
+		}
+	}
+}
+
+func TestSetAllMasksTrailingBits(t *testing.T) {
+	// A peer's bitfield may arrive with spare bits set; SetAll clears them
+	bf := NewBitfieldFromBytes([]byte{0x00, 0x3F}, 10)
+	bf.SetAll()
+	if got := bf.ToBytes(); !bytes.Equal(got, []byte{0xFF, 0xC0}) {
+		t.Errorf("SetAll = %08b, want [11111111 11000000]", got)
+	}
+}
+
+func TestSetRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		numPieces  int
+		start, end int
+		want       []byte
+	}{
+		{"empty", 10, 4, 4, []byte{0x00, 0x00}},
+		{"within a byte", 10, 1, 4, []byte{0x70, 0x00}},
+		{"across bytes", 20, 6, 18, []byte{0x03, 0xFF, 0xC0}},
+		{"whole bytes", 24, 8, 16, []byte{0x00, 0xFF, 0x00}},
+		{"to the end", 10, 3, 10, []byte{0x1F, 0xC0}},
+		{"everything", 17, 0, 17, []byte{0xFF, 0xFF, 0x80}},
+	}
+
+	for _, tt := range tests {
+		bf := NewBitfield(tt.numPieces)
+		if err := bf.SetRange(tt.start, tt.end); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := bf.ToBytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: SetRange(%d, %d) = %08b, want %08b", tt.name, tt.start, tt.end, got, tt.want)
+		}
+		if count, _ := bf.CountRange(0, tt.numPieces); count != tt.end-tt.start {
+			t.Errorf("%s: CountRange = %d after SetRange, want %d", tt.name, count, tt.end-tt.start)
+		}
+	}
+}
+
+func TestCountRange(t *testing.T) {
+	// Pieces 0-7 and 9 of 20; the spare trailing bits are set, as a peer's
+	// bitfield might have them
+	bf := NewBitfieldFromBytes([]byte{0xFF, 0x40, 0x0F}, 20)
+
+	tests := []struct {
+		start, end int
+		want       int
+	}{
+		{0, 0, 0},
+		{0, 8, 8},
+		{0, 20, 9},
+		{2, 10, 7},
+		{8, 20, 1},
+		{10, 20, 0},
+		{16, 20, 0},
+	}
+
+	for _, tt := range tests {
+		got, err := bf.CountRange(tt.start, tt.end)
+		if err != nil {
+			t.Errorf("CountRange(%d, %d): %v", tt.start, tt.end, err)
+		} else if got != tt.want {
+			t.Errorf("CountRange(%d, %d) = %d, want %d", tt.start, tt.end, got, tt.want)
+		}
+	}
+	if bf.GetNumCompletePieces() != 9 {
+		t.Errorf("%d complete pieces, want 9", bf.GetNumCompletePieces())
+	}
+}
+
+func TestRangeBounds(t *testing.T) {
+	bf := NewBitfield(10)
+	for _, r := range [][2]int{{-1, 5}, {0, 11}, {6, 5}, {10, 11}} {
+		if err := bf.SetRange(r[0], r[1]); err == nil {
+			t.Errorf("SetRange(%d, %d) succeeded", r[0], r[1])
+		}
+		if _, err := bf.CountRange(r[0], r[1]); err == nil {
+			t.Errorf("CountRange(%d, %d) succeeded", r[0], r[1])
+		}
+	}
+	if bf.GetNumCompletePieces() != 0 {
+		t.Error("failed SetRange marked pieces")
+	}
+}
@@ -3,27 +3,42 @@ package pieces
 import (
 	"crypto/sha1"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
 )
 
 const (
 	// BlockSize is the standard block size for BitTorrent (16KB).
 	// Pieces are downloaded in these smaller blocks for efficient transfer.
 	BlockSize = 16384
+
+	// avoidPeerTimeout is how long a re-requested block prefers peers other
+	// than the one suspected of sending corrupt data. After it expires any
+	// peer may fetch the block again, so a lone peer can't stall the piece.
+	avoidPeerTimeout = 30 * time.Second
 )
 
 // PieceManager coordinates piece downloads and verification.
 // It tracks which pieces are complete, in progress, or missing.
 type PieceManager struct {
-	mutex          sync.RWMutex        // Protects concurrent access
-	pieceLength    int                 // Size of each piece (except possibly the last)
-	totalLength    int64               // Total torrent size
-	pieceHashes    [][20]byte          // Expected SHA1 hash for each piece
-	numPieces      int                 // Total number of pieces
-	bitfield       *Bitfield           // Tracks completed pieces
-	pendingPieces  map[int]*PieceState // Pieces currently being downloaded
-	completePieces map[int][]byte      // Completed piece data
-	quiet          bool                // Suppress stdout output
+	mutex         sync.RWMutex        // Protects concurrent access
+	pieceLength   int                 // Size of each piece (except possibly the last)
+	totalLength   int64               // Total torrent size
+	pieceHashes   [][20]byte          // Expected SHA1 hash for each piece
+	numPieces     int                 // Total number of pieces
+	bitfield      *Bitfield           // Tracks completed pieces
+	wanted        *Bitfield           // Pieces to download; nil means all of them
+	pendingPieces map[int]*PieceState // Pieces currently being downloaded
+	storage       PieceStore          // Where verified pieces are written (nil discards them)
+	peerFailures  map[string]int      // Failed verifications each peer contributed to
+	verifier      *Verifier           // Bounds concurrent hash checks
+	spreadBlocks  bool                // Start each peer at its own block offset within a piece
+	quiet         bool                // Suppress stdout output
 }
 
 // PieceState tracks the download progress of a single piece.
@@ -34,6 +49,30 @@ type PieceState struct {
 	Downloaded int            // Bytes downloaded so far
 	Blocks     map[int][]byte // Downloaded blocks (offset -> data)
 	Requested  map[int]bool   // Requested blocks (offset -> requested)
+	Owners     map[int]string // Peer each requested block was handed to (offset -> peer address)
+	Sources    map[int]string // Peer that supplied each block (offset -> peer address)
+	Avoid      map[int]string // Peer to avoid when re-requesting a block (offset -> peer address)
+	AvoidUntil time.Time      // When the Avoid preferences expire
+	Failures   int            // Number of failed verification attempts
+	Verifying  bool           // All blocks are in and the hash is being checked
+	Hashed     int            // Bytes from the start of the piece fed to hasher so far (guarded by hashMutex)
+
+	hasher    hash.Hash  // SHA1 of the piece's first Hashed bytes (see advanceHash)
+	hashMutex sync.Mutex // Serializes use of hasher; taken before, never under, PieceManager.mutex
+}
+
+// PieceStore is where verified pieces are written, e.g. a FileStorage.
+type PieceStore interface {
+	WritePiece(pieceIndex int, data []byte) error
+	ReadPiece(pieceIndex int) ([]byte, error)
+	MarkPieceVerified(pieceIndex int) error
+}
+
+// BlockWriter is implemented by stores that can write part of a piece (e.g.
+// a FileStorage). Verified pieces are then written block by block, rather
+// than assembled into one buffer first.
+type BlockWriter interface {
+	WriteBlock(pieceIndex, begin int, data []byte) error
 }
 
 // BlockRequest represents a request for a specific block of data.
@@ -53,17 +92,99 @@ func NewPieceManagerWithOptions(pieceLength int, totalLength int64, pieceHashes
 	numPieces := len(pieceHashes)
 
 	return &PieceManager{
-		pieceLength:    pieceLength,
-		totalLength:    totalLength,
-		pieceHashes:    pieceHashes,
-		numPieces:      numPieces,
-		bitfield:       NewBitfield(numPieces),
-		pendingPieces:  make(map[int]*PieceState),
-		completePieces: make(map[int][]byte),
-		quiet:          quiet,
+		pieceLength:   pieceLength,
+		totalLength:   totalLength,
+		pieceHashes:   pieceHashes,
+		numPieces:     numPieces,
+		bitfield:      NewBitfield(numPieces),
+		pendingPieces: make(map[int]*PieceState),
+		peerFailures:  make(map[string]int),
+		verifier:      NewVerifier(0),
+		quiet:         quiet,
 	}
 }
 
+// SetVerifier replaces the verifier used to hash completed pieces, e.g. to
+// share one concurrency limit with the on-disk check.
+func (pm *PieceManager) SetVerifier(verifier *Verifier) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	pm.verifier = verifier
+}
+
+// SetStorage writes each piece to store as soon as it is verified, so that
+// progress survives a restart. Completed pieces are never held in memory;
+// without storage they are verified and then discarded.
+func (pm *PieceManager) SetStorage(store PieceStore) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	pm.storage = store
+}
+
+// SetCompleted marks the pieces set in have as complete without downloading
+// them, e.g. those already verified on disk when a download is resumed.
+func (pm *PieceManager) SetCompleted(have *Bitfield) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	for i := 0; i < pm.numPieces && i < have.GetNumPieces(); i++ {
+		if have.HasPiece(i) {
+			pm.bitfield.SetPiece(i)
+			delete(pm.pendingPieces, i)
+		}
+	}
+}
+
+// SetSpreadBlocks makes each peer start requesting a piece's blocks at an
+// offset of its own (derived from its address), wrapping around, instead of
+// always from the first block. Peers sharing a piece then work on different
+// parts of it rather than all competing for its first blocks.
+func (pm *PieceManager) SetSpreadBlocks(enabled bool) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	pm.spreadBlocks = enabled
+}
+
+// firstBlock returns the index of the block a peer starts scanning a piece
+// from: always 0 unless blocks are spread across peers.
+func (pm *PieceManager) firstBlock(pieceIndex int, peerAddr string, numBlocks int) int {
+	if !pm.spreadBlocks || peerAddr == "" || numBlocks <= 1 {
+		return 0
+	}
+
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%s/%d", peerAddr, pieceIndex)
+	return int(hash.Sum32() % uint32(numBlocks))
+}
+
+// SetWantedPieces limits downloading to the pieces set in wanted, for
+// selective downloads. IsComplete, GetMissingPieces and GetProgress then only
+// consider those pieces; GetBitfield still reports every piece we have, for
+// seeding. A nil bitfield makes every piece wanted again.
+func (pm *PieceManager) SetWantedPieces(wanted *Bitfield) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if wanted == nil {
+		pm.wanted = nil
+		return nil
+	}
+	if wanted.GetNumPieces() != pm.numPieces {
+		return fmt.Errorf("wanted bitfield has %d pieces, expected %d", wanted.GetNumPieces(), pm.numPieces)
+	}
+
+	pm.wanted = wanted.Clone()
+	return nil
+}
+
+// isWanted reports whether a piece is part of the download
+func (pm *PieceManager) isWanted(pieceIndex int) bool {
+	return pm.wanted == nil || pm.wanted.HasPiece(pieceIndex)
+}
+
 // GetBitfield returns a copy of the current bitfield
 func (pm *PieceManager) GetBitfield() *Bitfield {
 	pm.mutex.RLock()
@@ -82,20 +203,7 @@ func (pm *PieceManager) HasPiece(pieceIndex int) bool {
 
 // GetPieceLength returns the length of a specific piece
 func (pm *PieceManager) GetPieceLength(pieceIndex int) int {
-	if pieceIndex < 0 || pieceIndex >= pm.numPieces {
-		return 0
-	}
-
-	if pieceIndex == pm.numPieces-1 {
-		// Last piece might be shorter
-		lastPieceLength := int(pm.totalLength % int64(pm.pieceLength))
-		if lastPieceLength == 0 {
-			return pm.pieceLength
-		}
-		return lastPieceLength
-	}
-
-	return pm.pieceLength
+	return int(torrent.PieceLength(pieceIndex, pm.numPieces, int64(pm.pieceLength), pm.totalLength))
 }
 
 // StartPiece begins downloading a piece
@@ -124,6 +232,10 @@ func (pm *PieceManager) StartPiece(pieceIndex int) error {
 		Downloaded: 0,
 		Blocks:     make(map[int][]byte),
 		Requested:  make(map[int]bool),
+		Owners:     make(map[int]string),
+		Sources:    make(map[int]string),
+		Avoid:      make(map[int]string),
+		hasher:     sha1.New(),
 	}
 
 	return nil
@@ -131,6 +243,15 @@ func (pm *PieceManager) StartPiece(pieceIndex int) error {
 
 // GetNextBlockRequest returns the next block request for a piece
 func (pm *PieceManager) GetNextBlockRequest(pieceIndex int) (*BlockRequest, error) {
+	return pm.GetNextBlockRequestForPeer(pieceIndex, "")
+}
+
+// GetNextBlockRequestForPeer returns the next block request for a piece on
+// behalf of the given peer, which owns the block until it arrives or is
+// released (see ReleasePeerBlocks). Blocks that were discarded after a failed
+// verification are not handed back to the peer suspected of corrupting them
+// until the avoid timeout expires.
+func (pm *PieceManager) GetNextBlockRequestForPeer(pieceIndex int, peerAddr string) (*BlockRequest, error) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
@@ -140,7 +261,10 @@ func (pm *PieceManager) GetNextBlockRequest(pieceIndex int) (*BlockRequest, erro
 	}
 
 	// Find the next unrequested block
-	for offset := 0; offset < piece.Length; offset += BlockSize {
+	numBlocks := (piece.Length + BlockSize - 1) / BlockSize
+	first := pm.firstBlock(pieceIndex, peerAddr, numBlocks)
+	for i := 0; i < numBlocks; i++ {
+		offset := (first + i) % numBlocks * BlockSize
 		if piece.Requested[offset] {
 			continue
 		}
@@ -149,12 +273,19 @@ func (pm *PieceManager) GetNextBlockRequest(pieceIndex int) (*BlockRequest, erro
 			continue
 		}
 
+		if peerAddr != "" && piece.Avoid[offset] == peerAddr && time.Now().Before(piece.AvoidUntil) {
+			continue
+		}
+
 		blockLength := BlockSize
 		if offset+blockLength > piece.Length {
 			blockLength = piece.Length - offset
 		}
 
 		piece.Requested[offset] = true
+		if peerAddr != "" {
+			piece.Owners[offset] = peerAddr
+		}
 
 		return &BlockRequest{
 			PieceIndex: pieceIndex,
@@ -168,33 +299,66 @@ func (pm *PieceManager) GetNextBlockRequest(pieceIndex int) (*BlockRequest, erro
 
 // AddBlock adds a block to a piece being downloaded
 func (pm *PieceManager) AddBlock(pieceIndex, begin int, data []byte) error {
+	return pm.AddBlockFromPeer(pieceIndex, begin, data, "")
+}
+
+// AddBlockFromPeer adds a block to a piece being downloaded and records which
+// peer supplied it, so a failed verification can be traced back to its source.
+func (pm *PieceManager) AddBlockFromPeer(pieceIndex, begin int, data []byte, peerAddr string) error {
 	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
+	piece, complete, err := pm.addBlock(pieceIndex, begin, data, peerAddr)
+	pm.mutex.Unlock()
+
+	if err != nil || piece == nil {
+		return err
+	}
+	if complete {
+		return pm.completePiece(piece)
+	}
+	pm.advanceHash(piece)
+	return nil
+}
+
+// addBlock stores a block, returning the piece it belongs to. If it was the
+// piece's last missing block, the piece is marked Verifying and complete is
+// set, for completePiece. Blocks we already have are ignored, and give a
+// nil piece: in endgame the same block is requested from several peers, and
+// more than one may deliver it. The caller must hold the write lock.
+func (pm *PieceManager) addBlock(pieceIndex, begin int, data []byte, peerAddr string) (*PieceState, bool, error) {
+	if pieceIndex >= 0 && pieceIndex < pm.numPieces && pm.bitfield.HasPiece(pieceIndex) {
+		return nil, false, nil
+	}
 
 	piece, exists := pm.pendingPieces[pieceIndex]
 	if !exists {
-		return fmt.Errorf("piece %d not in progress", pieceIndex)
+		return nil, false, fmt.Errorf("piece %d not in progress", pieceIndex)
+	}
+
+	if _, hasBlock := piece.Blocks[begin]; hasBlock || piece.Verifying {
+		return nil, false, nil
 	}
 
 	if begin < 0 || begin >= piece.Length {
-		return fmt.Errorf("invalid block offset %d for piece %d", begin, pieceIndex)
+		return nil, false, fmt.Errorf("invalid block offset %d for piece %d", begin, pieceIndex)
 	}
 
 	if begin+len(data) > piece.Length {
-		return fmt.Errorf("block extends beyond piece boundary")
+		return nil, false, fmt.Errorf("block extends beyond piece boundary")
 	}
 
 	// Store the block
 	piece.Blocks[begin] = make([]byte, len(data))
 	copy(piece.Blocks[begin], data)
+	piece.Sources[begin] = peerAddr
 	piece.Downloaded += len(data)
 
 	// Check if piece is complete
-	if pm.isPieceComplete(piece) {
-		return pm.completePiece(pieceIndex)
+	if !pm.isPieceComplete(piece) {
+		return piece, false, nil
 	}
 
-	return nil
+	piece.Verifying = true
+	return piece, true, nil
 }
 
 // isPieceComplete checks if all blocks for a piece have been downloaded
@@ -210,29 +374,130 @@ func (pm *PieceManager) isPieceComplete(piece *PieceState) bool {
 	return totalDownloaded == piece.Length
 }
 
-// completePiece verifies and marks a piece as complete
-func (pm *PieceManager) completePiece(pieceIndex int) error {
-	piece := pm.pendingPieces[pieceIndex]
+// advanceHash feeds the hasher every block that continues the run hashed so
+// far, so that a piece whose blocks arrive in order is hashed by the time the
+// last one lands. A block that arrives ahead of a gap waits in Blocks until
+// the gap is filled. It is called without the lock: hashing is serialized by
+// the piece's hashMutex instead, so it doesn't hold up other pieces. If the
+// piece is already being hashed, the block is left to that goroutine rather
+// than waited on; anything it misses is hashed by the next call, or at the
+// latest by completePiece.
+func (pm *PieceManager) advanceHash(piece *PieceState) {
+	if piece.hasher == nil || !piece.hashMutex.TryLock() {
+		return
+	}
+	defer piece.hashMutex.Unlock()
 
-	// Assemble the complete piece
-	pieceData := make([]byte, piece.Length)
-	for offset := 0; offset < piece.Length; offset += BlockSize {
-		block := piece.Blocks[offset]
-		copy(pieceData[offset:], block)
+	pm.feedHash(piece)
+}
+
+// feedHash does the work of advanceHash. Each block is hashed in a verifier
+// slot, so streamed hashing counts against the same concurrency limit as
+// whole-piece verification. The caller must hold the piece's hashMutex but
+// not the lock.
+func (pm *PieceManager) feedHash(piece *PieceState) {
+	pm.mutex.RLock()
+	verifier := pm.verifier
+	pm.mutex.RUnlock()
+
+	for piece.Hashed < piece.Length {
+		// Stored blocks never change, but Blocks itself is only read under
+		// the lock
+		pm.mutex.RLock()
+		block, ok := piece.Blocks[piece.Hashed]
+		pm.mutex.RUnlock()
+		if !ok {
+			return
+		}
+
+		verifier.run(func() { piece.hasher.Write(block) })
+		piece.Hashed += len(block)
 	}
+}
 
-	// Verify hash
-	hash := sha1.Sum(pieceData)
-	if hash != piece.Hash {
-		// Hash mismatch, restart the piece
-		delete(pm.pendingPieces, pieceIndex)
+// completePiece verifies a piece that addBlock marked Verifying, then
+// records the result. It is called without the lock: while a piece is
+// Verifying nothing else modifies its blocks, so hashing and writing don't
+// hold up other pieces, and the lock is only taken briefly to update the
+// piece's state. Output is printed after the lock is released.
+func (pm *PieceManager) completePiece(piece *PieceState) error {
+	pieceIndex := piece.Index
+
+	pm.mutex.RLock()
+	verifier := pm.verifier
+	store := pm.storage
+	pm.mutex.RUnlock()
+
+	// Keep the hasher to ourselves until the outcome is settled, including
+	// re-hashing the blocks kept after a failure
+	if piece.hasher != nil {
+		piece.hashMutex.Lock()
+		defer piece.hashMutex.Unlock()
+	}
+
+	// The hash was mostly taken as the blocks arrived, in verifier slots
+	// (see advanceHash); only the blocks that arrived after a gap, or that
+	// haven't been fed in yet, are left to hash. Just a piece without a
+	// hasher is assembled and verified as a whole.
+	var pieceData []byte
+	var valid bool
+	if piece.hasher != nil {
+		pm.feedHash(piece)
+		var sum [20]byte
+		piece.hasher.Sum(sum[:0])
+		valid = sum == piece.Hash
+	} else {
+		pieceData = assemblePiece(piece)
+		valid = verifier.Verify(pieceData, piece.Hash)
+	}
+
+	// Write the piece out before it counts as complete, so that a piece we
+	// claim to have can always be read back
+	var writeErr error
+	if valid && store != nil {
+		writeErr = writePiece(store, piece, pieceData)
+	}
+
+	pm.mutex.Lock()
+	piece.Verifying = false
+
+	if pm.pendingPieces[pieceIndex] != piece {
+		pm.mutex.Unlock()
+		return fmt.Errorf("piece %d was cancelled during verification", pieceIndex)
+	}
+
+	if !valid {
+		suspect := pm.recoverFailedPiece(piece)
+		pm.mutex.Unlock()
+
+		if suspect != "" && piece.hasher != nil {
+			pm.feedHash(piece)
+		}
+
+		if suspect != "" && !pm.quiet {
+			fmt.Printf("Piece %d failed verification, re-requesting blocks from %s\n", pieceIndex, suspect)
+		}
 		return fmt.Errorf("piece %d hash verification failed", pieceIndex)
 	}
 
+	if writeErr != nil {
+		// Restart the piece; its blocks are fetched again once writing works
+		delete(pm.pendingPieces, pieceIndex)
+		pm.mutex.Unlock()
+		return fmt.Errorf("failed to store piece %d: %w", pieceIndex, writeErr)
+	}
+
 	// Mark piece as complete
 	pm.bitfield.SetPiece(pieceIndex)
-	pm.completePieces[pieceIndex] = pieceData
 	delete(pm.pendingPieces, pieceIndex)
+	pm.mutex.Unlock()
+
+	if store != nil {
+		err := store.MarkPieceVerified(pieceIndex)
+		if err != nil && !pm.quiet {
+			fmt.Printf("Warning: failed to record piece %d as verified: %v\n", pieceIndex, err)
+		}
+	}
 
 	if !pm.quiet {
 		fmt.Printf("Piece %d completed and verified\n", pieceIndex)
@@ -240,7 +505,95 @@ func (pm *PieceManager) completePiece(pieceIndex int) error {
 	return nil
 }
 
-// GetPieceData returns the data for a completed piece
+// assemblePiece copies a piece's blocks into one buffer.
+func assemblePiece(piece *PieceState) []byte {
+	pieceData := make([]byte, piece.Length)
+	for offset := 0; offset < piece.Length; offset += BlockSize {
+		copy(pieceData[offset:], piece.Blocks[offset])
+	}
+	return pieceData
+}
+
+// writePiece writes a verified piece to store, block by block if the store
+// allows it. pieceData is the assembled piece, or nil if it wasn't needed
+// for verification.
+func writePiece(store PieceStore, piece *PieceState, pieceData []byte) error {
+	if blockWriter, ok := store.(BlockWriter); ok && pieceData == nil {
+		for offset := 0; offset < piece.Length; offset += BlockSize {
+			err := blockWriter.WriteBlock(piece.Index, offset, piece.Blocks[offset])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if pieceData == nil {
+		pieceData = assemblePiece(piece)
+	}
+	return store.WritePiece(piece.Index, pieceData)
+}
+
+// recoverFailedPiece decides which blocks of a piece that failed verification
+// to throw away. When the blocks came from several peers, only the blocks of
+// the most likely culprit are discarded and re-requested from other peers;
+// the rest are kept. When the culprit can't be isolated (a single source, or
+// every suspect has already been tried), the whole piece is restarted. It
+// returns the suspected peer, or "" if the piece was restarted.
+func (pm *PieceManager) recoverFailedPiece(piece *PieceState) string {
+	piece.Failures++
+
+	blocksBySource := make(map[string]int)
+	for offset := range piece.Blocks {
+		blocksBySource[piece.Sources[offset]]++
+	}
+	for source := range blocksBySource {
+		if source != "" {
+			pm.peerFailures[source]++
+		}
+	}
+
+	if len(blocksBySource) < 2 || piece.Failures > len(blocksBySource) {
+		// Can't isolate the culprit, restart the piece from scratch
+		delete(pm.pendingPieces, piece.Index)
+		return ""
+	}
+
+	// Suspect the peer with the worst record; on a tie prefer the one that
+	// sent the fewest blocks, since that's the cheapest to re-fetch
+	suspect := ""
+	for source, count := range blocksBySource {
+		if suspect == "" ||
+			pm.peerFailures[source] > pm.peerFailures[suspect] ||
+			(pm.peerFailures[source] == pm.peerFailures[suspect] && count < blocksBySource[suspect]) {
+			suspect = source
+		}
+	}
+
+	for offset, block := range piece.Blocks {
+		if piece.Sources[offset] != suspect {
+			continue
+		}
+		piece.Downloaded -= len(block)
+		delete(piece.Blocks, offset)
+		delete(piece.Sources, offset)
+		delete(piece.Requested, offset)
+		delete(piece.Owners, offset)
+		piece.Avoid[offset] = suspect
+	}
+	piece.AvoidUntil = time.Now().Add(avoidPeerTimeout)
+
+	// The discarded blocks were hashed; the kept ones are hashed again once
+	// the lock is released (see completePiece)
+	if piece.hasher != nil {
+		piece.hasher.Reset()
+		piece.Hashed = 0
+	}
+
+	return suspect
+}
+
+// GetPieceData returns the data for a completed piece, read back from storage
 func (pm *PieceManager) GetPieceData(pieceIndex int) ([]byte, error) {
 	pm.mutex.RLock()
 	defer pm.mutex.RUnlock()
@@ -249,41 +602,107 @@ func (pm *PieceManager) GetPieceData(pieceIndex int) ([]byte, error) {
 		return nil, fmt.Errorf("piece %d not complete", pieceIndex)
 	}
 
-	if data, exists := pm.completePieces[pieceIndex]; exists {
-		result := make([]byte, len(data))
-		copy(result, data)
-		return result, nil
+	if pm.storage == nil {
+		return nil, fmt.Errorf("piece %d data not kept without storage", pieceIndex)
 	}
 
-	return nil, fmt.Errorf("piece %d data not found", pieceIndex)
+	return pm.storage.ReadPiece(pieceIndex)
+}
+
+// Progress is how far a download has got, both in pieces and in bytes.
+// When only some files are wanted, every field counts only wanted pieces.
+type Progress struct {
+	CompletedPieces int   // Pieces that passed hash verification
+	TotalPieces     int   // Pieces to download
+	VerifiedBytes   int64 // Bytes in the pieces that passed hash verification
+	TotalBytes      int64 // Bytes in the pieces to download
+}
+
+// Percentage returns the share of pieces verified, from 0 to 100. Nothing
+// to download counts as done.
+func (p Progress) Percentage() float64 {
+	if p.TotalPieces == 0 {
+		return 100.0
+	}
+	return float64(p.CompletedPieces) / float64(p.TotalPieces) * 100.0
 }
 
-// GetProgress returns download progress information
-func (pm *PieceManager) GetProgress() (int, int, float64) {
+// GetProgress returns download progress, counting only wanted pieces
+func (pm *PieceManager) GetProgress() Progress {
 	pm.mutex.RLock()
 	defer pm.mutex.RUnlock()
 
-	completed := pm.bitfield.GetNumCompletePieces()
-	total := pm.bitfield.GetNumPieces()
-	percentage := pm.bitfield.GetCompletionPercentage()
+	if pm.wanted == nil {
+		return Progress{
+			CompletedPieces: pm.bitfield.GetNumCompletePieces(),
+			TotalPieces:     pm.numPieces,
+			VerifiedBytes:   pm.bytesIn(pm.bitfield),
+			TotalBytes:      pm.totalLength,
+		}
+	}
 
-	return completed, total, percentage
+	completed := pm.bitfield.And(pm.wanted)
+	return Progress{
+		CompletedPieces: completed.GetNumCompletePieces(),
+		TotalPieces:     pm.wanted.GetNumCompletePieces(),
+		VerifiedBytes:   pm.bytesIn(completed),
+		TotalBytes:      pm.bytesIn(pm.wanted),
+	}
+}
+
+// GetBytesLeft returns the number of bytes in pieces not yet verified. It is
+// exactly zero once every piece is.
+func (pm *PieceManager) GetBytesLeft() int64 {
+	return pm.totalLength - pm.GetVerifiedBytes()
+}
+
+// GetVerifiedBytes returns the total size of the pieces that passed hash
+// verification. Unlike bytes received, re-downloads never count twice.
+func (pm *PieceManager) GetVerifiedBytes() int64 {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	return pm.bytesIn(pm.bitfield)
 }
 
-// IsComplete returns true if all pieces are downloaded
+// bytesIn returns the total size of the pieces set in bf.
+func (pm *PieceManager) bytesIn(bf *Bitfield) int64 {
+	count := bf.GetNumCompletePieces()
+	if count == 0 {
+		return 0
+	}
+
+	size := int64(count) * int64(pm.pieceLength)
+	if last := pm.numPieces - 1; bf.HasPiece(last) {
+		size -= int64(pm.pieceLength - pm.GetPieceLength(last))
+	}
+	return size
+}
+
+// IsComplete returns true if all wanted pieces are downloaded
 func (pm *PieceManager) IsComplete() bool {
 	pm.mutex.RLock()
 	defer pm.mutex.RUnlock()
 
-	return pm.bitfield.IsComplete()
+	for i := 0; i < pm.numPieces; i++ {
+		if pm.isWanted(i) && !pm.bitfield.HasPiece(i) {
+			return false
+		}
+	}
+	return true
 }
 
-// GetMissingPieces returns a list of missing piece indices
+// GetMissingPieces returns a list of wanted piece indices we don't have yet
 func (pm *PieceManager) GetMissingPieces() []int {
 	pm.mutex.RLock()
 	defer pm.mutex.RUnlock()
 
-	return pm.bitfield.GetMissingPieces()
+	var missing []int
+	for _, i := range pm.bitfield.GetMissingPieces() {
+		if pm.isWanted(i) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
 }
 
 // CancelPiece cancels downloading of a piece
@@ -294,6 +713,170 @@ func (pm *PieceManager) CancelPiece(pieceIndex int) {
 	delete(pm.pendingPieces, pieceIndex)
 }
 
+// ReleaseBlock returns a requested but undelivered block to the pool so it
+// can be requested again, e.g. after the peer it was requested from left.
+func (pm *PieceManager) ReleaseBlock(pieceIndex, begin int) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	piece, exists := pm.pendingPieces[pieceIndex]
+	if !exists {
+		return
+	}
+
+	if _, hasBlock := piece.Blocks[begin]; !hasBlock {
+		delete(piece.Requested, begin)
+		delete(piece.Owners, begin)
+	}
+}
+
+// ReleasePeerBlocks returns every block handed to peerAddr that it hasn't
+// delivered to the pool, e.g. after it disconnected, so that other peers can
+// request them. It returns the number of blocks released.
+func (pm *PieceManager) ReleasePeerBlocks(peerAddr string) int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	released := 0
+	for _, piece := range pm.pendingPieces {
+		for offset, owner := range piece.Owners {
+			if owner != peerAddr {
+				continue
+			}
+			delete(piece.Owners, offset)
+			if _, hasBlock := piece.Blocks[offset]; !hasBlock {
+				delete(piece.Requested, offset)
+				released++
+			}
+		}
+	}
+	return released
+}
+
+// UnrequestBlock returns a block that peerAddr was asked for but never
+// delivered to the pool, and steers the re-request to other peers for a
+// while.
+func (pm *PieceManager) UnrequestBlock(pieceIndex, begin int, peerAddr string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	piece, exists := pm.pendingPieces[pieceIndex]
+	if !exists {
+		return
+	}
+
+	if _, hasBlock := piece.Blocks[begin]; !hasBlock {
+		delete(piece.Requested, begin)
+		delete(piece.Owners, begin)
+		piece.Avoid[begin] = peerAddr
+		piece.AvoidUntil = time.Now().Add(avoidPeerTimeout)
+	}
+}
+
+// GetInProgressPieces returns the indices of pieces that have been started
+// but not yet completed, in ascending order.
+func (pm *PieceManager) GetInProgressPieces() []int {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	return pm.sortedPending()
+}
+
+// HasUnrequestedBlocks returns true if an in-progress piece still has blocks
+// that nobody has been asked for.
+func (pm *PieceManager) HasUnrequestedBlocks(pieceIndex int) bool {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	piece, exists := pm.pendingPieces[pieceIndex]
+	if !exists || piece.Verifying {
+		return false
+	}
+
+	for offset := 0; offset < piece.Length; offset += BlockSize {
+		if _, hasBlock := piece.Blocks[offset]; !hasBlock && !piece.Requested[offset] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetOutstandingBlocks returns every block that has been requested but not
+// yet received, across all in-progress pieces, in piece and offset order.
+func (pm *PieceManager) GetOutstandingBlocks() []*BlockRequest {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	var outstanding []*BlockRequest
+	for _, pieceIndex := range pm.sortedPending() {
+		piece := pm.pendingPieces[pieceIndex]
+		if piece.Verifying {
+			continue
+		}
+
+		for offset := 0; offset < piece.Length; offset += BlockSize {
+			if _, hasBlock := piece.Blocks[offset]; hasBlock || !piece.Requested[offset] {
+				continue
+			}
+			outstanding = append(outstanding, &BlockRequest{
+				PieceIndex: pieceIndex,
+				Begin:      offset,
+				Length:     min(BlockSize, piece.Length-offset),
+			})
+		}
+	}
+
+	return outstanding
+}
+
+// InEndgame reports whether every block still missing has been requested
+// from some peer, so that the download can only finish as fast as those
+// peers answer. In endgame the outstanding blocks are worth requesting from
+// other peers as well.
+func (pm *PieceManager) InEndgame() bool {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	outstanding := false
+	for _, pieceIndex := range pm.bitfield.GetMissingPieces() {
+		if !pm.isWanted(pieceIndex) {
+			continue
+		}
+
+		piece, exists := pm.pendingPieces[pieceIndex]
+		if !exists {
+			return false
+		}
+		if piece.Verifying {
+			continue
+		}
+
+		for offset := 0; offset < piece.Length; offset += BlockSize {
+			if _, hasBlock := piece.Blocks[offset]; hasBlock {
+				continue
+			}
+			if !piece.Requested[offset] {
+				return false
+			}
+			outstanding = true
+		}
+	}
+
+	return outstanding
+}
+
+// sortedPending returns the indices of in-progress pieces in ascending
+// order. The caller must hold the lock.
+func (pm *PieceManager) sortedPending() []int {
+	indices := make([]int, 0, len(pm.pendingPieces))
+	for pieceIndex := range pm.pendingPieces {
+		indices = append(indices, pieceIndex)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
 // GetPendingRequests returns the number of pending block requests for a piece
 func (pm *PieceManager) GetPendingRequests(pieceIndex int) int {
 	pm.mutex.RLock()
@@ -336,24 +919,3 @@ func (pm *PieceManager) GetPieceProgress(pieceIndex int) (int, int) {
 
 	return downloaded, piece.Length
 }
-
-// GetAllPieceData returns all completed piece data in order
-func (pm *PieceManager) GetAllPieceData() ([]byte, error) {
-	pm.mutex.RLock()
-	defer pm.mutex.RUnlock()
-
-	if !pm.bitfield.IsComplete() {
-		return nil, fmt.Errorf("download not complete")
-	}
-
-	var result []byte
-	for i := 0; i < pm.numPieces; i++ {
-		data, exists := pm.completePieces[i]
-		if !exists {
-			return nil, fmt.Errorf("missing piece %d data", i)
-		}
-		result = append(result, data...)
-	}
-
-	return result, nil
-}
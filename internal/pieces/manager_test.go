@@ -0,0 +1,582 @@
+package pieces
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+)
+
+// newTestManager returns a piece manager for numPieces pieces of three
+// blocks each, along with their data.
+func newTestManager(numPieces int) (*PieceManager, []byte) {
+	pieceLength := 3 * BlockSize
+	data := make([]byte, numPieces*pieceLength)
+	rand.Read(data)
+
+	hashes := make([][20]byte, numPieces)
+	for i := range hashes {
+		hashes[i] = sha1.Sum(data[i*pieceLength : (i+1)*pieceLength])
+	}
+	return NewPieceManagerWithOptions(pieceLength, int64(len(data)), hashes, true), data
+}
+
+// requestAll has peerAddr request every block of a piece it can, returning
+// the offsets handed out in order.
+func requestAll(t *testing.T, pm *PieceManager, pieceIndex int, peerAddr string) []int {
+	t.Helper()
+	var offsets []int
+	for {
+		req, err := pm.GetNextBlockRequestForPeer(pieceIndex, peerAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req == nil {
+			return offsets
+		}
+		offsets = append(offsets, req.Begin)
+	}
+}
+
+// deliver adds a block of piece 0 from peerAddr.
+func deliver(t *testing.T, pm *PieceManager, data []byte, begin int, peerAddr string) {
+	t.Helper()
+	err := pm.AddBlockFromPeer(0, begin, data[begin:begin+BlockSize], peerAddr)
+	if err != nil {
+		t.Fatalf("AddBlockFromPeer(0, %d): %v", begin, err)
+	}
+}
+
+func equalOffsets(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReleasePeerBlocks(t *testing.T) {
+	pm, data := newTestManager(2)
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A takes every block, so B gets none
+	if got := requestAll(t, pm, 0, "A"); !equalOffsets(got, []int{0, BlockSize, 2 * BlockSize}) {
+		t.Fatalf("A was handed %v", got)
+	}
+	if got := requestAll(t, pm, 0, "B"); len(got) != 0 {
+		t.Fatalf("B was handed %v while A owned every block", got)
+	}
+
+	// A delivers one block and disconnects: the other two go back to the pool
+	deliver(t, pm, data, 0, "A")
+	if released := pm.ReleasePeerBlocks("A"); released != 2 {
+		t.Fatalf("released %d blocks, want 2", released)
+	}
+	if !pm.HasUnrequestedBlocks(0) {
+		t.Fatal("released blocks aren't available")
+	}
+
+	got := requestAll(t, pm, 0, "B")
+	if !equalOffsets(got, []int{BlockSize, 2 * BlockSize}) {
+		t.Fatalf("B was handed %v, want the two A didn't deliver", got)
+	}
+
+	// B owns them now; releasing A again must not take them from B
+	if released := pm.ReleasePeerBlocks("A"); released != 0 {
+		t.Errorf("second release of A freed %d blocks", released)
+	}
+	if pm.HasUnrequestedBlocks(0) {
+		t.Error("B's blocks were released along with A's")
+	}
+
+	deliver(t, pm, data, BlockSize, "B")
+	deliver(t, pm, data, 2*BlockSize, "B")
+	if !pm.HasPiece(0) {
+		t.Error("piece 0 not complete after B finished it")
+	}
+	if released := pm.ReleasePeerBlocks("B"); released != 0 {
+		t.Errorf("releasing B after completion freed %d blocks", released)
+	}
+}
+
+// The same block can be released by a reject (UnrequestBlock), a timeout
+// or the departure of its peer (ReleaseBlock) and the peer's disconnect
+// (ReleasePeerBlocks), in any combination. Each block must return to the
+// pool once, and only while still undelivered.
+func TestOverlappingReleases(t *testing.T) {
+	pm, data := newTestManager(1)
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+	requestAll(t, pm, 0, "A")
+
+	pm.UnrequestBlock(0, 0, "A")  // Rejected
+	pm.ReleaseBlock(0, BlockSize) // Timed out
+	if released := pm.ReleasePeerBlocks("A"); released != 1 {
+		t.Errorf("disconnect released %d blocks, want only the one still owned", released)
+	}
+
+	// A rejected block 0, so it's steered to other peers for a while
+	if got := requestAll(t, pm, 0, "A"); !equalOffsets(got, []int{BlockSize, 2 * BlockSize}) {
+		t.Fatalf("A was handed %v after rejecting block 0", got)
+	}
+	if got := requestAll(t, pm, 0, "B"); !equalOffsets(got, []int{0}) {
+		t.Fatalf("B was handed %v, want the block A rejected", got)
+	}
+
+	// A delivers one block, then the rest of its requests are released
+	// through every path at once
+	deliver(t, pm, data, BlockSize, "A")
+	pm.ReleaseBlock(0, BlockSize)
+	pm.UnrequestBlock(0, BlockSize, "A")
+	pm.ReleaseBlock(0, 2*BlockSize)
+	if released := pm.ReleasePeerBlocks("A"); released != 0 {
+		t.Errorf("disconnect released %d blocks already released or delivered", released)
+	}
+	if got := requestAll(t, pm, 0, "C"); !equalOffsets(got, []int{2 * BlockSize}) {
+		t.Fatalf("C was handed %v, want only the undelivered block", got)
+	}
+
+	deliver(t, pm, data, 0, "B")
+	deliver(t, pm, data, 2*BlockSize, "C")
+	if !pm.HasPiece(0) {
+		t.Error("piece not complete")
+	}
+}
+
+// A piece whose only provider left stays in progress with what it already
+// delivered, and a peer arriving later finishes it.
+func TestStalledPieceFinishedByNewPeer(t *testing.T) {
+	pm, data := newTestManager(1)
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+	requestAll(t, pm, 0, "A")
+	deliver(t, pm, data, 0, "A")
+	pm.ReleasePeerBlocks("A")
+
+	if pieces := pm.GetInProgressPieces(); len(pieces) != 1 || pieces[0] != 0 {
+		t.Fatalf("in progress: %v, want [0]", pieces)
+	}
+	if downloaded, _ := pm.GetPieceProgress(0); downloaded != BlockSize {
+		t.Fatalf("%d bytes kept after the provider left, want %d", downloaded, BlockSize)
+	}
+
+	for _, begin := range requestAll(t, pm, 0, "B") {
+		deliver(t, pm, data, begin, "B")
+	}
+	if !pm.IsComplete() {
+		t.Error("new peer didn't finish the stalled piece")
+	}
+}
+
+// blockingStore is a PieceStore whose writes wait until released.
+type blockingStore struct {
+	writing chan int      // Receives each piece as its write starts
+	release chan struct{} // Closed to let writes finish
+}
+
+func (s *blockingStore) WritePiece(pieceIndex int, data []byte) error {
+	s.writing <- pieceIndex
+	<-s.release
+	return nil
+}
+
+func (s *blockingStore) ReadPiece(pieceIndex int) ([]byte, error) {
+	return nil, fmt.Errorf("not stored")
+}
+
+func (s *blockingStore) MarkPieceVerified(pieceIndex int) error {
+	return nil
+}
+
+func TestCompletePieceWritesWithoutLock(t *testing.T) {
+	pm, data := newTestManager(1)
+	store := &blockingStore{writing: make(chan int), release: make(chan struct{})}
+	pm.SetStorage(store)
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+	requestAll(t, pm, 0, "A")
+	deliver(t, pm, data, 0, "A")
+	deliver(t, pm, data, BlockSize, "A")
+
+	completed := make(chan error)
+	go func() {
+		completed <- pm.AddBlockFromPeer(0, 2*BlockSize, data[2*BlockSize:], "A")
+	}()
+	<-store.writing
+
+	// While the piece is being written, the rest of the manager carries on
+	locked := make(chan struct{})
+	go func() {
+		pm.GetProgress()
+		pm.mutex.Lock()
+		pm.mutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock held while the piece was written")
+	}
+	if pm.HasPiece(0) {
+		t.Error("piece complete before it was written")
+	}
+
+	close(store.release)
+	if err := <-completed; err != nil {
+		t.Fatal(err)
+	}
+	if !pm.HasPiece(0) {
+		t.Error("piece not complete once written")
+	}
+}
+
+// sleepStore is a PieceStore whose writes take delay, like a slow disk.
+type sleepStore struct {
+	delay time.Duration // How long each write takes
+}
+
+func (s sleepStore) WritePiece(pieceIndex int, data []byte) error {
+	time.Sleep(s.delay)
+	return nil
+}
+
+func (s sleepStore) ReadPiece(pieceIndex int) ([]byte, error) {
+	return nil, fmt.Errorf("not stored")
+}
+
+func (s sleepStore) MarkPieceVerified(pieceIndex int) error {
+	return nil
+}
+
+// BenchmarkCompletePieceLockWait completes one-block pieces written to a
+// slow store while another goroutine keeps taking pm.mutex, and reports the
+// longest that goroutine waited. It stays far below the write time as long
+// as completePiece doesn't hold the lock across the write.
+func BenchmarkCompletePieceLockWait(b *testing.B) {
+	const writeDelay = 200 * time.Microsecond
+	data := make([]byte, 1024)
+	rand.Read(data)
+	hash := sha1.Sum(data)
+	hashes := make([][20]byte, b.N)
+	for i := range hashes {
+		hashes[i] = hash
+	}
+	pm := NewPieceManagerWithOptions(len(data), int64(b.N*len(data)), hashes, true)
+	pm.SetStorage(sleepStore{delay: writeDelay})
+
+	stop := make(chan struct{})
+	maxWait := make(chan time.Duration)
+	go func() {
+		var longest time.Duration
+		for {
+			select {
+			case <-stop:
+				maxWait <- longest
+				return
+			default:
+			}
+			start := time.Now()
+			pm.mutex.Lock()
+			longest = max(longest, time.Since(start))
+			pm.mutex.Unlock()
+			// Leave the lock alone between probes, or the probe itself is
+			// what everyone waits for
+			time.Sleep(10 * time.Microsecond)
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := pm.StartPiece(i); err != nil {
+			b.Fatal(err)
+		}
+		if err := pm.AddBlockFromPeer(i, 0, data, "A"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	close(stop)
+	b.ReportMetric(float64((<-maxWait).Microseconds()), "max-lock-wait-µs")
+	b.ReportMetric(float64(writeDelay.Microseconds()), "write-µs")
+}
+
+func TestStreamedHashUsesVerifierSlotsOutsideLock(t *testing.T) {
+	pm, data := newTestManager(1)
+	verifier := NewVerifier(1)
+	pm.SetVerifier(verifier)
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+	requestAll(t, pm, 0, "A")
+
+	// With the only slot taken, hashing the first block has to wait for it
+	verifier.slots <- struct{}{}
+	added := make(chan error)
+	go func() {
+		added <- pm.AddBlockFromPeer(0, 0, data[:BlockSize], "A")
+	}()
+
+	select {
+	case err := <-added:
+		t.Fatalf("block hashed without a verifier slot (err %v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Meanwhile the manager isn't locked: other blocks are still taken in
+	locked := make(chan struct{})
+	go func() {
+		if err := pm.AddBlockFromPeer(0, 2*BlockSize, data[2*BlockSize:], "B"); err != nil {
+			t.Error(err)
+		}
+		pm.GetProgress()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock held while waiting to hash")
+	}
+
+	<-verifier.slots
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, pm, data, BlockSize, "A")
+	if !pm.HasPiece(0) {
+		t.Error("piece not complete")
+	}
+}
+
+func TestStreamedHashOutOfOrder(t *testing.T) {
+	pm, data := newTestManager(1)
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+	requestAll(t, pm, 0, "A")
+
+	for _, begin := range []int{2 * BlockSize, 0, BlockSize} {
+		deliver(t, pm, data, begin, "A")
+	}
+	if !pm.HasPiece(0) {
+		t.Error("piece delivered out of order didn't verify")
+	}
+}
+
+func TestStreamedHashAfterFailure(t *testing.T) {
+	pm, data := newTestManager(1)
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+	requestAll(t, pm, 0, "A")
+
+	// B corrupts the middle block; its blocks are discarded, A's are kept
+	// and hashed again
+	deliver(t, pm, data, 0, "A")
+	deliver(t, pm, data, 2*BlockSize, "A")
+	bad := make([]byte, BlockSize)
+	if err := pm.AddBlockFromPeer(0, BlockSize, bad, "B"); err == nil {
+		t.Fatal("corrupt piece verified")
+	}
+	if got := requestAll(t, pm, 0, "C"); !equalOffsets(got, []int{BlockSize}) {
+		t.Fatalf("C was handed %v, want only the corrupt block", got)
+	}
+
+	deliver(t, pm, data, BlockSize, "C")
+	if !pm.HasPiece(0) {
+		t.Error("piece didn't verify once the corrupt block was replaced")
+	}
+}
+
+func TestConcurrentBlocks(t *testing.T) {
+	const numPieces = 8
+	pm, data := newTestManager(numPieces)
+	for i := 0; i < numPieces; i++ {
+		if err := pm.StartPiece(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every block arrives on its own goroutine, in no particular order
+	var wg sync.WaitGroup
+	pieceLength := 3 * BlockSize
+	for i := 0; i < numPieces; i++ {
+		for begin := 0; begin < pieceLength; begin += BlockSize {
+			start := i*pieceLength + begin
+			wg.Add(1)
+			go func(i, begin int) {
+				defer wg.Done()
+				if err := pm.AddBlockFromPeer(i, begin, data[start:start+BlockSize], "A"); err != nil {
+					t.Error(err)
+				}
+			}(i, begin)
+		}
+	}
+	wg.Wait()
+
+	if !pm.IsComplete() {
+		t.Errorf("missing pieces %v", pm.GetMissingPieces())
+	}
+}
+
+func TestProgressAfterHashFailure(t *testing.T) {
+	pm, data := newTestManager(2)
+	pieceLength := int64(3 * BlockSize)
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+	requestAll(t, pm, 0, "A")
+
+	// Every block of the first attempt is received, but the piece fails
+	deliver(t, pm, data, 0, "A")
+	deliver(t, pm, data, BlockSize, "A")
+	if err := pm.AddBlockFromPeer(0, 2*BlockSize, make([]byte, BlockSize), "A"); err == nil {
+		t.Fatal("corrupt piece verified")
+	}
+	if progress := pm.GetProgress(); progress.VerifiedBytes != 0 || progress.CompletedPieces != 0 {
+		t.Errorf("after the hash failure: %+v, want nothing verified", progress)
+	}
+
+	// The blocks from the peer that sent bad data are discarded, so the
+	// piece starts over; once verified it counts once, although twice its
+	// size was received
+	if err := pm.StartPiece(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := requestAll(t, pm, 0, "B"); !equalOffsets(got, []int{0, BlockSize, 2 * BlockSize}) {
+		t.Fatalf("B was handed %v, want every block", got)
+	}
+	for begin := 0; begin < 3*BlockSize; begin += BlockSize {
+		deliver(t, pm, data, begin, "B")
+	}
+	want := Progress{CompletedPieces: 1, TotalPieces: 2, VerifiedBytes: pieceLength, TotalBytes: 2 * pieceLength}
+	if progress := pm.GetProgress(); progress != want {
+		t.Errorf("after the retry: %+v, want %+v", progress, want)
+	}
+	if left := pm.GetBytesLeft(); left != pieceLength {
+		t.Errorf("%d bytes left, want %d", left, pieceLength)
+	}
+}
+
+func TestCompleteWithWantedFiles(t *testing.T) {
+	pm, data := newTestManager(4)
+	pieceLength := 3 * BlockSize
+
+	// The last file starts halfway through piece 2 and runs to the end
+	info := torrent.TorrentInfo{
+		PieceLength: int64(pieceLength),
+		Files: []torrent.FileInfo{
+			{Length: int64(pieceLength / 2)},
+			{Length: int64(2 * pieceLength)},
+			{Length: int64(pieceLength + pieceLength/2)},
+		},
+	}
+	start, end := info.GetFilePieces(2)
+	wanted := NewBitfield(4)
+	if err := wanted.SetRange(start, end); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.SetWantedPieces(wanted); err != nil {
+		t.Fatal(err)
+	}
+	if missing := pm.GetMissingPieces(); !equalOffsets(missing, []int{2, 3}) {
+		t.Fatalf("missing %v, want [2 3]", missing)
+	}
+
+	for _, pieceIndex := range []int{3, 2} {
+		if pm.IsComplete() {
+			t.Fatalf("complete before piece %d", pieceIndex)
+		}
+		if err := pm.StartPiece(pieceIndex); err != nil {
+			t.Fatal(err)
+		}
+		requestAll(t, pm, pieceIndex, "A")
+		for begin := 0; begin < pieceLength; begin += BlockSize {
+			offset := pieceIndex*pieceLength + begin
+			if err := pm.AddBlockFromPeer(pieceIndex, begin, data[offset:offset+BlockSize], "A"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Pieces 0 and 1 were never downloaded, but aren't wanted
+	if !pm.IsComplete() {
+		t.Error("not complete with every wanted piece verified")
+	}
+	if missing := pm.GetMissingPieces(); len(missing) != 0 {
+		t.Errorf("missing %v with every wanted piece verified", missing)
+	}
+	want := Progress{CompletedPieces: 2, TotalPieces: 2, VerifiedBytes: int64(2 * pieceLength), TotalBytes: int64(2 * pieceLength)}
+	if progress := pm.GetProgress(); progress != want {
+		t.Errorf("progress %+v, want %+v", progress, want)
+	}
+	if bitfield := pm.GetBitfield(); bitfield.HasPiece(0) || bitfield.HasPiece(1) || !bitfield.HasPiece(2) || !bitfield.HasPiece(3) {
+		t.Errorf("bitfield %v, want only pieces 2 and 3", bitfield)
+	}
+}
+
+func TestSpreadBlocks(t *testing.T) {
+	const numBlocks = 16
+	peers := []string{"10.0.0.1:6881", "10.0.0.2:6881", "10.0.0.3:6881", "10.0.0.4:6881"}
+
+	for _, spread := range []bool{false, true} {
+		pm := NewPieceManagerWithOptions(numBlocks*BlockSize, numBlocks*BlockSize, make([][20]byte, 1), true)
+		pm.SetSpreadBlocks(spread)
+		if err := pm.StartPiece(0); err != nil {
+			t.Fatal(err)
+		}
+
+		// The peers take turns asking for a block, twice round
+		requested := make(map[string][]int)
+		owner := make(map[int]string)
+		for round := 0; round < 2; round++ {
+			for _, peerAddr := range peers {
+				req, err := pm.GetNextBlockRequestForPeer(0, peerAddr)
+				if err != nil || req == nil {
+					t.Fatalf("spread %v: %s got no block: %v", spread, peerAddr, err)
+				}
+				if other, taken := owner[req.Begin]; taken {
+					t.Fatalf("spread %v: block %d handed to %s and %s", spread, req.Begin, other, peerAddr)
+				}
+				owner[req.Begin] = peerAddr
+				requested[peerAddr] = append(requested[peerAddr], req.Begin/BlockSize)
+			}
+		}
+
+		if !spread {
+			// Everyone scans from the first block, so the peers interleave
+			if got := requested[peers[0]]; !equalOffsets(got, []int{0, len(peers)}) {
+				t.Errorf("unspread: %s got blocks %v, want [0 %d]", peers[0], got, len(peers))
+			}
+			continue
+		}
+
+		// Each peer starts at a block of its own and carries on from there,
+		// clear of the others
+		starts := make(map[int]bool)
+		for _, peerAddr := range peers {
+			first := pm.firstBlock(0, peerAddr, numBlocks)
+			if starts[first] {
+				t.Errorf("two peers start at block %d", first)
+			}
+			starts[first] = true
+			want := []int{first, (first + 1) % numBlocks}
+			if got := requested[peerAddr]; !equalOffsets(got, want) {
+				t.Errorf("%s got blocks %v, want %v", peerAddr, got, want)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package pieces
 
 import (
 	"crypto/sha1"
+	"runtime"
 )
 
 // VerifyPieceHash verifies that the given data matches the expected hash
@@ -9,3 +10,46 @@ func VerifyPieceHash(data []byte, expectedHash [20]byte) bool {
 	actualHash := sha1.Sum(data)
 	return actualHash == expectedHash
 }
+
+// Verifier hashes pieces with a cap on how many hashes run at once, so
+// verification can be kept from using every CPU.
+type Verifier struct {
+	slots chan struct{} // One token per concurrent verification
+	hash  func(data []byte, expectedHash [20]byte) bool
+}
+
+// NewVerifier creates a verifier running at most concurrency verifications
+// at a time. A concurrency of zero or less means one per CPU Go may use
+// (GOMAXPROCS), which respects container CPU limits where NumCPU doesn't.
+func NewVerifier(concurrency int) *Verifier {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	return &Verifier{
+		slots: make(chan struct{}, concurrency),
+		hash:  VerifyPieceHash,
+	}
+}
+
+// Verify checks data against expectedHash, waiting for a free slot first.
+func (v *Verifier) Verify(data []byte, expectedHash [20]byte) bool {
+	var valid bool
+	v.run(func() { valid = v.hash(data, expectedHash) })
+	return valid
+}
+
+// run calls f in a slot, waiting for one to free up first. It lets hashing
+// done piecemeal, such as a piece hashed block by block as it arrives, share
+// the concurrency limit.
+func (v *Verifier) run(f func()) {
+	v.slots <- struct{}{}
+	defer func() { <-v.slots }()
+
+	f()
+}
+
+// Concurrency returns the maximum number of verifications run at once.
+func (v *Verifier) Concurrency() int {
+	return cap(v.slots)
+}
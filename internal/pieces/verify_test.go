@@ -0,0 +1,59 @@
+package pieces
+
+import (
+	"crypto/sha1"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerifierConcurrencyLimit(t *testing.T) {
+	for _, limit := range []int{1, 2, 4} {
+		verifier := NewVerifier(limit)
+
+		// Count the hashes in flight, holding each long enough for the
+		// others to pile up behind it
+		var mutex sync.Mutex
+		running, peak := 0, 0
+		verifier.hash = func(data []byte, expectedHash [20]byte) bool {
+			mutex.Lock()
+			running++
+			peak = max(peak, running)
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			return VerifyPieceHash(data, expectedHash)
+		}
+
+		data := []byte("piece data")
+		hash := sha1.Sum(data)
+		var wg sync.WaitGroup
+		for i := 0; i < 4*limit; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !verifier.Verify(data, hash) {
+					t.Error("valid piece failed verification")
+				}
+			}()
+		}
+		wg.Wait()
+
+		if peak != limit {
+			t.Errorf("limit %d: %d verifications ran at once", limit, peak)
+		}
+	}
+}
+
+func TestVerifierDefaultConcurrency(t *testing.T) {
+	for _, concurrency := range []int{0, -1} {
+		if got := NewVerifier(concurrency).Concurrency(); got != runtime.GOMAXPROCS(0) {
+			t.Errorf("NewVerifier(%d).Concurrency() = %d, want GOMAXPROCS %d", concurrency, got, runtime.GOMAXPROCS(0))
+		}
+	}
+}
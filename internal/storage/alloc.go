@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// Allocation is how setupFiles makes room on disk for each file.
+type Allocation int
+
+const (
+	// AllocSparse sets each file to its full size without writing to it.
+	// Most filesystems then create a sparse file, allocating blocks as they
+	// are written; some reserve the space up front.
+	AllocSparse Allocation = iota
+
+	// AllocFull reserves each file's space up front, which catches a full
+	// disk before the download starts and keeps files from fragmenting.
+	// It uses fallocate where available and writes zeros elsewhere, which
+	// takes a while for large torrents.
+	AllocFull
+
+	// AllocNone leaves files empty, to grow as pieces are written. Files
+	// then only take the space of what has been downloaded, but pieces
+	// written out of order still leave holes.
+	AllocNone
+)
+
+// zeroChunk is how many zeros AllocFull writes at once where fallocate
+// isn't available.
+const zeroChunk = 1 << 20
+
+// ParseAllocation returns the allocation mode called name: sparse, full or
+// none.
+func ParseAllocation(name string) (Allocation, error) {
+	switch name {
+	case "sparse":
+		return AllocSparse, nil
+	case "full":
+		return AllocFull, nil
+	case "none":
+		return AllocNone, nil
+	}
+	return AllocSparse, fmt.Errorf("unknown allocation mode %q: expected sparse, full or none", name)
+}
+
+// String returns the name ParseAllocation accepts for a.
+func (a Allocation) String() string {
+	switch a {
+	case AllocFull:
+		return "full"
+	case AllocNone:
+		return "none"
+	}
+	return "sparse"
+}
+
+// allocate makes room for a file of length bytes that is size bytes long
+// on disk, as Options.Allocation says. Files longer than length are cut
+// short whatever the mode.
+func (fs *FileStorage) allocate(file *os.File, size, length int64) error {
+	if size > length {
+		return file.Truncate(length)
+	}
+
+	allocation := fs.options.Allocation
+	if allocation == AllocNone && fs.options.Mmap && mmapSupported {
+		// Only the part of a mapping within the file can be written
+		allocation = AllocSparse
+	}
+
+	switch allocation {
+	case AllocFull:
+		if fallocate(file, size, length-size) == nil {
+			return nil
+		}
+		return writeZeros(file, size, length)
+	case AllocNone:
+		return nil
+	default:
+		return file.Truncate(length)
+	}
+}
+
+// writeZeros fills a file with zeros from offset start up to end.
+func writeZeros(file *os.File, start, end int64) error {
+	zeros := make([]byte, min(zeroChunk, end-start))
+	for offset := start; offset < end; {
+		n, err := file.WriteAt(zeros[:min(int64(len(zeros)), end-offset)], offset)
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+	return nil
+}
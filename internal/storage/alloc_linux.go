@@ -0,0 +1,13 @@
+package storage
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocate reserves length bytes of file from offset, extending the file
+// if needed, without writing to it.
+func fallocate(file *os.File, offset, length int64) error {
+	return unix.Fallocate(int(file.Fd()), 0, offset, length)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// fallocate isn't available here, so AllocFull writes zeros instead.
+func fallocate(file *os.File, offset, length int64) error {
+	return errors.New("fallocate not supported on this platform")
+}
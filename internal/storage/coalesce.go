@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// pendingPiece buffers the blocks of one piece until they are flushed.
+type pendingPiece struct {
+	data   []byte   // Piece-sized buffer; only the ranges below are valid
+	ranges [][2]int // Sorted, non-overlapping [begin, end) ranges written so far
+	size   int      // Bytes accounted against Options.WriteBuffer
+}
+
+// add copies a block into the buffer and merges its range with its neighbours.
+func (p *pendingPiece) add(begin int, data []byte) {
+	copy(p.data[begin:], data)
+	end := begin + len(data)
+
+	i := sort.Search(len(p.ranges), func(i int) bool { return p.ranges[i][1] >= begin })
+	j := i
+	for j < len(p.ranges) && p.ranges[j][0] <= end {
+		if p.ranges[j][0] < begin {
+			begin = p.ranges[j][0]
+		}
+		if p.ranges[j][1] > end {
+			end = p.ranges[j][1]
+		}
+		j++
+	}
+
+	p.ranges = append(p.ranges[:i], append([][2]int{{begin, end}}, p.ranges[j:]...)...)
+}
+
+// complete reports whether the buffer holds the whole piece.
+func (p *pendingPiece) complete() bool {
+	return len(p.ranges) == 1 && p.ranges[0][0] == 0 && p.ranges[0][1] == len(p.data)
+}
+
+// bufferBlock copies a block into its piece's buffer for a later coalesced
+// write. The piece is flushed as soon as it is complete; if more than
+// WriteBuffer bytes are buffered everything is flushed. Caller must hold the
+// write lock.
+func (fs *FileStorage) bufferBlock(pieceIndex, begin int, data []byte) error {
+	p, ok := fs.pending[pieceIndex]
+	if !ok {
+		// Buffers are piece-sized, so account for the whole piece up front
+		pieceLength := fs.getPieceLength(pieceIndex)
+		p = &pendingPiece{data: fs.pieceBuffer(pieceLength), size: pieceLength}
+		fs.pending[pieceIndex] = p
+		fs.pendingSize += p.size
+	}
+
+	p.add(begin, data)
+
+	if p.complete() {
+		return fs.flushPiece(pieceIndex)
+	}
+	if fs.pendingSize > fs.options.WriteBuffer {
+		return fs.flushAll()
+	}
+
+	return nil
+}
+
+// pieceBuffer returns a buffer of the given length, reusing one released by
+// an earlier flush when possible. Caller must hold the write lock.
+func (fs *FileStorage) pieceBuffer(length int) []byte {
+	if n := len(fs.spare); n > 0 {
+		buf := fs.spare[n-1]
+		fs.spare = fs.spare[:n-1]
+		if cap(buf) >= length {
+			return buf[:length]
+		}
+	}
+
+	return make([]byte, length, fs.torrent.Info.PieceLength)
+}
+
+// FlushPiece writes out any buffered blocks of the given piece.
+func (fs *FileStorage) FlushPiece(pieceIndex int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.flushPiece(pieceIndex)
+}
+
+// Flush writes out all buffered blocks.
+func (fs *FileStorage) Flush() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.flushAll()
+}
+
+// flushAll writes out all buffered blocks. Caller must hold the write lock.
+func (fs *FileStorage) flushAll() error {
+	for pieceIndex := range fs.pending {
+		err := fs.flushPiece(pieceIndex)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flushPiece writes each contiguous range of a piece's buffered blocks with a
+// single write. Caller must hold the write lock.
+func (fs *FileStorage) flushPiece(pieceIndex int) error {
+	p, ok := fs.pending[pieceIndex]
+	if !ok {
+		return nil
+	}
+
+	pieceOffset := int64(pieceIndex) * int64(fs.torrent.Info.PieceLength)
+	for _, r := range p.ranges {
+		_, err := fs.writeAt(p.data[r[0]:r[1]], pieceOffset+int64(r[0]))
+		if err != nil {
+			return fmt.Errorf("failed to write piece %d: %w", pieceIndex, err)
+		}
+	}
+
+	fs.pendingSize -= p.size
+	delete(fs.pending, pieceIndex)
+	fs.spare = append(fs.spare, p.data[:cap(p.data)])
+
+	return nil
+}
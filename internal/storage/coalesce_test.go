@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+const (
+	benchBlockSize   = 16 * 1024
+	benchPieceLength = 16 * benchBlockSize
+	benchPieces      = 16
+)
+
+// shuffledBlocks returns the offset of every block of every piece, piece by
+// piece but with each piece's blocks in random order, as they arrive from
+// several peers at once.
+func shuffledBlocks(numPieces, pieceLength, blockSize int) [][2]int {
+	var blocks [][2]int
+	for piece := 0; piece < numPieces; piece++ {
+		start := len(blocks)
+		for begin := 0; begin < pieceLength; begin += blockSize {
+			blocks = append(blocks, [2]int{piece, begin})
+		}
+		rand.Shuffle(len(blocks)-start, func(i, j int) {
+			blocks[start+i], blocks[start+j] = blocks[start+j], blocks[start+i]
+		})
+	}
+	return blocks
+}
+
+func TestCoalescedWritesOutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+	pieceLength := 4 * 1024
+	data := testData(3*pieceLength + 1000)
+	tf := testTorrent(data, pieceLength, 5000, 2000, int64(len(data)-7000))
+
+	// A buffer smaller than two pieces forces some flushes of incomplete
+	// pieces along the way
+	fs, err := NewFileStorageWithOptions(tf, dir, Options{WriteBuffer: pieceLength + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	for _, block := range shuffledBlocks(4, pieceLength, 1024) {
+		piece, begin := block[0], block[1]
+		start := piece*pieceLength + begin
+		if start >= len(data) {
+			continue
+		}
+		end := min(start+1024, len(data))
+		if err := fs.WriteBlock(piece, begin, data[start:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		got, err := fs.ReadPiece(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[i*pieceLength:min((i+1)*pieceLength, len(data))]) {
+			t.Errorf("piece %d read back different data", i)
+		}
+	}
+}
+
+// benchmarkWriteBlocks writes a whole torrent block by block per iteration.
+func benchmarkWriteBlocks(b *testing.B, writeBuffer int) {
+	data := testData(benchPieces * benchPieceLength)
+	tf := testTorrent(data, benchPieceLength, int64(len(data)))
+	fs, err := NewFileStorageWithOptions(tf, b.TempDir(), Options{WriteBuffer: writeBuffer})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer fs.Close()
+
+	blocks := shuffledBlocks(benchPieces, benchPieceLength, benchBlockSize)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, block := range blocks {
+			piece, begin := block[0], block[1]
+			start := piece*benchPieceLength + begin
+			err := fs.WriteBlock(piece, begin, data[start:start+benchBlockSize])
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := fs.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteBlocksPerBlock(b *testing.B) {
+	benchmarkWriteBlocks(b, 0)
+}
+
+func BenchmarkWriteBlocksCoalesced(b *testing.B) {
+	benchmarkWriteBlocks(b, 4*benchPieceLength)
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileHandles opens torrent files on demand and keeps at most max of them
+// open, closing the least recently used handle to make room. Handles in use
+// by a read or write are never closed, so the cap can be exceeded briefly
+// while every open handle is busy.
+type fileHandles struct {
+	open  func(i int) (*os.File, error) // Opens file i
+	max   int                           // Handles kept open at most (0 means no limit)
+	files []*os.File                    // Open handles, nil when closed
+	users []int                         // Reads and writes currently using each handle
+	dirty []bool                        // Files written since they were last synced
+	uses  []*list.Element               // Position of each open handle in lru
+	lru   *list.List                    // Open file indexes, most recently used first
+	mutex sync.Mutex                    // Protects the fields above
+}
+
+// newFileHandles creates a handle cache for numFiles files.
+func newFileHandles(numFiles, max int, open func(i int) (*os.File, error)) *fileHandles {
+	return &fileHandles{
+		open:  open,
+		max:   max,
+		files: make([]*os.File, numFiles),
+		users: make([]int, numFiles),
+		dirty: make([]bool, numFiles),
+		uses:  make([]*list.Element, numFiles),
+		lru:   list.New(),
+	}
+}
+
+// acquire returns an open handle for file i, opening it if needed. The
+// handle stays open until the matching release. Set write if the caller
+// will write through it.
+func (h *fileHandles) acquire(i int, write bool) (*os.File, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.files[i] == nil {
+		file, err := h.open(i)
+		if err != nil {
+			return nil, err
+		}
+		h.add(i, file)
+	}
+
+	h.lru.MoveToFront(h.uses[i])
+	h.users[i]++
+	if write {
+		h.dirty[i] = true
+	}
+
+	// Only now that it is in use is the new handle safe from eviction
+	h.evict()
+	return h.files[i], nil
+}
+
+// release gives back a handle returned by acquire.
+func (h *fileHandles) release(i int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.users[i]--
+	h.evict()
+}
+
+// put hands an already opened file i over to the cache.
+func (h *fileHandles) put(i int, file *os.File) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.add(i, file)
+	h.evict()
+}
+
+// add records file as the open handle for i, without making room for it.
+// The caller must hold the mutex.
+func (h *fileHandles) add(i int, file *os.File) {
+	h.files[i] = file
+	h.uses[i] = h.lru.PushFront(i)
+}
+
+// evict closes idle handles, least recently used first, until no more than
+// max are open. The caller must hold the mutex.
+func (h *fileHandles) evict() {
+	if h.max <= 0 {
+		return
+	}
+
+	for e := h.lru.Back(); e != nil && h.lru.Len() > h.max; {
+		prev := e.Prev()
+		i := e.Value.(int)
+		if h.users[i] == 0 {
+			// Data written through the handle isn't lost by closing it;
+			// sync reopens dirty files that have been closed
+			h.closeFile(i)
+		}
+		e = prev
+	}
+}
+
+// closeFile closes handle i, which must be open. The caller must hold the
+// mutex.
+func (h *fileHandles) closeFile(i int) error {
+	err := h.files[i].Close()
+	h.lru.Remove(h.uses[i])
+	h.files[i] = nil
+	h.uses[i] = nil
+	return err
+}
+
+// close closes the handle for file i if it is open, e.g. before renaming the
+// file. The handle must not be in use.
+func (h *fileHandles) close(i int) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.files[i] == nil {
+		return nil
+	}
+	return h.closeFile(i)
+}
+
+// sync flushes file i to disk if it has been written since the last sync,
+// reopening it if its handle was closed in the meantime.
+func (h *fileHandles) sync(i int) error {
+	h.mutex.Lock()
+	dirty := h.dirty[i]
+	h.mutex.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	file, err := h.acquire(i, false)
+	if err != nil {
+		return err
+	}
+	defer h.release(i)
+
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	h.dirty[i] = false
+	h.mutex.Unlock()
+	return nil
+}
+
+// closeAll closes every open handle, returning the last error.
+func (h *fileHandles) closeAll(name func(i int) string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var lastError error
+	for i, file := range h.files {
+		if file == nil {
+			continue
+		}
+		err := h.closeFile(i)
+		if err != nil {
+			lastError = fmt.Errorf("failed to close file %s: %w", name(i), err)
+		}
+	}
+	return lastError
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+// openHandles returns how many file handles fs holds open.
+func openHandles(fs *FileStorage) int {
+	fs.handles.mutex.Lock()
+	defer fs.handles.mutex.Unlock()
+	return fs.handles.lru.Len()
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	fs, data, _ := fiveFiles(t, Options{MaxOpenFiles: 2})
+
+	// Piece 0 alone spans four files
+	for i, piece := range [][]byte{data[:4096], data[4096:]} {
+		if err := fs.WritePiece(i, piece); err != nil {
+			t.Fatalf("WritePiece(%d): %v", i, err)
+		}
+		if n := openHandles(fs); n > 2 {
+			t.Errorf("%d files open after writing piece %d, want at most 2", n, i)
+		}
+	}
+
+	// Visit the files out of order, so each read has to reopen a handle
+	// another closed
+	for round := 0; round < 3; round++ {
+		for _, file := range []int{0, 4, 1, 3, 2, 4, 0} {
+			offset := int64(file*1024 + 100*round)
+			got := make([]byte, 300)
+			if _, err := fs.readAt(got, offset); err != nil {
+				t.Fatalf("readAt(%d): %v", offset, err)
+			}
+			if !bytes.Equal(got, data[offset:offset+300]) {
+				t.Errorf("file %d read back different data at %d", file, offset)
+			}
+			if n := openHandles(fs); n > 2 {
+				t.Errorf("%d files open after reading file %d, want at most 2", n, file)
+			}
+		}
+	}
+
+	for i, want := range [][]byte{data[:4096], data[4096:]} {
+		got, err := fs.ReadPiece(i)
+		if err != nil {
+			t.Fatalf("ReadPiece(%d): %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("piece %d read back different data", i)
+		}
+	}
+	if err := fs.Sync(); err != nil {
+		t.Errorf("Sync with closed handles: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrMD5Mismatch is returned by VerifyMD5Sums when a file's contents don't
+// match the md5sum listed for it in the torrent.
+var ErrMD5Mismatch = errors.New("md5sum mismatch")
+
+// VerifyMD5Sums checks every file that has an md5sum in the torrent against
+// its contents on disk, and returns how many files were checked. Few
+// torrents carry md5sums; piece hashes remain the authoritative check.
+func (fs *FileStorage) VerifyMD5Sums() (int, error) {
+	err := fs.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	checked := 0
+	for i := range fs.fileInfos {
+		expected := fs.torrent.Info.GetFileMD5Sum(i)
+		if expected == "" {
+			continue
+		}
+
+		actual, err := fs.fileMD5(i)
+		if err != nil {
+			return checked, fmt.Errorf("failed to hash %s: %w", fs.diskPath(i), err)
+		}
+		checked++
+
+		if !strings.EqualFold(actual, expected) {
+			return checked, fmt.Errorf("%w: %s is %s, expected %s", ErrMD5Mismatch, fs.diskPath(i), actual, expected)
+		}
+	}
+
+	return checked, nil
+}
+
+// fileMD5 returns the hex MD5 of file i's contents
+func (fs *FileStorage) fileMD5(i int) (string, error) {
+	file, err := fs.handles.acquire(i, false)
+	if err != nil {
+		return "", err
+	}
+	defer fs.handles.release(i)
+
+	hash := md5.New()
+	_, err = io.Copy(hash, io.NewSectionReader(file, 0, fs.fileInfos[i].Length))
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
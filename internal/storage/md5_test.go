@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifyMD5Sums(t *testing.T) {
+	data := testData(3 * 1024)
+	tf := testTorrent(data, 1024, 1024, 1024, 1024)
+	sum := func(b []byte) string {
+		hash := md5.Sum(b)
+		return hex.EncodeToString(hash[:])
+	}
+	// File 1 has no md5sum; file 2's is set by each case
+	tf.Info.Files[0].MD5Sum = sum(data[:1024])
+
+	tests := []struct {
+		name    string
+		md5sum2 string
+		checked int
+		err     error
+	}{
+		{"match", sum(data[2048:]), 2, nil},
+		{"upper case match", strings.ToUpper(sum(data[2048:])), 2, nil},
+		{"mismatch", sum(data[:1024]), 2, ErrMD5Mismatch},
+	}
+
+	for _, tc := range tests {
+		tf.Info.Files[2].MD5Sum = tc.md5sum2
+		fs, err := NewFileStorageWithOptions(tf, t.TempDir(), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fs.Close()
+		writeVerified(t, fs, data, 1024)
+
+		checked, err := fs.VerifyMD5Sums()
+		if checked != tc.checked || !errors.Is(err, tc.err) {
+			t.Errorf("%s: checked %d files with error %v, want %d and %v", tc.name, checked, err, tc.checked, tc.err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import "fmt"
+
+// fileMaps holds the torrent's files mapped into memory (see Options.Mmap).
+// A mapping stays valid after the handle it was made from is closed, and
+// after its file is renamed.
+type fileMaps struct {
+	data  [][]byte // Mapping of each file, nil for empty files
+	dirty []bool   // Files written since they were last synced
+}
+
+// mapFiles maps every non-empty file into memory, which setupFiles has
+// already created at its full size. On failure no file is left mapped.
+func (fs *FileStorage) mapFiles() error {
+	maps := &fileMaps{
+		data:  make([][]byte, len(fs.fileInfos)),
+		dirty: make([]bool, len(fs.fileInfos)),
+	}
+
+	for i, fileInfo := range fs.fileInfos {
+		if fileInfo.Length == 0 {
+			continue
+		}
+
+		file, err := fs.handles.acquire(i, false)
+		if err != nil {
+			maps.unmapAll(fs.diskPath)
+			return err
+		}
+		data, err := mapFile(file, fileInfo.Length)
+		fs.handles.release(i)
+		if err != nil {
+			maps.unmapAll(fs.diskPath)
+			return fmt.Errorf("failed to map file %s: %w", fs.diskPath(i), err)
+		}
+		maps.data[i] = data
+	}
+
+	fs.maps = maps
+	return nil
+}
+
+// sync flushes the mapping of file i to disk if it has been written since
+// the last sync.
+func (m *fileMaps) sync(i int) error {
+	if !m.dirty[i] {
+		return nil
+	}
+
+	err := syncMap(m.data[i])
+	if err != nil {
+		return err
+	}
+	m.dirty[i] = false
+	return nil
+}
+
+// unmapAll unmaps every mapped file, returning the last error.
+func (m *fileMaps) unmapAll(name func(i int) string) error {
+	var lastError error
+	for i, data := range m.data {
+		if data == nil {
+			continue
+		}
+		err := unmapFile(data)
+		if err != nil {
+			lastError = fmt.Errorf("failed to unmap file %s: %w", name(i), err)
+		}
+		m.data[i] = nil
+	}
+	return lastError
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapSupported reports whether Options.Mmap has any effect. Here it
+// doesn't: files are read and written with regular I/O.
+const mmapSupported = false
+
+var errNoMmap = errors.New("memory-mapped I/O not supported on this platform")
+
+func mapFile(file *os.File, length int64) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+func syncMap(data []byte) error {
+	return errNoMmap
+}
+
+func unmapFile(data []byte) error {
+	return errNoMmap
+}
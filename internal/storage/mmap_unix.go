@@ -0,0 +1,32 @@
+//go:build unix
+
+package storage
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmapSupported reports whether Options.Mmap has any effect.
+const mmapSupported = true
+
+// mapFile maps the first length bytes of file into memory, shared, so that
+// writes to the mapping reach the file.
+func mapFile(file *os.File, length int64) ([]byte, error) {
+	if int64(int(length)) != length {
+		return nil, fmt.Errorf("%d bytes is too large to map", length)
+	}
+	return unix.Mmap(int(file.Fd()), 0, int(length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
+
+// syncMap writes a mapping's changes to disk, returning once they are there.
+func syncMap(data []byte) error {
+	return unix.Msync(data, unix.MS_SYNC)
+}
+
+// unmapFile unmaps a mapping made by mapFile.
+func unmapFile(data []byte) error {
+	return unix.Munmap(data)
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// MoveTo moves the torrent's files to destDir, keeping a multi-file
+// torrent's directory layout, along with the resume file. The storage then
+// carries on from there: files are reopened (and mapped again, with Mmap)
+// under their new names when next needed. Files are renamed where possible,
+// and copied then deleted across filesystems. Nothing is moved if any of
+// the files already exists in destDir.
+func (fs *FileStorage) MoveTo(destDir string) error {
+	if destDir == "" {
+		destDir = "."
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	from, err := filepath.Abs(fs.baseDir)
+	if err != nil {
+		return err
+	}
+	to, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+
+	// Work out every destination before touching anything
+	destPaths := make([]string, len(fs.fileInfos))
+	for i, fileInfo := range fs.fileInfos {
+		rel, err := filepath.Rel(fs.baseDir, fileInfo.Path)
+		if err != nil {
+			return err
+		}
+		destPaths[i] = filepath.Join(destDir, rel)
+
+		destDiskPath := destPaths[i]
+		if fs.partial[i] {
+			destDiskPath += partSuffix
+		}
+		if _, err := os.Lstat(destDiskPath); err == nil {
+			return fmt.Errorf("%s already exists", destDiskPath)
+		}
+	}
+
+	// Get everything onto disk and let go of the files
+	err = fs.flushAll()
+	if err != nil {
+		return err
+	}
+	for i := range fs.fileInfos {
+		err := fs.syncFile(i)
+		if err != nil {
+			return err
+		}
+	}
+	if fs.maps != nil {
+		err := fs.maps.unmapAll(fs.diskPath)
+		fs.maps = nil
+		if err != nil {
+			return err
+		}
+	}
+	err = fs.handles.closeAll(fs.diskPath)
+	if err != nil {
+		return err
+	}
+
+	// Paths are updated as files move, so a failure part way leaves the
+	// storage pointing at wherever each file is
+	for i, destPath := range destPaths {
+		err := os.MkdirAll(filepath.Dir(destPath), fs.options.DirMode)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(destPath), err)
+		}
+
+		srcDiskPath := fs.diskPath(i)
+		destDiskPath := destPath
+		if fs.partial[i] {
+			destDiskPath += partSuffix
+		}
+		err = moveFile(srcDiskPath, destDiskPath)
+		if err != nil {
+			return fmt.Errorf("failed to move %s: %w", srcDiskPath, err)
+		}
+		fs.fileInfos[i].Path = destPath
+	}
+
+	// The resume file is only worth carrying along; without it the next
+	// run checks the data again
+	oldResumePath := fs.resumePath()
+	oldBaseDir := fs.baseDir
+	fs.baseDir = destDir
+	moveFile(oldResumePath, fs.resumePath())
+	if fs.torrent.Info.IsMultiFile() {
+		removeEmptyDirs(filepath.Join(oldBaseDir, fs.torrent.Info.Name))
+	}
+
+	if fs.options.Mmap && mmapSupported {
+		return fs.mapFiles()
+	}
+	return nil
+}
+
+// moveFile renames src to dst, or copies it and deletes src if they are on
+// different filesystems. A copy keeps the file's permissions and mtime.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	err = copyFile(src, dst)
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst, which must not exist yet.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, stat.ModTime(), stat.ModTime())
+}
+
+// removeEmptyDirs removes root and the directories under it, deepest first,
+// leaving any that still hold something.
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// checkPartial fails the test unless each file is on disk under its .part
+// name if partial says so, and under its final name otherwise.
+func checkPartial(t *testing.T, fs *FileStorage, partial ...bool) {
+	t.Helper()
+	for i, want := range partial {
+		path := fs.fileInfos[i].Path
+		_, partErr := os.Stat(path + partSuffix)
+		_, finalErr := os.Stat(path)
+		if want && (partErr != nil || finalErr == nil) {
+			t.Errorf("file %d: want only %s%s (part: %v, final: %v)", i, path, partSuffix, partErr, finalErr)
+		}
+		if !want && (partErr == nil || finalErr != nil) {
+			t.Errorf("file %d: want only %s (part: %v, final: %v)", i, path, partErr, finalErr)
+		}
+	}
+}
+
+func TestPartFilesRenamedWhenComplete(t *testing.T) {
+	fs, data, _ := fiveFiles(t, Options{PartFiles: true})
+
+	// Written but not yet verified, file 4 keeps its .part name
+	if err := fs.WritePiece(1, data[4096:]); err != nil {
+		t.Fatal(err)
+	}
+	checkPartial(t, fs, true, true, true, true, true)
+
+	if err := fs.MarkPieceVerified(1); err != nil {
+		t.Fatal(err)
+	}
+	checkPartial(t, fs, true, true, true, true, false)
+
+	// Files 0-3 all complete with piece 0
+	if err := fs.WritePiece(0, data[:4096]); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MarkPieceVerified(0); err != nil {
+		t.Fatal(err)
+	}
+	checkPartial(t, fs, false, false, false, false, false)
+
+	for i := range fs.fileInfos {
+		got, err := os.ReadFile(fs.fileInfos[i].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[i*1024:(i+1)*1024]) {
+			t.Errorf("file %d has different data after the rename", i)
+		}
+	}
+
+	// Reads go to the renamed files
+	for i, want := range [][]byte{data[:4096], data[4096:]} {
+		got, err := fs.ReadPiece(i)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("ReadPiece(%d) after the rename: %v", i, err)
+		}
+	}
+}
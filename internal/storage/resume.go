@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+)
+
+const (
+	// resumeBatch and resumeInterval bound how much verification a crash
+	// can lose: the resume file is saved again once this many pieces have
+	// been marked verified since it was last saved, or when a piece is
+	// marked verified this long after it was.
+	resumeBatch    = 16
+	resumeInterval = 30 * time.Second
+)
+
+// fileStamp records what a file looked like when its pieces were verified.
+type fileStamp struct {
+	Size    int64 // File size in bytes
+	ModTime int64 // Modification time in Unix nanoseconds
+}
+
+// SaveResume records the pieces marked verified (see MarkPieceVerified)
+// alongside the current size and mtime of every file, so the next
+// GetCompletionBitfield can skip re-hashing pieces whose files haven't
+// changed. Buffered blocks are written out and every file is synced first,
+// so the resume file never claims a piece that isn't on disk.
+func (fs *FileStorage) SaveResume() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.saveVerified()
+}
+
+// saveVerified does the work of SaveResume. The caller must hold the write
+// lock.
+func (fs *FileStorage) saveVerified() error {
+	err := fs.flushAll()
+	if err != nil {
+		return err
+	}
+
+	for i := range fs.fileInfos {
+		err := fs.syncFile(i)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = fs.saveResume(fs.verified)
+	if err != nil {
+		return err
+	}
+	fs.unsaved = 0
+	fs.savedAt = time.Now()
+	return nil
+}
+
+// saveResume writes the resume file without taking the lock. The new file
+// replaces the old one atomically, so a crash leaves one or the other.
+func (fs *FileStorage) saveResume(verified *pieces.Bitfield) error {
+	stamps, err := fs.statFiles()
+	if err != nil {
+		return err
+	}
+
+	files := make([]interface{}, len(stamps))
+	for i, stamp := range stamps {
+		files[i] = map[string]interface{}{
+			"size":  stamp.Size,
+			"mtime": stamp.ModTime,
+		}
+	}
+
+	var buf bytes.Buffer
+	err = bencode.NewEncoder(&buf).Encode(map[string]interface{}{
+		"info hash": fs.torrent.InfoHash[:],
+		"pieces":    verified.ToBytes(),
+		"files":     files,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode resume data: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn resume
+	// file, and sync it so the rename can't reach the disk before the data
+	path := fs.resumePath()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write resume file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), fs.options.FileMode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write resume file: %w", err)
+	}
+
+	// Best effort: persist the rename itself
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+// loadResume reads the resume file. It returns the pieces verified when it was
+// written and, per file, whether that file is unchanged since. A nil bitfield
+// means there is no usable resume data.
+func (fs *FileStorage) loadResume() (*pieces.Bitfield, []bool) {
+	raw, err := os.ReadFile(fs.resumePath())
+	if err != nil {
+		return nil, nil
+	}
+
+	data, err := bencode.NewDecoder(bytes.NewReader(raw)).DecodeOnly()
+	if err != nil {
+		return nil, nil
+	}
+
+	dict, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	infoHash, ok := dict["info hash"].([]byte)
+	if !ok || !bytes.Equal(infoHash, fs.torrent.InfoHash[:]) {
+		return nil, nil
+	}
+
+	numPieces := fs.torrent.Info.GetNumPieces()
+	pieceBytes, ok := dict["pieces"].([]byte)
+	if !ok || len(pieceBytes) != (numPieces+7)/8 {
+		return nil, nil
+	}
+
+	files, ok := dict["files"].([]interface{})
+	if !ok || len(files) != len(fs.fileInfos) {
+		return nil, nil
+	}
+
+	current, err := fs.statFiles()
+	if err != nil {
+		return nil, nil
+	}
+
+	unchanged := make([]bool, len(files))
+	for i, f := range files {
+		fileDict, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+
+		size, _ := fileDict["size"].(int64)
+		modTime, _ := fileDict["mtime"].(int64)
+		unchanged[i] = current[i] == fileStamp{Size: size, ModTime: modTime}
+	}
+
+	return pieces.NewBitfieldFromBytes(pieceBytes, numPieces), unchanged
+}
+
+// statFiles returns the current size and mtime of every file
+func (fs *FileStorage) statFiles() ([]fileStamp, error) {
+	stamps := make([]fileStamp, len(fs.fileInfos))
+	for i := range fs.fileInfos {
+		stat, err := os.Stat(fs.diskPath(i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", fs.diskPath(i), err)
+		}
+
+		stamps[i] = fileStamp{
+			Size:    stat.Size(),
+			ModTime: stat.ModTime().UnixNano(),
+		}
+	}
+
+	return stamps, nil
+}
+
+// pieceUnchanged reports whether every file a piece touches is unchanged
+func (fs *FileStorage) pieceUnchanged(pieceIndex int, unchanged []bool) bool {
+	start := int64(pieceIndex) * int64(fs.torrent.Info.PieceLength)
+	end := start + int64(fs.getPieceLength(pieceIndex))
+
+	for i, fileInfo := range fs.fileInfos {
+		if fileInfo.Offset < end && fileInfo.Offset+fileInfo.Length > start && !unchanged[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resumePath returns where the resume file for this torrent is kept
+func (fs *FileStorage) resumePath() string {
+	return filepath.Join(fs.baseDir, fmt.Sprintf(".%x.resume", fs.torrent.InfoHash))
+}
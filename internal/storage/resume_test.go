@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeVerified writes every piece of data and marks it verified
+func writeVerified(t *testing.T, fs *FileStorage, data []byte, pieceLength int) {
+	t.Helper()
+	for i := 0; i*pieceLength < len(data); i++ {
+		piece := data[i*pieceLength : min((i+1)*pieceLength, len(data))]
+		if err := fs.WritePiece(i, piece); err != nil {
+			t.Fatalf("WritePiece(%d): %v", i, err)
+		}
+		if err := fs.MarkPieceVerified(i); err != nil {
+			t.Fatalf("MarkPieceVerified(%d): %v", i, err)
+		}
+	}
+}
+
+func TestCloseSavesResume(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(4 * 1024)
+	tf := testTorrent(data, 1024, 2048, 2048)
+
+	fs, err := NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fewer pieces than a batch, so only Close saves them
+	writeVerified(t, fs, data, 1024)
+	if _, err := os.Stat(fs.resumePath()); err == nil {
+		t.Fatal("resume file saved before a batch was complete")
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	cached, unchanged := fs.loadResume()
+	if cached == nil {
+		t.Fatal("no resume data after Close")
+	}
+	for i := 0; i < 4; i++ {
+		if !cached.HasPiece(i) || !fs.pieceUnchanged(i, unchanged) {
+			t.Errorf("piece %d not recorded as verified and unchanged", i)
+		}
+	}
+}
+
+func TestResumeSavedInBatches(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(resumeBatch * 256)
+	tf := testTorrent(data, 256, int64(len(data)))
+
+	fs, err := NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	writeVerified(t, fs, data, 256)
+	cached, _ := fs.loadResume()
+	if cached == nil || !cached.IsComplete() {
+		t.Fatalf("resume file not saved after %d verified pieces", resumeBatch)
+	}
+	if fs.unsaved != 0 {
+		t.Errorf("unsaved = %d after a batch save, want 0", fs.unsaved)
+	}
+}
+
+func TestTornResumeFileIgnored(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(4 * 1024)
+	tf := testTorrent(data, 1024, 1024, 3072)
+
+	fs, err := NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeVerified(t, fs, data, 1024)
+	if err := fs.SaveResume(); err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(fs.resumePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash part way through a save leaves a torn temporary file next to
+	// the last good resume file, which must still be the one used
+	torn := fs.resumePath() + "-crashed"
+	if err := os.WriteFile(torn, good[:len(good)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := fs.loadResume(); cached == nil || !cached.IsComplete() {
+		t.Fatal("last good resume file not used")
+	}
+
+	// A torn resume file itself is ignored, and every piece is hashed
+	if err := os.WriteFile(fs.resumePath(), good[:len(good)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := fs.loadResume(); cached != nil {
+		t.Fatal("torn resume file was loaded")
+	}
+	bitfield, err := fs.GetCompletionBitfield()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bitfield.IsComplete() {
+		t.Errorf("pieces %v missing after a full check", bitfield.GetMissingPieces())
+	}
+	fs.Close()
+
+	// Saving replaced the torn file atomically, leaving no temporary files
+	// of its own behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, filepath.Base(fs.resumePath())+"-") && name != filepath.Base(torn) {
+			t.Errorf("temporary file %s left behind", name)
+		}
+	}
+	if cached, _ := fs.loadResume(); cached == nil || !cached.IsComplete() {
+		t.Error("resume file not rewritten after the full check")
+	}
+}
+
+func TestVerifiedPiecesNotRehashed(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(4 * 1024)
+	tf := testTorrent(data, 1024, 2048, 2048)
+
+	fs, err := NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeVerified(t, fs, data, 1024)
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt both files, but keep the first one's size and mtime. Pieces
+	// 0 and 1 then only still count as complete if they are taken from the
+	// resume file rather than hashed again.
+	for i, keepStamp := range []bool{true, false} {
+		path := filepath.Join(dir, "test", fmt.Sprintf("file%d", i))
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 2048), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := stat.ModTime()
+		if !keepStamp {
+			// Don't rely on the filesystem's timestamp granularity
+			modTime = modTime.Add(time.Second)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs, err = NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	bitfield, err := fs.GetCompletionBitfield()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, true, false, false} {
+		if bitfield.HasPiece(i) != want {
+			t.Errorf("piece %d complete = %v, want %v", i, bitfield.HasPiece(i), want)
+		}
+	}
+}
+
+func TestRecheckFindsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(4 * 1024)
+	tf := testTorrent(data, 1024, 2048, 2048)
+
+	fs, err := NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeVerified(t, fs, data, 1024)
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte of piece 1 without changing the file's size or mtime, so
+	// the resume file still vouches for it
+	path := filepath.Join(dir, "test", "file0")
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), data[:2048]...)
+	corrupt[1024+5] ^= 0xFF
+	if err := os.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bitfield, err := fs.GetCompletionBitfield(); err != nil || !bitfield.IsComplete() {
+		t.Fatalf("resume file not trusted before the recheck (err %v)", err)
+	}
+
+	bitfield, failed, err := fs.Recheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(failed, []int{1}) {
+		t.Errorf("recheck failed pieces %v, want [1]", failed)
+	}
+	if missing := bitfield.GetMissingPieces(); !reflect.DeepEqual(missing, []int{1}) {
+		t.Errorf("recheck left pieces %v missing, want [1]", missing)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The resume file no longer vouches for piece 1
+	fs, err = NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	bitfield, err = fs.GetCompletionBitfield()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := bitfield.GetMissingPieces(); !reflect.DeepEqual(missing, []int{1}) {
+		t.Errorf("after the recheck, pieces %v missing, want [1]", missing)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/yashkadam007/bittorrent-client/internal/pieces"
 	"github.com/yashkadam007/bittorrent-client/internal/torrent"
@@ -14,12 +15,68 @@ import (
 // FileStorage manages reading and writing torrent data to disk.
 // Handles both single-file and multi-file torrents transparently.
 type FileStorage struct {
-	torrent     *torrent.TorrentFile // The torrent metadata
-	baseDir     string               // Base directory for downloads
-	files       []*os.File           // Open file handles
-	fileInfos   []FileInfo           // File metadata and offsets
-	totalLength int64                // Total size of all files
-	mutex       sync.RWMutex         // Protects concurrent access
+	torrent     *torrent.TorrentFile   // The torrent metadata
+	baseDir     string                 // Base directory for downloads
+	handles     *fileHandles           // Open file handles, opened on demand
+	maps        *fileMaps              // Memory-mapped files, nil unless Options.Mmap
+	fileInfos   []FileInfo             // File metadata and offsets
+	totalLength int64                  // Total size of all files
+	options     Options                // Storage configuration
+	pending     map[int]*pendingPiece  // Buffered pieces, when coalescing writes
+	pendingSize int                    // Total bytes buffered in pending
+	spare       [][]byte               // Flushed piece buffers kept for reuse
+	partial     []bool                 // Files still carrying the .part suffix
+	verified    *pieces.Bitfield       // Pieces known to have passed verification
+	unsaved     int                    // Pieces marked verified since the resume file was last saved
+	savedAt     time.Time              // When the resume file was last saved
+	mutex       sync.RWMutex           // Protects concurrent access
+}
+
+// Options configures how FileStorage creates and writes files on disk.
+type Options struct {
+	FileMode os.FileMode // Permission bits for created files (subject to umask)
+	DirMode  os.FileMode // Permission bits for created directories (subject to umask)
+
+	// WriteBuffer, when positive, makes WriteBlock buffer blocks in memory and
+	// write each piece out in as few contiguous writes as possible once it is
+	// complete, or once buffers for more than this many bytes of pieces are
+	// held. Should be several pieces long to be effective.
+	WriteBuffer int
+
+	// Verifier hashes pieces during GetCompletionBitfield. Nil means one
+	// verification per CPU.
+	Verifier *pieces.Verifier
+
+	// PartFiles, when set, keeps a .part suffix on each file until every
+	// piece it covers is verified (see MarkPieceVerified), so other programs
+	// don't mistake a partial download for a finished one.
+	PartFiles bool
+
+	// MaxOpenFiles caps how many of the torrent's files are held open at
+	// once; the least recently used is closed to make room for another.
+	// Zero means no limit.
+	MaxOpenFiles int
+
+	// Mmap, when set, maps each file into memory, so that blocks are read
+	// and written by copying rather than with a system call each; Sync
+	// flushes the mappings with msync. It is ignored on platforms without
+	// memory-mapped I/O, which keep using regular reads and writes.
+	Mmap bool
+
+	// Allocation is how space is made for each file when it is created or
+	// has the wrong size: sparse (the default), fully reserved, or not at
+	// all. Files already at their full size are left alone. With Mmap,
+	// AllocNone is treated as AllocSparse, since mappings need the whole
+	// file.
+	Allocation Allocation
+}
+
+// DefaultOptions returns the storage options used by NewFileStorage.
+func DefaultOptions() Options {
+	return Options{
+		FileMode: 0644,
+		DirMode:  0755,
+	}
 }
 
 // FileInfo contains metadata about a file in the torrent.
@@ -31,14 +88,36 @@ type FileInfo struct {
 
 // NewFileStorage creates a new file storage instance for the given torrent.
 func NewFileStorage(t *torrent.TorrentFile, baseDir string) (*FileStorage, error) {
+	return NewFileStorageWithOptions(t, baseDir, DefaultOptions())
+}
+
+// NewFileStorageWithOptions creates a new file storage instance with additional options.
+// Zero modes fall back to the defaults. Modes only apply to files and directories
+// this call creates, and the process umask is applied on top of them.
+func NewFileStorageWithOptions(t *torrent.TorrentFile, baseDir string, options Options) (*FileStorage, error) {
 	if baseDir == "" {
 		baseDir = "."
 	}
 
+	defaults := DefaultOptions()
+	if options.FileMode == 0 {
+		options.FileMode = defaults.FileMode
+	}
+	if options.DirMode == 0 {
+		options.DirMode = defaults.DirMode
+	}
+	if options.Verifier == nil {
+		options.Verifier = pieces.NewVerifier(0)
+	}
+
 	fs := &FileStorage{
 		torrent:     t,
 		baseDir:     baseDir,
 		totalLength: t.Info.GetTotalLength(),
+		options:     options,
+		pending:     make(map[int]*pendingPiece),
+		verified:    pieces.NewBitfield(t.Info.GetNumPieces()),
+		savedAt:     time.Now(),
 	}
 
 	err := fs.setupFiles()
@@ -54,7 +133,7 @@ func (fs *FileStorage) setupFiles() error {
 	if fs.torrent.Info.IsMultiFile() {
 		// Multi-file torrent
 		baseDir := filepath.Join(fs.baseDir, fs.torrent.Info.Name)
-		err := os.MkdirAll(baseDir, 0755)
+		err := os.MkdirAll(baseDir, fs.options.DirMode)
 		if err != nil {
 			return fmt.Errorf("failed to create base directory: %w", err)
 		}
@@ -66,7 +145,7 @@ func (fs *FileStorage) setupFiles() error {
 			
 			// Create directory if needed
 			dir := filepath.Dir(fullPath)
-			err := os.MkdirAll(dir, 0755)
+			err := os.MkdirAll(dir, fs.options.DirMode)
 			if err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", dir, err)
 			}
@@ -86,7 +165,7 @@ func (fs *FileStorage) setupFiles() error {
 		// Create directory if needed
 		dir := filepath.Dir(fullPath)
 		if dir != "." {
-			err := os.MkdirAll(dir, 0755)
+			err := os.MkdirAll(dir, fs.options.DirMode)
 			if err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", dir, err)
 			}
@@ -99,36 +178,69 @@ func (fs *FileStorage) setupFiles() error {
 		})
 	}
 
-	// Open all files
-	fs.files = make([]*os.File, len(fs.fileInfos))
+	// Create every file up front; the handle cache then keeps as many open
+	// as it is allowed to and reopens the rest when they're needed
+	fs.handles = newFileHandles(len(fs.fileInfos), fs.options.MaxOpenFiles, fs.openFile)
+	fs.partial = make([]bool, len(fs.fileInfos))
 	for i, fileInfo := range fs.fileInfos {
-		file, err := os.OpenFile(fileInfo.Path, os.O_CREATE|os.O_RDWR, 0644)
+		fs.partial[i] = fs.startsPartial(i)
+		file, err := os.OpenFile(fs.diskPath(i), os.O_CREATE|os.O_RDWR, fs.options.FileMode)
 		if err != nil {
 			// Close already opened files
-			for j := 0; j < i; j++ {
-				fs.files[j].Close()
-			}
-			return fmt.Errorf("failed to open file %s: %w", fileInfo.Path, err)
+			fs.handles.closeAll(fs.diskPath)
+			return fmt.Errorf("failed to open file %s: %w", fs.diskPath(i), err)
 		}
 
-		// Ensure file has correct size
-		err = file.Truncate(fileInfo.Length)
+		// Ensure file has correct size. Truncating bumps the mtime even when
+		// the size doesn't change, which would invalidate the resume data.
+		stat, err := file.Stat()
+		if err == nil && stat.Size() != fileInfo.Length {
+			err = fs.allocate(file, stat.Size(), fileInfo.Length)
+		}
 		if err != nil {
 			file.Close()
-			return fmt.Errorf("failed to set file size for %s: %w", fileInfo.Path, err)
+			fs.handles.closeAll(fs.diskPath)
+			return fmt.Errorf("failed to set file size for %s: %w", fs.diskPath(i), err)
 		}
 
-		fs.files[i] = file
+		fs.handles.put(i, file)
+	}
+
+	if fs.options.Mmap && mmapSupported {
+		err := fs.mapFiles()
+		if err != nil {
+			fs.handles.closeAll(fs.diskPath)
+			return err
+		}
 	}
 
 	return nil
 }
 
+// openFile reopens file i, which setupFiles has already created.
+func (fs *FileStorage) openFile(i int) (*os.File, error) {
+	file, err := os.OpenFile(fs.diskPath(i), os.O_RDWR, fs.options.FileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", fs.diskPath(i), err)
+	}
+	return file, nil
+}
+
 // ReadPiece reads a complete piece from the files on disk.
 func (fs *FileStorage) ReadPiece(pieceIndex int) ([]byte, error) {
+	err := fs.FlushPiece(pieceIndex)
+	if err != nil {
+		return nil, err
+	}
+
 	fs.mutex.RLock()
 	defer fs.mutex.RUnlock()
 
+	return fs.readPiece(pieceIndex)
+}
+
+// readPiece reads a piece without taking the lock
+func (fs *FileStorage) readPiece(pieceIndex int) ([]byte, error) {
 	if pieceIndex < 0 || pieceIndex >= fs.torrent.Info.GetNumPieces() {
 		return nil, fmt.Errorf("piece index %d out of range", pieceIndex)
 	}
@@ -171,6 +283,11 @@ func (fs *FileStorage) WritePiece(pieceIndex int, data []byte) error {
 
 // ReadBlock reads a block from storage
 func (fs *FileStorage) ReadBlock(pieceIndex, begin, length int) ([]byte, error) {
+	err := fs.FlushPiece(pieceIndex)
+	if err != nil {
+		return nil, err
+	}
+
 	fs.mutex.RLock()
 	defer fs.mutex.RUnlock()
 
@@ -190,7 +307,7 @@ func (fs *FileStorage) ReadBlock(pieceIndex, begin, length int) ([]byte, error)
 	offset := int64(pieceIndex)*int64(fs.torrent.Info.PieceLength) + int64(begin)
 	data := make([]byte, length)
 	
-	_, err := fs.readAt(data, offset)
+	_, err = fs.readAt(data, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read block: %w", err)
 	}
@@ -216,6 +333,10 @@ func (fs *FileStorage) WriteBlock(pieceIndex, begin int, data []byte) error {
 		return fmt.Errorf("block extends beyond piece boundary")
 	}
 
+	if fs.options.WriteBuffer > 0 {
+		return fs.bufferBlock(pieceIndex, begin, data)
+	}
+
 	offset := int64(pieceIndex)*int64(fs.torrent.Info.PieceLength) + int64(begin)
 	_, err := fs.writeAt(data, offset)
 	if err != nil {
@@ -225,7 +346,9 @@ func (fs *FileStorage) WriteBlock(pieceIndex, begin int, data []byte) error {
 	return nil
 }
 
-// readAt reads data from the specified offset across multiple files
+// readAt reads data from the specified offset across multiple files.
+// A piece may span any number of files (including zero-length ones, which
+// are skipped); each file contributes the part of the range it covers.
 func (fs *FileStorage) readAt(data []byte, offset int64) (int, error) {
 	if offset < 0 || offset >= fs.totalLength {
 		return 0, fmt.Errorf("offset %d out of range", offset)
@@ -251,7 +374,7 @@ func (fs *FileStorage) readAt(data []byte, offset int64) (int, error) {
 		}
 
 		// Read from file
-		n, err := fs.files[i].ReadAt(data[totalRead:totalRead+maxRead], fileOffset)
+		n, err := fs.readFile(i, data[totalRead:totalRead+maxRead], fileOffset)
 		totalRead += n
 
 		if err != nil && err != io.EOF {
@@ -266,10 +389,15 @@ func (fs *FileStorage) readAt(data []byte, offset int64) (int, error) {
 		}
 	}
 
+	if remaining > 0 {
+		return totalRead, io.ErrUnexpectedEOF
+	}
+
 	return totalRead, nil
 }
 
-// writeAt writes data to the specified offset across multiple files
+// writeAt writes data to the specified offset across multiple files.
+// Spans are handled the same way as in readAt.
 func (fs *FileStorage) writeAt(data []byte, offset int64) (int, error) {
 	if offset < 0 || offset >= fs.totalLength {
 		return 0, fmt.Errorf("offset %d out of range", offset)
@@ -295,7 +423,7 @@ func (fs *FileStorage) writeAt(data []byte, offset int64) (int, error) {
 		}
 
 		// Write to file
-		n, err := fs.files[i].WriteAt(data[totalWritten:totalWritten+maxWrite], fileOffset)
+		n, err := fs.writeFile(i, data[totalWritten:totalWritten+maxWrite], fileOffset)
 		totalWritten += n
 
 		if err != nil {
@@ -310,20 +438,62 @@ func (fs *FileStorage) writeAt(data []byte, offset int64) (int, error) {
 		}
 	}
 
+	if remaining > 0 {
+		return totalWritten, io.ErrShortWrite
+	}
+
 	return totalWritten, nil
 }
 
+// readFile reads from file i at fileOffset, through its mapping if it has
+// one.
+func (fs *FileStorage) readFile(i int, data []byte, fileOffset int64) (int, error) {
+	if fs.maps != nil {
+		return copy(data, fs.maps.data[i][fileOffset:]), nil
+	}
+
+	file, err := fs.handles.acquire(i, false)
+	if err != nil {
+		return 0, err
+	}
+	defer fs.handles.release(i)
+
+	return file.ReadAt(data, fileOffset)
+}
+
+// writeFile writes to file i at fileOffset, through its mapping if it has
+// one. The caller must hold the write lock.
+func (fs *FileStorage) writeFile(i int, data []byte, fileOffset int64) (int, error) {
+	if fs.maps != nil {
+		fs.maps.dirty[i] = true
+		return copy(fs.maps.data[i][fileOffset:], data), nil
+	}
+
+	file, err := fs.handles.acquire(i, true)
+	if err != nil {
+		return 0, err
+	}
+	defer fs.handles.release(i)
+
+	return file.WriteAt(data, fileOffset)
+}
+
+// syncFile flushes file i to disk, and its mapping if it has one. The
+// caller must hold the write lock.
+func (fs *FileStorage) syncFile(i int) error {
+	err := fs.handles.sync(i)
+	if err == nil && fs.maps != nil {
+		err = fs.maps.sync(i)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync file %s: %w", fs.diskPath(i), err)
+	}
+	return nil
+}
+
 // getPieceLength returns the length of a specific piece
 func (fs *FileStorage) getPieceLength(pieceIndex int) int {
-	if pieceIndex == fs.torrent.Info.GetNumPieces()-1 {
-		// Last piece might be shorter
-		lastPieceLength := int(fs.totalLength % int64(fs.torrent.Info.PieceLength))
-		if lastPieceLength == 0 {
-			return int(fs.torrent.Info.PieceLength)
-		}
-		return lastPieceLength
-	}
-	return int(fs.torrent.Info.PieceLength)
+	return int(torrent.PieceLength(pieceIndex, fs.torrent.Info.GetNumPieces(), fs.torrent.Info.PieceLength, fs.totalLength))
 }
 
 // Sync flushes all file buffers to disk
@@ -331,39 +501,111 @@ func (fs *FileStorage) Sync() error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 
-	for i, file := range fs.files {
-		if file != nil {
-			err := file.Sync()
-			if err != nil {
-				return fmt.Errorf("failed to sync file %s: %w", fs.fileInfos[i].Path, err)
-			}
+	err := fs.flushAll()
+	if err != nil {
+		return err
+	}
+
+	for i := range fs.fileInfos {
+		err := fs.syncFile(i)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// Close closes all open files
+// Close closes all open files, saving the resume file first if pieces
+// were marked verified since it was last saved
 func (fs *FileStorage) Close() error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 
-	var lastError error
-	for i, file := range fs.files {
-		if file != nil {
-			err := file.Close()
-			if err != nil {
-				lastError = fmt.Errorf("failed to close file %s: %w", fs.fileInfos[i].Path, err)
-			}
-			fs.files[i] = nil
+	lastError := fs.flushAll()
+	if lastError == nil && fs.unsaved > 0 {
+		lastError = fs.saveVerified()
+	}
+	if fs.maps != nil {
+		err := fs.maps.unmapAll(fs.diskPath)
+		if err != nil {
+			lastError = err
 		}
+		fs.maps = nil
+	}
+	err := fs.handles.closeAll(fs.diskPath)
+	if err != nil {
+		lastError = err
 	}
 
 	return lastError
 }
 
-// GetCompletionBitfield scans existing files to determine which pieces are complete
+// GetCompletionBitfield scans existing files to determine which pieces are complete.
+// Pieces whose files are unchanged since the resume file was written are taken
+// from it; everything else is re-hashed. The result is saved as the new resume file,
+// and complete pieces are marked verified.
 func (fs *FileStorage) GetCompletionBitfield() (*pieces.Bitfield, error) {
+	bitfield, err := fs.scanPieces(true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.markVerified(bitfield)
+	if err != nil {
+		return nil, err
+	}
+
+	return bitfield, nil
+}
+
+// Recheck re-hashes every piece, ignoring the resume file, and saves the
+// result as the new resume file. It returns the pieces that are complete and,
+// in order, those that were recorded as verified (in the resume file or
+// through MarkPieceVerified) but failed the check.
+func (fs *FileStorage) Recheck() (*pieces.Bitfield, []int, error) {
+	fs.mutex.RLock()
+	previous, _ := fs.loadResume()
+	fs.mutex.RUnlock()
+
+	bitfield, err := fs.scanPieces(false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if previous != nil {
+		previous = previous.Or(fs.verified)
+	} else {
+		previous = fs.verified
+	}
+
+	var failed []int
+	for _, i := range previous.GetAvailablePieces() {
+		if !bitfield.HasPiece(i) {
+			failed = append(failed, i)
+		}
+	}
+
+	fs.verified = bitfield.Clone()
+	err = fs.finishFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bitfield, failed, nil
+}
+
+// scanPieces works out which pieces are complete for GetCompletionBitfield.
+// With useResume unset every piece is hashed, whatever the resume file says.
+func (fs *FileStorage) scanPieces(useResume bool) (*pieces.Bitfield, error) {
+	err := fs.Flush()
+	if err != nil {
+		return nil, err
+	}
+
 	fs.mutex.RLock()
 	defer fs.mutex.RUnlock()
 
@@ -375,18 +617,57 @@ func (fs *FileStorage) GetCompletionBitfield() (*pieces.Bitfield, error) {
 		return nil, fmt.Errorf("failed to get piece hashes: %w", err)
 	}
 
+	var cached *pieces.Bitfield
+	var unchanged []bool
+	if useResume {
+		cached, unchanged = fs.loadResume()
+	}
+
+	// Hash pieces on as many workers as the verifier allows. Each worker
+	// holds one piece at a time, so memory stays bounded however large the
+	// torrent is.
+	verifier := fs.options.Verifier
+	toCheck := make(chan int)
+	var bitfieldMutex sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < verifier.Concurrency(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range toCheck {
+				data, err := fs.readPiece(i)
+				if err != nil {
+					continue // Piece not available
+				}
+
+				// Verify hash
+				if verifier.Verify(data, pieceHashes[i]) {
+					bitfieldMutex.Lock()
+					bitfield.SetPiece(i)
+					bitfieldMutex.Unlock()
+				}
+			}
+		}()
+	}
+
 	// Check each piece
 	for i := 0; i < numPieces; i++ {
-		data, err := fs.ReadPiece(i)
-		if err != nil {
-			continue // Piece not available
+		if cached != nil && fs.pieceUnchanged(i, unchanged) {
+			if cached.HasPiece(i) {
+				bitfieldMutex.Lock()
+				bitfield.SetPiece(i)
+				bitfieldMutex.Unlock()
+			}
+			continue
 		}
 
-		// Verify hash
-		if pieces.VerifyPieceHash(data, pieceHashes[i]) {
-			bitfield.SetPiece(i)
-		}
+		toCheck <- i
 	}
+	close(toCheck)
+	wg.Wait()
+
+	// Best effort: a missing resume file only costs a full check next time
+	fs.saveResume(bitfield)
 
 	return bitfield, nil
 }
@@ -406,26 +687,64 @@ func (fs *FileStorage) GetTotalLength() int64 {
 	return fs.totalLength
 }
 
-// GetProgress returns the current download progress by checking file sizes
-func (fs *FileStorage) GetProgress() (int64, int64, error) {
+// GetBytesOnDisk returns how many bytes the files take up on disk (each
+// counted up to its length) and the torrent's total length. Files are
+// preallocated or written out of order, so this is not download progress;
+// see pieces.Progress for that.
+func (fs *FileStorage) GetBytesOnDisk() (int64, int64, error) {
 	fs.mutex.RLock()
 	defer fs.mutex.RUnlock()
 
 	var downloaded int64
 	for i, fileInfo := range fs.fileInfos {
-		if fs.files[i] != nil {
-			stat, err := fs.files[i].Stat()
-			if err != nil {
+		stat, err := os.Stat(fs.diskPath(i))
+		if err != nil {
+			continue
+		}
+
+		fileSize := stat.Size()
+		if fileSize > fileInfo.Length {
+			fileSize = fileInfo.Length
+		}
+		downloaded += fileSize
+	}
+
+	return downloaded, fs.totalLength, nil
+}
+
+// FileProgress is how much of one file has been downloaded.
+type FileProgress struct {
+	Path       string // Path of the file within the torrent
+	Downloaded int64  // Bytes of the file in verified pieces
+	Total      int64  // File size in bytes
+}
+
+// GetPerFileProgress returns the progress of each file, in torrent order,
+// from the pieces verified so far. A piece spanning several files counts
+// toward each of them by the bytes it holds of that file.
+func (fs *FileStorage) GetPerFileProgress() []FileProgress {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	info := &fs.torrent.Info
+	result := make([]FileProgress, len(fs.fileInfos))
+	for i, fileInfo := range fs.fileInfos {
+		result[i] = FileProgress{Path: info.Name, Total: fileInfo.Length}
+		if info.IsMultiFile() {
+			result[i].Path = filepath.Join(info.Files[i].Path...)
+		}
+
+		fileEnd := fileInfo.Offset + fileInfo.Length
+		start, end := info.GetFilePieces(i)
+		for pieceIndex := start; pieceIndex < end; pieceIndex++ {
+			if !fs.verified.HasPiece(pieceIndex) {
 				continue
 			}
-			
-			fileSize := stat.Size()
-			if fileSize > fileInfo.Length {
-				fileSize = fileInfo.Length
-			}
-			downloaded += fileSize
+			pieceStart := int64(pieceIndex) * info.PieceLength
+			pieceEnd := pieceStart + int64(fs.getPieceLength(pieceIndex))
+			result[i].Downloaded += min(pieceEnd, fileEnd) - max(pieceStart, fileInfo.Offset)
 		}
 	}
 
-	return downloaded, fs.totalLength, nil
+	return result
 }
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+)
+
+// testTorrent builds a multi-file torrent over data, split into files of the
+// given lengths, with real piece hashes.
+func testTorrent(data []byte, pieceLength int, fileLengths ...int64) *torrent.TorrentFile {
+	t := &torrent.TorrentFile{
+		Info: torrent.TorrentInfo{
+			Name:        "test",
+			PieceLength: int64(pieceLength),
+		},
+	}
+	for i, length := range fileLengths {
+		t.Info.Files = append(t.Info.Files, torrent.FileInfo{
+			Length: length,
+			Path:   []string{fmt.Sprintf("file%d", i)},
+		})
+	}
+	for start := 0; start < len(data); start += pieceLength {
+		hash := sha1.Sum(data[start:min(start+pieceLength, len(data))])
+		t.Info.Pieces = append(t.Info.Pieces, hash[:]...)
+	}
+	t.InfoHash = sha1.Sum(t.Info.Pieces)
+	return t
+}
+
+// testData returns n reproducible pseudo-random bytes
+func testData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// fiveFiles is five 1KB files under 4KB pieces: piece 0 covers files 0-3,
+// piece 1 is file 4 alone.
+func fiveFiles(t *testing.T, options Options) (*FileStorage, []byte, string) {
+	t.Helper()
+	dir := t.TempDir()
+	data := testData(5 * 1024)
+	tf := testTorrent(data, 4096, 1024, 1024, 1024, 1024, 1024)
+
+	fs, err := NewFileStorageWithOptions(tf, dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs, data, dir
+}
+
+func TestReadWriteAcrossFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int64
+		length int
+	}{
+		{"within one file", 100, 500},
+		{"ends on a boundary", 512, 512},
+		{"starts on a boundary", 1024, 700},
+		{"two files", 900, 300},
+		{"three files", 512, 2048},
+		{"a whole middle file", 1024, 1024},
+		{"whole piece", 0, 4096},
+		{"last file", 4096, 1024},
+		{"every file", 0, 5 * 1024},
+	}
+
+	for _, mmap := range []bool{false, true} {
+		for _, tc := range tests {
+			t.Run(fmt.Sprintf("%s/mmap=%v", tc.name, mmap), func(t *testing.T) {
+				fs, data, _ := fiveFiles(t, Options{Mmap: mmap})
+				want := data[tc.offset : tc.offset+int64(tc.length)]
+
+				n, err := fs.writeAt(want, tc.offset)
+				if err != nil || n != tc.length {
+					t.Fatalf("writeAt = %d, %v; want %d, nil", n, err, tc.length)
+				}
+
+				got := make([]byte, tc.length)
+				n, err = fs.readAt(got, tc.offset)
+				if err != nil || n != tc.length {
+					t.Fatalf("readAt = %d, %v; want %d, nil", n, err, tc.length)
+				}
+				if !bytes.Equal(got, want) {
+					t.Error("read back different data")
+				}
+			})
+		}
+	}
+}
+
+func TestBlockSpanningThreeFiles(t *testing.T) {
+	fs, data, dir := fiveFiles(t, Options{})
+
+	// Bytes 512-2559 of piece 0: the end of file 0, all of file 1 and the
+	// start of file 2
+	block := data[512:2560]
+	if err := fs.WriteBlock(0, 512, block); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadBlock(0, 512, len(block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, block) {
+		t.Error("ReadBlock returned different data")
+	}
+
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range [][]byte{
+		append(make([]byte, 512), data[512:1024]...),
+		data[1024:2048],
+		append(append([]byte{}, data[2048:2560]...), make([]byte, 512)...),
+		make([]byte, 1024),
+	} {
+		onDisk, err := os.ReadFile(filepath.Join(dir, "test", fmt.Sprintf("file%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(onDisk, want) {
+			t.Errorf("file%d holds the wrong bytes", i)
+		}
+	}
+}
+
+func TestPieceAcrossFiles(t *testing.T) {
+	fs, data, _ := fiveFiles(t, Options{})
+
+	for i, piece := range [][]byte{data[:4096], data[4096:]} {
+		if err := fs.WritePiece(i, piece); err != nil {
+			t.Fatalf("WritePiece(%d): %v", i, err)
+		}
+	}
+	for i, want := range [][]byte{data[:4096], data[4096:]} {
+		got, err := fs.ReadPiece(i)
+		if err != nil {
+			t.Fatalf("ReadPiece(%d): %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("piece %d read back different data", i)
+		}
+	}
+
+	bitfield, err := fs.GetCompletionBitfield()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bitfield.IsComplete() {
+		t.Errorf("pieces %v failed verification", bitfield.GetMissingPieces())
+	}
+}
+
+func TestReadWriteOutOfRange(t *testing.T) {
+	fs, _, _ := fiveFiles(t, Options{})
+
+	if _, err := fs.writeAt([]byte{1}, 5*1024); err == nil {
+		t.Error("writeAt past the end succeeded")
+	}
+	if _, err := fs.writeAt(make([]byte, 2), 5*1024-1); err == nil {
+		t.Error("writeAt running past the end succeeded")
+	}
+	if _, err := fs.readAt(make([]byte, 2), 5*1024-1); err == nil {
+		t.Error("readAt running past the end succeeded")
+	}
+	if err := fs.WriteBlock(0, 4000, make([]byte, 200)); err == nil {
+		t.Error("WriteBlock beyond the piece boundary succeeded")
+	}
+}
+
+func TestPieceLengthsAgree(t *testing.T) {
+	tests := []struct {
+		name        string
+		totalLength int
+		pieceLength int
+		want        []int64 // Length of each piece
+	}{
+		{"exact multiple", 4096, 1024, []int64{1024, 1024, 1024, 1024}},
+		{"remainder", 4000, 1024, []int64{1024, 1024, 1024, 928}},
+		{"single short piece", 500, 1024, []int64{500}},
+		{"single full piece", 1024, 1024, []int64{1024}},
+	}
+
+	for _, tc := range tests {
+		data := testData(tc.totalLength)
+		tf := testTorrent(data, tc.pieceLength, int64(tc.totalLength))
+		fs, err := NewFileStorageWithOptions(tf, t.TempDir(), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fs.Close()
+		hashes, err := tf.Info.GetPieceHashes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm := pieces.NewPieceManagerWithOptions(tc.pieceLength, int64(tc.totalLength), hashes, true)
+
+		// One past each end as well, which every call site reports as 0
+		for i := -1; i <= len(tc.want); i++ {
+			var want int64
+			if i >= 0 && i < len(tc.want) {
+				want = tc.want[i]
+			}
+			info, storage, manager := tf.Info.GetPieceLength(i), int64(fs.getPieceLength(i)), int64(pm.GetPieceLength(i))
+			if info != want || storage != want || manager != want {
+				t.Errorf("%s: piece %d is %d bytes in the torrent, %d in storage and %d in the piece manager, want %d",
+					tc.name, i, info, storage, manager, want)
+			}
+		}
+		if got := tf.Info.GetLastPieceLength(); got != tc.want[len(tc.want)-1] {
+			t.Errorf("%s: GetLastPieceLength = %d, want %d", tc.name, got, tc.want[len(tc.want)-1])
+		}
+	}
+}
+
+// umask returns the process's file mode creation mask, found by creating a
+// file that asks for every permission bit.
+func umask(t *testing.T) os.FileMode {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "umask")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0777 &^ info.Mode().Perm()
+}
+
+func TestCreatedModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no Unix permission bits")
+	}
+	mask := umask(t)
+
+	tests := []struct {
+		fileMode, dirMode os.FileMode
+	}{
+		{0640, 0750},
+		{0666, 0777}, // Left to the umask
+		{0600, 0700},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		data := testData(3 * 1024)
+		tf := testTorrent(data, 1024, 1024, 1024, 1024)
+		tf.Info.Files[1].Path = []string{"sub", "file1"}
+
+		fs, err := NewFileStorageWithOptions(tf, dir, Options{FileMode: tt.fileMode, DirMode: tt.dirMode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs.Close()
+
+		want := map[string]os.FileMode{
+			"test":           os.ModeDir | tt.dirMode&^mask,
+			"test/sub":       os.ModeDir | tt.dirMode&^mask,
+			"test/file0":     tt.fileMode &^ mask,
+			"test/sub/file1": tt.fileMode &^ mask,
+			"test/file2":     tt.fileMode &^ mask,
+		}
+		for path, mode := range want {
+			info, err := os.Stat(filepath.Join(dir, path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode() & (os.ModeDir | os.ModePerm); got != mode {
+				t.Errorf("%s created %v with modes %v/%v, want %v (umask %03o)",
+					path, got, tt.fileMode, tt.dirMode, mode, mask)
+			}
+		}
+	}
+}
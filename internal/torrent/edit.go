@@ -0,0 +1,187 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+)
+
+// Editor changes the trackers of a .torrent file. Everything else in the
+// file, in particular the info dictionary, is written back byte-for-byte,
+// so the info hash is preserved.
+type Editor struct {
+	path         string                      // File the torrent was loaded from
+	fields       map[string]bencode.RawValue // Top-level entries as they were encoded
+	infoHash     [20]byte                    // Info hash of the original file
+	Announce     string                      // Primary tracker URL
+	AnnounceList [][]string                  // Tracker tiers
+}
+
+// Edit loads a .torrent file for editing.
+func Edit(path string) (*Editor, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open torrent file: %w", err)
+	}
+
+	t, err := parseTorrent(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := bencode.SplitDict(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode torrent file: %w", err)
+	}
+
+	return &Editor{
+		path:         path,
+		fields:       fields,
+		infoHash:     t.InfoHash,
+		Announce:     t.Announce,
+		AnnounceList: t.AnnounceList,
+	}, nil
+}
+
+// InfoHash returns the torrent's info hash, which editing never changes.
+func (e *Editor) InfoHash() [20]byte {
+	return e.infoHash
+}
+
+// AddTracker adds a tracker in a tier of its own, after the existing ones.
+// A torrent without trackers gets it as its primary tracker. Adding a tracker
+// that is already listed does nothing.
+func (e *Editor) AddTracker(url string) {
+	if e.hasTracker(url) {
+		return
+	}
+
+	if e.Announce == "" && len(e.AnnounceList) == 0 {
+		e.Announce = url
+		return
+	}
+
+	// Clients that understand announce-list ignore announce, so the primary
+	// tracker has to be carried over before the list becomes non-empty
+	if len(e.AnnounceList) == 0 && e.Announce != "" {
+		e.AnnounceList = [][]string{{e.Announce}}
+	}
+	e.AnnounceList = append(e.AnnounceList, []string{url})
+}
+
+// RemoveTracker removes a tracker from announce and every tier. Tiers left
+// empty are dropped, and if the primary tracker was removed the first
+// remaining one takes its place. Returns false if the tracker wasn't listed.
+func (e *Editor) RemoveTracker(url string) bool {
+	found := false
+
+	var tiers [][]string
+	for _, tier := range e.AnnounceList {
+		var kept []string
+		for _, tracker := range tier {
+			if tracker == url {
+				found = true
+				continue
+			}
+			kept = append(kept, tracker)
+		}
+		if len(kept) > 0 {
+			tiers = append(tiers, kept)
+		}
+	}
+	e.AnnounceList = tiers
+
+	if e.Announce == url {
+		found = true
+		e.Announce = ""
+		if len(tiers) > 0 {
+			e.Announce = tiers[0][0]
+		}
+	}
+
+	return found
+}
+
+// hasTracker reports whether url is already listed anywhere.
+func (e *Editor) hasTracker(url string) bool {
+	if e.Announce == url {
+		return true
+	}
+	for _, tier := range e.AnnounceList {
+		for _, tracker := range tier {
+			if tracker == url {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Save writes the torrent back to the file it was loaded from.
+func (e *Editor) Save() error {
+	return e.SaveAs(e.path)
+}
+
+// SaveAs writes the torrent to path. The result is parsed again before it is
+// written, and rejected if its info hash doesn't match the original.
+func (e *Editor) SaveAs(path string) error {
+	entries := make(map[string]interface{}, len(e.fields)+2)
+	for key, value := range e.fields {
+		entries[key] = value
+	}
+
+	delete(entries, "announce")
+	if e.Announce != "" {
+		entries["announce"] = e.Announce
+	}
+
+	delete(entries, "announce-list")
+	if len(e.AnnounceList) > 0 {
+		entries["announce-list"] = e.AnnounceList
+	}
+
+	var buf bytes.Buffer
+	err := bencode.NewEncoder(&buf).Encode(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode torrent: %w", err)
+	}
+
+	t, err := parseTorrent(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("edited torrent does not parse: %w", err)
+	}
+	if t.InfoHash != e.infoHash || t.InfoHash != sha1.Sum(e.fields["info"]) {
+		return fmt.Errorf("edited torrent changed the info hash")
+	}
+
+	// Write to a temporary file first so a failed write never truncates the original
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".torrent-edit-*")
+	if err != nil {
+		return fmt.Errorf("failed to save torrent: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		mode := os.FileMode(0644)
+		if stat, statErr := os.Stat(path); statErr == nil {
+			mode = stat.Mode().Perm()
+		}
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save torrent: %w", err)
+	}
+
+	return nil
+}
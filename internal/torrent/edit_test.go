@@ -0,0 +1,78 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+)
+
+func TestEditAddTrackerKeepsInfoHash(t *testing.T) {
+	// Keys this package doesn't know about, inside info and out, have to
+	// survive the edit too
+	info := testInfo()
+	info["x-source"] = "test"
+	raw := encode(t, map[string]interface{}{
+		"announce":   "http://tracker.example.com/announce",
+		"comment":    "kept",
+		"x-unknown":  []interface{}{1, "two"},
+		"info":       info,
+		"created by": "test",
+	})
+	path := filepath.Join(t.TempDir(), "test.torrent")
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := bencode.SplitDict(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHash := sha1.Sum(fields["info"])
+
+	editor, err := Edit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if editor.InfoHash() != wantHash {
+		t.Fatalf("info hash %x, want %x", editor.InfoHash(), wantHash)
+	}
+	editor.AddTracker("udp://backup.example.com:6969")
+	editor.AddTracker("http://tracker.example.com/announce")
+	if err := editor.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := parseTorrent(edited)
+	if err != nil {
+		t.Fatalf("edited torrent doesn't parse: %v", err)
+	}
+	if tf.InfoHash != wantHash {
+		t.Errorf("info hash changed to %x, want %x", tf.InfoHash, wantHash)
+	}
+	if tf.Announce != "http://tracker.example.com/announce" {
+		t.Errorf("announce = %q", tf.Announce)
+	}
+	wantTiers := [][]string{{"http://tracker.example.com/announce"}, {"udp://backup.example.com:6969"}}
+	if !reflect.DeepEqual(tf.AnnounceList, wantTiers) {
+		t.Errorf("announce-list = %q, want %q", tf.AnnounceList, wantTiers)
+	}
+
+	editedFields, err := bencode.SplitDict(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"info", "comment", "x-unknown", "created by"} {
+		if !bytes.Equal(editedFields[key], fields[key]) {
+			t.Errorf("%q changed from %q to %q", key, fields[key], editedFields[key])
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+)
+
+// MagnetInfo is what a magnet link says about a torrent. It identifies the
+// torrent but lacks its info dictionary, which has to be fetched from peers.
+type MagnetInfo struct {
+	InfoHash [20]byte // SHA1 hash of the info dict (xt)
+	Name     string   // Display name (dn), possibly empty
+	Trackers []string // Tracker URLs (tr), in order, without duplicates
+	WebSeeds []string // Web seed URLs (ws)
+}
+
+// btihPrefix introduces a BitTorrent info hash in a magnet link's xt.
+const btihPrefix = "urn:btih:"
+
+// ParseMagnetURI parses a magnet:?xt=urn:btih:... link. The info hash may be
+// given in hex (40 characters) or base32 (32 characters).
+func ParseMagnetURI(uri string) (*MagnetInfo, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %w", err)
+	}
+	if parsed.Scheme != "magnet" {
+		return nil, fmt.Errorf("not a magnet link: %s", uri)
+	}
+
+	params, err := url.ParseQuery(parsed.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link parameters: %w", err)
+	}
+
+	m := &MagnetInfo{
+		Name:     params.Get("dn"),
+		WebSeeds: params["ws"],
+	}
+
+	found := false
+	for _, xt := range params["xt"] {
+		if len(xt) < len(btihPrefix) || !strings.EqualFold(xt[:len(btihPrefix)], btihPrefix) {
+			continue
+		}
+		m.InfoHash, err = parseInfoHash(xt[len(btihPrefix):])
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("magnet link has no BitTorrent info hash (xt=urn:btih:...)")
+	}
+
+	for _, tracker := range params["tr"] {
+		if !containsString(m.Trackers, tracker) {
+			m.Trackers = append(m.Trackers, tracker)
+		}
+	}
+
+	return m, nil
+}
+
+// parseInfoHash decodes a hex or base32 info hash.
+func parseInfoHash(s string) ([20]byte, error) {
+	var hash [20]byte
+
+	var decoded []byte
+	var err error
+	switch len(s) {
+	case 40:
+		decoded, err = hex.DecodeString(s)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("invalid info hash length %d in magnet link", len(s))
+	}
+	if err != nil {
+		return hash, fmt.Errorf("invalid info hash in magnet link: %w", err)
+	}
+
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, existing := range list {
+		if existing == s {
+			return true
+		}
+	}
+	return false
+}
+
+// TorrentFile returns a torrent with the magnet link's info hash, name and
+// trackers, and no info dictionary beyond the name. It is enough to announce
+// with, but not to download until the metadata has been fetched.
+func (m *MagnetInfo) TorrentFile() *TorrentFile {
+	t := &TorrentFile{
+		InfoHash: m.InfoHash,
+		URLList:  m.WebSeeds,
+		Info:     TorrentInfo{Name: m.Name},
+	}
+	if len(m.Trackers) > 0 {
+		t.Announce = m.Trackers[0]
+		t.AnnounceList = [][]string{m.Trackers}
+	}
+	return t
+}
+
+// WithMetadata completes the torrent from its bencoded info dictionary, as
+// fetched from peers. The dictionary must hash to the magnet's info hash.
+func (m *MagnetInfo) WithMetadata(info []byte) (*TorrentFile, error) {
+	if sha1.Sum(info) != m.InfoHash {
+		return nil, fmt.Errorf("metadata doesn't match info hash %x", m.InfoHash)
+	}
+
+	value, err := bencode.NewDecoder(bytes.NewReader(info)).DecodeOnly()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	infoDict, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("metadata is not a dictionary")
+	}
+
+	t := m.TorrentFile()
+	err = t.parseInfo(infoDict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse info dictionary: %w", err)
+	}
+	return t, nil
+}
+
+// String provides a human-readable summary of the magnet link.
+func (m *MagnetInfo) String() string {
+	var sb strings.Builder
+
+	name := m.Name
+	if name == "" {
+		name = "(unknown)"
+	}
+	sb.WriteString(fmt.Sprintf("Name: %s\n", name))
+	sb.WriteString(fmt.Sprintf("Info Hash: %x\n", m.InfoHash))
+	for _, tracker := range m.Trackers {
+		sb.WriteString(fmt.Sprintf("Tracker: %s\n", tracker))
+	}
+
+	return sb.String()
+}
+
+// IsMagnetURI reports whether s looks like a magnet link rather than a path.
+func IsMagnetURI(s string) bool {
+	return strings.HasPrefix(s, "magnet:")
+}
+
+// MagnetURI returns a magnet link for the torrent: its info hash (xt), name
+// (dn), every tracker (tr) and any web seeds (ws).
+func (t *TorrentFile) MagnetURI() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("magnet:?xt=urn:btih:%x", t.InfoHash))
+	if t.Info.Name != "" {
+		sb.WriteString("&dn=" + magnetEscape(t.Info.Name))
+	}
+	for _, tracker := range t.GetAllTrackers() {
+		sb.WriteString("&tr=" + magnetEscape(tracker))
+	}
+	for _, webSeed := range t.URLList {
+		sb.WriteString("&ws=" + magnetEscape(webSeed))
+	}
+
+	return sb.String()
+}
+
+// magnetEscape percent-encodes a magnet parameter value. Spaces become %20
+// rather than '+', which not every client decodes as a space.
+func magnetEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
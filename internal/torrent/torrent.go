@@ -1,6 +1,7 @@
 package torrent
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"fmt"
 	"os"
@@ -20,6 +21,14 @@ type TorrentFile struct {
 	CreationDate int64       `json:"creation_date"` // Unix timestamp
 	Info         TorrentInfo `json:"info"`          // File/piece information
 	InfoHash     [20]byte    `json:"info_hash"`     // SHA1 hash of info dict
+	Nodes        []Node      `json:"nodes"`         // DHT bootstrap nodes (trackerless torrents)
+	URLList      []string    `json:"url_list"`      // Web seed URLs (BEP 19)
+}
+
+// Node is a DHT bootstrap node listed in a trackerless torrent.
+type Node struct {
+	Host string `json:"host"` // Hostname or IP address
+	Port int    `json:"port"` // UDP port
 }
 
 // TorrentInfo represents the info dictionary from a torrent file.
@@ -30,6 +39,7 @@ type TorrentInfo struct {
 	Pieces      []byte     `json:"pieces"`       // Concatenated SHA1 hashes (20 bytes each)
 	Private     int64      `json:"private"`      // Private torrent flag
 	Length      int64      `json:"length"`       // Total size (single file mode)
+	MD5Sum      string     `json:"md5sum"`       // Optional MD5 of the file, in hex (single file mode)
 	Files       []FileInfo `json:"files"`        // File list (multi-file mode)
 }
 
@@ -37,6 +47,7 @@ type TorrentInfo struct {
 type FileInfo struct {
 	Length int64    `json:"length"` // File size in bytes
 	Path   []string `json:"path"`   // File path components
+	MD5Sum string   `json:"md5sum"` // Optional MD5 of the file, in hex
 }
 
 // GetPieceHashes extracts individual 20-byte SHA1 hashes from the pieces field.
@@ -82,31 +93,115 @@ func (t *TorrentInfo) GetNumPieces() int {
 
 // GetLastPieceLength calculates the size of the final piece (may be shorter than piece_length).
 func (t *TorrentInfo) GetLastPieceLength() int64 {
-	totalLength := t.GetTotalLength()
-	numPieces := t.GetNumPieces()
+	return t.GetPieceLength(t.GetNumPieces() - 1)
+}
+
+// GetPieceLength returns the size of a piece, or 0 if the index is out of range.
+func (t *TorrentInfo) GetPieceLength(pieceIndex int) int64 {
+	return PieceLength(pieceIndex, t.GetNumPieces(), t.PieceLength, t.GetTotalLength())
+}
+
+// GetFileMD5Sum returns the MD5 listed for the file at fileIndex, or "" if
+// the torrent doesn't give one. Single-file torrents have one file, index 0.
+func (t *TorrentInfo) GetFileMD5Sum(fileIndex int) string {
+	if !t.IsMultiFile() {
+		if fileIndex != 0 {
+			return ""
+		}
+		return t.MD5Sum
+	}
+	if fileIndex < 0 || fileIndex >= len(t.Files) {
+		return ""
+	}
+	return t.Files[fileIndex].MD5Sum
+}
+
+// GetFilePieces returns the range [start, end) of pieces holding any part of
+// the file at fileIndex. The range is empty for zero-length files and for
+// indices that don't name a file. Single-file torrents have one file, index 0.
+func (t *TorrentInfo) GetFilePieces(fileIndex int) (int, int) {
+	files := t.Files
+	if !t.IsMultiFile() {
+		files = []FileInfo{{Length: t.Length}}
+	}
+	if fileIndex < 0 || fileIndex >= len(files) || files[fileIndex].Length == 0 || t.PieceLength <= 0 {
+		return 0, 0
+	}
+
+	var offset int64
+	for _, file := range files[:fileIndex] {
+		offset += file.Length
+	}
 
-	if numPieces == 0 {
+	start := int(offset / t.PieceLength)
+	end := int((offset+files[fileIndex].Length-1)/t.PieceLength) + 1
+	return start, end
+}
+
+// PieceLength returns the size of a piece in a torrent with the given layout,
+// or 0 if the index is out of range. Every piece is pieceLength bytes except
+// the last, which holds whatever remains (a full piece when totalLength is an
+// exact multiple). This is the one place piece sizes are worked out; storage
+// and the piece manager call it with their cached lengths.
+func PieceLength(pieceIndex, numPieces int, pieceLength, totalLength int64) int64 {
+	if pieceIndex < 0 || pieceIndex >= numPieces {
 		return 0
 	}
 
-	lastPieceLength := totalLength % t.PieceLength
-	if lastPieceLength == 0 {
-		return t.PieceLength
+	if pieceIndex == numPieces-1 {
+		// Last piece might be shorter
+		lastPieceLength := totalLength % pieceLength
+		if lastPieceLength == 0 {
+			return pieceLength
+		}
+		return lastPieceLength
 	}
-	return lastPieceLength
+
+	return pieceLength
 }
 
 // ParseTorrentFile reads and parses a .torrent file from disk.
 // Returns a TorrentFile struct with all metadata and calculated info hash.
 func ParseTorrentFile(filePath string) (*TorrentFile, error) {
-	file, err := os.Open(filePath)
+	raw, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open torrent file: %w", err)
 	}
-	defer file.Close()
 
-	decoder := bencode.NewDecoder(file)
-	data, err := decoder.Decode()
+	return parseTorrent(raw)
+}
+
+// ParseOptions configures how a .torrent file is parsed.
+type ParseOptions struct {
+	// Lenient accepts torrents whose bencoding breaks the spec's ordering
+	// and integer format rules (see bencode.DecoderOptions). The info hash
+	// is still taken from the file's bytes, so it isn't affected.
+	Lenient bool
+}
+
+// ParseTorrentFileWithOptions reads and parses a .torrent file from disk
+// with additional options.
+func ParseTorrentFileWithOptions(filePath string, options ParseOptions) (*TorrentFile, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open torrent file: %w", err)
+	}
+
+	return parseTorrentWithOptions(raw, options)
+}
+
+// parseTorrent parses the contents of a .torrent file.
+func parseTorrent(raw []byte) (*TorrentFile, error) {
+	return parseTorrentWithOptions(raw, ParseOptions{})
+}
+
+// parseTorrentWithOptions parses the contents of a .torrent file with
+// additional options.
+func parseTorrentWithOptions(raw []byte, options ParseOptions) (*TorrentFile, error) {
+	decoder := bencode.NewDecoderWithOptions(bytes.NewReader(raw), bencode.DecoderOptions{
+		Lenient: options.Lenient,
+	})
+	data, err := decoder.DecodeOnly()
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode torrent file: %w", err)
 	}
@@ -118,26 +213,64 @@ func ParseTorrentFile(filePath string) (*TorrentFile, error) {
 
 	torrent := &TorrentFile{}
 
-	// Parse announce
-	if announce, ok := dict["announce"].([]byte); ok {
+	// Parse announce (optional for trackerless torrents, which list DHT nodes instead)
+	if announceValue, exists := dict["announce"]; exists {
+		announce, ok := announceValue.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("invalid announce field")
+		}
 		torrent.Announce = string(announce)
-	} else {
-		return nil, fmt.Errorf("missing or invalid announce field")
 	}
 
-	// Parse announce-list (optional)
+	// Parse announce-list (optional). Some torrents use a flat list of URLs
+	// instead of a list of tiers; each bare URL becomes its own tier.
 	if announceList, ok := dict["announce-list"].([]interface{}); ok {
 		for _, tierInterface := range announceList {
-			if tier, ok := tierInterface.([]interface{}); ok {
+			switch tier := tierInterface.(type) {
+			case []interface{}:
 				var tierStrings []string
 				for _, urlInterface := range tier {
-					if urlBytes, ok := urlInterface.([]byte); ok {
+					if urlBytes, ok := urlInterface.([]byte); ok && len(urlBytes) > 0 {
 						tierStrings = append(tierStrings, string(urlBytes))
 					}
 				}
 				if len(tierStrings) > 0 {
 					torrent.AnnounceList = append(torrent.AnnounceList, tierStrings)
 				}
+			case []byte:
+				if len(tier) > 0 {
+					torrent.AnnounceList = append(torrent.AnnounceList, []string{string(tier)})
+				}
+			}
+		}
+	}
+
+	// Parse DHT bootstrap nodes (optional): a list of [host, port] pairs
+	if nodes, ok := dict["nodes"].([]interface{}); ok {
+		for _, nodeInterface := range nodes {
+			pair, ok := nodeInterface.([]interface{})
+			if !ok || len(pair) != 2 {
+				continue
+			}
+			host, hostOK := pair[0].([]byte)
+			port, portOK := pair[1].(int64)
+			if !hostOK || !portOK || len(host) == 0 || port <= 0 || port > 65535 {
+				continue
+			}
+			torrent.Nodes = append(torrent.Nodes, Node{Host: string(host), Port: int(port)})
+		}
+	}
+
+	// Parse web seeds (optional): a single URL or a list of them
+	switch urlList := dict["url-list"].(type) {
+	case []byte:
+		if len(urlList) > 0 {
+			torrent.URLList = []string{string(urlList)}
+		}
+	case []interface{}:
+		for _, urlInterface := range urlList {
+			if urlBytes, ok := urlInterface.([]byte); ok && len(urlBytes) > 0 {
+				torrent.URLList = append(torrent.URLList, string(urlBytes))
 			}
 		}
 	}
@@ -170,7 +303,7 @@ func ParseTorrentFile(filePath string) (*TorrentFile, error) {
 	}
 
 	// Calculate info hash
-	err = torrent.calculateInfoHash(infoDict)
+	err = torrent.calculateInfoHash(raw)
 	if err != nil {
 		return nil, fmt.Errorf("failed to calculate info hash: %w", err)
 	}
@@ -213,6 +346,9 @@ func (t *TorrentFile) parseInfo(infoDict map[string]interface{}) error {
 	if length, ok := infoDict["length"].(int64); ok {
 		// Single file torrent
 		t.Info.Length = length
+		if md5sum, ok := infoDict["md5sum"].([]byte); ok {
+			t.Info.MD5Sum = string(md5sum)
+		}
 	} else if filesInterface, ok := infoDict["files"].([]interface{}); ok {
 		// Multi file mode
 		for _, fileInterface := range filesInterface {
@@ -230,7 +366,10 @@ func (t *TorrentFile) parseInfo(infoDict map[string]interface{}) error {
 				return fmt.Errorf("missing or invalid file length")
 			}
 
-			// md5sum is optional and rarely used, so we skip it for simplicity
+			// Parse md5sum (optional)
+			if md5sum, ok := fileDict["md5sum"].([]byte); ok {
+				fileInfo.MD5Sum = string(md5sum)
+			}
 
 			// Parse path
 			pathInterface, ok := fileDict["path"].([]interface{})
@@ -260,18 +399,24 @@ func (t *TorrentFile) parseInfo(infoDict map[string]interface{}) error {
 }
 
 // calculateInfoHash computes the SHA1 hash of the info dictionary.
-// This hash is used to identify the torrent in the protocol.
-func (t *TorrentFile) calculateInfoHash(infoDict map[string]interface{}) error {
-	// Re-encode the info dictionary to calculate hash
-	var buf strings.Builder
-	encoder := bencode.NewEncoder(&buf)
-	err := encoder.Encode(infoDict)
+// This hash is used to identify the torrent in the protocol. It is taken over
+// the info dictionary's bytes exactly as they appear in the file, as split
+// out by bencode.SplitDict, and never over a re-encoding: key order or
+// integer forms the decoder normalizes (see ParseOptions.Lenient) would
+// change the hash.
+func (t *TorrentFile) calculateInfoHash(raw []byte) error {
+	// Some tools end the file with a newline
+	fields, err := bencode.SplitDict(bytes.TrimRight(raw, " \t\r\n"))
 	if err != nil {
-		return fmt.Errorf("failed to encode info dictionary: %w", err)
+		return err
 	}
 
-	hash := sha1.Sum([]byte(buf.String()))
-	t.InfoHash = hash
+	info, ok := fields["info"]
+	if !ok {
+		return fmt.Errorf("missing info dictionary")
+	}
+
+	t.InfoHash = sha1.Sum(info)
 	return nil
 }
 
@@ -289,7 +434,10 @@ func (t *TorrentFile) GetOutputPath(baseDir string) string {
 
 // GetAllTrackers combines primary tracker and announce-list into a single slice.
 func (t *TorrentFile) GetAllTrackers() []string {
-	trackers := []string{t.Announce}
+	var trackers []string
+	if t.Announce != "" {
+		trackers = append(trackers, t.Announce)
+	}
 
 	for _, tier := range t.AnnounceList {
 		for _, tracker := range tier {
@@ -310,12 +458,34 @@ func (t *TorrentFile) GetAllTrackers() []string {
 	return trackers
 }
 
+// IsTrackerless returns true if the torrent lists no trackers at all, so
+// peers can only be found through DHT.
+func (t *TorrentFile) IsTrackerless() bool {
+	return len(t.GetAllTrackers()) == 0
+}
+
+// IsPrivate returns true if the torrent sets the private flag (BEP 27).
+// Peers of a private torrent may only be found through the trackers the
+// torrent lists, never through DHT, PEX or other trackers; every peer source
+// other than those trackers and inbound connections must check this.
+func (t *TorrentFile) IsPrivate() bool {
+	return t.Info.Private == 1
+}
+
 // String provides a human-readable summary of the torrent information.
 func (t *TorrentFile) String() string {
 	var sb strings.Builder
 
 	sb.WriteString(fmt.Sprintf("Name: %s\n", t.Info.Name))
-	sb.WriteString(fmt.Sprintf("Announce: %s\n", t.Announce))
+	if t.Announce != "" {
+		sb.WriteString(fmt.Sprintf("Announce: %s\n", t.Announce))
+	}
+	if len(t.Nodes) > 0 {
+		sb.WriteString(fmt.Sprintf("DHT Nodes: %d\n", len(t.Nodes)))
+	}
+	if t.IsPrivate() {
+		sb.WriteString("Private: yes (peers only from the torrent's trackers)\n")
+	}
 	sb.WriteString(fmt.Sprintf("Info Hash: %x\n", t.InfoHash))
 	sb.WriteString(fmt.Sprintf("Piece Length: %d bytes\n", t.Info.PieceLength))
 	sb.WriteString(fmt.Sprintf("Number of Pieces: %d\n", t.Info.GetNumPieces()))
@@ -0,0 +1,210 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"reflect"
+	"testing"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+)
+
+// encode bencodes value, failing the test on error.
+func encode(t *testing.T, value interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := bencode.NewEncoder(&buf).Encode(value); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testInfo returns the info dictionary of a two-piece, single-file torrent.
+func testInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"name":         "test.bin",
+		"length":       1500,
+		"piece length": 1024,
+		"pieces":       bytes.Repeat([]byte{0xAB}, 40),
+	}
+}
+
+// testTorrent bencodes a torrent with testInfo and the given top-level keys.
+func testTorrent(t *testing.T, meta map[string]interface{}) []byte {
+	t.Helper()
+	root := map[string]interface{}{"info": testInfo()}
+	for key, value := range meta {
+		root[key] = value
+	}
+	return encode(t, root)
+}
+
+func TestParseTrackerless(t *testing.T) {
+	raw := testTorrent(t, map[string]interface{}{
+		"nodes": []interface{}{
+			[]interface{}{"router.example.com", 6881},
+			[]interface{}{"192.0.2.1", 51413},
+			// Malformed entries are skipped
+			[]interface{}{"no-port.example.com"},
+			[]interface{}{"", 6881},
+			[]interface{}{"192.0.2.2", 70000},
+			"192.0.2.3:6881",
+		},
+	})
+
+	tf, err := parseTorrent(raw)
+	if err != nil {
+		t.Fatalf("torrent without announce rejected: %v", err)
+	}
+	want := []Node{{"router.example.com", 6881}, {"192.0.2.1", 51413}}
+	if !reflect.DeepEqual(tf.Nodes, want) {
+		t.Errorf("Nodes = %v, want %v", tf.Nodes, want)
+	}
+	if tf.Announce != "" || !tf.IsTrackerless() {
+		t.Errorf("announce %q, trackerless %v", tf.Announce, tf.IsTrackerless())
+	}
+	if tf.Info.Name != "test.bin" || tf.Info.GetNumPieces() != 2 {
+		t.Errorf("info parsed as %q with %d pieces", tf.Info.Name, tf.Info.GetNumPieces())
+	}
+	if tf.InfoHash != sha1.Sum(encode(t, testInfo())) {
+		t.Error("wrong info hash")
+	}
+}
+
+func TestParseAnnounce(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    map[string]interface{}
+		wantErr bool
+	}{
+		{"announce", map[string]interface{}{"announce": "http://tracker.example.com/announce"}, false},
+		{"announce and nodes", map[string]interface{}{
+			"announce": "http://tracker.example.com/announce",
+			"nodes":    []interface{}{[]interface{}{"192.0.2.1", 6881}},
+		}, false},
+		{"neither", nil, false},
+		{"announce not a string", map[string]interface{}{"announce": 42}, true},
+	}
+
+	for _, tt := range tests {
+		tf, err := parseTorrent(testTorrent(t, tt.meta))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && tf.IsTrackerless() != (tt.meta["announce"] == nil) {
+			t.Errorf("%s: trackerless = %v", tt.name, tf.IsTrackerless())
+		}
+	}
+}
+
+func TestParseAnnounceList(t *testing.T) {
+	tests := []struct {
+		name string
+		list []interface{}
+		want [][]string
+	}{
+		{
+			"tiers",
+			[]interface{}{
+				[]interface{}{"http://a.example/announce", "http://b.example/announce"},
+				[]interface{}{"udp://c.example:6969/announce"},
+			},
+			[][]string{{"http://a.example/announce", "http://b.example/announce"}, {"udp://c.example:6969/announce"}},
+		},
+		{
+			"flat",
+			[]interface{}{"http://a.example/announce", "udp://c.example:6969/announce"},
+			[][]string{{"http://a.example/announce"}, {"udp://c.example:6969/announce"}},
+		},
+		{
+			"mixed",
+			[]interface{}{
+				"http://a.example/announce",
+				[]interface{}{"http://b.example/announce", "udp://c.example:6969/announce"},
+				"udp://d.example:6969/announce",
+			},
+			[][]string{{"http://a.example/announce"}, {"http://b.example/announce", "udp://c.example:6969/announce"}, {"udp://d.example:6969/announce"}},
+		},
+		{
+			"empty entries skipped",
+			[]interface{}{"", []interface{}{}, []interface{}{"", 7}, 42, "http://a.example/announce"},
+			[][]string{{"http://a.example/announce"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tf, err := parseTorrent(testTorrent(t, map[string]interface{}{"announce-list": tt.list}))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tf.AnnounceList, tt.want) {
+			t.Errorf("%s: AnnounceList = %q, want %q", tt.name, tf.AnnounceList, tt.want)
+		}
+	}
+}
+
+func TestParseMD5Sums(t *testing.T) {
+	single := testInfo()
+	single["md5sum"] = "0123456789abcdef0123456789abcdef"
+	tf, err := parseTorrent(encode(t, map[string]interface{}{"announce": "http://tracker.example/announce", "info": single}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tf.Info.GetFileMD5Sum(0); got != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("single file md5sum = %q", got)
+	}
+	if got := tf.Info.GetFileMD5Sum(1); got != "" {
+		t.Errorf("md5sum of file 1 in a single-file torrent = %q", got)
+	}
+
+	multi := testInfo()
+	delete(multi, "length")
+	multi["files"] = []interface{}{
+		map[string]interface{}{"length": 1000, "path": []interface{}{"a"}, "md5sum": "fedcba9876543210fedcba9876543210"},
+		map[string]interface{}{"length": 500, "path": []interface{}{"b"}},
+	}
+	raw := encode(t, map[string]interface{}{"announce": "http://tracker.example/announce", "info": multi})
+	tf, err = parseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"fedcba9876543210fedcba9876543210", ""}
+	for i, md5sum := range want {
+		if got := tf.Info.GetFileMD5Sum(i); got != md5sum {
+			t.Errorf("file %d md5sum = %q, want %q", i, got, md5sum)
+		}
+	}
+
+	// The md5sums are part of the info dictionary, so they count towards
+	// the info hash
+	if wantHash := sha1.Sum(encode(t, multi)); tf.InfoHash != wantHash {
+		t.Errorf("info hash %x, want %x", tf.InfoHash, wantHash)
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	// Written by hand as a non-compliant encoder would: unsorted keys, in
+	// the info dictionary and out, and a length with a leading zero
+	pieces := bytes.Repeat([]byte{0xAB}, 40)
+	info := []byte("d4:name8:test.bin6:lengthi01500e12:piece lengthi1024e6:pieces40:" + string(pieces) + "e")
+	raw := append(append([]byte("d4:info"), info...), "8:announce31:http://tracker.example/announcee"...)
+
+	if _, err := parseTorrent(raw); err == nil {
+		t.Fatal("non-compliant torrent parsed strictly")
+	}
+
+	tf, err := parseTorrentWithOptions(raw, ParseOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("lenient parse: %v", err)
+	}
+	if tf.Announce != "http://tracker.example/announce" || tf.Info.Name != "test.bin" || tf.Info.Length != 1500 || tf.Info.PieceLength != 1024 {
+		t.Errorf("parsed announce %q, name %q, length %d, piece length %d",
+			tf.Announce, tf.Info.Name, tf.Info.Length, tf.Info.PieceLength)
+	}
+	// Hashed as written, not as it would be re-encoded
+	if want := sha1.Sum(info); tf.InfoHash != want {
+		t.Errorf("info hash %x, want %x", tf.InfoHash, want)
+	}
+}
@@ -0,0 +1,140 @@
+package tracker
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// announceFailureLimit is how many announces in a row a tracker may fail
+// before it is tried after every tracker that hasn't.
+const announceFailureLimit = 2
+
+// trackerHealth counts how many of a tracker's peers we could connect to.
+type trackerHealth struct {
+	attempted int // Connection attempts to peers this tracker returned
+	connected int // Attempts that ended in a working connection
+	failures  int // Announces in a row that failed
+}
+
+// score estimates the fraction of a tracker's peers that are reachable.
+// Trackers we know nothing about score 0.5, so a single bad batch doesn't
+// sink a tracker below ones we haven't tried yet.
+func (h trackerHealth) score() float64 {
+	return float64(h.connected+1) / float64(h.attempted+2)
+}
+
+// healthTable records dial outcomes per tracker URL.
+type healthTable struct {
+	mutex    sync.Mutex
+	trackers map[string]trackerHealth
+}
+
+// ReportDials records the outcome of connecting to peers returned by
+// trackerURL. Trackers whose peers mostly fail to connect are tried after
+// the others on later announces.
+func (tc *TrackerClient) ReportDials(trackerURL string, attempted, connected int) {
+	if trackerURL == "" || attempted <= 0 {
+		return
+	}
+
+	tc.health.mutex.Lock()
+	defer tc.health.mutex.Unlock()
+
+	if tc.health.trackers == nil {
+		tc.health.trackers = make(map[string]trackerHealth)
+	}
+	h := tc.health.trackers[trackerURL]
+	h.attempted += attempted
+	h.connected += connected
+	tc.health.trackers[trackerURL] = h
+}
+
+// reportAnnounce records whether an announce to trackerURL succeeded.
+func (tc *TrackerClient) reportAnnounce(trackerURL string, ok bool) {
+	tc.health.mutex.Lock()
+	defer tc.health.mutex.Unlock()
+
+	if tc.health.trackers == nil {
+		tc.health.trackers = make(map[string]trackerHealth)
+	}
+	h := tc.health.trackers[trackerURL]
+	if ok {
+		h.failures = 0
+	} else {
+		h.failures++
+	}
+	tc.health.trackers[trackerURL] = h
+}
+
+// orderByHealth returns the trackers of tiers in the order to try them:
+// tier by tier, and within a tier by how reachable their peers have been,
+// best first. Trackers with equal scores keep their order in the tier.
+// Trackers that keep failing go after every tier; a failing UDP tracker's
+// HTTP form, if the torrent lists one, takes its place (UDP is often
+// firewalled where HTTP gets through).
+func (tc *TrackerClient) orderByHealth(tiers [][]string) []string {
+	tc.health.mutex.Lock()
+	defer tc.health.mutex.Unlock()
+
+	var trackers []string
+	for _, tier := range tiers {
+		trackers = append(trackers, tier...)
+	}
+
+	scores := make(map[string]float64, len(trackers))
+	failing := make(map[string]bool, len(trackers))
+	for _, trackerURL := range trackers {
+		h := tc.health.trackers[trackerURL]
+		scores[trackerURL] = h.score()
+		failing[trackerURL] = h.failures >= announceFailureLimit
+	}
+
+	ordered := make([]string, 0, len(trackers))
+	placed := make(map[string]bool, len(trackers))
+	var demoted []string
+	for _, tier := range tiers {
+		byScore := append([]string(nil), tier...)
+		sort.SliceStable(byScore, func(i, j int) bool {
+			return scores[byScore[i]] > scores[byScore[j]]
+		})
+
+		for _, trackerURL := range byScore {
+			if !failing[trackerURL] {
+				if !placed[trackerURL] {
+					ordered = append(ordered, trackerURL)
+					placed[trackerURL] = true
+				}
+				continue
+			}
+
+			if fallback := httpEquivalent(trackerURL, trackers); fallback != "" && !failing[fallback] && !placed[fallback] {
+				ordered = append(ordered, fallback)
+				placed[fallback] = true
+			}
+			demoted = append(demoted, trackerURL)
+		}
+	}
+
+	return append(ordered, demoted...)
+}
+
+// httpEquivalent returns the HTTP(S) tracker among trackers with the same
+// host and path as the UDP tracker udpURL, or "" if there is none.
+func httpEquivalent(udpURL string, trackers []string) string {
+	u, err := url.Parse(udpURL)
+	if err != nil || u.Scheme != "udp" {
+		return ""
+	}
+
+	for _, candidate := range trackers {
+		c, err := url.Parse(candidate)
+		if err != nil || (c.Scheme != "http" && c.Scheme != "https") {
+			continue
+		}
+		if c.Hostname() == u.Hostname() && c.Path == u.Path {
+			return candidate
+		}
+	}
+	return ""
+}
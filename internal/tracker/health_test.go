@@ -0,0 +1,47 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHealthyTrackerPreferred(t *testing.T) {
+	dead := newHTTPTracker(t, PeerInfo{IP: "192.0.2.1", Port: 6881}, PeerInfo{IP: "192.0.2.2", Port: 6881})
+	healthy := newHTTPTracker(t, PeerInfo{IP: "198.51.100.1", Port: 6881}, PeerInfo{IP: "198.51.100.2", Port: 6881})
+
+	// Both in one tier
+	tf := testTorrent(dead.announceURL(), healthy.announceURL())
+	tf.AnnounceList = [][]string{{dead.announceURL(), healthy.announceURL()}}
+
+	tc := NewTrackerClientWithOptions(true)
+	// Tiers are shuffled; make sure the dead one is tried first
+	tc.trackerManager(tf).Promote(dead.announceURL())
+	reachable := map[string]bool{"198.51.100.1": true, "198.51.100.2": true}
+	var answered []string
+	for cycle := 0; cycle < 3; cycle++ {
+		resp, err := tc.GetPeers(context.Background(), tf, 6881, "", AnnounceStats{})
+		if err != nil {
+			t.Fatalf("cycle %d: %v", cycle, err)
+		}
+		answered = append(answered, resp.Tracker)
+
+		// Dial the peers, as the download manager would, and report back
+		connected := 0
+		for _, p := range resp.Peers {
+			if reachable[p.IP] {
+				connected++
+			}
+		}
+		tc.ReportDials(resp.Tracker, len(resp.Peers), connected)
+	}
+
+	want := []string{dead.announceURL(), healthy.announceURL(), healthy.announceURL()}
+	for i := range want {
+		if answered[i] != want[i] {
+			t.Errorf("cycle %d announced to %s, want %s", i, answered[i], want[i])
+		}
+	}
+	if n := len(dead.received()); n != 1 {
+		t.Errorf("dead tracker asked %d times, want once", n)
+	}
+}
@@ -0,0 +1,199 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+)
+
+// maxUDPScrapeHashes is how many info hashes fit in one UDP scrape (BEP 15).
+const maxUDPScrapeHashes = 74
+
+// ErrScrapeUnsupported is returned for HTTP trackers whose announce URL has
+// no "announce" path segment to derive a scrape URL from.
+var ErrScrapeUnsupported = errors.New("tracker does not support scrape")
+
+// ScrapeStats are a tracker's counts for one torrent.
+type ScrapeStats struct {
+	Complete   int64 `json:"complete"`   // Peers with the whole torrent (seeders)
+	Downloaded int64 `json:"downloaded"` // Downloads the tracker has seen complete
+	Incomplete int64 `json:"incomplete"` // Peers still downloading (leechers)
+}
+
+// ScrapeResponse holds a tracker's counts for each scraped torrent.
+type ScrapeResponse struct {
+	Tracker string                   // Tracker that answered
+	Files   map[[20]byte]ScrapeStats // Counts per info hash
+}
+
+// Scrape asks the torrent's trackers for its seeder, leecher and download
+// counts without announcing. Trackers are tried in the same order as for
+// GetPeers until one answers, or ctx is cancelled.
+func (tc *TrackerClient) Scrape(ctx context.Context, t *torrent.TorrentFile) (*ScrapeResponse, error) {
+	tiers := tc.trackerManager(t).Tiers()
+	if len(tiers) == 0 {
+		return nil, ErrTrackerless
+	}
+
+	var lastErr error
+	for _, trackerURL := range tc.orderByHealth(tiers) {
+		resp, err := tc.ScrapeTracker(ctx, trackerURL, [][20]byte{t.InfoHash})
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !tc.quiet {
+			fmt.Printf("Failed to scrape tracker %s: %v\n", trackerURL, err)
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("all trackers failed to scrape: %w", lastErr)
+}
+
+// ScrapeTracker asks one tracker for the counts of several torrents at once.
+// Torrents the tracker doesn't know are missing from the response.
+func (tc *TrackerClient) ScrapeTracker(ctx context.Context, trackerURL string, infoHashes [][20]byte) (*ScrapeResponse, error) {
+	parsedURL, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker URL: %w", err)
+	}
+
+	var resp *ScrapeResponse
+	switch parsedURL.Scheme {
+	case "http", "https":
+		resp, err = tc.scrapeHTTPTracker(ctx, trackerURL, infoHashes)
+	case "udp":
+		resp, err = tc.scrapeUDPTracker(ctx, trackerURL, infoHashes)
+	default:
+		return nil, fmt.Errorf("unsupported tracker protocol: %s", parsedURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Tracker = trackerURL
+	return resp, nil
+}
+
+// scrapeURL derives an HTTP tracker's scrape URL from its announce URL by
+// replacing "announce" at the start of the last path segment with "scrape",
+// e.g. /x/announce.php becomes /x/scrape.php.
+func scrapeURL(announceURL string) (string, error) {
+	parsedURL, err := url.Parse(announceURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid tracker URL: %w", err)
+	}
+
+	slash := strings.LastIndex(parsedURL.Path, "/")
+	segment := parsedURL.Path[slash+1:]
+	if !strings.HasPrefix(segment, "announce") {
+		return "", fmt.Errorf("%w: %s", ErrScrapeUnsupported, announceURL)
+	}
+
+	parsedURL.Path = parsedURL.Path[:slash+1] + "scrape" + strings.TrimPrefix(segment, "announce")
+	parsedURL.RawPath = ""
+	return parsedURL.String(), nil
+}
+
+// scrapeHTTPTracker sends an HTTP/HTTPS scrape request.
+func (tc *TrackerClient) scrapeHTTPTracker(ctx context.Context, trackerURL string, infoHashes [][20]byte) (*ScrapeResponse, error) {
+	fullURL, err := scrapeURL(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	for _, infoHash := range infoHashes {
+		params.Add("info_hash", string(infoHash[:]))
+	}
+	separator := "?"
+	if strings.Contains(fullURL, "?") {
+		separator = "&"
+	}
+
+	dict, err := tc.getDictionary(ctx, fullURL+separator+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if failureBytes, ok := dict["failure reason"].([]byte); ok {
+		return nil, fmt.Errorf("tracker returned failure: %s", failureBytes)
+	}
+
+	files, ok := dict["files"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("missing files in scrape response")
+	}
+
+	resp := &ScrapeResponse{Files: make(map[[20]byte]ScrapeStats)}
+	for key, value := range files {
+		fileDict, ok := value.(map[string]interface{})
+		if !ok || len(key) != 20 {
+			continue
+		}
+
+		var infoHash [20]byte
+		copy(infoHash[:], key)
+		var stats ScrapeStats
+		stats.Complete, _ = fileDict["complete"].(int64)
+		stats.Downloaded, _ = fileDict["downloaded"].(int64)
+		stats.Incomplete, _ = fileDict["incomplete"].(int64)
+		resp.Files[infoHash] = stats
+	}
+
+	return resp, nil
+}
+
+// scrapeUDPTracker sends BEP 15 scrape requests, in batches of at most
+// maxUDPScrapeHashes info hashes.
+func (tc *TrackerClient) scrapeUDPTracker(ctx context.Context, trackerURL string, infoHashes [][20]byte) (*ScrapeResponse, error) {
+	resp := &ScrapeResponse{Files: make(map[[20]byte]ScrapeStats)}
+
+	for len(infoHashes) > 0 {
+		batch := infoHashes[:min(len(infoHashes), maxUDPScrapeHashes)]
+		infoHashes = infoHashes[len(batch):]
+
+		scrapeResp := make([]byte, 8+12*len(batch))
+		n, _, err := tc.udpRequest(ctx, trackerURL, udpActionScrape, func(connectionID, transactionID []byte) []byte {
+			return encodeUDPScrape(connectionID, transactionID, batch)
+		}, scrapeResp)
+		if err != nil {
+			return nil, fmt.Errorf("scrape failed: %w", err)
+		}
+		if n != len(scrapeResp) {
+			return nil, fmt.Errorf("invalid scrape response length: %d", n)
+		}
+
+		// Counts come back in request order: seeders, completed, leechers
+		for i, infoHash := range batch {
+			record := scrapeResp[8+12*i:]
+			resp.Files[infoHash] = ScrapeStats{
+				Complete:   int64(binary.BigEndian.Uint32(record[0:4])),
+				Downloaded: int64(binary.BigEndian.Uint32(record[4:8])),
+				Incomplete: int64(binary.BigEndian.Uint32(record[8:12])),
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+// encodeUDPScrape builds a BEP 15 scrape packet.
+func encodeUDPScrape(connectionID, transactionID []byte, infoHashes [][20]byte) []byte {
+	scrapeReq := make([]byte, 16, 16+20*len(infoHashes))
+	copy(scrapeReq[0:8], connectionID)                           // Connection ID
+	binary.BigEndian.PutUint32(scrapeReq[8:12], udpActionScrape) // Action: scrape
+	copy(scrapeReq[12:16], transactionID)                        // Transaction ID
+	for _, infoHash := range infoHashes {
+		scrapeReq = append(scrapeReq, infoHash[:]...) // Info hashes
+	}
+	return scrapeReq
+}
@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+)
+
+// TrackerManager keeps a torrent's trackers in their tiers, as BEP 12
+// describes: each tier is shuffled once, tiers are tried in order, and a
+// tracker that answers moves to the front of its tier so later announces
+// stick with it.
+type TrackerManager struct {
+	tiers [][]string // Trackers by tier, in the order they are tried
+	mutex sync.Mutex // Protects tiers
+}
+
+// NewTrackerManager creates a tracker manager for t's trackers. As BEP 12
+// requires, the announce URL is only used when the announce-list names no
+// trackers. A URL listed in several tiers is kept in the first.
+func NewTrackerManager(t *torrent.TorrentFile) *TrackerManager {
+	m := &TrackerManager{}
+	seen := make(map[string]bool)
+	for _, tier := range t.AnnounceList {
+		var trackers []string
+		for _, trackerURL := range tier {
+			if trackerURL != "" && !seen[trackerURL] {
+				trackers = append(trackers, trackerURL)
+				seen[trackerURL] = true
+			}
+		}
+		if len(trackers) == 0 {
+			continue
+		}
+
+		rand.Shuffle(len(trackers), func(i, j int) {
+			trackers[i], trackers[j] = trackers[j], trackers[i]
+		})
+		m.tiers = append(m.tiers, trackers)
+	}
+
+	if len(m.tiers) == 0 && t.Announce != "" {
+		m.tiers = [][]string{{t.Announce}}
+	}
+	return m
+}
+
+// Tiers returns a copy of the trackers by tier, in the order to try them.
+func (m *TrackerManager) Tiers() [][]string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tiers := make([][]string, len(m.tiers))
+	for i, tier := range m.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// Promote moves a tracker that answered to the front of its tier.
+func (m *TrackerManager) Promote(trackerURL string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, tier := range m.tiers {
+		for i, candidate := range tier {
+			if candidate == trackerURL {
+				copy(tier[1:i+1], tier[:i])
+				tier[0] = trackerURL
+				return
+			}
+		}
+	}
+}
+
+// trackerManager returns the tracker manager for t, creating it on first use
+// so that each torrent's tiers are shuffled only once.
+func (tc *TrackerClient) trackerManager(t *torrent.TorrentFile) *TrackerManager {
+	tc.managersMutex.Lock()
+	defer tc.managersMutex.Unlock()
+
+	if tc.managers == nil {
+		tc.managers = make(map[[20]byte]*TrackerManager)
+	}
+	m, ok := tc.managers[t.InfoHash]
+	if !ok {
+		m = NewTrackerManager(t)
+		tc.managers[t.InfoHash] = m
+	}
+	return m
+}
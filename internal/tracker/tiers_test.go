@@ -0,0 +1,81 @@
+package tracker
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// silentUDPTracker returns the announce URL of a UDP port that takes
+// requests but never answers, as when a firewall drops UDP.
+func silentUDPTracker(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return "udp://" + conn.LocalAddr().String() + "/announce"
+}
+
+func TestUDPFailsOverToHTTPInTier(t *testing.T) {
+	setUDPTiming(t, 100*time.Millisecond, udpConnectionIDLifetime)
+	udpURL := silentUDPTracker(t)
+	httpTr := newHTTPTracker(t, PeerInfo{IP: "192.0.2.1", Port: 6881})
+
+	tf := testTorrent(udpURL, httpTr.announceURL())
+	tf.AnnounceList = [][]string{{udpURL, httpTr.announceURL()}}
+
+	tc := NewTrackerClientWithOptions(true)
+	tc.SetUDPRetries(0)
+	// Tiers are shuffled; make sure UDP is tried first
+	tc.trackerManager(tf).Promote(udpURL)
+
+	for i := 0; i < 2; i++ {
+		resp, err := tc.GetPeers(context.Background(), tf, 6881, "", AnnounceStats{})
+		if err != nil {
+			t.Fatalf("announce %d: %v", i, err)
+		}
+		if resp.Tracker != httpTr.announceURL() || len(resp.Peers) != 1 {
+			t.Errorf("announce %d answered by %s with %v, want the HTTP tracker's peer", i, resp.Tracker, resp.Peers)
+		}
+	}
+
+	// Having answered, the HTTP tracker is tried first from then on
+	if n := len(httpTr.received()); n != 2 {
+		t.Errorf("HTTP tracker asked %d times, want 2", n)
+	}
+	if tiers := tc.trackerManager(tf).Tiers(); tiers[0][0] != httpTr.announceURL() {
+		t.Errorf("tier order %v, want the HTTP tracker first", tiers[0])
+	}
+}
+
+func TestFailingUDPReplacedByHTTPForm(t *testing.T) {
+	udpURL := "udp://tracker.example:6969/announce"
+	httpURL := "http://tracker.example/announce"
+	other := "http://other.example/announce"
+	tiers := [][]string{{udpURL}, {other}, {httpURL}}
+
+	tc := NewTrackerClientWithOptions(true)
+	for i := 0; i < announceFailureLimit; i++ {
+		if got := tc.orderByHealth(tiers); !reflect.DeepEqual(got, []string{udpURL, other, httpURL}) {
+			t.Fatalf("after %d failures: order %v", i, got)
+		}
+		tc.reportAnnounce(udpURL, false)
+	}
+
+	// The HTTP form of the same tracker takes the UDP tracker's place, and
+	// the UDP tracker goes last
+	want := []string{httpURL, other, udpURL}
+	if got := tc.orderByHealth(tiers); !reflect.DeepEqual(got, want) {
+		t.Errorf("order %v, want %v", got, want)
+	}
+
+	// HTTP trackers on another host or path aren't its equivalent
+	if got := httpEquivalent(udpURL, []string{other, strings.Replace(httpURL, "/announce", "/scrape", 1)}); got != "" {
+		t.Errorf("httpEquivalent matched %s", got)
+	}
+}
@@ -1,21 +1,34 @@
 package tracker
 
 import (
-	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/yashkadam007/bittorrent-client/internal/bencode"
 	"github.com/yashkadam007/bittorrent-client/internal/torrent"
 )
 
+// StopAnnounceTimeout bounds the last announce sent while shutting down,
+// which can't use the context that was just cancelled, so that a slow
+// tracker doesn't hold up exit.
+const StopAnnounceTimeout = 5 * time.Second
+
+// ErrTrackerless is returned when a torrent lists no trackers. Such torrents
+// (which usually carry DHT bootstrap nodes instead) need DHT to find peers.
+var ErrTrackerless = errors.New("trackerless torrent requires DHT")
+
 // TrackerResponse represents a response from a BitTorrent tracker.
 type TrackerResponse struct {
 	FailureReason  string     `json:"failure_reason"`  // Error message if request failed
@@ -26,6 +39,7 @@ type TrackerResponse struct {
 	Complete       int64      `json:"complete"`        // Number of seeders
 	Incomplete     int64      `json:"incomplete"`      // Number of leechers
 	Peers          []PeerInfo `json:"peers"`           // List of available peers
+	Tracker        string     `json:"tracker"`         // URL of the tracker that answered
 }
 
 // PeerInfo represents information about a single peer from the tracker.
@@ -35,11 +49,18 @@ type PeerInfo struct {
 	Port int    `json:"port"` // Peer's listening port
 }
 
+// Addr returns the peer's address in host:port form, with IPv6 addresses
+// bracketed, ready to dial.
+func (p PeerInfo) Addr() string {
+	return net.JoinHostPort(p.IP, strconv.Itoa(p.Port))
+}
+
 // TrackerRequest represents parameters for a tracker announce request.
 type TrackerRequest struct {
 	InfoHash   [20]byte // Torrent identifier
 	PeerID     [20]byte // Our client identifier
 	Port       int      // Our listening port
+	Uploaded   int64    // Bytes uploaded so far
 	Downloaded int64    // Bytes downloaded so far
 	Left       int64    // Bytes remaining to download
 	Event      string   // "started", "completed", "stopped", or ""
@@ -47,20 +68,52 @@ type TrackerRequest struct {
 	Key        uint32   // Random key for tracker session
 }
 
+// AnnounceStats are the transfer totals reported in an announce. Private
+// trackers compute ratios from them, and a left of zero marks us a seed.
+type AnnounceStats struct {
+	Uploaded   int64 // Bytes sent to peers
+	Downloaded int64 // Bytes received from peers
+	Left       int64 // Bytes still needed to complete the torrent
+}
+
 // TrackerClient handles communication with BitTorrent trackers.
 // Supports both HTTP/HTTPS and UDP tracker protocols.
 type TrackerClient struct {
-	httpClient *http.Client // HTTP client for tracker requests
-	peerID     [20]byte     // Our unique peer identifier
-	key        uint32       // Random session key
+	httpClient *http.Client   // HTTP client for tracker requests
+	peerID     [20]byte       // Our unique peer identifier
+	key        uint32         // Random session key, stable for the client's lifetime
+	quiet      bool           // Suppress stdout output
+	health     healthTable    // Dial outcomes per tracker, for ordering announces
+	udpConns   udpConnections // UDP tracker connection IDs still in their validity window
+	udpRetries int            // Retransmissions per UDP tracker step
+	freeSlots  func() int     // Peer slots left to fill, for sizing numwant (nil uses defaultNumWant)
+
+	managers      map[[20]byte]*TrackerManager // Tracker tiers per torrent, by info hash
+	managersMutex sync.Mutex                   // Protects managers
 }
 
+const (
+	// defaultNumWant is the numwant sent when the free peer slots are unknown.
+	defaultNumWant = 50
+
+	// maxNumWant caps numwant however many peer slots are free.
+	maxNumWant = 200
+)
+
 // NewTrackerClient creates a new tracker client with a random peer ID.
 func NewTrackerClient() *TrackerClient {
+	return NewTrackerClientWithOptions(false)
+}
+
+// NewTrackerClientWithOptions creates a new tracker client with additional options.
+func NewTrackerClientWithOptions(quiet bool) *TrackerClient {
 	var peerID [20]byte
 	copy(peerID[:], "-GO0001-")
 	rand.Read(peerID[8:])
 
+	// The key lets a tracker recognise us if our IP changes, so it is
+	// generated once per session and shared by every announce on every
+	// transport rather than regenerated per request
 	var key uint32
 	binary.Read(rand.Reader, binary.BigEndian, &key)
 
@@ -68,72 +121,126 @@ func NewTrackerClient() *TrackerClient {
 		httpClient: &http.Client{
 			Timeout: 15 * time.Second,
 		},
-		peerID: peerID,
-		key:    key,
+		peerID:     peerID,
+		key:        key,
+		quiet:      quiet,
+		udpRetries: udpMaxRetries,
 	}
 }
 
+// SetUDPRetries sets how many times each UDP tracker step is retransmitted
+// before the tracker is given up on, each attempt waiting twice as long as
+// the last. It is clamped to the 0 to 8 that BEP 15 allows; 8 waits over an
+// hour for a dead tracker.
+func (tc *TrackerClient) SetUDPRetries(retries int) {
+	tc.udpRetries = min(max(retries, 0), udpBEP15Retries)
+}
+
+// SetPeerSlots makes announces ask for as many peers as freeSlots reports
+// room for, rather than a fixed defaultNumWant.
+func (tc *TrackerClient) SetPeerSlots(freeSlots func() int) {
+	tc.freeSlots = freeSlots
+}
+
+// numWant returns how many peers to ask for in an announce with the given
+// event. A stopping client wants none.
+func (tc *TrackerClient) numWant(event string) int {
+	if event == "stopped" {
+		return 0
+	}
+	if tc.freeSlots == nil {
+		return defaultNumWant
+	}
+	return min(max(tc.freeSlots(), 0), maxNumWant)
+}
+
 // GetPeers requests a list of peers from the tracker.
-// Tries all available trackers until one succeeds.
-func (tc *TrackerClient) GetPeers(t *torrent.TorrentFile, port int, event string) (*TrackerResponse, error) {
+// Tries all available trackers until one succeeds, tier by tier (see
+// TrackerManager), preferring within a tier those whose peers have connected
+// best (see ReportDials). Only the trackers the torrent lists are ever
+// contacted, which private torrents rely on (see torrent.IsPrivate).
+// Cancelling ctx aborts the request in flight and returns ctx's error.
+func (tc *TrackerClient) GetPeers(ctx context.Context, t *torrent.TorrentFile, port int, event string, stats AnnounceStats) (*TrackerResponse, error) {
 	// Try all trackers until one succeeds
-	trackers := t.GetAllTrackers()
+	manager := tc.trackerManager(t)
+	tiers := manager.Tiers()
+	if len(tiers) == 0 {
+		return nil, ErrTrackerless
+	}
 
-	for _, trackerURL := range trackers {
-		resp, err := tc.requestPeers(trackerURL, t, port, event)
+	for _, trackerURL := range tc.orderByHealth(tiers) {
+		resp, err := tc.requestPeers(ctx, trackerURL, t, port, event, stats)
+		if ctx.Err() != nil {
+			// Cancelled, which says nothing about the tracker
+			return nil, ctx.Err()
+		}
+		tc.reportAnnounce(trackerURL, err == nil && resp.FailureReason == "")
 		if err != nil {
 			// Log error and try next tracker
-			fmt.Printf("Failed to contact tracker %s: %v\n", trackerURL, err)
+			if !tc.quiet {
+				fmt.Printf("Failed to contact tracker %s: %v\n", trackerURL, err)
+			}
 			continue
 		}
 
 		if resp.FailureReason != "" {
 			// Log failure and try next tracker
-			fmt.Printf("Tracker %s returned failure: %s\n", trackerURL, resp.FailureReason)
+			if !tc.quiet {
+				fmt.Printf("Tracker %s returned failure: %s\n", trackerURL, resp.FailureReason)
+			}
 			continue
 		}
 
+		manager.Promote(trackerURL)
+		resp.Tracker = trackerURL
 		return resp, nil
 	}
 
 	return nil, fmt.Errorf("all trackers failed")
 }
 
-func (tc *TrackerClient) requestPeers(trackerURL string, t *torrent.TorrentFile, port int, event string) (*TrackerResponse, error) {
+func (tc *TrackerClient) requestPeers(ctx context.Context, trackerURL string, t *torrent.TorrentFile, port int, event string, stats AnnounceStats) (*TrackerResponse, error) {
 	parsedURL, err := url.Parse(trackerURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid tracker URL: %w", err)
 	}
 
+	req := tc.newAnnounceRequest(t, port, event, stats)
+
 	switch parsedURL.Scheme {
 	case "http", "https":
-		return tc.requestHTTPTracker(trackerURL, t, port, event)
+		return tc.requestHTTPTracker(ctx, trackerURL, req)
 	case "udp":
-		return tc.requestUDPTracker(trackerURL, t, port, event)
+		return tc.requestUDPTracker(ctx, trackerURL, req)
 	default:
 		return nil, fmt.Errorf("unsupported tracker protocol: %s", parsedURL.Scheme)
 	}
 }
 
-// requestHTTPTracker sends an HTTP/HTTPS tracker request.
-func (tc *TrackerClient) requestHTTPTracker(trackerURL string, t *torrent.TorrentFile, port int, event string) (*TrackerResponse, error) {
-	req := TrackerRequest{
+// newAnnounceRequest builds the announce parameters shared by every transport.
+// Both the HTTP and UDP encoders read from the returned request, so session
+// values such as the key can't drift between them.
+func (tc *TrackerClient) newAnnounceRequest(t *torrent.TorrentFile, port int, event string, stats AnnounceStats) TrackerRequest {
+	return TrackerRequest{
 		InfoHash:   t.InfoHash,
 		PeerID:     tc.peerID,
 		Port:       port,
-		Downloaded: 0, // Simplified: we don't track upload/download for basic client
-		Left:       t.Info.GetTotalLength(),
+		Uploaded:   stats.Uploaded,
+		Downloaded: stats.Downloaded,
+		Left:       stats.Left,
 		Event:      event,
-		NumWant:    50, // Request up to 50 peers
+		NumWant:    tc.numWant(event),
 		Key:        tc.key,
 	}
+}
 
-	// Build query parameters
+// encodeHTTPAnnounce builds the query parameters for an HTTP announce.
+func encodeHTTPAnnounce(req TrackerRequest) url.Values {
 	params := url.Values{}
 	params.Set("info_hash", string(req.InfoHash[:]))
 	params.Set("peer_id", string(req.PeerID[:]))
 	params.Set("port", strconv.Itoa(req.Port))
-	params.Set("uploaded", "0") // Simplified: no upload tracking
+	params.Set("uploaded", strconv.FormatInt(req.Uploaded, 10))
 	params.Set("downloaded", strconv.FormatInt(req.Downloaded, 10))
 	params.Set("left", strconv.FormatInt(req.Left, 10))
 	params.Set("compact", "1")
@@ -142,158 +249,121 @@ func (tc *TrackerClient) requestHTTPTracker(trackerURL string, t *torrent.Torren
 	}
 	params.Set("numwant", strconv.Itoa(req.NumWant))
 	params.Set("key", strconv.FormatUint(uint64(req.Key), 10))
+	return params
+}
 
-	// Make request
-	fullURL := trackerURL + "?" + params.Encode()
-	resp, err := tc.httpClient.Get(fullURL)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %w", err)
+// encodeUDPAnnounce builds a BEP 15 announce packet.
+func encodeUDPAnnounce(connectionID, transactionID []byte, req TrackerRequest) []byte {
+	eventNum := uint32(0)
+	switch req.Event {
+	case "started":
+		eventNum = 2
+	case "completed":
+		eventNum = 1
+	case "stopped":
+		eventNum = 3
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP request failed with status: %d", resp.StatusCode)
-	}
+	announceReq := make([]byte, 98)
+	copy(announceReq[0:8], connectionID)                                   // Connection ID
+	binary.BigEndian.PutUint32(announceReq[8:12], 1)                       // Action: announce
+	copy(announceReq[12:16], transactionID)                                // Transaction ID
+	copy(announceReq[16:36], req.InfoHash[:])                              // Info hash
+	copy(announceReq[36:56], req.PeerID[:])                                // Peer ID
+	binary.BigEndian.PutUint64(announceReq[56:64], uint64(req.Downloaded)) // Downloaded
+	binary.BigEndian.PutUint64(announceReq[64:72], uint64(req.Left))       // Left
+	binary.BigEndian.PutUint64(announceReq[72:80], uint64(req.Uploaded))   // Uploaded
+	binary.BigEndian.PutUint32(announceReq[80:84], eventNum)               // Event
+	binary.BigEndian.PutUint32(announceReq[84:88], 0)                      // IP (default)
+	binary.BigEndian.PutUint32(announceReq[88:92], req.Key)                // Key
+	binary.BigEndian.PutUint32(announceReq[92:96], uint32(req.NumWant))    // Num want
+	binary.BigEndian.PutUint16(announceReq[96:98], uint16(req.Port))       // Port
+	return announceReq
+}
 
-	// Parse response
-	decoder := bencode.NewDecoder(resp.Body)
-	data, err := decoder.Decode()
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode tracker response: %w", err)
-	}
+// requestHTTPTracker sends an HTTP/HTTPS tracker request.
+func (tc *TrackerClient) requestHTTPTracker(ctx context.Context, trackerURL string, req TrackerRequest) (*TrackerResponse, error) {
+	params := encodeHTTPAnnounce(req)
 
-	dict, ok := data.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("tracker response is not a dictionary")
+	dict, err := tc.getDictionary(ctx, trackerURL+"?"+params.Encode())
+	if err != nil {
+		return nil, err
 	}
 
 	return tc.parseTrackerResponse(dict)
 }
 
-func (tc *TrackerClient) requestUDPTracker(trackerURL string, t *torrent.TorrentFile, port int, event string) (*TrackerResponse, error) {
-	parsedURL, err := url.Parse(trackerURL)
+// getDictionary fetches a bencoded dictionary from an HTTP tracker.
+func (tc *TrackerClient) getDictionary(ctx context.Context, fullURL string) (map[string]interface{}, error) {
+	// Make request. Setting Accept-Encoding ourselves turns off Go's
+	// transparent decompression, so gzip bodies are unwrapped below.
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("invalid UDP tracker URL: %w", err)
+		return nil, fmt.Errorf("failed to build HTTP request: %w", err)
 	}
+	httpReq.Header.Set("Accept-Encoding", "gzip")
 
-	// Resolve address
-	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(parsedURL.Hostname(), parsedURL.Port()))
+	resp, err := tc.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve UDP address: %w", err)
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
+	defer resp.Body.Close()
 
-	// Create UDP connection
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create UDP connection: %w", err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP request failed with status: %d", resp.StatusCode)
 	}
-	defer conn.Close()
-
-	// Set timeout
-	conn.SetDeadline(time.Now().Add(15 * time.Second))
-
-	// Step 1: Send connect request
-	transactionID := make([]byte, 4)
-	rand.Read(transactionID)
-
-	connectReq := make([]byte, 16)
-	binary.BigEndian.PutUint64(connectReq[0:8], 0x41727101980) // Protocol ID
-	binary.BigEndian.PutUint32(connectReq[8:12], 0)            // Action: connect
-	copy(connectReq[12:16], transactionID)
 
-	_, err = conn.Write(connectReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send connect request: %w", err)
+	body := io.Reader(resp.Body)
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress tracker response: %w", err)
+		}
+		defer gz.Close()
+		body = gz
 	}
 
-	// Receive connect response
-	connectResp := make([]byte, 16)
-	n, err := conn.Read(connectResp)
+	// Parse response
+	decoder := bencode.NewDecoder(body)
+	data, err := decoder.Decode()
 	if err != nil {
-		return nil, fmt.Errorf("failed to receive connect response: %w", err)
-	}
-	if n != 16 {
-		return nil, fmt.Errorf("invalid connect response length: %d", n)
-	}
-
-	// Verify response
-	respAction := binary.BigEndian.Uint32(connectResp[0:4])
-	respTransactionID := connectResp[4:8]
-	if respAction != 0 || !bytes.Equal(respTransactionID, transactionID) {
-		return nil, fmt.Errorf("invalid connect response")
+		return nil, fmt.Errorf("failed to decode tracker response: %w", err)
 	}
 
-	connectionID := connectResp[8:16]
-
-	// Step 2: Send announce request
-	rand.Read(transactionID)
-
-	eventNum := uint32(0)
-	switch event {
-	case "started":
-		eventNum = 2
-	case "completed":
-		eventNum = 1
-	case "stopped":
-		eventNum = 3
+	dict, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("tracker response is not a dictionary")
 	}
 
-	announceReq := make([]byte, 98)
-	copy(announceReq[0:8], connectionID)                                            // Connection ID
-	binary.BigEndian.PutUint32(announceReq[8:12], 1)                                // Action: announce
-	copy(announceReq[12:16], transactionID)                                         // Transaction ID
-	copy(announceReq[16:36], t.InfoHash[:])                                         // Info hash
-	copy(announceReq[36:56], tc.peerID[:])                                          // Peer ID
-	binary.BigEndian.PutUint64(announceReq[56:64], 0)                               // Downloaded
-	binary.BigEndian.PutUint64(announceReq[64:72], uint64(t.Info.GetTotalLength())) // Left
-	binary.BigEndian.PutUint64(announceReq[72:80], 0)                               // Uploaded
-	binary.BigEndian.PutUint32(announceReq[80:84], eventNum)                        // Event
-	binary.BigEndian.PutUint32(announceReq[84:88], 0)                               // IP (default)
-	binary.BigEndian.PutUint32(announceReq[88:92], tc.key)                          // Key
-	binary.BigEndian.PutUint32(announceReq[92:96], 50)                              // Num want
-	binary.BigEndian.PutUint16(announceReq[96:98], uint16(port))                    // Port
-
-	_, err = conn.Write(announceReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send announce request: %w", err)
-	}
+	return dict, nil
+}
 
-	// Receive announce response
+func (tc *TrackerClient) requestUDPTracker(ctx context.Context, trackerURL string, req TrackerRequest) (*TrackerResponse, error) {
 	announceResp := make([]byte, 1024) // Buffer for response
-	n, err = conn.Read(announceResp)
+	n, addr, err := tc.udpRequest(ctx, trackerURL, udpActionAnnounce, func(connectionID, transactionID []byte) []byte {
+		return encodeUDPAnnounce(connectionID, transactionID, req)
+	}, announceResp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to receive announce response: %w", err)
+		return nil, fmt.Errorf("announce failed: %w", err)
 	}
 
 	if n < 20 {
 		return nil, fmt.Errorf("invalid announce response length: %d", n)
 	}
 
-	// Parse announce response
-	respAction = binary.BigEndian.Uint32(announceResp[0:4])
-	respTransactionID = announceResp[4:8]
-	if respAction != 1 || !bytes.Equal(respTransactionID, transactionID) {
-		return nil, fmt.Errorf("invalid announce response")
-	}
-
 	interval := binary.BigEndian.Uint32(announceResp[8:12])
 	leechers := binary.BigEndian.Uint32(announceResp[12:16])
 	seeders := binary.BigEndian.Uint32(announceResp[16:20])
 
-	// Parse peers (compact format)
-	peerData := announceResp[20:n]
-	if len(peerData)%6 != 0 {
-		return nil, fmt.Errorf("invalid peer data length: %d", len(peerData))
+	// Parse peers (compact format). Trackers reached over IPv6 answer with
+	// IPv6 peers.
+	ipLength := net.IPv4len
+	if addr.IP.To4() == nil {
+		ipLength = net.IPv6len
 	}
-
-	var peers []PeerInfo
-	for i := 0; i < len(peerData); i += 6 {
-		ip := net.IP(peerData[i : i+4])
-		port := binary.BigEndian.Uint16(peerData[i+4 : i+6])
-		peers = append(peers, PeerInfo{
-			IP:   ip.String(),
-			Port: int(port),
-		})
+	peers, err := parseCompact(announceResp[20:n], ipLength)
+	if err != nil {
+		return nil, err
 	}
 
 	return &TrackerResponse{
@@ -363,25 +433,59 @@ func (tc *TrackerClient) parseTrackerResponse(dict map[string]interface{}) (*Tra
 		}
 	}
 
+	// Parse IPv6 peers (BEP 7), which only come in compact form
+	if peers6, ok := dict["peers6"].([]byte); ok {
+		err := tc.parseCompact6Peers(peers6, resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse compact IPv6 peers: %w", err)
+		}
+	}
+
 	return resp, nil
 }
 
 func (tc *TrackerClient) parseCompactPeers(data []byte, resp *TrackerResponse) error {
-	if len(data)%6 != 0 {
-		return fmt.Errorf("invalid compact peers length: %d", len(data))
+	peers, err := parseCompact(data, net.IPv4len)
+	if err != nil {
+		return err
 	}
 
-	for i := 0; i < len(data); i += 6 {
-		ip := net.IP(data[i : i+4])
-		port := binary.BigEndian.Uint16(data[i+4 : i+6])
+	resp.Peers = append(resp.Peers, peers...)
+	return nil
+}
+
+// parseCompact6Peers parses the 18-byte records of a peers6 string: a
+// 16-byte IPv6 address followed by a 2-byte port.
+func (tc *TrackerClient) parseCompact6Peers(data []byte, resp *TrackerResponse) error {
+	peers, err := parseCompact(data, net.IPv6len)
+	if err != nil {
+		return err
+	}
+
+	resp.Peers = append(resp.Peers, peers...)
+	return nil
+}
+
+// parseCompact parses compact peer records, each an ipLength-byte address
+// followed by a 2-byte port in network order.
+func parseCompact(data []byte, ipLength int) ([]PeerInfo, error) {
+	recordLength := ipLength + 2
+	if len(data)%recordLength != 0 {
+		return nil, fmt.Errorf("invalid compact peers length: %d", len(data))
+	}
 
-		resp.Peers = append(resp.Peers, PeerInfo{
+	var peers []PeerInfo
+	for i := 0; i < len(data); i += recordLength {
+		ip := net.IP(data[i : i+ipLength])
+		port := binary.BigEndian.Uint16(data[i+ipLength : i+recordLength])
+
+		peers = append(peers, PeerInfo{
 			IP:   ip.String(),
 			Port: int(port),
 		})
 	}
 
-	return nil
+	return peers, nil
 }
 
 func (tc *TrackerClient) parseDictionaryPeers(peers []interface{}, resp *TrackerResponse) error {
@@ -456,7 +560,7 @@ func FormatPeers(peers []PeerInfo) string {
 			parts = append(parts, fmt.Sprintf("... and %d more", len(peers)-10))
 			break
 		}
-		parts = append(parts, fmt.Sprintf("%s:%d", peer.IP, peer.Port))
+		parts = append(parts, peer.Addr())
 	}
 
 	return strings.Join(parts, ", ")
@@ -0,0 +1,185 @@
+package tracker
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yashkadam007/bittorrent-client/internal/bencode"
+	"github.com/yashkadam007/bittorrent-client/internal/torrent"
+)
+
+// httpTracker is an HTTP tracker on a loopback port. It answers every
+// announce with its peers in compact form and records the queries.
+type httpTracker struct {
+	*httptest.Server
+	peers []PeerInfo // IPv4 peers handed out in every announce reply
+	gzip  bool       // Compress replies, as some trackers do
+
+	mutex   sync.Mutex
+	queries []url.Values // Announce queries received, in order
+}
+
+// newHTTPTracker starts an HTTP tracker that hands out peers, stopping it
+// when the test ends.
+func newHTTPTracker(t *testing.T, peers ...PeerInfo) *httpTracker {
+	t.Helper()
+	tr := &httpTracker{peers: peers}
+	tr.Server = httptest.NewServer(http.HandlerFunc(tr.announce))
+	t.Cleanup(tr.Close)
+	return tr
+}
+
+// announceURL returns the tracker's announce URL.
+func (tr *httpTracker) announceURL() string {
+	return tr.URL + "/announce"
+}
+
+// received returns the announce queries received so far.
+func (tr *httpTracker) received() []url.Values {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	return append([]url.Values(nil), tr.queries...)
+}
+
+func (tr *httpTracker) announce(w http.ResponseWriter, r *http.Request) {
+	tr.mutex.Lock()
+	tr.queries = append(tr.queries, r.URL.Query())
+	tr.mutex.Unlock()
+
+	body := io.Writer(w)
+	if tr.gzip {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		body = gz
+	}
+	bencode.NewEncoder(body).Encode(map[string]interface{}{
+		"interval": 1800,
+		"peers":    compactPeers(tr.peers),
+	})
+}
+
+// compactPeers encodes IPv4 peers in the compact form trackers reply with.
+func compactPeers(peers []PeerInfo) []byte {
+	var data []byte
+	for _, p := range peers {
+		data = append(data, net.ParseIP(p.IP).To4()...)
+		data = append(data, byte(p.Port>>8), byte(p.Port))
+	}
+	return data
+}
+
+// testTorrent returns a torrent announcing to trackerURLs, each in a tier
+// of its own. Its info hash is derived from the URLs, so torrents with
+// different trackers don't share a TrackerManager.
+func testTorrent(trackerURLs ...string) *torrent.TorrentFile {
+	t := &torrent.TorrentFile{InfoHash: sha1.Sum([]byte(strings.Join(trackerURLs, " ")))}
+	for _, trackerURL := range trackerURLs {
+		t.AnnounceList = append(t.AnnounceList, []string{trackerURL})
+	}
+	return t
+}
+
+func TestKeyStableAcrossTransports(t *testing.T) {
+	httpTr := newHTTPTracker(t)
+	udpTr := newUDPTracker(t)
+	tc := NewTrackerClientWithOptions(true)
+
+	// Announce to each transport a few times through the same client
+	ctx := context.Background()
+	for _, event := range []string{"started", "", "completed"} {
+		for _, trackerURL := range []string{httpTr.announceURL(), udpTr.announceURL()} {
+			if _, err := tc.GetPeers(ctx, testTorrent(trackerURL), 6881, event, AnnounceStats{}); err != nil {
+				t.Fatalf("announce to %s: %v", trackerURL, err)
+			}
+		}
+	}
+
+	queries, announces := httpTr.received(), udpTr.received()
+	if len(queries) != 3 || len(announces) != 3 {
+		t.Fatalf("%d HTTP and %d UDP announces, want 3 of each", len(queries), len(announces))
+	}
+	for i := range queries {
+		httpKey, err := strconv.ParseUint(queries[i].Get("key"), 10, 32)
+		if err != nil {
+			t.Fatalf("HTTP announce %d: bad key %q", i, queries[i].Get("key"))
+		}
+		if uint32(httpKey) != tc.key || announces[i].Key != tc.key {
+			t.Errorf("announce %d sent key %d over HTTP and %d over UDP, want %d on both",
+				i, httpKey, announces[i].Key, tc.key)
+		}
+	}
+
+	// Another session has a key of its own
+	if other := NewTrackerClientWithOptions(true); other.key == tc.key {
+		t.Errorf("two clients share key %d", tc.key)
+	}
+}
+
+func TestGzipResponse(t *testing.T) {
+	peers := []PeerInfo{{IP: "192.0.2.1", Port: 6881}, {IP: "192.0.2.2", Port: 51413}}
+	for _, compressed := range []bool{false, true} {
+		tr := newHTTPTracker(t, peers...)
+		tr.gzip = compressed
+
+		tc := NewTrackerClientWithOptions(true)
+		resp, err := tc.GetPeers(context.Background(), testTorrent(tr.announceURL()), 6881, "started", AnnounceStats{})
+		if err != nil {
+			t.Errorf("gzip %v: %v", compressed, err)
+			continue
+		}
+		if resp.Interval != 1800 || !reflect.DeepEqual(resp.Peers, peers) {
+			t.Errorf("gzip %v: interval %d, peers %v; want 1800, %v", compressed, resp.Interval, resp.Peers, peers)
+		}
+	}
+}
+
+func TestNumWant(t *testing.T) {
+	slots := func(n int) func() int { return func() int { return n } }
+	tests := []struct {
+		name      string
+		freeSlots func() int // nil leaves SetPeerSlots uncalled
+		event     string
+		want      int
+	}{
+		{"slots unknown", nil, "started", defaultNumWant},
+		{"no peers yet", slots(80), "started", 80},
+		{"nearly full", slots(5), "", 5},
+		{"full", slots(0), "", 0},
+		{"over full", slots(-3), "", 0},
+		{"capped", slots(1000), "", maxNumWant},
+		{"stopped", slots(40), "stopped", 0},
+		{"stopped, slots unknown", nil, "stopped", 0},
+	}
+
+	httpTr := newHTTPTracker(t)
+	udpTr := newUDPTracker(t)
+	for i, tc := range tests {
+		client := NewTrackerClientWithOptions(true)
+		if tc.freeSlots != nil {
+			client.SetPeerSlots(tc.freeSlots)
+		}
+		for _, trackerURL := range []string{httpTr.announceURL(), udpTr.announceURL()} {
+			if _, err := client.GetPeers(context.Background(), testTorrent(trackerURL), 6881, tc.event, AnnounceStats{}); err != nil {
+				t.Fatalf("%s: announce to %s: %v", tc.name, trackerURL, err)
+			}
+		}
+
+		httpNumWant := httpTr.received()[i].Get("numwant")
+		udpNumWant := udpTr.received()[i].NumWant
+		if httpNumWant != strconv.Itoa(tc.want) || udpNumWant != tc.want {
+			t.Errorf("%s: numwant %s over HTTP and %d over UDP, want %d", tc.name, httpNumWant, udpNumWant, tc.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// UDP tracker protocol (BEP 15) actions and timing.
+const (
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	udpProtocolID = 0x41727101980
+)
+
+var (
+	// udpTimeout is how long the first attempt at each step waits for a
+	// reply. BEP 15 doubles it on every retransmission.
+	udpTimeout = 15 * time.Second
+
+	// udpMaxRetries bounds retransmissions per step by default. BEP 15
+	// allows eight, which takes over an hour; two keeps a dead tracker from
+	// stalling the announce rotation for more than 105 seconds per step.
+	// SetUDPRetries raises it for lossy links.
+	udpMaxRetries = 2
+
+	// udpBEP15Retries is the most retransmissions BEP 15 makes per step.
+	udpBEP15Retries = 8
+
+	// udpConnectionIDLifetime is how long a tracker accepts a connection ID.
+	udpConnectionIDLifetime = time.Minute
+)
+
+// errUDPTimeout is returned when a step gets no reply after every retransmission.
+var errUDPTimeout = errors.New("UDP tracker did not respond")
+
+// udpTrackerError is an error message sent by a UDP tracker.
+type udpTrackerError string
+
+func (e udpTrackerError) Error() string {
+	return "tracker error: " + string(e)
+}
+
+// udpConnection is a connection ID obtained from a UDP tracker.
+type udpConnection struct {
+	id       [8]byte   // Connection ID to send with announces
+	obtained time.Time // When the tracker issued it
+}
+
+// udpConnections caches connection IDs per tracker address, so announces
+// within a minute of each other can skip the connect step.
+type udpConnections struct {
+	mutex sync.Mutex
+	ids   map[string]udpConnection
+}
+
+// get returns the cached connection ID for addr if it is still valid.
+func (c *udpConnections) get(addr string) ([8]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	conn, ok := c.ids[addr]
+	if !ok || time.Since(conn.obtained) >= udpConnectionIDLifetime {
+		return [8]byte{}, false
+	}
+	return conn.id, true
+}
+
+// put caches a connection ID that was issued at obtained.
+func (c *udpConnections) put(addr string, id [8]byte, obtained time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ids == nil {
+		c.ids = make(map[string]udpConnection)
+	}
+	c.ids[addr] = udpConnection{id: id, obtained: obtained}
+}
+
+// forget drops the cached connection ID for addr.
+func (c *udpConnections) forget(addr string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.ids, addr)
+}
+
+// udpRequest sends the request encode builds to the UDP tracker at
+// trackerURL and reads the reply into resp, connecting first if no valid
+// connection ID is cached. It returns the reply's length and the address
+// the tracker was reached at. Cancelling ctx abandons the request.
+func (tc *TrackerClient) udpRequest(ctx context.Context, trackerURL string, action uint32, encode func(connectionID, transactionID []byte) []byte, resp []byte) (int, *net.UDPAddr, error) {
+	parsedURL, err := url.Parse(trackerURL)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid UDP tracker URL: %w", err)
+	}
+
+	// Resolve the address and create the UDP connection
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(parsedURL.Hostname(), parsedURL.Port()))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create UDP connection: %w", err)
+	}
+	defer conn.Close()
+	addr := conn.RemoteAddr().(*net.UDPAddr)
+
+	// Send the request, reconnecting first if the connection ID runs out
+	// while we wait for a reply
+	key := addr.String()
+	build := func(transactionID []byte) ([]byte, error) {
+		connectionID, err := tc.udpConnect(ctx, conn, key)
+		if err != nil {
+			return nil, err
+		}
+		return encode(connectionID[:], transactionID), nil
+	}
+
+	n, err := udpRoundTrip(ctx, conn, action, build, resp, tc.udpRetries)
+
+	// A tracker that restarted or expired our ID early rejects the request;
+	// try once more with a fresh ID
+	var trackerErr udpTrackerError
+	if errors.As(err, &trackerErr) {
+		tc.udpConns.forget(key)
+		n, err = udpRoundTrip(ctx, conn, action, build, resp, tc.udpRetries)
+	}
+	return n, addr, err
+}
+
+// udpConnect returns a valid connection ID for the tracker at addr, reusing
+// a cached one unless it has expired.
+func (tc *TrackerClient) udpConnect(ctx context.Context, conn net.Conn, addr string) ([8]byte, error) {
+	if id, ok := tc.udpConns.get(addr); ok {
+		return id, nil
+	}
+
+	connectResp := make([]byte, 16)
+	sent := time.Now()
+	n, err := udpRoundTrip(ctx, conn, udpActionConnect, func(transactionID []byte) ([]byte, error) {
+		connectReq := make([]byte, 16)
+		binary.BigEndian.PutUint64(connectReq[0:8], udpProtocolID)
+		binary.BigEndian.PutUint32(connectReq[8:12], udpActionConnect)
+		copy(connectReq[12:16], transactionID)
+		return connectReq, nil
+	}, connectResp, tc.udpRetries)
+	if err != nil {
+		return [8]byte{}, fmt.Errorf("connect failed: %w", err)
+	}
+	if n != 16 {
+		return [8]byte{}, fmt.Errorf("invalid connect response length: %d", n)
+	}
+
+	// The ID's lifetime is counted from when we asked for it, so a slow
+	// reply can't make us use an ID the tracker has already expired
+	var id [8]byte
+	copy(id[:], connectResp[8:16])
+	tc.udpConns.put(addr, id, sent)
+	return id, nil
+}
+
+// udpRoundTrip sends the request built by build and waits for the reply
+// with the same transaction ID, retransmitting up to retries times with a
+// doubled timeout each time one goes unanswered. Each call has its own deadlines, so a slow
+// connect doesn't eat into the announce that follows. build is called before
+// every transmission, letting callers refresh anything that may have expired
+// while waiting. The reply is read into resp; its length is returned. The
+// wait ends early, with ctx's error, if ctx is cancelled.
+func udpRoundTrip(ctx context.Context, conn net.Conn, action uint32, build func(transactionID []byte) ([]byte, error), resp []byte, retries int) (int, error) {
+	transactionID := make([]byte, 4)
+	rand.Read(transactionID)
+
+	timeout := udpTimeout
+	for attempt := 0; attempt <= retries; attempt++ {
+		req, err := build(transactionID)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = conn.Write(req)
+		if err != nil {
+			return 0, fmt.Errorf("failed to send request: %w", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := readUDPReplyContext(ctx, conn, transactionID, resp)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			timeout *= 2
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to receive response: %w", err)
+		}
+
+		switch respAction := binary.BigEndian.Uint32(resp[0:4]); respAction {
+		case action:
+			return n, nil
+		case udpActionError:
+			return 0, udpTrackerError(resp[8:n])
+		default:
+			return 0, fmt.Errorf("unexpected action %d in response", respAction)
+		}
+	}
+
+	return 0, errUDPTimeout
+}
+
+// readUDPReplyContext is readUDPReply, giving up as soon as ctx is done.
+func readUDPReplyContext(ctx context.Context, conn net.Conn, transactionID, resp []byte) (int, error) {
+	var n int
+	var err error
+	done := make(chan struct{})
+	go func() {
+		n, err = readUDPReply(conn, transactionID, resp)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return n, err
+	case <-ctx.Done():
+		// Unblock the read, and wait for it so resp is ours again
+		conn.SetReadDeadline(time.Now())
+		<-done
+		return 0, ctx.Err()
+	}
+}
+
+// readUDPReply reads datagrams until one carries transactionID. Replies to
+// other transactions (e.g. late answers from an earlier step) are skipped.
+func readUDPReply(conn net.Conn, transactionID, resp []byte) (int, error) {
+	for {
+		n, err := conn.Read(resp)
+		if err != nil {
+			return 0, err
+		}
+		if n >= 8 && bytes.Equal(resp[4:8], transactionID) {
+			return n, nil
+		}
+	}
+}
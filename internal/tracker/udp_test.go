@@ -0,0 +1,194 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// udpTracker is a BEP 15 tracker on a loopback UDP port. It answers every
+// announce with its peers and records what was announced.
+type udpTracker struct {
+	conn  *net.UDPConn
+	peers []PeerInfo // IPv4 peers handed out in every announce reply
+
+	mutex         sync.Mutex
+	delay         time.Duration    // Wait before each reply, as a slow tracker does
+	connects      int              // Connect requests answered
+	announces     []TrackerRequest // Announces received, in order
+	connectionIDs []uint64         // Connection ID each announce was sent with
+}
+
+// newUDPTracker starts a UDP tracker that hands out peers, stopping it when
+// the test ends.
+func newUDPTracker(t *testing.T, peers ...PeerInfo) *udpTracker {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	tr := &udpTracker{conn: conn, peers: peers}
+	go tr.serve()
+	return tr
+}
+
+// announceURL returns the tracker's announce URL.
+func (tr *udpTracker) announceURL() string {
+	return "udp://" + tr.conn.LocalAddr().String() + "/announce"
+}
+
+// received returns the announces received so far.
+func (tr *udpTracker) received() []TrackerRequest {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	return append([]TrackerRequest(nil), tr.announces...)
+}
+
+// serve answers requests until the connection is closed.
+func (tr *udpTracker) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := tr.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n < 16 {
+			continue
+		}
+		if reply := tr.handle(buf[:n]); reply != nil {
+			tr.mutex.Lock()
+			delay := tr.delay
+			tr.mutex.Unlock()
+
+			time.Sleep(delay)
+			tr.conn.WriteToUDP(reply, addr)
+		}
+	}
+}
+
+// handle returns the reply to a request, or nil to ignore it.
+func (tr *udpTracker) handle(req []byte) []byte {
+	action := binary.BigEndian.Uint32(req[8:12])
+	transactionID := req[12:16]
+
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	switch {
+	case action == udpActionConnect && binary.BigEndian.Uint64(req[0:8]) == udpProtocolID:
+		tr.connects++
+		reply := make([]byte, 16)
+		binary.BigEndian.PutUint32(reply[0:4], udpActionConnect)
+		copy(reply[4:8], transactionID)
+		binary.BigEndian.PutUint64(reply[8:16], uint64(tr.connects))
+		return reply
+
+	case action == udpActionAnnounce && len(req) >= 98:
+		tr.announces = append(tr.announces, decodeUDPAnnounce(req))
+		tr.connectionIDs = append(tr.connectionIDs, binary.BigEndian.Uint64(req[0:8]))
+		reply := make([]byte, 20)
+		binary.BigEndian.PutUint32(reply[0:4], udpActionAnnounce)
+		copy(reply[4:8], transactionID)
+		binary.BigEndian.PutUint32(reply[8:12], 1800) // Interval
+		return append(reply, compactPeers(tr.peers)...)
+	}
+	return nil
+}
+
+// decodeUDPAnnounce reads the fields of an announce packet built by
+// encodeUDPAnnounce.
+func decodeUDPAnnounce(packet []byte) TrackerRequest {
+	req := TrackerRequest{
+		Downloaded: int64(binary.BigEndian.Uint64(packet[56:64])),
+		Left:       int64(binary.BigEndian.Uint64(packet[64:72])),
+		Uploaded:   int64(binary.BigEndian.Uint64(packet[72:80])),
+		Key:        binary.BigEndian.Uint32(packet[88:92]),
+		NumWant:    int(binary.BigEndian.Uint32(packet[92:96])),
+		Port:       int(binary.BigEndian.Uint16(packet[96:98])),
+	}
+	copy(req.InfoHash[:], packet[16:36])
+	copy(req.PeerID[:], packet[36:56])
+	switch binary.BigEndian.Uint32(packet[80:84]) {
+	case 1:
+		req.Event = "completed"
+	case 2:
+		req.Event = "started"
+	case 3:
+		req.Event = "stopped"
+	}
+	return req
+}
+
+// setUDPTiming sets the UDP timeout and connection ID lifetime until the
+// test ends.
+func setUDPTiming(t *testing.T, timeout, lifetime time.Duration) {
+	t.Helper()
+	savedTimeout, savedLifetime := udpTimeout, udpConnectionIDLifetime
+	udpTimeout, udpConnectionIDLifetime = timeout, lifetime
+	t.Cleanup(func() { udpTimeout, udpConnectionIDLifetime = savedTimeout, savedLifetime })
+}
+
+func TestUDPReconnectAfterExpiry(t *testing.T) {
+	setUDPTiming(t, udpTimeout, 200*time.Millisecond)
+	tr := newUDPTracker(t)
+	tc := NewTrackerClientWithOptions(true)
+	tf := testTorrent(tr.announceURL())
+
+	announce := func() {
+		t.Helper()
+		if _, err := tc.GetPeers(context.Background(), tf, 6881, "", AnnounceStats{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second announce reuses the first's connection ID
+	announce()
+	announce()
+	// Once it has expired, the third connects again
+	time.Sleep(300 * time.Millisecond)
+	announce()
+
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	if tr.connects != 2 {
+		t.Errorf("%d connects, want 2", tr.connects)
+	}
+	if want := []uint64{1, 1, 2}; !reflect.DeepEqual(tr.connectionIDs, want) {
+		t.Errorf("announced with connection IDs %v, want %v", tr.connectionIDs, want)
+	}
+}
+
+func TestUDPTimeoutPerStep(t *testing.T) {
+	// Connect and announce each take most of the timeout, together more
+	// than all of it
+	setUDPTiming(t, 400*time.Millisecond, udpConnectionIDLifetime)
+	tr := newUDPTracker(t, PeerInfo{IP: "192.0.2.1", Port: 6881})
+	tr.mutex.Lock()
+	tr.delay = 250 * time.Millisecond
+	tr.mutex.Unlock()
+
+	tc := NewTrackerClientWithOptions(true)
+	tc.SetUDPRetries(0)
+	resp, err := tc.GetPeers(context.Background(), testTorrent(tr.announceURL()), 6881, "started", AnnounceStats{})
+	if err != nil {
+		t.Fatalf("announce with slow steps failed: %v", err)
+	}
+	if len(resp.Peers) != 1 {
+		t.Errorf("got peers %v, want the tracker's one", resp.Peers)
+	}
+
+	// A step slower than the timeout still fails
+	tr.mutex.Lock()
+	tr.delay = 600 * time.Millisecond
+	tr.mutex.Unlock()
+	_, err = tc.GetPeers(context.Background(), testTorrent(tr.announceURL()), 6881, "", AnnounceStats{})
+	if err == nil {
+		t.Error("announce slower than the timeout succeeded")
+	}
+}
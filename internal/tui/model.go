@@ -8,14 +8,16 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/yashkadam007/bittorrent-client/internal/download"
+	"github.com/yashkadam007/bittorrent-client/internal/pieces"
+	"github.com/yashkadam007/bittorrent-client/internal/storage"
 )
 
 // Model represents the terminal UI state
 type Model struct {
 	// Download state
 	torrentName     string
-	totalSize       int64
 	downloadManager *download.DownloadManager
+	fileStorage     *storage.FileStorage // Source of per-file progress, if set
 
 	// UI state
 	width      int
@@ -24,42 +26,71 @@ type Model struct {
 
 	// Cached stats for display
 	stats    download.DownloadStats
-	progress ProgressInfo
+	progress pieces.Progress
+	have     *pieces.Bitfield // Pieces we have
+	started  map[int]bool     // Pieces being downloaded
 	peers    []PeerInfo
+	peerTop  int                    // Index of the first peer shown in the peer list
+	files    []storage.FileProgress // Per-file progress, refreshed while shown
+	paused   error                  // Why the download is paused, nil while running
+	moveErr  error                  // Why the finished download couldn't be moved, if it couldn't
+	seeded   bool                   // A seeding limit was reached and the download stopped
 
 	// UI flags
-	showHelp bool
-	quitting bool
-}
-
-// ProgressInfo holds download progress information
-type ProgressInfo struct {
-	CompletedPieces int
-	TotalPieces     int
-	Percentage      float64
-	DownloadedBytes int64
-	TotalBytes      int64
+	showHelp  bool
+	showFiles bool // Show per-file progress
+	quitting  bool
+
+	// Post-completion countdown
+	autoQuit     time.Duration // How long to wait after completion before quitting; 0 stays running
+	countingDown bool          // The download is complete and the countdown is running
+	quitIn       time.Duration // Time left on the countdown
 }
 
 // PeerInfo holds information about connected peers
 type PeerInfo struct {
 	Address         string
+	Client          string // Client name decoded from the peer ID
+	Capabilities    string // Advertised extensions, e.g. "EXT DHT FAST"
 	DownloadedBytes int64
+	DownloadSpeed   float64 // Bytes/second received from the peer
+	RequestDepth    int     // Requests kept in flight to the peer
+	Pieces          int     // Pieces the peer has
 	Status          string
+	Upload          string // "up" while we upload to the peer, "up*" if optimistically, else "-"
 }
 
+// maxFileRows caps how many files the file panel lists
+const maxFileRows = 10
+
+// maxPeerRows is how many peers the peer list shows before the terminal
+// size is known
+const maxPeerRows = 8
+
 // NewModel creates a new TUI model
 func NewModel(torrentName string, totalSize int64, dm *download.DownloadManager) Model {
+	return NewModelWithOptions(torrentName, totalSize, dm, 0)
+}
+
+// NewModelWithOptions creates a new TUI model that quits autoQuit after the
+// download completes. Zero keeps it running until the user quits.
+func NewModelWithOptions(torrentName string, totalSize int64, dm *download.DownloadManager, autoQuit time.Duration) Model {
 	return Model{
 		torrentName:     torrentName,
-		totalSize:       totalSize,
+		progress:        pieces.Progress{TotalBytes: totalSize},
 		downloadManager: dm,
 		lastUpdate:      time.Now(),
 		showHelp:        false,
 		quitting:        false,
+		autoQuit:        autoQuit,
 	}
 }
 
+// SetFileStorage lets the model show per-file progress from fs.
+func (m *Model) SetFileStorage(fs *storage.FileStorage) {
+	m.fileStorage = fs
+}
+
 // Init initializes the model (required by bubbletea)
 func (m Model) Init() tea.Cmd {
 	return tea.Batch(
@@ -84,6 +115,44 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "h", "?":
 			m.showHelp = !m.showHelp
 			return m, nil
+		case "s":
+			// Stay running (e.g. to keep seeding) instead of quitting
+			m.countingDown = false
+			return m, nil
+		case "f":
+			m.showFiles = !m.showFiles
+			m.updateStats()
+			return m, nil
+		case "up", "k":
+			m.peerTop = max(m.peerTop-1, 0)
+			return m, nil
+		case "down", "j":
+			m.peerTop = min(m.peerTop+1, max(len(m.peers)-1, 0))
+			return m, nil
+		case "a":
+			// Ask the tracker for more peers, within its min interval
+			if m.downloadManager != nil {
+				m.downloadManager.Reannounce()
+			}
+			return m, nil
+		case "p":
+			// Pause or resume at the user's request
+			if m.downloadManager != nil {
+				if m.paused == nil {
+					m.downloadManager.Pause(download.ErrPausedByUser)
+				} else {
+					m.downloadManager.Resume()
+				}
+				m.paused = m.downloadManager.PauseReason()
+			}
+			return m, nil
+		case "r":
+			// Resume after e.g. freeing disk space
+			if m.downloadManager != nil {
+				m.downloadManager.Resume()
+				m.paused = nil
+			}
+			return m, nil
 		}
 
 	case tickMsg:
@@ -93,10 +162,31 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case completionMsg:
 		// Download completed
-		m.progress.Percentage = 100.0
 		m.progress.CompletedPieces = m.progress.TotalPieces
+		m.progress.VerifiedBytes = m.progress.TotalBytes
+		m.moveErr = msg.moveErr
+		if m.autoQuit <= 0 || m.countingDown {
+			return m, nil
+		}
+		m.countingDown = true
+		m.quitIn = m.autoQuit
+		return m, countdownCmd()
+
+	case seedingDoneMsg:
+		m.seeded = true
 		return m, nil
 
+	case countdownMsg:
+		if !m.countingDown {
+			return m, nil
+		}
+		m.quitIn -= time.Second
+		if m.quitIn <= 0 {
+			m.quitting = true
+			return m, tea.Quit
+		}
+		return m, countdownCmd()
+
 	case tea.QuitMsg:
 		return m, tea.Quit
 	}
@@ -125,15 +215,56 @@ func (m *Model) updateStats() {
 
 	// Get download statistics
 	m.stats = m.downloadManager.GetStats()
+	m.paused = m.downloadManager.PauseReason()
 
 	// Get progress information
-	completed, total, percentage := m.downloadManager.GetProgress()
-	m.progress = ProgressInfo{
-		CompletedPieces: completed,
-		TotalPieces:     total,
-		Percentage:      percentage,
-		DownloadedBytes: m.stats.DownloadedBytes,
-		TotalBytes:      m.totalSize,
+	m.progress = m.downloadManager.GetProgress()
+
+	if m.showFiles && m.fileStorage != nil {
+		m.files = m.fileStorage.GetPerFileProgress()
+	}
+
+	// Get piece states for the piece map
+	m.have = m.downloadManager.GetPieceBitfield()
+	m.started = make(map[int]bool)
+	for _, pieceIndex := range m.downloadManager.GetInProgressPieces() {
+		m.started[pieceIndex] = true
+	}
+
+	// Get per-peer information
+	uploads := make(map[string]string)
+	for _, c := range m.downloadManager.GetChokeState() {
+		switch {
+		case c.Choking:
+			uploads[c.Address] = "-"
+		case c.Optimistic:
+			uploads[c.Address] = "up*"
+		default:
+			uploads[c.Address] = "up"
+		}
+	}
+
+	m.peers = nil
+	for _, p := range m.downloadManager.GetPeerStats() {
+		status := "unchoked"
+		if p.Choked {
+			status = "choked"
+		}
+		capabilities := strings.Join(p.Capabilities, " ")
+		if capabilities == "" {
+			capabilities = "-"
+		}
+		m.peers = append(m.peers, PeerInfo{
+			Address:         p.Address,
+			Client:          p.Client,
+			Capabilities:    capabilities,
+			DownloadedBytes: p.DownloadedBytes,
+			DownloadSpeed:   p.DownloadSpeed,
+			RequestDepth:    p.RequestDepth,
+			Pieces:          p.Pieces,
+			Status:          status,
+			Upload:          uploads[p.Address],
+		})
 	}
 
 	m.lastUpdate = time.Now()
@@ -155,8 +286,22 @@ func (m Model) mainView() string {
 	// Piece visualization
 	sections = append(sections, m.pieceView())
 
+	// Per-file progress
+	if m.showFiles {
+		sections = append(sections, m.filesView())
+	}
+
+	// Peer list, in whatever height the other sections leave
+	footer := m.footerView()
+	rows := maxPeerRows
+	if m.height > 0 {
+		used := lipgloss.Height(lipgloss.JoinVertical(lipgloss.Left, append(sections, footer)...))
+		rows = max(m.height-used-3, 1) // Title and blank lines around the list
+	}
+	sections = append(sections, m.peersView(rows))
+
 	// Footer
-	sections = append(sections, m.footerView())
+	sections = append(sections, footer)
 
 	return lipgloss.JoinVertical(lipgloss.Left, sections...)
 }
@@ -173,6 +318,13 @@ func (m Model) headerView() string {
 		Foreground(lipgloss.Color("#059669")).
 		Render(m.torrentName)
 
+	if m.paused != nil {
+		name += " " + lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#DC2626")).
+			Render("PAUSED")
+	}
+
 	return fmt.Sprintf("%s\n%s\n", title, name)
 }
 
@@ -187,7 +339,7 @@ func (m Model) progressView() string {
 		progressWidth = 60
 	}
 
-	completed := int(float64(progressWidth) * (m.progress.Percentage / 100))
+	completed := int(float64(progressWidth) * (m.progress.Percentage() / 100))
 	remaining := progressWidth - completed
 
 	progressBar := strings.Repeat("█", completed) + strings.Repeat("░", remaining)
@@ -195,7 +347,7 @@ func (m Model) progressView() string {
 	progressStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#10B981"))
 
-	percentage := fmt.Sprintf("%.1f%%", m.progress.Percentage)
+	percentage := fmt.Sprintf("%.1f%%", m.progress.Percentage())
 
 	return fmt.Sprintf("\n📥 Download Progress:\n%s %s\n",
 		progressStyle.Render(progressBar), percentage)
@@ -206,7 +358,7 @@ func (m Model) statsView() string {
 	_ = time.Since(m.stats.StartTime) // For potential future use
 
 	// Format file sizes
-	downloadedSize := formatBytes(m.progress.DownloadedBytes)
+	downloadedSize := formatBytes(m.progress.VerifiedBytes)
 	totalSize := formatBytes(m.progress.TotalBytes)
 
 	// Format speed
@@ -214,8 +366,12 @@ func (m Model) statsView() string {
 
 	// Calculate ETA
 	eta := "∞"
-	if m.stats.DownloadSpeed > 0 {
-		remaining := float64(m.progress.TotalBytes - m.progress.DownloadedBytes)
+	if m.paused != nil {
+		// Blocks requested before pausing still trickle in
+		speed = formatSpeed(0)
+		eta = "paused"
+	} else if m.stats.DownloadSpeed > 0 {
+		remaining := float64(m.progress.TotalBytes - m.progress.VerifiedBytes)
 		etaSeconds := remaining / m.stats.DownloadSpeed
 		eta = formatDuration(time.Duration(etaSeconds) * time.Second)
 	}
@@ -223,10 +379,11 @@ func (m Model) statsView() string {
 	statsStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#6366F1"))
 
-	return fmt.Sprintf("\n📊 Statistics:\n%s\n%s\n%s\n%s\n%s\n",
+	return fmt.Sprintf("\n📊 Statistics:\n%s\n%s\n%s\n%s\n%s\n%s\n",
 		statsStyle.Render(fmt.Sprintf("Size:      %s / %s", downloadedSize, totalSize)),
 		statsStyle.Render(fmt.Sprintf("Pieces:    %d / %d", m.progress.CompletedPieces, m.progress.TotalPieces)),
 		statsStyle.Render(fmt.Sprintf("Speed:     %s", speed)),
+		statsStyle.Render(fmt.Sprintf("Uploaded:  %s (ratio %.2f)", formatBytes(m.stats.UploadedBytes), m.stats.Ratio)),
 		statsStyle.Render(fmt.Sprintf("Peers:     %d", m.stats.PeersConnected)),
 		statsStyle.Render(fmt.Sprintf("ETA:       %s", eta)),
 	)
@@ -234,35 +391,54 @@ func (m Model) statsView() string {
 
 // pieceView renders piece completion visualization
 func (m Model) pieceView() string {
-	if m.progress.TotalPieces == 0 {
+	if m.have == nil || m.have.GetNumPieces() == 0 {
 		return ""
 	}
 
+	// The map covers every piece, wanted or not
+	numPieces := m.have.GetNumPieces()
+
 	// Limit visualization to reasonable size
 	maxPieces := 100
-	displayPieces := m.progress.TotalPieces
+	displayPieces := numPieces
 	if displayPieces > maxPieces {
 		displayPieces = maxPieces
 	}
 
 	// Calculate pieces per display unit
-	piecesPerUnit := float64(m.progress.TotalPieces) / float64(displayPieces)
+	piecesPerUnit := float64(numPieces) / float64(displayPieces)
 
-	var pieces []string
+	var cells []string
 	for i := 0; i < displayPieces; i++ {
 		startPiece := int(float64(i) * piecesPerUnit)
-		_ = int(float64(i+1) * piecesPerUnit) // endPiece for potential future use
+		endPiece := int(float64(i+1) * piecesPerUnit)
+		if i == displayPieces-1 {
+			endPiece = numPieces
+		}
 
-		// Check if all pieces in this range are complete
-		// For simplification, we'll use overall completion percentage
-		completed := float64(startPiece) < float64(m.progress.CompletedPieces)
+		// A cell is complete only if every piece it covers is, and in
+		// progress if any of them has been started or finished
+		have := 0
+		started := false
+		for pieceIndex := startPiece; pieceIndex < endPiece; pieceIndex++ {
+			if m.have.HasPiece(pieceIndex) {
+				have++
+			} else if m.started[pieceIndex] {
+				started = true
+			}
+		}
 
-		if completed {
-			pieces = append(pieces, lipgloss.NewStyle().
+		switch {
+		case have == endPiece-startPiece:
+			cells = append(cells, lipgloss.NewStyle().
 				Foreground(lipgloss.Color("#10B981")).
 				Render("█"))
-		} else {
-			pieces = append(pieces, lipgloss.NewStyle().
+		case have > 0 || started:
+			cells = append(cells, lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#F59E0B")).
+				Render("▒"))
+		default:
+			cells = append(cells, lipgloss.NewStyle().
 				Foreground(lipgloss.Color("#6B7280")).
 				Render("░"))
 		}
@@ -271,23 +447,118 @@ func (m Model) pieceView() string {
 	// Break into multiple lines if too wide
 	piecesPerLine := 50
 	var lines []string
-	for i := 0; i < len(pieces); i += piecesPerLine {
+	for i := 0; i < len(cells); i += piecesPerLine {
 		end := i + piecesPerLine
-		if end > len(pieces) {
-			end = len(pieces)
+		if end > len(cells) {
+			end = len(cells)
 		}
-		lines = append(lines, strings.Join(pieces[i:end], ""))
+		lines = append(lines, strings.Join(cells[i:end], ""))
 	}
 
 	return fmt.Sprintf("\n🧩 Pieces:\n%s\n", strings.Join(lines, "\n"))
 }
 
+// filesView renders the progress of each file of a multi-file torrent
+func (m Model) filesView() string {
+	if len(m.files) <= 1 {
+		return ""
+	}
+
+	fileStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#0EA5E9"))
+
+	var lines []string
+	for i, f := range m.files {
+		if i == maxFileRows {
+			lines = append(lines, fileStyle.Render(fmt.Sprintf("... and %d more", len(m.files)-maxFileRows)))
+			break
+		}
+		percentage := 100.0
+		if f.Total > 0 {
+			percentage = float64(f.Downloaded) / float64(f.Total) * 100
+		}
+		lines = append(lines, fileStyle.Render(fmt.Sprintf("%5.1f%% %10s / %-10s %s",
+			percentage, formatBytes(f.Downloaded), formatBytes(f.Total), f.Path)))
+	}
+
+	return fmt.Sprintf("\n📁 Files:\n%s\n", strings.Join(lines, "\n"))
+}
+
+// peersView renders up to rows of the connected peers with their client,
+// capabilities, transfer and request depth, starting at peerTop. The list scrolls with the
+// arrow keys when there are more.
+func (m Model) peersView(rows int) string {
+	if len(m.peers) == 0 {
+		return ""
+	}
+
+	peerStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#6366F1"))
+
+	// Peers may have left since the list was scrolled
+	top := max(min(m.peerTop, len(m.peers)-rows), 0)
+	end := min(top+rows, len(m.peers))
+
+	var lines []string
+	for _, p := range m.peers[top:end] {
+		lines = append(lines, peerStyle.Render(fmt.Sprintf("%-21s %-10s %-12s %-8s %-3s %10s/s %3d req %5d pcs %s",
+			p.Address, p.Client, p.Capabilities, p.Status, p.Upload,
+			formatBytes(int64(p.DownloadSpeed)), p.RequestDepth, p.Pieces, formatBytes(p.DownloadedBytes))))
+	}
+
+	title := "👥 Peers:"
+	if top > 0 || end < len(m.peers) {
+		title = fmt.Sprintf("👥 Peers %d-%d of %d (↑/↓ to scroll):", top+1, end, len(m.peers))
+	}
+	return fmt.Sprintf("\n%s\n%s\n", title, strings.Join(lines, "\n"))
+}
+
 // footerView renders the footer with help info
 func (m Model) footerView() string {
 	helpStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#6B7280")).
 		Italic(true)
 
+	if m.moveErr != nil {
+		errorStyle := lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#DC2626"))
+
+		return fmt.Sprintf("\n%s\n%s\n",
+			errorStyle.Render(fmt.Sprintf("⚠ Download complete, but not moved: %v", m.moveErr)),
+			helpStyle.Render("Press 'h' for help • 'q' to quit"))
+	}
+
+	if m.countingDown {
+		countdownStyle := lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#10B981"))
+
+		return fmt.Sprintf("\n%s\n%s\n",
+			countdownStyle.Render(fmt.Sprintf("✅ Download complete, quitting in %s", formatDuration(m.quitIn))),
+			helpStyle.Render("Press 's' to stay • 'q' to quit now"))
+	}
+
+	if m.seeded {
+		seededStyle := lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#10B981"))
+
+		return fmt.Sprintf("\n%s\n%s\n",
+			seededStyle.Render("✅ Seeding limit reached, stopped uploading"),
+			helpStyle.Render("Press 'q' to quit"))
+	}
+
+	if m.paused != nil {
+		pausedStyle := lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#DC2626"))
+
+		return fmt.Sprintf("\n%s\n%s\n",
+			pausedStyle.Render(fmt.Sprintf("⏸ Paused: %v", m.paused)),
+			helpStyle.Render("Press 'p' or 'r' to resume • 'q' to quit"))
+	}
+
 	return fmt.Sprintf("\n%s\n",
 		helpStyle.Render("Press 'h' for help • 'q' to quit"))
 }
@@ -303,13 +574,22 @@ func (m Model) helpView() string {
 
 Keyboard Controls:
   h, ?    Toggle this help screen
+  s       Stay running after completion (cancels auto-quit)
+  p       Pause or resume the download
+  r       Resume a paused download (e.g. after freeing disk space)
+  f       Show or hide per-file progress (multi-file torrents)
+  a       Re-announce to the tracker (no sooner than it allows)
+  ↑/↓     Scroll the peer list (also k/j)
   q       Quit the application
   Ctrl+C  Force quit
 
 Information Display:
   📥 Progress bar shows download completion
   📊 Statistics show speed, peers, and ETA
-  🧩 Piece visualization shows which parts are complete
+  🧩 Piece visualization shows which parts are complete (█), in
+     progress (▒) or not started (░)
+  👥 Peers show client, capabilities (EXT, DHT, FAST), status, and
+     "up" while we upload to them ("up*" for the optimistic unchoke)
 
 The client automatically:
   • Connects to peers from trackers
@@ -381,7 +661,15 @@ func formatDuration(d time.Duration) string {
 type tickMsg time.Time
 
 // completionMsg is sent when download completes
-type completionMsg struct{}
+type completionMsg struct {
+	moveErr error // Error moving the download to the complete directory
+}
+
+// seedingDoneMsg is sent when a seeding limit stops the download
+type seedingDoneMsg struct{}
+
+// countdownMsg advances the post-completion countdown by one second
+type countdownMsg struct{}
 
 // tickCmd returns a command that sends a tick message every second
 func tickCmd() tea.Cmd {
@@ -389,3 +677,10 @@ func tickCmd() tea.Cmd {
 		return tickMsg(t)
 	})
 }
+
+// countdownCmd returns a command that sends a countdown message after a second
+func countdownCmd() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return countdownMsg{}
+	})
+}
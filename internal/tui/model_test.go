@@ -0,0 +1,75 @@
+package tui
+
+import (
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// update passes msg to m's Update and returns the new model and command.
+func update(t *testing.T, m Model, msg tea.Msg) (Model, tea.Cmd) {
+	t.Helper()
+	next, cmd := m.Update(msg)
+	return next.(Model), cmd
+}
+
+// quits reports whether cmd is tea.Quit.
+func quits(cmd tea.Cmd) bool {
+	if cmd == nil {
+		return false
+	}
+	_, ok := cmd().(tea.QuitMsg)
+	return ok
+}
+
+func TestCountdownQuitsAfterCompletion(t *testing.T) {
+	m := NewModelWithOptions("test", 1024, nil, 3*time.Second)
+
+	m, cmd := update(t, m, completionMsg{})
+	if !m.countingDown || cmd == nil {
+		t.Fatal("completion didn't start the countdown")
+	}
+	if m.quitIn != 3*time.Second {
+		t.Errorf("countdown starts at %s, want 3s", m.quitIn)
+	}
+
+	// Each countdown message takes off a second and schedules the next one
+	for i := 0; i < 2; i++ {
+		m, cmd = update(t, m, countdownMsg{})
+		if m.quitting || cmd == nil {
+			t.Fatalf("stopped counting down with %s left", m.quitIn)
+		}
+	}
+	m, cmd = update(t, m, countdownMsg{})
+	if !m.quitting || !quits(cmd) {
+		t.Error("didn't quit when the countdown ran out")
+	}
+}
+
+func TestCountdownCancelled(t *testing.T) {
+	m := NewModelWithOptions("test", 1024, nil, 2*time.Second)
+	m, _ = update(t, m, completionMsg{})
+
+	// "s" stays running, and the countdown message already on its way is
+	// ignored
+	m, _ = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	if m.countingDown {
+		t.Fatal("s didn't stop the countdown")
+	}
+	for i := 0; i < 3; i++ {
+		var cmd tea.Cmd
+		m, cmd = update(t, m, countdownMsg{})
+		if m.quitting || cmd != nil {
+			t.Fatal("countdown carried on after s")
+		}
+	}
+}
+
+func TestNoCountdownWithoutAutoQuit(t *testing.T) {
+	m := NewModel("test", 1024, nil)
+	m, cmd := update(t, m, completionMsg{})
+	if m.countingDown || cmd != nil {
+		t.Error("countdown started without an autoquit delay")
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/yashkadam007/bittorrent-client/internal/dht"
 	"github.com/yashkadam007/bittorrent-client/internal/download"
+	"github.com/yashkadam007/bittorrent-client/internal/peer"
 	"github.com/yashkadam007/bittorrent-client/internal/pieces"
 	"github.com/yashkadam007/bittorrent-client/internal/storage"
 	"github.com/yashkadam007/bittorrent-client/internal/torrent"
@@ -22,12 +24,15 @@ type Runner struct {
 	outputDir string
 	port      int
 	verbose   bool
+	options   Options
 
 	// Download components
 	pieceManager    *pieces.PieceManager
 	fileStorage     *storage.FileStorage
 	downloadManager *download.DownloadManager
 	trackerClient   *tracker.TrackerClient
+	dhtNode         *dht.Node
+	listener        *peer.Listener
 
 	// TUI
 	program *tea.Program
@@ -38,14 +43,32 @@ type Runner struct {
 	cancel context.CancelFunc
 }
 
+// Options holds optional settings for the TUI runner.
+type Options struct {
+	Storage       storage.Options       // How downloaded files and directories are created
+	Download      download.Options      // Peer connection tuning
+	Media         download.MediaOptions // Fetch the first and last pieces first, then in order
+	VerifyWorkers int                   // Maximum concurrent piece hash checks (0 means one per CPU)
+	SpreadBlocks  bool                  // Start each peer at a different block within a shared piece
+	UDPRetries    int                   // Retransmissions per UDP tracker step (0 keeps the default)
+	AutoQuit      time.Duration         // Quit this long after completion (0 keeps running)
+	CompleteDir   string                // Move the download here once it completes (empty leaves it in place)
+}
+
 // NewRunner creates a new TUI runner
-func NewRunner(torrentPath, outputDir string, port int, verbose bool) (*Runner, error) {
+func NewRunner(torrentPath, outputDir string, port int, verbose bool, options Options) (*Runner, error) {
 	// Parse torrent file
 	t, err := torrent.ParseTorrentFile(torrentPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse torrent file: %w", err)
 	}
 
+	return NewRunnerFor(t, outputDir, port, verbose, options), nil
+}
+
+// NewRunnerFor creates a TUI runner for an already loaded torrent, such as
+// one whose metadata was fetched for a magnet link.
+func NewRunnerFor(t *torrent.TorrentFile, outputDir string, port int, verbose bool, options Options) *Runner {
 	// Create context for cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -54,11 +77,12 @@ func NewRunner(torrentPath, outputDir string, port int, verbose bool) (*Runner,
 		outputDir: outputDir,
 		port:      port,
 		verbose:   verbose,
+		options:   options,
 		ctx:       ctx,
 		cancel:    cancel,
 	}
 
-	return runner, nil
+	return runner
 }
 
 // Run starts the TUI and download process
@@ -70,7 +94,8 @@ func (r *Runner) Run() error {
 	}
 
 	// Create TUI model
-	r.model = NewModel(r.torrent.Info.Name, r.torrent.Info.GetTotalLength(), r.downloadManager)
+	r.model = NewModelWithOptions(r.torrent.Info.Name, r.torrent.Info.GetTotalLength(), r.downloadManager, r.options.AutoQuit)
+	r.model.SetFileStorage(r.fileStorage)
 
 	// Create TUI program
 	r.program = tea.NewProgram(r.model, tea.WithAltScreen())
@@ -101,8 +126,15 @@ func (r *Runner) initializeComponents() error {
 		true, // quiet mode for TUI
 	)
 
+	// Share one hashing limit between the on-disk check and the download
+	verifier := pieces.NewVerifier(r.options.VerifyWorkers)
+	r.pieceManager.SetVerifier(verifier)
+	r.pieceManager.SetSpreadBlocks(r.options.SpreadBlocks)
+	storageOpts := r.options.Storage
+	storageOpts.Verifier = verifier
+
 	// Create file storage
-	r.fileStorage, err = storage.NewFileStorage(r.torrent, r.outputDir)
+	r.fileStorage, err = storage.NewFileStorageWithOptions(r.torrent, r.outputDir, storageOpts)
 	if err != nil {
 		return fmt.Errorf("failed to create file storage: %w", err)
 	}
@@ -111,33 +143,65 @@ func (r *Runner) initializeComponents() error {
 	existingBitfield, err := r.fileStorage.GetCompletionBitfield()
 	if err == nil && existingBitfield != nil && existingBitfield.GetNumCompletePieces() > 0 {
 		// Update piece manager with existing progress
-		for i := 0; i < existingBitfield.GetNumPieces(); i++ {
-			if existingBitfield.HasPiece(i) {
-				// Mark piece as complete in piece manager
-				// This is a simplified approach - in a full implementation,
-				// you'd restore the actual piece data
-			}
-		}
+		r.pieceManager.SetCompleted(existingBitfield)
 	}
+	r.pieceManager.SetStorage(r.fileStorage)
 
 	// Create tracker client
-	r.trackerClient = tracker.NewTrackerClient()
+	r.trackerClient = tracker.NewTrackerClientWithOptions(true)
+	if r.options.UDPRetries > 0 {
+		r.trackerClient.SetUDPRetries(r.options.UDPRetries)
+	}
 
-	// Create download manager with rarest-first strategy (quiet mode for TUI)
-	strategy := download.NewRarestFirstStrategy()
-	r.downloadManager = download.NewDownloadManagerWithOptions(r.pieceManager, strategy, true)
+	// Create download manager with rarest-first (or media mode) strategy, quiet for TUI
+	strategy := download.NewStrategyFor(r.torrent.Info.GetNumPieces(), r.options.Media)
+	downloadOpts := r.options.Download
+	downloadOpts.Quiet = true
+	r.downloadManager = download.NewDownloadManagerWithOptions(r.pieceManager, strategy, downloadOpts)
+	r.trackerClient.SetPeerSlots(r.downloadManager.FreePeerSlots)
+	r.downloadManager.SetStorage(r.fileStorage)
+
+	// Learn more peers from the ones we're connected to. Like the DHT
+	// below, this is off for private torrents (see torrent.IsPrivate).
+	if !r.torrent.IsPrivate() {
+		r.downloadManager.EnablePEX(r.torrent.InfoHash, r.trackerClient.GetPeerID(), r.port)
+	}
+
+	// Trackerless torrents find peers through the DHT instead. Private
+	// torrents never use it.
+	if r.torrent.IsTrackerless() && !r.torrent.IsPrivate() {
+		r.dhtNode, err = dht.Join(r.ctx, r.port, dht.BootstrapNodes(r.torrent), true)
+		if err != nil {
+			return fmt.Errorf("failed to join the DHT: %w", err)
+		}
+		r.downloadManager.SetDHT(r.dhtNode.Port(), r.dhtNode.AddNode)
+	}
 
 	return nil
 }
 
+// announce gets peers from the tracker, or the DHT for trackerless torrents.
+func (r *Runner) announce(event string) (*tracker.TrackerResponse, error) {
+	if r.dhtNode != nil {
+		return r.dhtNode.Announce(r.ctx, r.torrent.InfoHash)
+	}
+	return r.trackerClient.GetPeers(r.ctx, r.torrent, r.port, event, r.downloadManager.AnnounceStats())
+}
+
 // startDownload begins the download process
 func (r *Runner) startDownload() {
 	// Start download manager
 	r.downloadManager.Start()
-	defer r.downloadManager.Stop()
+
+	// Accept inbound peers; without a listener we can still download outbound
+	listener, err := peer.ListenWithOptions(fmt.Sprintf(":%d", r.port), r.torrent.InfoHash, r.trackerClient.GetPeerID(), true, r.downloadManager.PeerOptions())
+	if err == nil {
+		r.listener = listener
+		go listener.Serve(r.downloadManager.AddInboundPeer)
+	}
 
 	// Get initial peers from tracker (silently in TUI mode)
-	trackerResp, err := r.trackerClient.GetPeers(r.torrent, r.port, "started")
+	trackerResp, err := r.announce("started")
 	if err != nil {
 		// In TUI mode, we don't print errors to stdout as it interferes with the UI
 		// Errors will be visible in the TUI interface or logs
@@ -149,66 +213,44 @@ func (r *Runner) startDownload() {
 		return
 	}
 
-	// Add peers to download manager
-	r.downloadManager.AddPeers(trackerResp.Peers, r.torrent.InfoHash, r.trackerClient.GetPeerID())
-
-	// Periodic tracker announcements
-	go r.announceToTracker(trackerResp.Interval)
+	// Connect to peers and keep announcing
+	go r.downloadManager.RunAnnouncer(r.ctx, trackerResp, r.torrent.InfoHash, r.trackerClient.GetPeerID(),
+		func() (*tracker.TrackerResponse, error) {
+			return r.announce("")
+		}, r.trackerClient)
 
 	// Monitor for completion
 	go r.monitorCompletion()
 }
 
-// announceToTracker handles periodic tracker announcements
-func (r *Runner) announceToTracker(interval int64) {
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-r.ctx.Done():
-			return
-		case <-ticker.C:
-			if !r.downloadManager.IsActive() {
-				return
-			}
-
-			resp, err := r.trackerClient.GetPeers(r.torrent, r.port, "")
-			if err != nil {
-				if r.verbose {
-					fmt.Printf("Tracker announce failed: %v\n", err)
-				}
-				continue
-			}
-
-			if len(resp.Peers) > 0 {
-				r.downloadManager.AddPeers(resp.Peers, r.torrent.InfoHash, r.trackerClient.GetPeerID())
-			}
-		}
-	}
-}
-
 // monitorCompletion watches for download completion
 func (r *Runner) monitorCompletion() {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-r.ctx.Done():
-			return
-		case <-ticker.C:
-			if r.pieceManager.IsComplete() {
-				// Announce completion to tracker
-				r.trackerClient.GetPeers(r.torrent, r.port, "completed")
-
-				// Send completion message to TUI
-				if r.program != nil {
-					r.program.Send(completionMsg{})
-				}
-				return
-			}
-		}
+	if r.downloadManager.WaitComplete(r.ctx) != nil {
+		return
+	}
+
+	// Announce completion to tracker
+	r.trackerClient.GetPeers(r.ctx, r.torrent, r.port, "completed", r.downloadManager.AnnounceStats())
+
+	// Move the finished download to where it belongs
+	var moveErr error
+	if r.options.CompleteDir != "" {
+		moveErr = r.fileStorage.MoveTo(r.options.CompleteDir)
+	}
+
+	// Send completion message to TUI
+	if r.program != nil {
+		r.program.Send(completionMsg{moveErr: moveErr})
+	}
+
+	// Seed until a seeding limit is reached, if there is one, then stop
+	if r.downloadManager.WaitSeedLimit(r.ctx) != nil {
+		return
+	}
+	r.downloadManager.Stop()
+	r.trackerClient.GetPeers(r.ctx, r.torrent, r.port, "stopped", r.downloadManager.AnnounceStats())
+	if r.program != nil {
+		r.program.Send(seedingDoneMsg{})
 	}
 }
 
@@ -228,23 +270,32 @@ func (r *Runner) shutdown() {
 	// Cancel context to stop background goroutines
 	r.cancel()
 
+	// Stop accepting inbound peers
+	if r.listener != nil {
+		r.listener.Close()
+	}
+
 	// Stop download manager
 	if r.downloadManager != nil {
 		r.downloadManager.Stop()
 	}
 
+	// Leave the DHT
+	if r.dhtNode != nil {
+		r.dhtNode.Close()
+	}
+
 	// Close file storage
 	if r.fileStorage != nil {
 		r.fileStorage.Close()
 	}
 
-	// Final tracker announce
-	if r.trackerClient != nil && r.torrent != nil {
-		if r.pieceManager != nil && r.pieceManager.IsComplete() {
-			r.trackerClient.GetPeers(r.torrent, r.port, "completed")
-		} else {
-			r.trackerClient.GetPeers(r.torrent, r.port, "stopped")
-		}
+	// Final tracker announce; completion was announced when it happened,
+	// and so was stopping if a seeding limit was reached
+	if r.trackerClient != nil && r.torrent != nil && r.downloadManager != nil && !r.downloadManager.SeedLimitReached() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracker.StopAnnounceTimeout)
+		r.trackerClient.GetPeers(ctx, r.torrent, r.port, "stopped", r.downloadManager.AnnounceStats())
+		cancel()
 	}
 
 	// Quit TUI
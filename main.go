@@ -13,21 +13,34 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/yashkadam007/bittorrent-client/cmd"
+	"github.com/yashkadam007/bittorrent-client/internal/download"
+	"github.com/yashkadam007/bittorrent-client/internal/storage"
 )
 
+// exitIncomplete is the exit status of a headless download stopped before it
+// completed, so scripts can tell it apart from other failures (status 1).
+const exitIncomplete = 3
+
 func main() {
+	if len(os.Args) >= 2 && os.Args[1] == "info" {
+		runInfo(os.Args[2:])
+		return
+	}
+
 	// Auto-detect .torrent file if not provided
 	if len(os.Args) < 2 {
 		files, err := filepath.Glob("*.torrent")
 		if err != nil || len(files) == 0 {
-			fmt.Println("Usage: go run main.go <file.torrent> [options]")
+			fmt.Println("Usage: go run main.go <file.torrent|magnet-link> [options]")
 			fmt.Println("Or place a .torrent file in the current directory")
 			os.Exit(1)
 		}
@@ -38,31 +51,181 @@ func main() {
 
 	// Parse command line arguments
 	torrentFile := os.Args[1]
+	var err error
 
 	// Set up flags for remaining arguments
 	outputDir := flag.String("output", ".", "Output directory")
+	incompleteDir := flag.String("incomplete-dir", "", "Download into this directory instead, and move finished torrents to -complete-dir (or -output)")
+	completeDir := flag.String("complete-dir", "", "Move finished torrents to this directory")
 	port := flag.Int("port", 6881, "Port to listen on")
 	verbose := flag.Bool("verbose", false, "Verbose output")
 	useTUI := flag.Bool("tui", true, "Use terminal UI (default: true)")
+	autoQuit := flag.Duration("autoquit", 0, "Quit the terminal UI this long after the download completes, e.g. 10s (0 keeps running)")
+	fileMode := flag.String("filemode", "0644", "Permissions for created files, in octal (umask applies)")
+	dirMode := flag.String("dirmode", "0755", "Permissions for created directories, in octal (umask applies)")
+	quiet := flag.Bool("quiet", false, "Suppress all output (headless mode only)")
+	jsonEvents := flag.Bool("json-events", false, "Emit one JSON event per line to stdout (headless mode only)")
+	jsonOutput := flag.Bool("json", false, "Run headless and emit progress as one JSON object per line instead of text; errors still go to stderr")
+	partFiles := flag.Bool("part-files", false, "Name incomplete files with a .part suffix until they finish")
+	maxOpenFiles := flag.Int("max-open-files", 0, "Keep at most this many of the torrent's files open at once (0 means no limit)")
+	writeBuffer := flag.Int("write-buffer", 0, "Buffer up to this many KiB of blocks and write pieces in larger chunks (0 disables)")
+	useMmap := flag.Bool("mmap", false, "Read and write files through memory mappings instead of a system call per block (where supported)")
+	alloc := flag.String("alloc", "sparse", "How to make room for files: sparse (set their size), full (reserve their space up front) or none (grow them as pieces arrive)")
+	seed := flag.Bool("seed", false, "Keep serving peers after the download completes, until interrupted (headless mode only)")
+	seedRatio := flag.Float64("seed-ratio", 0, "Seed until this much has been uploaded per byte downloaded, e.g. 2.0; implies -seed and also applies to -listen-only (0 seeds indefinitely)")
+	seedTime := flag.Duration("seed-time", 0, "Seed for this long after the download completes, e.g. 1h; implies -seed and also applies to -listen-only (0 seeds indefinitely)")
+	verifyMD5 := flag.Bool("verify-md5", false, "Check completed files against the torrent's md5sums, if it has any (headless mode only)")
+	mediaMode := flag.Bool("mediamode", false, "Fetch the first and last pieces first, then the rest in order (for streaming media)")
+	mediaHead := flag.Int("media-head", 4, "Pieces at the start to fetch first in media mode")
+	mediaTail := flag.Int("media-tail", 2, "Pieces at the end to fetch first in media mode")
+	verifyWorkers := flag.Int("verify-workers", 0, "Maximum pieces hashed at once (0 means one per CPU)")
+	spreadBlocks := flag.Bool("spread-blocks", false, "Have each peer start at a different block of a shared piece")
+	connectBudget := flag.Int("connect-budget", 30, "Maximum peer connection attempts per tracker announce")
+	dialConcurrency := flag.Int("dial-concurrency", 10, "Maximum peer connection attempts in flight at once")
+	probe := flag.String("probe", "", "Connect to one peer (host:port), report which pieces it has, and exit")
+	recheck := flag.Bool("recheck", false, "Re-hash all data in the download directory (or -complete-dir), ignoring the resume file, and exit")
+	check := flag.Bool("check", false, "Report which pieces and files in the download directory (or -complete-dir) pass their hash check, and exit without contacting a tracker")
+	listenOnly := flag.Bool("listen-only", false, "Serve verified data in the download directory (or -complete-dir) to inbound peers without contacting a tracker")
+	udpRetries := flag.Int("udp-retries", 2, "Retransmit unanswered UDP tracker requests this many times, doubling the 15s wait each time (8 follows BEP 15 fully)")
+	maxUp := flag.Int64("maxup", 0, "Cap the upload speed at this many KiB/s across all peers (0 means unlimited)")
+	maxDown := flag.Int64("maxdown", 0, "Cap the download speed at this many KiB/s across all peers (0 means unlimited)")
+	maxPeers := flag.Int("maxpeers", 50, "Connect to at most this many peers at once")
+	targetPeers := flag.Int("target-peers", 20, "Re-announce early when fewer peers than this connect")
+	warmup := flag.Duration("warmup", 0, "Connect to peers but hold back piece requests this long at startup, e.g. 3s (0 disables)")
+	warmupPeers := flag.Int("warmup-peers", 0, "End the warmup early once this many peers have sent their bitfields (0 waits it out)")
 
 	flag.CommandLine.Parse(os.Args[2:])
+	if *jsonOutput {
+		*jsonEvents = true
+		*useTUI = false
+	}
+
+	if *probe != "" {
+		err = cmd.ProbePeer(torrentFile, *probe, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	opts := cmd.Options{
+		Quiet:      *quiet,
+		JSONEvents: *jsonEvents,
+		Download: download.Options{
+			ConnectBudget:   *connectBudget,
+			DialConcurrency: *dialConcurrency,
+			TargetPeers:     *targetPeers,
+			MaxPeers:        *maxPeers,
+			Warmup:          *warmup,
+			WarmupPeers:     *warmupPeers,
+			SeedRatioLimit:  *seedRatio,
+			SeedTimeLimit:   *seedTime,
+
+			MaxDownloadBytesPerSec: *maxDown * 1024,
+			MaxUploadBytesPerSec:   *maxUp * 1024,
+		},
+		VerifyWorkers: *verifyWorkers,
+		SpreadBlocks:  *spreadBlocks,
+		UDPRetries:    *udpRetries,
+		VerifyMD5:     *verifyMD5,
+		Seed:          *seed || *seedRatio > 0 || *seedTime > 0, // A seeding limit implies seeding
+		AutoQuit:      *autoQuit,
+		CompleteDir:   *completeDir,
+		Media: download.MediaOptions{
+			Enabled: *mediaMode,
+			Head:    *mediaHead,
+			Tail:    *mediaTail,
+		},
+	}
+	opts.Storage, err = parseStorageModes(*fileMode, *dirMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Storage.WriteBuffer = *writeBuffer * 1024
+	opts.Storage.PartFiles = *partFiles
+	opts.Storage.MaxOpenFiles = *maxOpenFiles
+	opts.Storage.Mmap = *useMmap
+	opts.Storage.Allocation, err = storage.ParseAllocation(*alloc)
+	if err != nil {
+		log.Fatalf("invalid -alloc: %v", err)
+	}
+
+	// Downloads go to the incomplete directory, if any, until they finish
+	downloadDir := *outputDir
+	if *incompleteDir != "" {
+		downloadDir = *incompleteDir
+		if opts.CompleteDir == "" {
+			opts.CompleteDir = *outputDir
+		}
+	}
 
 	// Show startup info only in non-TUI mode
-	if !*useTUI {
+	if !*useTUI && !*quiet && !*jsonEvents {
 		fmt.Printf("BitTorrent Client\n")
 		fmt.Printf("Torrent: %s\n", torrentFile)
-		fmt.Printf("Output: %s\n", *outputDir)
+		fmt.Printf("Output: %s\n", downloadDir)
 		fmt.Printf("Port: %d\n", *port)
 	}
 
 	// Delegate to cmd package
+	if *check {
+		err = cmd.Check(torrentFile, downloadDir, opts)
+	} else if *recheck {
+		err = cmd.Recheck(torrentFile, downloadDir, opts)
+	} else if *listenOnly {
+		err = cmd.ListenOnly(torrentFile, downloadDir, *port, opts)
+	} else if *useTUI {
+		err = cmd.RunWithTUI(torrentFile, downloadDir, *port, *verbose, opts)
+	} else {
+		err = cmd.Run(torrentFile, downloadDir, *port, *verbose, opts)
+	}
+	if errors.Is(err, cmd.ErrIncomplete) {
+		log.Print(err)
+		os.Exit(exitIncomplete)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runInfo implements "info [-raw | -magnet [-qr]] <file.torrent>".
+func runInfo(args []string) {
+	infoFlags := flag.NewFlagSet("info", flag.ExitOnError)
+	raw := infoFlags.Bool("raw", false, "Dump the decoded bencode structure")
+	magnet := infoFlags.Bool("magnet", false, "Print the torrent's magnet link")
+	qr := infoFlags.Bool("qr", false, "With -magnet, also print the link as a QR code")
+	infoFlags.Parse(args)
+
+	if infoFlags.NArg() != 1 {
+		fmt.Println("Usage: go run main.go info [-raw | -magnet [-qr]] <file.torrent>")
+		os.Exit(1)
+	}
+
 	var err error
-	if *useTUI {
-		err = cmd.RunWithTUI(torrentFile, *outputDir, *port, *verbose)
+	if *magnet {
+		err = cmd.Magnet(infoFlags.Arg(0), *qr, os.Stdout)
 	} else {
-		err = cmd.Run(torrentFile, *outputDir, *port, *verbose)
+		err = cmd.Info(infoFlags.Arg(0), *raw, os.Stdout)
 	}
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// parseStorageModes converts the octal -filemode and -dirmode flags into storage options.
+func parseStorageModes(fileMode, dirMode string) (storage.Options, error) {
+	fm, err := strconv.ParseUint(fileMode, 8, 32)
+	if err != nil || fm > 0777 {
+		return storage.Options{}, fmt.Errorf("invalid -filemode %q: expected octal permissions like 0644", fileMode)
+	}
+
+	dm, err := strconv.ParseUint(dirMode, 8, 32)
+	if err != nil || dm > 0777 {
+		return storage.Options{}, fmt.Errorf("invalid -dirmode %q: expected octal permissions like 0755", dirMode)
+	}
+
+	return storage.Options{
+		FileMode: os.FileMode(fm),
+		DirMode:  os.FileMode(dm),
+	}, nil
+}